@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Access restricts who may read the site, for mirroring private forum
+// categories. A request is allowed when it passes any of the checks.
+type Access struct {
+	// Header is set by an authenticating proxy, such as an OIDC one, to
+	// identify the user. Requests with it non-empty are allowed, so the
+	// proxy must be the only way to reach the server.
+	Header string `json:"header"`
+
+	// BasicAuth maps user names to passwords.
+	BasicAuth map[string]string `json:"basic-auth"`
+
+	// AllowIPs lists addresses or CIDR ranges allowed without other checks.
+	AllowIPs []string `json:"allow-ips"`
+
+	nets []*net.IPNet
+}
+
+func (a *Access) init() error {
+	for _, s := range a.AllowIPs {
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("invalid allowed address %q: %v", s, err)
+		}
+		a.nets = append(a.nets, ipnet)
+	}
+	if a.Header == "" && len(a.BasicAuth) == 0 && len(a.nets) == 0 {
+		return fmt.Errorf("access control has no checks")
+	}
+	return nil
+}
+
+// allows returns whether req passes the access checks.
+func (a *Access) allows(req *http.Request) bool {
+	if a.Header != "" && req.Header.Get(a.Header) != "" {
+		return true
+	}
+	if user, password, ok := req.BasicAuth(); ok {
+		if expected, ok := a.BasicAuth[user]; ok && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+			return true
+		}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			for _, ipnet := range a.nets {
+				if ipnet.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// checkAccess returns whether req may be served, responding with an
+// authentication request otherwise. Requests carrying the administration
+// token pass, as their Authorization header cannot also hold the access
+// credentials.
+func checkAccess(resp http.ResponseWriter, req *http.Request) bool {
+	a := config.Access
+	if a == nil {
+		return true
+	}
+	if a.allows(req) || isAdmin(req) {
+		// Shared caches must not serve restricted content to others.
+		resp.Header().Set("Cache-Control", "private")
+		return true
+	}
+	if len(a.BasicAuth) > 0 {
+		resp.Header().Set("WWW-Authenticate", `Basic realm="snapdocs"`)
+		resp.WriteHeader(http.StatusUnauthorized)
+	} else {
+		resp.WriteHeader(http.StatusForbidden)
+	}
+	return false
+}
+
+// forumAuthTransport authenticates requests to the forum with an API
+// key, so that private categories may be mirrored.
+type forumAuthTransport struct {
+	base     http.RoundTripper
+	key      string
+	username string
+}
+
+func (t *forumAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "forum.snapcraft.io" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Api-Key", t.key)
+	req.Header.Set("Api-Username", t.username)
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMECache configures where -acme keeps certificates and keys, so that
+// replicas share them rather than each requesting their own. The -acme
+// flag still enables obtaining certificates, but its directory is only
+// used by the "dir" type.
+type ACMECache struct {
+	// Type is "dir" (the default), "s3", "kubernetes", or "vault".
+	Type string `json:"type"`
+
+	// Location is the s3://bucket/prefix for the s3 type, whose
+	// credentials come from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY.
+	Location   string `json:"location"`
+	Endpoint   string `json:"endpoint"`   // Defaults to s3.amazonaws.com.
+	Region     string `json:"region"`     // Defaults to us-east-1.
+	Encryption string `json:"encryption"` // AES256 (the default) or aws:kms.
+	KMSKeyID   string `json:"kms-key-id"`
+
+	// Secret is the Kubernetes secret holding the entries, in the
+	// namespace of the pod unless Namespace is set.
+	Secret    string `json:"secret"`
+	Namespace string `json:"namespace"`
+
+	// Address and Path locate the entries in a Vault KV version 2 store,
+	// with Path starting with the mount (e.g. "secret/snapdocs/acme").
+	// The token is provided via $VAULT_TOKEN, and the address defaults to
+	// $VAULT_ADDR.
+	Address string `json:"address"`
+	Path    string `json:"path"`
+
+	cache autocert.Cache
+}
+
+func (c *ACMECache) init() error {
+	var err error
+	switch c.Type {
+	case "", "dir":
+		c.Type = "dir"
+	case "s3":
+		c.cache, err = newS3Cache(c)
+	case "kubernetes":
+		c.cache, err = newKubernetesCache(c)
+	case "vault":
+		c.cache, err = newVaultCache(c)
+	default:
+		err = fmt.Errorf("unknown ACME cache type %q", c.Type)
+	}
+	return err
+}
+
+// acmeCache returns the cache for certificates obtained via -acme.
+func acmeCache() autocert.Cache {
+	if config.ACMECache != nil && config.ACMECache.cache != nil {
+		return config.ACMECache.cache
+	}
+	return autocert.DirCache(*acmeFlag)
+}
+
+type s3Cache struct {
+	store  *s3Store
+	prefix string
+	header map[string]string
+}
+
+func newS3Cache(c *ACMECache) (autocert.Cache, error) {
+	u, err := url.Parse(c.Location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ACME cache location, expected s3://bucket/prefix: %s", c.Location)
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("s3 ACME cache requires $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	}
+	store := &s3Store{endpoint: c.Endpoint, region: c.Region, bucket: u.Host, accessKey: accessKey, secretKey: secretKey}
+	if store.endpoint == "" {
+		store.endpoint = "s3.amazonaws.com"
+	}
+	if store.region == "" {
+		store.region = "us-east-1"
+	}
+	header := map[string]string{"Content-Type": "application/octet-stream"}
+	switch c.Encryption {
+	case "", "AES256":
+		header["X-Amz-Server-Side-Encryption"] = "AES256"
+	case "aws:kms":
+		header["X-Amz-Server-Side-Encryption"] = "aws:kms"
+		if c.KMSKeyID != "" {
+			header["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"] = c.KMSKeyID
+		}
+	default:
+		return nil, fmt.Errorf("invalid ACME cache encryption %q", c.Encryption)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &s3Cache{store, prefix, header}, nil
+}
+
+func (c *s3Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.store.do("GET", c.prefix+key, nil, nil)
+	if resp != nil && resp.StatusCode == 404 {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *s3Cache) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := c.store.do("PUT", c.prefix+key, c.header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Cache) Delete(ctx context.Context, key string) error {
+	resp, err := c.store.do("DELETE", c.prefix+key, nil, nil)
+	if resp != nil && resp.StatusCode == 404 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+const kubernetesServiceAccount = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// kubernetesCache keeps all entries in one secret, talking to the API
+// server with the credentials of the pod's service account.
+type kubernetesCache struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+func newKubernetesCache(c *ACMECache) (autocert.Cache, error) {
+	if c.Secret == "" {
+		return nil, fmt.Errorf("kubernetes ACME cache has no secret")
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("kubernetes ACME cache must run inside a cluster")
+	}
+	token, err := ioutil.ReadFile(kubernetesServiceAccount + "token")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account token: %v", err)
+	}
+	caCert, err := ioutil.ReadFile(kubernetesServiceAccount + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read service account CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+	namespace := c.Namespace
+	if namespace == "" {
+		data, err := ioutil.ReadFile(kubernetesServiceAccount + "namespace")
+		if err != nil {
+			return nil, fmt.Errorf("cannot read service account namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	return &kubernetesCache{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		url:   fmt.Sprintf("https://%s/api/v1/namespaces/%s/secrets/%s", net.JoinHostPort(host, port), namespace, c.Secret),
+		token: strings.TrimSpace(string(token)),
+	}, nil
+}
+
+// kubernetesKey maps cache keys such as "example.com+rsa" to the
+// characters allowed in secret data keys.
+var kubernetesKey = strings.NewReplacer("+", "_", "*", "_")
+
+func (c *kubernetesCache) do(ctx context.Context, method, contentType string, body interface{}) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	url := c.url
+	if method == "POST" {
+		url = url[:strings.LastIndex(url, "/")]
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, fmt.Errorf("got %v status from Kubernetes: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
+
+func (c *kubernetesCache) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, "GET", "", nil)
+	if resp != nil && resp.StatusCode == 404 {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("cannot decode Kubernetes secret: %v", err)
+	}
+	data, ok := secret.Data[kubernetesKey.Replace(key)]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (c *kubernetesCache) Put(ctx context.Context, key string, data []byte) error {
+	entry := map[string][]byte{kubernetesKey.Replace(key): data}
+	resp, err := c.do(ctx, "PATCH", "application/merge-patch+json", map[string]interface{}{"data": entry})
+	if resp != nil && resp.StatusCode == 404 {
+		name := c.url[strings.LastIndex(c.url, "/")+1:]
+		resp, err = c.do(ctx, "POST", "application/json", map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]string{"name": name},
+			"data":       entry,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *kubernetesCache) Delete(ctx context.Context, key string) error {
+	entry := map[string]interface{}{kubernetesKey.Replace(key): nil}
+	resp, err := c.do(ctx, "PATCH", "application/merge-patch+json", map[string]interface{}{"data": entry})
+	if resp != nil && resp.StatusCode == 404 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// vaultCache keeps each entry as a secret in a Vault KV version 2 store.
+type vaultCache struct {
+	address string
+	mount   string
+	path    string
+	token   string
+}
+
+func newVaultCache(c *ACMECache) (autocert.Cache, error) {
+	address := c.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if address == "" || token == "" {
+		return nil, fmt.Errorf("vault ACME cache requires an address and $VAULT_TOKEN")
+	}
+	path := strings.Trim(c.Path, "/")
+	mount := path
+	if i := strings.Index(path, "/"); i >= 0 {
+		mount, path = path[:i], path[i+1:]
+	} else {
+		path = ""
+	}
+	if mount == "" {
+		return nil, fmt.Errorf("vault ACME cache has no path")
+	}
+	if path != "" {
+		path += "/"
+	}
+	return &vaultCache{strings.TrimSuffix(address, "/"), mount, path, token}, nil
+}
+
+func (c *vaultCache) do(ctx context.Context, method, kind, key string, body interface{}, result interface{}) (status int, err error) {
+	var data []byte
+	if body != nil {
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	u := c.address + "/v1/" + c.mount + "/" + kind + "/" + c.path + url.PathEscape(key)
+	req, err := http.NewRequest(method, u, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", c.token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("got %v status from Vault: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+	if result != nil {
+		return resp.StatusCode, json.Unmarshal(data, result)
+	}
+	return resp.StatusCode, nil
+}
+
+func (c *vaultCache) Get(ctx context.Context, key string) ([]byte, error) {
+	var result struct {
+		Data struct {
+			Data struct {
+				Value string `json:"value"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	status, err := c.do(ctx, "GET", "data", key, nil, &result)
+	if status == 404 {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(result.Data.Data.Value)
+}
+
+func (c *vaultCache) Put(ctx context.Context, key string, data []byte) error {
+	value := map[string]string{"value": base64.StdEncoding.EncodeToString(data)}
+	_, err := c.do(ctx, "POST", "data", key, map[string]interface{}{"data": value}, nil)
+	return err
+}
+
+func (c *vaultCache) Delete(ctx context.Context, key string) error {
+	status, err := c.do(ctx, "DELETE", "metadata", key, nil, nil)
+	if status == 404 {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEDNS configures obtaining the certificate for the -domains with
+// DNS-01 challenges, for sites not reachable from the internet and for
+// wildcard domains (e.g. "*.docs.example.com").
+type ACMEDNS struct {
+	// Provider publishes the challenge TXT records: "exec" or "cloudflare".
+	Provider string `json:"provider"`
+
+	// Command is run by the exec provider with the arguments "present" or
+	// "cleanup", the record name, and the record value.
+	Command string `json:"command"`
+
+	// ZoneID is the Cloudflare zone holding the domains. The API token is
+	// provided via $CLOUDFLARE_API_TOKEN.
+	ZoneID string `json:"zone-id"`
+
+	// PropagationDelay is how long to wait for published records to be
+	// visible to the CA. Defaults to "1m".
+	PropagationDelay string `json:"propagation-delay"`
+
+	provider dnsProvider
+	delay    time.Duration
+}
+
+// dnsProvider publishes TXT records. Records for the same name must be
+// added alongside existing ones rather than replacing them, as a domain
+// and its wildcard are validated with two records of the same name.
+type dnsProvider interface {
+	present(ctx context.Context, name, value string) (cleanup func() error, err error)
+}
+
+// dnsProviders creates the DNS providers by name.
+var dnsProviders = map[string]func(c *ACMEDNS) (dnsProvider, error){
+	"exec":       newExecDNSProvider,
+	"cloudflare": newCloudflareDNSProvider,
+}
+
+func (c *ACMEDNS) init() error {
+	newProvider, ok := dnsProviders[c.Provider]
+	if !ok {
+		return fmt.Errorf("unknown ACME DNS provider %q", c.Provider)
+	}
+	provider, err := newProvider(c)
+	if err != nil {
+		return err
+	}
+	c.provider = provider
+	c.delay = time.Minute
+	if c.PropagationDelay != "" {
+		c.delay, err = time.ParseDuration(c.PropagationDelay)
+		if err != nil {
+			return fmt.Errorf("invalid ACME DNS propagation delay: %v", err)
+		}
+	}
+	return nil
+}
+
+const (
+	dnsCertRenewBefore = 30 * 24 * time.Hour
+	dnsCertCheckEvery  = 12 * time.Hour
+
+	// Failures to obtain a missing certificate are retried sooner, backing
+	// off from dnsCertRetryMin to dnsCertRetryMax.
+	dnsCertRetryMin = time.Minute
+	dnsCertRetryMax = time.Hour
+)
+
+// dnsCertManager obtains and renews a single certificate for all domains
+// with DNS-01 challenges, keeping it in the same cache as autocert.
+type dnsCertManager struct {
+	domains []string
+	cache   autocert.Cache
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+func newDNSCertManager() *dnsCertManager {
+	var domains []string
+	for _, domain := range strings.Split(*domainsFlag, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return &dnsCertManager{domains: domains, cache: acmeCache()}
+}
+
+func (m *dnsCertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cert == nil {
+		return nil, fmt.Errorf("certificate not yet obtained")
+	}
+	return m.cert, nil
+}
+
+// loop loads the cached certificate, and obtains a new one whenever it is
+// missing or close to expiring. While there's no certificate at all,
+// failures are retried with exponential backoff.
+func (m *dnsCertManager) loop() {
+	ctx := context.Background()
+	if cert, err := m.load(ctx); err == nil {
+		m.mu.Lock()
+		m.cert = cert
+		m.mu.Unlock()
+	} else if err != autocert.ErrCacheMiss {
+		log.Printf("Cannot load cached certificate: %v", err)
+	}
+	retry := dnsCertRetryMin
+	for {
+		m.mu.Lock()
+		cert := m.cert
+		m.mu.Unlock()
+		wait := dnsCertCheckEvery
+		if cert == nil || time.Until(cert.Leaf.NotAfter) < dnsCertRenewBefore {
+			log.Printf("Requesting certificate for %s via DNS-01...", strings.Join(m.domains, ", "))
+			if fresh, err := m.obtain(ctx); err != nil {
+				log.Printf("Cannot obtain certificate: %v", err)
+				reportError(nil, "error", fmt.Errorf("cannot obtain certificate: %v", err), nil)
+				if cert == nil {
+					wait = retry
+					if retry *= 2; retry > dnsCertRetryMax {
+						retry = dnsCertRetryMax
+					}
+				}
+			} else {
+				m.mu.Lock()
+				m.cert = fresh
+				m.mu.Unlock()
+				log.Printf("Obtained certificate valid until %s.", fresh.Leaf.NotAfter.Format(time.RFC3339))
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (m *dnsCertManager) cacheKey() string {
+	return "dns01+" + strings.Replace(m.domains[0], "*", "_", -1)
+}
+
+func (m *dnsCertManager) load(ctx context.Context) (*tls.Certificate, error) {
+	data, err := m.cache.Get(ctx, m.cacheKey())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// accountKey returns the ACME account key, generating and caching one if
+// necessary.
+func (m *dnsCertManager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	const name = "dns01+account.key"
+	data, err := m.cache.Get(ctx, name)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid cached ACME account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if err != autocert.ErrCacheMiss {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.cache.Put(ctx, name, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (m *dnsCertManager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	dns := config.ACMEDNS
+
+	accountKey, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain ACME account key: %v", err)
+	}
+	client := &acme.Client{Key: accountKey, DirectoryURL: autocert.DefaultACMEDirectory}
+	_, err = client.Register(ctx, &acme.Account{Contact: []string{"mailto:gustavo@niemeyer.net"}}, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("cannot register ACME account: %v", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.domains...))
+	if err != nil {
+		return nil, err
+	}
+
+	// All records are published before accepting any challenge, as the
+	// CA may look up a domain and its wildcard at once.
+	var accept []*acme.Challenge
+	var authzURLs []string
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+			}
+		}
+		if challenge == nil {
+			return nil, fmt.Errorf("CA offers no DNS-01 challenge for %s", authz.Identifier.Value)
+		}
+		value, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		cleanup, err := dns.provider.present(ctx, "_acme-challenge."+authz.Identifier.Value, value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot publish DNS challenge for %s: %v", authz.Identifier.Value, err)
+		}
+		defer func() {
+			if err := cleanup(); err != nil {
+				log.Printf("Cannot remove DNS challenge for %s: %v", authz.Identifier.Value, err)
+			}
+		}()
+		accept = append(accept, challenge)
+		authzURLs = append(authzURLs, authzURL)
+	}
+	if len(accept) > 0 {
+		time.Sleep(dns.delay)
+	}
+	for i, challenge := range accept {
+		if _, err := client.Accept(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if _, err := client.WaitAuthorization(ctx, authzURLs[i]); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.domains[0]},
+		DNSNames: m.domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	for _, c := range chain {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c})
+	}
+	if err := m.cache.Put(ctx, m.cacheKey(), buf.Bytes()); err != nil {
+		log.Printf("Cannot cache certificate: %v", err)
+	}
+	return m.load(ctx)
+}
+
+type execDNSProvider struct {
+	command string
+}
+
+func newExecDNSProvider(c *ACMEDNS) (dnsProvider, error) {
+	if c.Command == "" {
+		return nil, fmt.Errorf("exec ACME DNS provider has no command")
+	}
+	return &execDNSProvider{c.Command}, nil
+}
+
+func (p *execDNSProvider) run(ctx context.Context, args ...string) error {
+	output, err := exec.CommandContext(ctx, p.command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+func (p *execDNSProvider) present(ctx context.Context, name, value string) (func() error, error) {
+	if err := p.run(ctx, "present", name, value); err != nil {
+		return nil, err
+	}
+	return func() error { return p.run(context.Background(), "cleanup", name, value) }, nil
+}
+
+type cloudflareDNSProvider struct {
+	zoneID string
+	token  string
+}
+
+func newCloudflareDNSProvider(c *ACMEDNS) (dnsProvider, error) {
+	token := os.Getenv("CLOUDFLARE_API_TOKEN")
+	if c.ZoneID == "" || token == "" {
+		return nil, fmt.Errorf("cloudflare ACME DNS provider requires a zone-id and $CLOUDFLARE_API_TOKEN")
+	}
+	return &cloudflareDNSProvider{c.ZoneID, token}, nil
+}
+
+func (p *cloudflareDNSProvider) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, "https://api.cloudflare.com/client/v4/zones/"+p.zoneID+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %v status from Cloudflare: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+func (p *cloudflareDNSProvider) present(ctx context.Context, name, value string) (func() error, error) {
+	var result struct {
+		Result struct {
+			ID string `json:"id"`
+		} `json:"result"`
+	}
+	record := map[string]interface{}{"type": "TXT", "name": name, "content": value, "ttl": 120}
+	if err := p.do(ctx, "POST", "/dns_records", record, &result); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return p.do(context.Background(), "DELETE", "/dns_records/"+result.Result.ID, nil, nil)
+	}, nil
+}
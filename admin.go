@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// isAdmin reports whether req carries the administration token, either
+// as a bearer token or as the password in basic authentication. Requests
+// changing state from other sites are never administrative.
+func isAdmin(req *http.Request) bool {
+	if *adminTokenFlag == "" || crossSite(req) {
+		return false
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if _, password, ok := req.BasicAuth(); ok {
+		token = password
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(*adminTokenFlag)) == 1
+}
+
+// crossSite reports whether req changes state on behalf of another site,
+// as forms posted from other sites do, which browsers send along with the
+// basic authentication credentials for this one. Requests with neither
+// Sec-Fetch-Site, Origin nor Referer headers don't come from browsers.
+func crossSite(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	switch req.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return false
+	case "":
+	default:
+		return true
+	}
+	source := req.Header.Get("Origin")
+	if source == "" {
+		source = req.Header.Get("Referer")
+	}
+	if source == "" {
+		return false
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return true
+	}
+	if u.Host == req.Host {
+		return false
+	}
+	base, err := url.Parse(*baseURLFlag)
+	return err != nil || base.Host != u.Host
+}
+
+var adminDiffPattern = regexp.MustCompile("^/admin/diff/([0-9]+)$")
+
+func serveAdmin(resp http.ResponseWriter, req *http.Request) {
+	if *adminTokenFlag == "" {
+		sendNotFound(resp, "Administration is disabled.")
+		return
+	}
+	if crossSite(req) {
+		log.Printf("Cross-site administration request for %s from %s", req.URL, req.RemoteAddr)
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !isAdmin(req) {
+		log.Printf("Unauthorized administration request for %s from %s", req.URL, req.RemoteAddr)
+		resp.Header().Set("WWW-Authenticate", `Basic realm="snapdocs admin"`)
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	audit.Login(req)
+
+	if req.URL.Path == "/admin" || req.URL.Path == "/admin/" {
+		serveDashboard(resp, req)
+		return
+	}
+
+	if req.URL.Path == "/admin/orphans" {
+		serveOrphans(resp, req)
+		return
+	}
+
+	if req.URL.Path == "/admin/duplicates" {
+		serveDuplicates(resp, req)
+		return
+	}
+
+	if req.URL.Path == "/admin/reindex" {
+		serveReindex(resp, req)
+		return
+	}
+
+	if req.URL.Path == "/admin/crawl" {
+		serveCrawlStatus(resp, req)
+		return
+	}
+
+	if req.URL.Path == "/admin/search-index" {
+		serveSearchIndex(resp, req)
+		return
+	}
+
+	if req.URL.Path == "/admin/snapshots" || strings.HasPrefix(req.URL.Path, "/admin/snapshots/") {
+		serveSnapshots(resp, req)
+		return
+	}
+
+	if req.URL.Path == "/admin/held" || strings.HasPrefix(req.URL.Path, "/admin/held/") {
+		serveHeld(resp, req)
+		return
+	}
+
+	if m := adminDiffPattern.FindStringSubmatch(req.URL.Path); m != nil {
+		serveDiff(resp, req, "/"+m[1])
+		return
+	}
+
+	sendNotFound(resp, "Unknown administration page: %s", req.URL.Path)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+var crossSiteTests = []struct {
+	method  string
+	headers map[string]string
+	cross   bool
+}{
+	{"GET", map[string]string{"Origin": "https://evil.example"}, false},
+	{"POST", nil, false},
+	{"POST", map[string]string{"Sec-Fetch-Site": "same-origin"}, false},
+	{"POST", map[string]string{"Sec-Fetch-Site": "none"}, false},
+	{"POST", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://docs.example"}, true},
+	{"POST", map[string]string{"Sec-Fetch-Site": "same-site"}, true},
+	{"POST", map[string]string{"Origin": "https://docs.example"}, false},
+	{"POST", map[string]string{"Origin": "https://evil.example"}, true},
+	{"POST", map[string]string{"Origin": "null"}, true},
+	{"POST", map[string]string{"Referer": "https://docs.example/admin/held"}, false},
+	{"POST", map[string]string{"Referer": "https://evil.example/docs.example"}, true},
+	{"DELETE", map[string]string{"Origin": "https://evil.example"}, true},
+}
+
+func TestCrossSite(t *testing.T) {
+	for _, test := range crossSiteTests {
+		req := httptest.NewRequest(test.method, "https://docs.example/admin/reindex", nil)
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		if cross := crossSite(req); cross != test.cross {
+			t.Errorf("crossSite of %s with %v = %v, want %v", test.method, test.headers, cross, test.cross)
+		}
+	}
+}
+
+func TestIsAdminCrossSite(t *testing.T) {
+	saved := *adminTokenFlag
+	*adminTokenFlag = "secret"
+	defer func() { *adminTokenFlag = saved }()
+
+	req := httptest.NewRequest("POST", "https://docs.example/admin/reindex", nil)
+	req.SetBasicAuth("admin", "secret")
+	if !isAdmin(req) {
+		t.Errorf("isAdmin rejects same-site request with the token")
+	}
+	req.Header.Set("Origin", "https://evil.example")
+	if isAdmin(req) {
+		t.Errorf("isAdmin accepts cross-site request with the token")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// admonitionKind is a kind of callout box.
+type admonitionKind struct {
+	Class string
+	Title string
+	Icon  string
+}
+
+var admonitionKinds = map[string]*admonitionKind{
+	"note":      {"note", "Note", "&#x2139;&#xfe0e;"},
+	"tip":       {"tip", "Tip", "&#x2605;&#xfe0e;"},
+	"important": {"important", "Important", "&#x2757;&#xfe0e;"},
+	"warning":   {"warning", "Warning", "&#x26a0;&#xfe0e;"},
+	"danger":    {"danger", "Danger", "&#x26d4;&#xfe0e;"},
+}
+
+// admonitionMarkers maps the words, emoji, and notice types that start
+// callouts to their kinds.
+var admonitionMarkers = map[string]string{
+	"note":        "note",
+	"info":        "note",
+	"information": "note",
+	"tip":         "tip",
+	"hint":        "tip",
+	"positive":    "tip",
+	"important":   "important",
+	"warning":     "warning",
+	"caution":     "warning",
+	"danger":      "danger",
+	"negative":    "danger",
+
+	":information_source:": "note",
+	":bulb:":               "tip",
+	":exclamation:":        "important",
+	":warning:":            "warning",
+	":no_entry:":           "danger",
+	":stop_sign:":          "danger",
+	"ⓘ":                    "note",
+	"ℹ":                    "note",
+	"💡":                    "tip",
+	"⚠":                    "warning",
+	"⛔":                    "danger",
+}
+
+const admonitionWords = `note|info|tip|hint|important|warning|caution|danger`
+
+var (
+	admonitionQuote  = regexp.MustCompile(`(?s)<blockquote>\s*<p>(.*?)</blockquote>`)
+	admonitionMarker = regexp.MustCompile(`^\s*(?:(?:<img [^>]*title="(:[a-z_]+:)"[^>]*>|(ⓘ|ℹ|💡|⚠|⛔)\x{fe0f}?)\s*)?` +
+		`(?i:<strong>\s*(` + admonitionWords + `)\s*:?\s*</strong>\s*:?|(` + admonitionWords + `)\s*:)?\s*`)
+	admonitionNotice = regexp.MustCompile(`(?s)<p>\[note(?:\s+type=(?:"|&#34;|&quot;)([a-z-]+))?[^\]]*\]\s*(?:<br/?>)?\s*(.*?)\s*(?:<br/?>)?\s*\[/note\]</p>`)
+	emptyParagraph   = regexp.MustCompile(`<p>\s*</p>\s*`)
+)
+
+// renderAdmonitions turns conventional callouts into styled boxes: block
+// quotes starting with a marker such as "Note:" or a warning emoji, and
+// the [note] notices of the forum.
+func renderAdmonitions(content string) string {
+	content = admonitionQuote.ReplaceAllStringFunc(content, func(quote string) string {
+		body := admonitionQuote.FindStringSubmatch(quote)[1]
+		m := admonitionMarker.FindStringSubmatchIndex(body)
+		var marker, word string
+		for i := 2; i < len(m); i += 2 {
+			if m[i] < 0 {
+				continue
+			}
+			if i <= 4 {
+				marker = body[m[i]:m[i+1]]
+			} else {
+				word = body[m[i]:m[i+1]]
+			}
+		}
+		kind := admonitionMarkers[strings.ToLower(word)]
+		if kind == "" {
+			kind = admonitionMarkers[marker]
+		}
+		if kind == "" {
+			return quote
+		}
+		return admonitionBox(admonitionKinds[kind], word, "<p>"+body[m[1]:])
+	})
+	content = admonitionNotice.ReplaceAllStringFunc(content, func(notice string) string {
+		m := admonitionNotice.FindStringSubmatch(notice)
+		kind := admonitionMarkers[m[1]]
+		if kind == "" {
+			kind = "note"
+		}
+		return admonitionBox(admonitionKinds[kind], "", "<p>"+m[2]+"</p>")
+	})
+	return content
+}
+
+// admonitionBox returns a callout box of kind holding content. The title
+// defaults to that of the kind.
+func admonitionBox(kind *admonitionKind, title, content string) string {
+	if title == "" {
+		title = kind.Title
+	} else {
+		title = strings.ToUpper(title[:1]) + strings.ToLower(title[1:])
+	}
+	content = emptyParagraph.ReplaceAllString(content, "")
+	return fmt.Sprintf(`<div class="admonition admonition-%s" role="note"><p class="admonition-title"><span class="admonition-icon" aria-hidden="true">%s</span> %s</p>%s</div>`, kind.Class, kind.Icon, title, content)
+}
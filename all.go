@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// outlineSection is a heading in the documentation outline together with
+// the topics linked under it, in outline order.
+type outlineSection struct {
+	Title    string
+	TopicIDs []int
+}
+
+var (
+	outlineItem = regexp.MustCompile(`(?s)<h[1-6][^>]*>(.*?)</h[1-6]>|href="((?:/[a-z0-9-]+)+/[0-9]+)"`)
+	htmlTag     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// outlineSections parses the outline part of the index page content.
+func outlineSections(outline string) []*outlineSection {
+	var sections []*outlineSection
+	var section *outlineSection
+	for _, m := range outlineItem.FindAllStringSubmatch(outline, -1) {
+		if m[2] == "" {
+			section = &outlineSection{Title: plainText(m[1])}
+			sections = append(sections, section)
+			continue
+		}
+		id, err := topicPathID(m[2])
+		if err != nil {
+			continue
+		}
+		if section == nil {
+			section = &outlineSection{}
+			sections = append(sections, section)
+		}
+		section.TopicIDs = append(section.TopicIDs, id)
+	}
+	return sections
+}
+
+// plainText strips tags from an HTML fragment and unescapes its entities.
+func plainText(s string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(s, "")))
+}
+
+// indexOutline returns the outline parts of the index pages, or an empty
+// string if they cannot be obtained within budget.
+func indexOutline(budget *upstreamBudget) string {
+	var outlines []string
+	for _, idx := range siteIndexes() {
+		outlines = append(outlines, idx.outline(budget))
+	}
+	return strings.Join(outlines, "\n")
+}
+
+type allGroup struct {
+	Title  string
+	Topics []*Topic
+}
+
+type allData struct {
+	Sections []*allGroup
+	Letters  []*allGroup
+}
+
+func serveAll(resp http.ResponseWriter, req *http.Request) {
+	budget := requestBudget(req)
+	topics, err := forum.TopicsWithin(budget)
+	if err != nil {
+		log.Printf("Cannot send %s to %s: %v", req.URL, req.RemoteAddr, err)
+		if sendForumError(resp, err) {
+			return
+		}
+		resp.Header().Set("Location", "/")
+		resp.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	topics = append([]*Topic(nil), topics...)
+	sort.Slice(topics, func(i, j int) bool {
+		return strings.ToLower(topics[i].Title) < strings.ToLower(topics[j].Title)
+	})
+
+	byID := make(map[int]*Topic, len(topics))
+	for _, topic := range topics {
+		byID[topic.ID] = topic
+	}
+
+	var data allData
+
+	listed := make(map[int]bool)
+	for _, section := range outlineSections(indexOutline(budget)) {
+		group := &allGroup{Title: section.Title}
+		for _, id := range section.TopicIDs {
+			if topic, ok := byID[id]; ok && !listed[id] {
+				listed[id] = true
+				group.Topics = append(group.Topics, topic)
+			}
+		}
+		if len(group.Topics) > 0 {
+			data.Sections = append(data.Sections, group)
+		}
+	}
+	other := &allGroup{Title: "Not in the outline"}
+	for _, topic := range topics {
+		if !listed[topic.ID] && topic.ID != indexPageID {
+			other.Topics = append(other.Topics, topic)
+		}
+	}
+	if len(other.Topics) > 0 {
+		data.Sections = append(data.Sections, other)
+	}
+
+	var letter *allGroup
+	for _, topic := range topics {
+		if topic.ID == indexPageID {
+			continue
+		}
+		title := "#"
+		if r, _ := utf8.DecodeRuneInString(topic.Title); unicode.IsLetter(r) {
+			title = string(unicode.ToUpper(r))
+		}
+		if letter == nil || letter.Title != title {
+			letter = &allGroup{Title: title}
+			data.Letters = append(data.Letters, letter)
+		}
+		letter.Topics = append(letter.Topics, topic)
+	}
+
+	var buf bytes.Buffer
+	err = allTemplate.Execute(&buf, &data)
+	if err != nil {
+		log.Printf("Cannot execute all-pages template: %v", err)
+	}
+
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "All pages", Content: buf.String()})
+}
+
+var allTemplate = template.Must(template.New("all").Parse(`
+<p>{{range .Letters}}<a href="#letter-{{.Title}}">{{.Title}}</a> {{end}}</p>
+<h2>By section</h2>
+{{range .Sections}}
+<h3>{{or .Title "Untitled"}}</h3>
+<ul>
+{{range .Topics}}<li><a href="{{.}}">{{.Title}}</a></li>
+{{end}}
+</ul>
+{{end}}
+<h2>Alphabetical</h2>
+{{range .Letters}}
+<h3 id="letter-{{.Title}}">{{.Title}}</h3>
+<ul>
+{{range .Topics}}<li><a href="{{.}}">{{.Title}}</a></li>
+{{end}}
+</ul>
+{{end}}
+`))
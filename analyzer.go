@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// SearchAnalyzer configures how text is turned into terms for the local
+// search index, so the vocabulary of the documentation matches the way
+// people search for it.
+type SearchAnalyzer struct {
+	// Language selects the stemmer: "en", "es", "pt", "fr", "de", "it",
+	// or "none". It defaults to the -lang flag.
+	Language string `json:"language"`
+
+	// StopWords are ignored when indexing and searching. They default to
+	// common words of the language.
+	StopWords []string `json:"stop-words"`
+
+	// Synonyms is the path of a file with a group of equivalent words or
+	// phrases per line, separated by commas, such as:
+	//
+	//	snapcraft.yaml, snapcraft yaml
+	//	interface, plug/slot
+	//
+	// Each phrase is indexed and searched as the first one in its group.
+	Synonyms string `json:"synonyms"`
+
+	stop      map[string]bool
+	synonyms  map[string][]*synonymRule
+	signature string
+}
+
+// synonymRule replaces the terms in from with those in to.
+type synonymRule struct {
+	from []string
+	to   []string
+}
+
+func (a *SearchAnalyzer) init() error {
+	if a.Language == "" {
+		a.Language = *langFlag
+	}
+	if _, ok := stemmers[a.Language]; !ok && a.Language != "none" {
+		return fmt.Errorf("invalid search analyzer language %q", a.Language)
+	}
+	if a.StopWords == nil {
+		a.StopWords = langStopWords[a.Language]
+	}
+	a.stop = make(map[string]bool)
+	for _, w := range a.StopWords {
+		a.stop[strings.ToLower(w)] = true
+	}
+
+	var synonyms []byte
+	a.synonyms = make(map[string][]*synonymRule)
+	if a.Synonyms != "" {
+		data, err := ioutil.ReadFile(a.Synonyms)
+		if err != nil {
+			return fmt.Errorf("cannot read search synonyms: %v", err)
+		}
+		synonyms = data
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			var group [][]string
+			for _, phrase := range strings.Split(text, ",") {
+				if terms := searchTokens(phrase); len(terms) > 0 {
+					group = append(group, terms)
+				}
+			}
+			if len(group) < 2 {
+				return fmt.Errorf("synonyms line %d in %s has a single phrase", line, a.Synonyms)
+			}
+			for _, from := range group[1:] {
+				a.synonyms[from[0]] = append(a.synonyms[from[0]], &synonymRule{from: from, to: group[0]})
+			}
+		}
+		for _, rules := range a.synonyms {
+			sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].from) > len(rules[j].from) })
+		}
+	}
+
+	stop := append([]string(nil), a.StopWords...)
+	sort.Strings(stop)
+	sum := sha256.Sum256([]byte(a.Language + "\n" + strings.Join(stop, " ") + "\n" + string(synonyms)))
+	a.signature = fmt.Sprintf("%x", sum[:8])
+	return nil
+}
+
+// analyze replaces synonyms in terms, drops stop words, and stems the rest.
+func (a *SearchAnalyzer) analyze(terms []string) []string {
+	var result []string
+	for i := 0; i < len(terms); {
+		replaced := false
+		for _, rule := range a.synonyms[terms[i]] {
+			if hasTermPrefix(terms[i:], rule.from) {
+				result = append(result, rule.to...)
+				i += len(rule.from)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			result = append(result, terms[i])
+			i++
+		}
+	}
+	analyzed := result[:0]
+	for _, term := range result {
+		if a.stop[term] {
+			continue
+		}
+		if stem := stemmers[a.Language]; stem != nil {
+			term = stem(term)
+		}
+		analyzed = append(analyzed, term)
+	}
+	return analyzed
+}
+
+func hasTermPrefix(terms, prefix []string) bool {
+	if len(terms) < len(prefix) {
+		return false
+	}
+	for i := range prefix {
+		if terms[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// searchTerms returns the terms for text in the local search index.
+func searchTerms(text string) []string {
+	terms := searchTokens(text)
+	if a := config.SearchAnalyzer; a != nil {
+		terms = a.analyze(terms)
+	}
+	return terms
+}
+
+// searchAnalyzerSignature identifies the analyzer configuration, so an
+// index persisted with different settings is rebuilt.
+func searchAnalyzerSignature() string {
+	if a := config.SearchAnalyzer; a != nil {
+		return a.signature
+	}
+	return ""
+}
+
+// stemmers strip common inflections from words of each language. They
+// are deliberately light: words that stem the same must mean the same.
+var stemmers = map[string]func(string) string{
+	"en": stemEnglish,
+	"es": stemSuffixes("es", "s"),
+	"pt": stemSuffixes("s"),
+	"fr": stemSuffixes("s", "x"),
+	"de": stemSuffixes("en", "er", "e", "n", "s"),
+	"it": stemSuffixes("i", "e", "o", "a"),
+}
+
+const stemMinLen = 3
+
+// stemSuffixes returns a stemmer removing the first of suffixes that
+// leaves a long enough stem.
+func stemSuffixes(suffixes ...string) func(string) string {
+	return func(word string) string {
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(word, suffix) && len([]rune(word))-len([]rune(suffix)) >= stemMinLen {
+				return strings.TrimSuffix(word, suffix)
+			}
+		}
+		return word
+	}
+}
+
+func stemEnglish(word string) string {
+	if len(word) <= stemMinLen {
+		return word
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") && !strings.HasSuffix(word, "eies") && !strings.HasSuffix(word, "aies"):
+		word = word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "ss"), strings.HasSuffix(word, "us"), strings.HasSuffix(word, "is"):
+	case strings.HasSuffix(word, "s"):
+		word = word[:len(word)-1]
+	}
+	undouble := false
+	for _, suffix := range []string{"ing", "ed"} {
+		if strings.HasSuffix(word, suffix) && len(word)-len(suffix) >= stemMinLen {
+			word = strings.TrimSuffix(word, suffix)
+			undouble = true
+			break
+		}
+	}
+	if n := len(word); undouble && n > stemMinLen && word[n-1] == word[n-2] && !strings.ContainsRune("aeioulsz", rune(word[n-1])) {
+		word = word[:n-1]
+	}
+	if strings.HasSuffix(word, "e") && len(word) > stemMinLen {
+		word = word[:len(word)-1]
+	}
+	return word
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// artifactTimeout is how long generated artifacts are served before
+// being generated again.
+const artifactTimeout = 10 * time.Minute
+
+// artifact is a large generated download, kept together with its
+// pre-compressed variant so that each download costs no compression.
+// Generating the same content again keeps its ETag and modification
+// time, so interrupted downloads may be resumed across regenerations.
+type artifact struct {
+	data    []byte
+	gzipped []byte // Nil if compression does not pay off.
+	etag    string
+	time    time.Time // When the content last changed.
+	checked time.Time // When the content was last generated.
+}
+
+var artifacts struct {
+	mu  sync.Mutex
+	m   map[string]*artifact
+	gen map[string]*artifactGen // Generations in progress.
+}
+
+// artifactGen is the generation of an artifact, shared by all the callers
+// asking for it meanwhile.
+type artifactGen struct {
+	done chan struct{}
+	a    *artifact
+	err  error
+}
+
+// cachedArtifact returns the artifact with the given name, generating it
+// with generate when missing or expired. Artifacts are generated without
+// holding up others, and once at a time per name.
+func cachedArtifact(name string, generate func() ([]byte, error)) (*artifact, error) {
+	artifacts.mu.Lock()
+	old, ok := artifacts.m[name]
+	if ok && old.checked.Add(artifactTimeout).After(time.Now()) {
+		artifacts.mu.Unlock()
+		return old, nil
+	}
+	if g, ok := artifacts.gen[name]; ok {
+		artifacts.mu.Unlock()
+		<-g.done
+		return g.a, g.err
+	}
+	if artifacts.gen == nil {
+		artifacts.gen = make(map[string]*artifactGen)
+	}
+	g := &artifactGen{done: make(chan struct{})}
+	artifacts.gen[name] = g
+	artifacts.mu.Unlock()
+
+	data, err := generate()
+	if err == nil {
+		g.a, err = newArtifact(data)
+	}
+	if err != nil {
+		g.err = err
+	} else if ok && old.etag == g.a.etag {
+		g.a.time = old.time
+	}
+
+	artifacts.mu.Lock()
+	delete(artifacts.gen, name)
+	if g.err == nil {
+		if artifacts.m == nil {
+			artifacts.m = make(map[string]*artifact)
+		}
+		artifacts.m[name] = g.a
+	}
+	artifacts.mu.Unlock()
+	close(g.done)
+	return g.a, g.err
+}
+
+func newArtifact(data []byte) (*artifact, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot compress artifact: %v", err)
+	}
+	a := &artifact{
+		data:    data,
+		gzipped: buf.Bytes(),
+		etag:    fmt.Sprintf("%x", sha256.Sum256(data))[:16],
+		time:    time.Now().UTC().Truncate(time.Second),
+	}
+	a.checked = a.time
+	if len(a.gzipped) >= len(data) {
+		// Archives and images are compressed already.
+		a.gzipped = nil
+	}
+	return a, nil
+}
+
+// size returns the memory held by the artifact.
+func (a *artifact) size() int64 {
+	return int64(len(a.data) + len(a.gzipped))
+}
+
+// serve serves the artifact, compressed if the client accepts it, with
+// support for conditional and range requests.
+func (a *artifact) serve(resp http.ResponseWriter, req *http.Request, contentType string) {
+	resp.Header().Set("Content-Type", contentType)
+	resp.Header().Add("Vary", "Accept-Encoding")
+	data, etag := a.data, a.etag
+	if a.gzipped != nil && acceptsGzip(req) {
+		resp.Header().Set("Content-Encoding", "gzip")
+		data, etag = a.gzipped, etag+"-gzip"
+	}
+	resp.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(resp, req, "", a.time, bytes.NewReader(data))
+}
+
+// acceptsGzip returns whether the Accept-Encoding header of req allows
+// gzip-compressed responses.
+func acceptsGzip(req *http.Request) bool {
+	for _, field := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(field, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
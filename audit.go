@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	auditRecent        = 200
+	auditLoginInterval = time.Hour
+)
+
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Resource string    `json:"resource,omitempty"`
+}
+
+// auditLog records administrative actions, keeping the most recent
+// ones in memory for the admin dashboard and appending all of them to
+// the file provided via -audit-log, if any.
+type auditLog struct {
+	mu     sync.Mutex
+	recent []*auditEntry
+	logins map[string]time.Time
+}
+
+var audit auditLog
+
+// auditActor describes who made req.
+func auditActor(req *http.Request) string {
+	if isAdmin(req) {
+		if user, _, ok := req.BasicAuth(); ok && user != "" {
+			return "admin " + user + " (" + req.RemoteAddr + ")"
+		}
+		return "admin (" + req.RemoteAddr + ")"
+	}
+	return "anonymous (" + req.RemoteAddr + ")"
+}
+
+// Record records that the actor of req performed action on resource.
+func (a *auditLog) Record(req *http.Request, action, resource string) {
+	entry := &auditEntry{
+		Time:     time.Now().UTC(),
+		Actor:    auditActor(req),
+		Action:   action,
+		Resource: resource,
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.recent = append(a.recent, entry)
+	if len(a.recent) > auditRecent {
+		a.recent = a.recent[len(a.recent)-auditRecent:]
+	}
+	if *auditLogFlag == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Cannot marshal audit entry: %v", err)
+		return
+	}
+	f, err := os.OpenFile(*auditLogFlag, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Printf("Cannot open audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Cannot write audit log: %v", err)
+	}
+}
+
+// Login records an administrative login for req, unless the same actor
+// logged in recently, as every administrative request is authenticated.
+func (a *auditLog) Login(req *http.Request) {
+	actor := auditActor(req)
+	now := time.Now()
+	a.mu.Lock()
+	if a.logins == nil {
+		a.logins = make(map[string]time.Time)
+	}
+	last, ok := a.logins[actor]
+	a.logins[actor] = now
+	for other, t := range a.logins {
+		if t.Add(auditLoginInterval).Before(now) {
+			delete(a.logins, other)
+		}
+	}
+	a.mu.Unlock()
+	if !ok || last.Add(auditLoginInterval).Before(now) {
+		a.Record(req, "login", "")
+	}
+}
+
+// Recent returns the recently recorded entries, newest first.
+func (a *auditLog) Recent() []*auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries := make([]*auditEntry, len(a.recent))
+	for i, entry := range a.recent {
+		entries[len(entries)-1-i] = entry
+	}
+	return entries
+}
+
+type dashboardData struct {
+	Audit   []*auditEntry
+	Popular []*topicStats
+}
+
+// serveDashboard serves the administration dashboard.
+func serveDashboard(resp http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	err := dashboardTemplate.Execute(&buf, &dashboardData{
+		Audit:   audit.Recent(),
+		Popular: stats.Popular(metricsTopTopics),
+	})
+	if err != nil {
+		log.Printf("Cannot execute dashboard template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Administration", Content: buf.String()})
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(pageFuncs).Parse(`
+<p>Reports: <a href="/admin/orphans">orphan pages</a>, <a href="/admin/duplicates">duplicate titles</a>, <a href="/admin/search-index">search index</a>, <a href="/admin/reindex">reindex</a>, <a href="/admin/crawl">crawl status</a>, <a href="/admin/snapshots">pinned pages</a>, <a href="/admin/held">held updates</a>.</p>
+
+<h2>Top pages by traffic</h2>
+{{if .Popular}}
+<table class="traffic">
+<thead><tr><th>Page</th><th>Views</th></tr></thead>
+<tbody>
+{{range .Popular}}<tr><td><a href="{{.Path}}">{{.Title}}</a></td><td>{{.Views}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>No page views were recorded yet.</p>
+{{end}}
+
+<h2>Audit log</h2>
+{{if .Audit}}
+<table class="audit">
+<thead><tr><th>Time</th><th>Actor</th><th>Action</th><th>Resource</th></tr></thead>
+<tbody>
+{{range .Audit}}<tr><td>{{formatTime .Time}}</td><td>{{.Actor}}</td><td>{{.Action}}</td><td>{{.Resource}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>No administrative actions were recorded since the server started.</p>
+{{end}}
+`))
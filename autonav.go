@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+)
+
+// AutoNav configures building the sidebar outline from the structure of
+// the documentation category, for forums without a manual outline topic.
+type AutoNav struct {
+	// GroupBy is "subcategory" (the default), "tag", or "none".
+	GroupBy string `json:"group-by"`
+
+	// Order is the path of a topic whose links define the order of the
+	// pages. Pages it does not link to follow alphabetically.
+	Order string `json:"order"`
+}
+
+func (nav *AutoNav) check() error {
+	switch nav.GroupBy {
+	case "":
+		nav.GroupBy = "subcategory"
+	case "subcategory", "tag", "none":
+	default:
+		return fmt.Errorf("invalid auto-nav grouping %q", nav.GroupBy)
+	}
+	if nav.Order != "" {
+		if _, err := topicPathID(nav.Order); err != nil {
+			return fmt.Errorf("invalid auto-nav order path %q: %v", nav.Order, err)
+		}
+	}
+	return nil
+}
+
+// autoNavOutline returns an outline of the documentation topics built
+// according to config.AutoNav, in the same form as outline topics. The
+// topics are obtained within budget.
+func autoNavOutline(budget *upstreamBudget) string {
+	nav := config.AutoNav
+	topics, err := forum.TopicsWithin(budget)
+	if err != nil {
+		log.Printf("Cannot obtain topic list for navigation: %v", err)
+		return ""
+	}
+
+	rank := make(map[int]int)
+	if nav.Order != "" {
+		if order, err := forum.TopicWithin(nav.Order, budget); err != nil {
+			log.Printf("Cannot obtain navigation order topic: %v", err)
+		} else {
+			for _, section := range outlineSections(order.Content()) {
+				for _, id := range section.TopicIDs {
+					if _, ok := rank[id]; !ok {
+						rank[id] = len(rank)
+					}
+				}
+			}
+		}
+	}
+
+	var pages []*Topic
+	for _, topic := range topics {
+		if !isIndex(topic) && (nav.Order == "" || topic.String() != nav.Order) {
+			pages = append(pages, topic)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		ri, iok := rank[pages[i].ID]
+		rj, jok := rank[pages[j].ID]
+		if iok != jok {
+			return iok
+		}
+		if iok {
+			return ri < rj
+		}
+		return strings.ToLower(pages[i].Title) < strings.ToLower(pages[j].Title)
+	})
+
+	type group struct {
+		title  string
+		topics []*Topic
+	}
+	var groups []*group
+	switch nav.GroupBy {
+	case "subcategory":
+		byCategory := map[int]*group{docCategory: {}}
+		groups = append(groups, byCategory[docCategory])
+		for _, sub := range subcategories(docCategory) {
+			byCategory[sub.ID] = &group{title: sub.Name}
+			groups = append(groups, byCategory[sub.ID])
+		}
+		for _, topic := range pages {
+			// Topics in deeper subcategories go with their top subcategory.
+			for id := topic.Category; id != 0; id = parentCategory(id) {
+				if g, ok := byCategory[id]; ok {
+					g.topics = append(g.topics, topic)
+					break
+				}
+			}
+		}
+	case "tag":
+		byTag := make(map[string]*group)
+		other := &group{title: "Other"}
+		for _, topic := range pages {
+			for _, tag := range topic.Tags {
+				if byTag[tag] == nil {
+					byTag[tag] = &group{title: tag}
+					groups = append(groups, byTag[tag])
+				}
+				byTag[tag].topics = append(byTag[tag].topics, topic)
+			}
+			if len(topic.Tags) == 0 {
+				other.topics = append(other.topics, topic)
+			}
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i].title < groups[j].title })
+		groups = append(groups, other)
+	default:
+		groups = []*group{{topics: pages}}
+	}
+
+	var buf strings.Builder
+	for _, g := range groups {
+		if len(g.topics) == 0 {
+			continue
+		}
+		if g.title != "" {
+			buf.WriteString("<h2>" + html.EscapeString(g.title) + "</h2>\n")
+		}
+		buf.WriteString("<ul>\n")
+		for _, topic := range g.topics {
+			fmt.Fprintf(&buf, "<li><a href=\"%s\">%s</a></li>\n", topic, html.EscapeString(topic.Title))
+		}
+		buf.WriteString("</ul>\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+var badgePathPattern = regexp.MustCompile(`^/badge/([0-9]+)\.(svg|json)$`)
+
+// ageString describes the time elapsed since t in rough terms.
+func ageString(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Hour:
+		return "just now"
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	}
+	return plural(int(d/(365*24*time.Hour)), "year")
+}
+
+// badgeColor returns the color for a page last updated at the given age.
+func badgeColor(age time.Duration) string {
+	switch {
+	case age < 30*24*time.Hour:
+		return "brightgreen"
+	case age < 90*24*time.Hour:
+		return "yellow"
+	case age < 365*24*time.Hour:
+		return "orange"
+	}
+	return "red"
+}
+
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+}
+
+// serveBadge serves a badge showing how long ago the topic was updated,
+// either as SVG or as a shields.io endpoint JSON.
+func serveBadge(resp http.ResponseWriter, req *http.Request) {
+	m := badgePathPattern.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		sendNotFound(resp, "Invalid badge path.")
+		return
+	}
+	topic, err := forum.TopicWithin("/"+m[1], requestBudget(req))
+	if err != nil && sendForumError(resp, err) {
+		return
+	}
+	if err != nil || !isDocCategory(topic.Category) {
+		sendNotFound(resp, "Documentation page not found.")
+		return
+	}
+
+	now := time.Now()
+	label := "docs updated"
+	message := ageString(topic.LastUpdate(), now)
+	color := badgeColor(now.Sub(topic.LastUpdate()))
+
+	resp.Header().Set("Cache-Control", "max-age=3600")
+
+	if m[2] == "json" {
+		data, err := json.Marshal(map[string]interface{}{
+			"schemaVersion": 1,
+			"label":         label,
+			"message":       message,
+			"color":         color,
+		})
+		if err != nil {
+			log.Printf("Cannot marshal badge: %v", err)
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write(data)
+		return
+	}
+
+	// Rough text widths, which is good enough for short ASCII strings.
+	data := &badgeData{
+		Label:        label,
+		Message:      message,
+		Color:        badgeColors[color],
+		LabelWidth:   len(label)*7 + 10,
+		MessageWidth: len(message)*7 + 10,
+	}
+	data.Width = data.LabelWidth + data.MessageWidth
+	resp.Header().Set("Content-Type", "image/svg+xml")
+	err = badgeTemplate.Execute(resp, data)
+	if err != nil {
+		log.Printf("Cannot execute badge template: %v", err)
+	}
+}
+
+type badgeData struct {
+	Label        string
+	Message      string
+	Color        string
+	Width        int
+	LabelWidth   int
+	MessageWidth int
+}
+
+func (d *badgeData) LabelX() int   { return d.LabelWidth / 2 }
+func (d *badgeData) MessageX() int { return d.LabelWidth + d.MessageWidth/2 }
+
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Message}}">
+<title>{{.Label}}: {{.Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="#555"/>
+<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="15" fill="#010101" fill-opacity=".3">{{.Label}}</text>
+<text x="{{.LabelX}}" y="14">{{.Label}}</text>
+<text x="{{.MessageX}}" y="15" fill="#010101" fill-opacity=".3">{{.Message}}</text>
+<text x="{{.MessageX}}" y="14">{{.Message}}</text>
+</g>
+</svg>
+`))
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Bookmarks are kept by the browser of each reader, in local storage, so
+// they need no accounts. The bookmarks page asks the API below for the
+// current titles and addresses of the bookmarked pages.
+
+const bookmarksMax = 200
+
+type bookmarkPage struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Path    string `json:"path"`
+	Updated string `json:"updated"`
+}
+
+// serveBookmarksAPI returns the pages with the IDs in the ids parameter,
+// separated by commas, leaving out those no longer documented.
+func serveBookmarksAPI(resp http.ResponseWriter, req *http.Request) {
+	var ids []int
+	for _, s := range strings.Split(req.Form.Get("ids"), ",") {
+		if s == "" {
+			continue
+		}
+		id, err := strconv.Atoi(s)
+		if err != nil || id <= 0 || len(ids) == bookmarksMax {
+			resp.WriteHeader(http.StatusBadRequest)
+			resp.Write([]byte("invalid ids"))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	topics, err := forum.TopicsWithin(requestBudget(req))
+	if err != nil {
+		log.Printf("Cannot list topics: %v", err)
+		if !sendForumError(resp, err) {
+			resp.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	byID := make(map[int]*Topic, len(topics))
+	for _, topic := range topics {
+		byID[topic.ID] = topic
+	}
+	pages := []*bookmarkPage{}
+	for _, id := range ids {
+		if topic, ok := byID[id]; ok {
+			pages = append(pages, &bookmarkPage{
+				ID:      topic.ID,
+				Title:   topic.Title,
+				Path:    topic.String(),
+				Updated: formatTime(topic.BumpedAt),
+			})
+		}
+	}
+
+	data, err := json.Marshal(pages)
+	if err != nil {
+		log.Printf("Cannot marshal bookmarks: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
+
+func serveBookmarks(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{
+		Title: "Bookmarks",
+		Content: `<p>Pages you bookmark are listed here. They are saved in this browser only.</p>
+<div id="bookmarks"><p class="text-muted">Loading bookmarks&hellip;</p></div>
+<noscript><p>Bookmarks need JavaScript.</p></noscript>`,
+	})
+}
+
+func serveBookmarksScript(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/javascript")
+	resp.Header().Set("Cache-Control", "max-age=3600")
+	resp.Write([]byte(bookmarksScript))
+}
+
+// bookmarksScript toggles the bookmark of the current page with the
+// bookmark button, and renders the list on the bookmarks page.
+const bookmarksScript = `(function() {
+	var key = "snapdocs-bookmarks";
+
+	function load() {
+		try {
+			var ids = JSON.parse(localStorage.getItem(key) || "[]");
+			return Array.isArray(ids) ? ids : [];
+		} catch (e) {
+			return [];
+		}
+	}
+	function save(ids) {
+		try {
+			localStorage.setItem(key, JSON.stringify(ids));
+		} catch (e) {}
+	}
+
+	var button = document.querySelector(".bookmark-toggle");
+	if (button) {
+		var id = parseInt(button.getAttribute("data-id"), 10);
+		var show = function() {
+			var marked = load().indexOf(id) >= 0;
+			button.textContent = marked ? "★ Bookmarked" : "☆ Bookmark";
+			button.setAttribute("aria-pressed", String(marked));
+		};
+		button.addEventListener("click", function() {
+			var ids = load();
+			var i = ids.indexOf(id);
+			if (i >= 0) {
+				ids.splice(i, 1);
+			} else {
+				ids.unshift(id);
+			}
+			save(ids);
+			show();
+		});
+		show();
+		button.hidden = false;
+	}
+
+	var list = document.getElementById("bookmarks");
+	if (!list) {
+		return;
+	}
+	function render(pages) {
+		list.textContent = "";
+		if (pages.length === 0) {
+			var empty = document.createElement("p");
+			empty.textContent = "You have no bookmarks yet. Use the Bookmark button on any page to add it here.";
+			list.appendChild(empty);
+			return;
+		}
+		var ul = document.createElement("ul");
+		pages.forEach(function(page) {
+			var li = document.createElement("li");
+			var a = document.createElement("a");
+			a.href = page.path;
+			a.textContent = page.title;
+			var remove = document.createElement("button");
+			remove.type = "button";
+			remove.className = "bookmark-remove";
+			remove.textContent = "Remove";
+			remove.setAttribute("aria-label", "Remove " + page.title);
+			remove.addEventListener("click", function() {
+				save(load().filter(function(id) { return id !== page.id; }));
+				li.parentNode.removeChild(li);
+			});
+			li.appendChild(a);
+			li.appendChild(document.createTextNode(" "));
+			li.appendChild(remove);
+			ul.appendChild(li);
+		});
+		list.appendChild(ul);
+	}
+	var ids = load();
+	if (ids.length === 0) {
+		render([]);
+		return;
+	}
+	fetch("/api/v1/bookmarks?ids=" + ids.join(",")).then(function(resp) {
+		if (!resp.ok) {
+			throw new Error(resp.statusText);
+		}
+		return resp.json();
+	}).then(render, function() {
+		list.textContent = "Cannot load your bookmarks right now.";
+	});
+})();
+`
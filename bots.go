@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// botPattern matches the user agents of crawlers, which are served cached
+// content only, so that a crawl never translates into forum traffic.
+var botPattern = regexp.MustCompile(`(?i)bot\b|crawl|spider|slurp|archiver|facebookexternalhit|bingpreview|headlesschrome`)
+
+// isBot returns whether req comes from a crawler.
+func isBot(req *http.Request) bool {
+	return botPattern.MatchString(req.UserAgent())
+}
+
+const limiterMaxClients = 10000
+
+// clientLimiter rate-limits requests per client address with a token
+// bucket holding up to a period worth of requests.
+type clientLimiter struct {
+	rate *int          // Requests allowed per period.
+	per  time.Duration // The period.
+
+	mu      sync.Mutex
+	clients map[string]*clientBucket
+}
+
+type clientBucket struct {
+	tokens float64
+	time   time.Time
+}
+
+var bots = &clientLimiter{rate: botRateFlag, per: time.Minute}
+
+// allow returns whether the client sending req may be served now.
+func (l *clientLimiter) allow(req *http.Request) bool {
+	client := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	rate := float64(*l.rate)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients == nil {
+		l.clients = make(map[string]*clientBucket)
+	}
+	bucket, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= limiterMaxClients {
+			// Buckets idle for over a period are full again and may be dropped.
+			for c, b := range l.clients {
+				if now.Sub(b.time) > l.per {
+					delete(l.clients, c)
+				}
+			}
+		}
+		bucket = &clientBucket{tokens: rate, time: now}
+		l.clients[client] = bucket
+	}
+	bucket.tokens += float64(now.Sub(bucket.time)) / float64(l.per) * rate
+	if bucket.tokens > rate {
+		bucket.tokens = rate
+	}
+	bucket.time = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// retryAfter returns the seconds after which a client over the limit
+// may try again, for the Retry-After header.
+func (l *clientLimiter) retryAfter() string {
+	if *l.rate <= 0 {
+		return strconv.Itoa(int(l.per.Seconds()))
+	}
+	return strconv.Itoa(int(l.per.Seconds()) / *l.rate + 1)
+}
+
+// checkBot rate-limits crawlers, answering with 429 Too Many Requests
+// when they go over the limit, and returns whether req may be served.
+func checkBot(resp http.ResponseWriter, req *http.Request) bool {
+	if *botRateFlag <= 0 || !isBot(req) || bots.allow(req) {
+		return true
+	}
+	log.Printf("Rate limiting %s from %s (%s)", req.URL, req.RemoteAddr, req.UserAgent())
+	resp.Header().Set("Retry-After", bots.retryAfter())
+	resp.WriteHeader(http.StatusTooManyRequests)
+	return false
+}
+
+// sendUncached tells a crawler that the page is not cached yet, and
+// fetches it in the background so that it's ready for a later visit.
+func sendUncached(resp http.ResponseWriter, path string) {
+	crawlWarmer.warm(path)
+	resp.Header().Set("Retry-After", "60")
+	resp.WriteHeader(http.StatusServiceUnavailable)
+}
+
+const warmMaxPending = 10
+
+// topicWarmer fetches topics asked for by crawlers in the background,
+// each at most once at a time, and no more than warmMaxPending at once.
+type topicWarmer struct {
+	mu      sync.Mutex
+	pending map[int]bool
+}
+
+var crawlWarmer topicWarmer
+
+// warm fetches the topic at path in the background, if it's a known
+// documentation topic, so that crawlers guessing paths never make the
+// forum work.
+func (w *topicWarmer) warm(path string) {
+	id, err := topicPathID(path)
+	if err != nil || !knownTopic(id) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[id] || len(w.pending) >= warmMaxPending {
+		return
+	}
+	if w.pending == nil {
+		w.pending = make(map[int]bool)
+	}
+	w.pending[id] = true
+	go func() {
+		forum.TopicWithin(path, crawlBudget())
+		w.mu.Lock()
+		delete(w.pending, id)
+		w.mu.Unlock()
+	}()
+}
+
+// knownTopic returns whether the topic with id is in the cached topic list
+// or linked from a cached index outline.
+func knownTopic(id int) bool {
+	// The topic list is locked while it's fetched, and then left out.
+	if forum.category.mu.TryLock() {
+		topics := forum.category.topics
+		forum.category.mu.Unlock()
+		for _, topic := range topics {
+			if topic.ID == id {
+				return true
+			}
+		}
+	}
+	indexes := siteIndexes()
+	for _, l := range config.Languages {
+		if l.Index != "" {
+			idx := &Index{Path: l.Index}
+			if idx.init() == nil {
+				indexes = append(indexes, idx)
+			}
+		}
+	}
+	for _, idx := range indexes {
+		if id == idx.id || outlineLists(idx.outline(cacheOnlyBudget()), id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestKnownTopic(t *testing.T) {
+	withTestForum(testOutline, nil, func() {
+		for _, test := range []struct {
+			id    int
+			known bool
+		}{
+			{indexPageID, true},
+			{100, true},
+			{101, true},
+			{102, false},
+		} {
+			if known := knownTopic(test.id); known != test.known {
+				t.Errorf("knownTopic(%d) = %v, want %v", test.id, known, test.known)
+			}
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	bundleMaxAssets    = 100
+	bundleMaxAssetSize = 10 << 20
+)
+
+var (
+	bundleImage      = regexp.MustCompile(`(<img\b[^>]*?\ssrc=")([^"]+)(")`)
+	bundleSrcset     = regexp.MustCompile(`\ssrcset="[^"]*"`)
+	bundleStylesheet = regexp.MustCompile(`(<link\b[^>]*?\shref=")(https?://[^"]+\.css)("[^>]*>)`)
+	bundleIntegrity  = regexp.MustCompile(`\s(?:integrity|crossorigin)="[^"]*"`)
+	bundleLocalLink  = regexp.MustCompile(`(\shref=")(/[^/"][^"]*)(")`)
+	bundleAssetName  = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// serveBundle serves a zip archive holding the rendered page at path
+// together with its images and stylesheets, for reading offline.
+func serveBundle(resp http.ResponseWriter, req *http.Request, pagePath string) {
+	topic, err := forum.TopicWithin(pagePath, requestBudget(req))
+	if err != nil && sendForumError(resp, err) {
+		return
+	}
+	if err != nil || !isDocCategory(topic.Category) {
+		sendNotFound(resp, "Documentation page not found.")
+		return
+	}
+
+	// Bundles are cached so that interrupted downloads may be resumed
+	// with range requests.
+	budget := requestBudget(req)
+	a, err := cachedArtifact(req.URL.Path, func() ([]byte, error) {
+		return buildBundle(topic, budget)
+	})
+	if err != nil {
+		log.Printf("Cannot create bundle for %s: %v", topic, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", topic.Slug+".zip"))
+	a.serve(resp, req, "application/zip")
+}
+
+// buildBundle returns the zip archive of the bundle of topic, rendered
+// within budget. Its files have no modification times, so the same
+// content gives the same bytes. Nothing in it depends on the request
+// asking for it, as bundles are shared.
+func buildBundle(topic *Topic, budget *upstreamBudget) ([]byte, error) {
+	req, _ := http.NewRequest("GET", topic.String(), nil)
+	req.Form = url.Values{}
+	var page bytes.Buffer
+	renderPage(&page, req, &pageData{Topic: topic, budget: budget})
+
+	bundle := &pageBundle{names: make(map[string]string)}
+	content := page.String()
+
+	// Responsive images would reference remote variants.
+	content = bundleSrcset.ReplaceAllString(content, "")
+	content = bundleImage.ReplaceAllStringFunc(content, func(tag string) string {
+		m := bundleImage.FindStringSubmatch(tag)
+		return m[1] + bundle.asset(m[2]) + m[3]
+	})
+	content = bundleStylesheet.ReplaceAllStringFunc(content, func(tag string) string {
+		m := bundleStylesheet.FindStringSubmatch(tag)
+		return bundleIntegrity.ReplaceAllString(m[1]+bundle.asset(m[2])+m[3], "")
+	})
+	content = bundleLocalLink.ReplaceAllStringFunc(content, func(attr string) string {
+		m := bundleLocalLink.FindStringSubmatch(attr)
+		return m[1] + bundleLink(m[2]) + m[3]
+	})
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	name := topic.Slug
+	files := append([]bundleFile{{"index.html", []byte(content)}}, bundle.files...)
+	for _, file := range files {
+		w, err := zw.Create(path.Join(name, file.name))
+		if err == nil {
+			_, err = w.Write(file.data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bundleLink returns the absolute URL of the site path linked from a
+// bundle, which is on the forum for pages if the site's base URL is
+// unknown.
+func bundleLink(path string) string {
+	if *baseURLFlag != "" {
+		return strings.TrimSuffix(*baseURLFlag, "/") + path
+	}
+	if _, err := topicPathID(path); err == nil {
+		path, _ = stripNamespace(path)
+		return "https://forum.snapcraft.io/t" + path
+	}
+	return path
+}
+
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+type pageBundle struct {
+	names map[string]string
+	files []bundleFile
+}
+
+// asset fetches the asset at ref into the bundle and returns the relative
+// path it should be referenced by. If the asset cannot be fetched, the
+// original ref is returned.
+func (b *pageBundle) asset(ref string) string {
+	if name, ok := b.names[ref]; ok {
+		return name
+	}
+	if len(b.files) >= bundleMaxAssets || strings.HasPrefix(ref, "data:") {
+		return ref
+	}
+
+	if ref == bootstrapURL {
+		data, err := bootstrapStylesheet()
+		if err != nil {
+			return ref
+		}
+		return b.add(ref, data)
+	}
+	fetchURL, ok := bundleAssetURL(ref)
+	if !ok {
+		log.Printf("Cannot fetch bundle asset %s: host not allowed", ref)
+		return ref
+	}
+	resp, err := httpClient.Get(fetchURL)
+	if err != nil {
+		log.Printf("Cannot fetch bundle asset %s: %v", fetchURL, err)
+		return ref
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		log.Printf("Cannot fetch bundle asset %s: got %v status", fetchURL, resp.StatusCode)
+		return ref
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, bundleMaxAssetSize+1))
+	if err != nil || len(data) > bundleMaxAssetSize {
+		log.Printf("Cannot fetch bundle asset %s: too large or unreadable", fetchURL)
+		return ref
+	}
+	return b.add(ref, data)
+}
+
+// bundleAssetURL returns the URL to fetch the asset referenced by ref
+// from, and whether it may be fetched. Images served via the image proxy
+// are fetched from their origin, and other relative references are
+// resolved against the forum, never against the requested host. Only the
+// hosts the image proxy fetches from are allowed.
+func bundleAssetURL(ref string) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	if u.Path == "/image" && u.Host == "" {
+		if u, err = url.Parse(u.Query().Get("url")); err != nil {
+			return "", false
+		}
+	}
+	u = bundleBaseURL.ResolveReference(u)
+	if !imageProxyAllowed(u) {
+		return "", false
+	}
+	return u.String(), true
+}
+
+var bundleBaseURL, _ = url.Parse("https://forum.snapcraft.io/")
+
+func (b *pageBundle) add(ref string, data []byte) string {
+	base := path.Base(strings.SplitN(ref, "?", 2)[0])
+	name := fmt.Sprintf("assets/%03d-%s", len(b.files), bundleAssetName.ReplaceAllString(base, "_"))
+	b.names[ref] = name
+	b.files = append(b.files, bundleFile{name, data})
+	return name
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Cache files hold an entry of the on-disk caches. They start with a
+// versioned header of "key: value" lines ending in an empty line, which
+// must include the length of the data following it, and end with the
+// SHA-256 checksum of the rest of the header and of the data:
+//
+//	snapdocs-cache 1
+//	type: image/png
+//	length: 1234
+//	sha256: 5f2b...
+//
+//	DATA
+//
+// A file that fails to decode, as left by a partial write, or written
+// by a version with another format, is treated as missing, so that its
+// content is fetched again instead of being served.
+
+const cacheFileMagic = "snapdocs-cache"
+
+const cacheFileVersion = 1
+
+// cacheFileMaxHeader bounds the header, so that garbage is not scanned
+// for its end.
+const cacheFileMaxHeader = 4096
+
+var errCacheFileCorrupt = errors.New("corrupted cache file")
+
+// cacheEntry is the content of a cache file.
+type cacheEntry struct {
+	Header map[string]string // Excluding length and sha256.
+	Data   []byte
+}
+
+// cacheFileChecksum returns the checksum of a cache file with the given
+// header lines, up to the checksum, and data.
+func cacheFileChecksum(header, data []byte) string {
+	h := sha256.New()
+	h.Write(header)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// encodeCacheEntry returns the cache file holding entry.
+func encodeCacheEntry(entry *cacheEntry) ([]byte, error) {
+	keys := make([]string, 0, len(entry.Header))
+	for key, value := range entry.Header {
+		if key == "" || key == "length" || key == "sha256" || strings.ContainsAny(key, ":\n") || strings.Contains(value, "\n") {
+			return nil, fmt.Errorf("invalid cache header %q", key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %d\n", cacheFileMagic, cacheFileVersion)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s: %s\n", key, entry.Header[key])
+	}
+	fmt.Fprintf(&buf, "length: %d\n", len(entry.Data))
+	fmt.Fprintf(&buf, "sha256: %s\n\n", cacheFileChecksum(buf.Bytes(), entry.Data))
+	if buf.Len() > cacheFileMaxHeader {
+		return nil, fmt.Errorf("cache header too large")
+	}
+	buf.Write(entry.Data)
+	return buf.Bytes(), nil
+}
+
+// decodeCacheEntry parses the cache file in data, verifying its version,
+// length, and checksum.
+func decodeCacheEntry(data []byte) (*cacheEntry, error) {
+	end := bytes.Index(data, []byte("\n\n"))
+	if end < 0 || end > cacheFileMaxHeader {
+		return nil, errCacheFileCorrupt
+	}
+	header, body := data[:end+1], data[end+2:]
+	last := bytes.LastIndexByte(header[:end], '\n') + 1
+	lines := strings.Split(string(header[:end]), "\n")
+	if lines[0] != fmt.Sprintf("%s %d", cacheFileMagic, cacheFileVersion) {
+		return nil, fmt.Errorf("unsupported cache file version")
+	}
+	if len(lines) < 3 || !strings.HasPrefix(lines[len(lines)-1], "sha256: ") {
+		return nil, errCacheFileCorrupt
+	}
+	if strings.TrimPrefix(lines[len(lines)-1], "sha256: ") != cacheFileChecksum(header[:last], body) {
+		return nil, errCacheFileCorrupt
+	}
+
+	entry := &cacheEntry{Header: make(map[string]string), Data: body}
+	length := -1
+	for _, line := range lines[1 : len(lines)-1] {
+		i := strings.Index(line, ": ")
+		if i <= 0 {
+			return nil, errCacheFileCorrupt
+		}
+		key, value := line[:i], line[i+2:]
+		switch key {
+		case "length":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, errCacheFileCorrupt
+			}
+			length = n
+		case "sha256":
+			return nil, errCacheFileCorrupt
+		default:
+			entry.Header[key] = value
+		}
+	}
+	if length != len(body) {
+		return nil, errCacheFileCorrupt
+	}
+	return entry, nil
+}
+
+// readCacheFile returns the entry cached at path. Files failing to decode
+// are removed, and reported as missing.
+func readCacheFile(path string) (*cacheEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := decodeCacheEntry(data)
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("cannot use cache file %s: %v", path, err)
+	}
+	return entry, nil
+}
+
+// readStateFile returns the data stored at path by writeCacheFile, or all
+// of it if the file predates the cache file format. Unlike readCacheFile,
+// files failing to decode are kept, as they hold state that cannot be
+// fetched again.
+func readStateFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || !bytes.HasPrefix(data, []byte(cacheFileMagic+" ")) {
+		return data, err
+	}
+	entry, err := decodeCacheEntry(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use state file %s: %v", path, err)
+	}
+	return entry.Data, nil
+}
+
+// writeCacheFile stores entry at path. The file is synced before being
+// renamed into place, so that a crash leaves either the previous entry,
+// the new one, or a temporary file, but never a partial entry.
+func writeCacheFile(path string, entry *cacheEntry) error {
+	data, err := encodeCacheEntry(entry)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// cleanCacheDir removes the temporary files left in dir by writes that
+// were interrupted.
+func cleanCacheDir(dir string) error {
+	temps, err := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	if err != nil {
+		return err
+	}
+	for _, temp := range temps {
+		if err := os.Remove(temp); err != nil {
+			return fmt.Errorf("cannot clean cache directory: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var cacheEntryTests = []*cacheEntry{
+	{Header: map[string]string{}, Data: []byte{}},
+	{Header: map[string]string{"type": "image/png"}, Data: []byte("\x89PNG\r\n\x1a\n")},
+	{Header: map[string]string{"a": "1", "b": "two words"}, Data: []byte("line\n\nafter an empty line\n")},
+}
+
+func TestCacheEntryRoundTrip(t *testing.T) {
+	for _, entry := range cacheEntryTests {
+		data, err := encodeCacheEntry(entry)
+		if err != nil {
+			t.Fatalf("encodeCacheEntry(%v) failed: %v", entry.Header, err)
+		}
+		decoded, err := decodeCacheEntry(data)
+		if err != nil {
+			t.Fatalf("decodeCacheEntry failed for %v: %v", entry.Header, err)
+		}
+		if !reflect.DeepEqual(decoded.Header, entry.Header) || !bytes.Equal(decoded.Data, entry.Data) {
+			t.Errorf("decodeCacheEntry returned %v %q, want %v %q", decoded.Header, decoded.Data, entry.Header, entry.Data)
+		}
+	}
+}
+
+func TestCacheEntryInvalidHeader(t *testing.T) {
+	for _, header := range []map[string]string{
+		{"": "x"},
+		{"length": "1"},
+		{"sha256": "x"},
+		{"a:b": "x"},
+		{"a": "x\ny"},
+	} {
+		if _, err := encodeCacheEntry(&cacheEntry{Header: header}); err == nil {
+			t.Errorf("encodeCacheEntry(%v) succeeded", header)
+		}
+	}
+}
+
+func TestCacheEntryTruncated(t *testing.T) {
+	data, err := encodeCacheEntry(cacheEntryTests[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(data); n++ {
+		if _, err := decodeCacheEntry(data[:n]); err == nil {
+			t.Errorf("decodeCacheEntry succeeded with %d of %d bytes", n, len(data))
+		}
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 1
+	if _, err := decodeCacheEntry(corrupt); err == nil {
+		t.Errorf("decodeCacheEntry succeeded with corrupted data")
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapdocs-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "entry")
+	entry := cacheEntryTests[1]
+	if err := writeCacheFile(path, entry); err != nil {
+		t.Fatalf("writeCacheFile failed: %v", err)
+	}
+	read, err := readCacheFile(path)
+	if err != nil {
+		t.Fatalf("readCacheFile failed: %v", err)
+	}
+	if !reflect.DeepEqual(read.Header, entry.Header) || !bytes.Equal(read.Data, entry.Data) {
+		t.Errorf("readCacheFile returned %v %q, want %v %q", read.Header, read.Data, entry.Header, entry.Data)
+	}
+	if temps, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(temps) > 0 {
+		t.Errorf("writeCacheFile left temporary files: %v", temps)
+	}
+
+	// Partial entries are removed and reported as missing.
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, data[:len(data)-1], 0644)
+	if _, err := readCacheFile(path); err == nil {
+		t.Errorf("readCacheFile succeeded with a truncated file")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("readCacheFile kept a truncated file")
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapdocs-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	ioutil.WriteFile(path, []byte(`{"legacy":true}`), 0644)
+	data, err := readStateFile(path)
+	if err != nil || string(data) != `{"legacy":true}` {
+		t.Errorf("readStateFile returned %q, %v for a legacy file", data, err)
+	}
+
+	if err := writeCacheFile(path, &cacheEntry{Data: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = readStateFile(path)
+	if err != nil || string(data) != `{}` {
+		t.Errorf("readStateFile returned %q, %v", data, err)
+	}
+
+	// Corrupted state is kept, unlike cache entries.
+	ioutil.WriteFile(path, []byte(cacheFileMagic+" 1\nlength: 2\nsha256: x\n\n{}"), 0644)
+	if _, err := readStateFile(path); err == nil {
+		t.Errorf("readStateFile succeeded with a corrupted file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("readStateFile removed a corrupted file: %v", err)
+	}
+}
+
+func FuzzDecodeCacheEntry(f *testing.F) {
+	for _, entry := range cacheEntryTests {
+		data, err := encodeCacheEntry(entry)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte(cacheFileMagic + " 1\n\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		entry, err := decodeCacheEntry(data)
+		if err != nil {
+			return
+		}
+		// Whatever decodes must encode back to the same file.
+		encoded, err := encodeCacheEntry(entry)
+		if err != nil {
+			t.Fatalf("cannot encode decoded entry: %v", err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("decoded entry encodes to %q, not %q", encoded, data)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+type category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Parent   int    `json:"parent_category_id"`
+}
+
+var categoryTree struct {
+	mu   sync.Mutex
+	time time.Time
+	byID map[int]*category
+}
+
+// categories returns all forum categories by ID, refreshing them as
+// often as the topic list.
+func categories() map[int]*category {
+	now := time.Now()
+	categoryTree.mu.Lock()
+	defer categoryTree.mu.Unlock()
+	if categoryTree.time.Add(topicCacheTimeout).After(now) {
+		return categoryTree.byID
+	}
+	byID, err := fetchCategories()
+	if err != nil {
+		log.Printf("Cannot refresh categories: %v", err)
+		if categoryTree.time.Add(topicCacheFallback).After(now) {
+			return categoryTree.byID
+		}
+		// Retry on the next call rather than hammering the forum.
+		categoryTree.time = now.Add(time.Minute - topicCacheTimeout)
+		categoryTree.byID = nil
+		return nil
+	}
+	categoryTree.byID = byID
+	categoryTree.time = now
+	return byID
+}
+
+func fetchCategories() (map[int]*category, error) {
+	resp, err := httpClient.Get("https://forum.snapcraft.io/site.json")
+	if err != nil {
+		return nil, fmt.Errorf("cannot obtain categories: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("cannot obtain categories: got %v status", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read categories: %v", err)
+	}
+	var result struct {
+		Categories []*category
+	}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal categories: %v", err)
+	}
+	byID := make(map[int]*category, len(result.Categories))
+	for _, c := range result.Categories {
+		byID[c.ID] = c
+	}
+	return byID, nil
+}
+
+// parentCategory returns the ID of the parent of the category with id,
+// or zero if it's a top-level or unknown category.
+func parentCategory(id int) int {
+	if c, ok := categories()[id]; ok {
+		return c.Parent
+	}
+	return 0
+}
+
+// subcategories returns the direct subcategories of the category with
+// id, in forum order.
+func subcategories(id int) []*category {
+	var subs []*category
+	for _, c := range categories() {
+		if c.Parent == id {
+			subs = append(subs, c)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Position != subs[j].Position {
+			return subs[i].Position < subs[j].Position
+		}
+		return subs[i].ID < subs[j].ID
+	})
+	return subs
+}
+
+// isDocCategory returns whether topics in the category with id are
+// documentation, that is, whether it is one of the documentation
+// categories, including the namespaced ones, or a descendant of one.
+func isDocCategory(id int) bool {
+	for depth := 0; id != 0 && depth < 10; depth++ {
+		for _, category := range docCategories() {
+			if id == category {
+				return true
+			}
+		}
+		id = parentCategory(id)
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ChangeHook posts a JSON event to URL whenever a documentation page is
+// created, updated, or removed, so other systems may react to changes.
+type ChangeHook struct {
+	URL string `json:"url"`
+
+	// Types limits the events posted to the given change types, out of
+	// "created", "updated", and "removed". All are posted by default.
+	Types []string `json:"types"`
+
+	// Secret, if set, signs each event with HMAC-SHA256 in the
+	// X-Snapdocs-Signature header, as "sha256=" and the hex digest.
+	Secret string `json:"secret"`
+}
+
+var changeTypes = []string{"created", "updated", "removed"}
+
+func (h *ChangeHook) init() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid change hook URL %q", h.URL)
+	}
+	for _, t := range h.Types {
+		known := false
+		for _, ct := range changeTypes {
+			known = known || t == ct
+		}
+		if !known {
+			return fmt.Errorf("invalid change type %q for change hook %s", t, h.URL)
+		}
+	}
+	return nil
+}
+
+func (h *ChangeHook) wants(changeType string) bool {
+	if len(h.Types) == 0 {
+		return true
+	}
+	for _, t := range h.Types {
+		if t == changeType {
+			return true
+		}
+	}
+	return false
+}
+
+type changeEvent struct {
+	Type    string    `json:"type"`
+	ID      int       `json:"id"`
+	Slug    string    `json:"slug"`
+	Title   string    `json:"title"`
+	URL     string    `json:"url"`
+	DiffURL string    `json:"diff_url,omitempty"`
+	Added   int       `json:"lines_added,omitempty"`
+	Removed int       `json:"lines_removed,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+func newChangeEvent(changeType string, topic *Topic) *changeEvent {
+	return &changeEvent{
+		Type:  changeType,
+		ID:    topic.ID,
+		Slug:  topic.Slug,
+		Title: topic.Title,
+		URL:   topic.URL(),
+		Time:  time.Now().UTC(),
+	}
+}
+
+// emitTopicChange posts an "updated" event for a topic whose content
+// changed, linking to the forum revision with the differences.
+func emitTopicChange(old, new *Topic) {
+	if len(config.ChangeHooks) == 0 {
+		return
+	}
+	event := newChangeEvent("updated", new)
+	event.DiffURL = revisionURL(new)
+	for _, line := range diffLines(strings.Split(old.Content(), "\n"), strings.Split(new.Content(), "\n")) {
+		switch line.Op {
+		case "+":
+			event.Added++
+		case "-":
+			event.Removed++
+		}
+	}
+	emitChangeEvent(event)
+}
+
+// revisionURL returns the forum URL of the revision that brought topic
+// to its current version, with the differences from the previous one, or
+// an empty string if the topic was never edited.
+func revisionURL(topic *Topic) string {
+	if topic.Post.ID == 0 || topic.Post.Version < 2 {
+		return ""
+	}
+	return fmt.Sprintf("https://forum.snapcraft.io/posts/%d/revisions/%d.json", topic.Post.ID, topic.Post.Version)
+}
+
+// emitListingChanges posts "created" and "removed" events for the
+// topics that appeared in or disappeared from the topic list.
+func emitListingChanges(old, new []*Topic) {
+	if len(config.ChangeHooks) == 0 {
+		return
+	}
+	before := make(map[int]bool, len(old))
+	for _, topic := range old {
+		before[topic.ID] = true
+	}
+	after := make(map[int]bool, len(new))
+	for _, topic := range new {
+		after[topic.ID] = true
+		if !before[topic.ID] {
+			emitChangeEvent(newChangeEvent("created", topic))
+		}
+	}
+	for _, topic := range old {
+		if !after[topic.ID] {
+			emitChangeEvent(newChangeEvent("removed", topic))
+		}
+	}
+}
+
+const (
+	changeHookAttempts = 3
+	changeHookRetry    = 10 * time.Second
+)
+
+func emitChangeEvent(event *changeEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Cannot marshal change event: %v", err)
+		return
+	}
+	for _, h := range config.ChangeHooks {
+		if h.wants(event.Type) {
+			go h.post(data, event)
+		}
+	}
+}
+
+// post sends the event in data to the hook, retrying a few times.
+func (h *ChangeHook) post(data []byte, event *changeEvent) {
+	var err error
+	for attempt := 1; attempt <= changeHookAttempts; attempt++ {
+		if err = h.send(data); err == nil {
+			return
+		}
+		if attempt < changeHookAttempts {
+			time.Sleep(time.Duration(attempt) * changeHookRetry)
+		}
+	}
+	log.Printf("Cannot post %s event for topic %d to %s: %v", event.Type, event.ID, h.URL, err)
+}
+
+func (h *ChangeHook) send(data []byte) error {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(data)
+		req.Header.Set("X-Snapdocs-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("change hook returned %v status", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type clusterMessage struct {
+	// Invalidate is the path of a topic to refresh.
+	Invalidate string `json:"invalidate"`
+}
+
+// clusterPeers returns the base URLs of the other replicas.
+func clusterPeers() []string {
+	var peers []string
+	for _, peer := range strings.Split(*peersFlag, ",") {
+		if peer = strings.TrimSuffix(strings.TrimSpace(peer), "/"); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// broadcastInvalidate asks the other replicas to refresh the topic at
+// path, so they don't serve stale content until their cache expires.
+func broadcastInvalidate(path string) {
+	peers := clusterPeers()
+	if len(peers) == 0 {
+		return
+	}
+	data, err := json.Marshal(&clusterMessage{Invalidate: path})
+	if err != nil {
+		log.Printf("Cannot marshal cluster message: %v", err)
+		return
+	}
+	for _, peer := range peers {
+		go func(peer string) {
+			req, err := http.NewRequest("POST", peer+"/cluster/message", bytes.NewReader(data))
+			if err != nil {
+				log.Printf("Cannot send cluster message to %s: %v", peer, err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer "+*clusterTokenFlag)
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				log.Printf("Cannot send cluster message to %s: %v", peer, err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				log.Printf("Cannot send cluster message to %s: got %v status", peer, resp.StatusCode)
+			}
+		}(peer)
+	}
+}
+
+// broadcastTopicChange tells the other replicas about a topic that
+// changed, so they refresh it and notify their own readers.
+func broadcastTopicChange(old, new *Topic) {
+	broadcastInvalidate(new.String())
+}
+
+// serveClusterMessage handles messages sent by other replicas. Topics
+// invalidated by a peer are fetched again right away when cached, so
+// that changes are detected and announced to the readers of this replica.
+func serveClusterMessage(resp http.ResponseWriter, req *http.Request) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if *clusterTokenFlag == "" || subtle.ConstantTimeCompare([]byte(token), []byte(*clusterTokenFlag)) != 1 {
+		resp.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(resp, req.Body, 1<<16))
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if msg.Invalidate != "" {
+		id, err := topicPathID(msg.Invalidate)
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log.Printf("Peer %s invalidated %s", req.RemoteAddr, msg.Invalidate)
+		forum.Refresh(msg.Invalidate)
+		if forum.Cached(id) != nil {
+			go forum.Topic(msg.Invalidate)
+		}
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
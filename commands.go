@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+)
+
+// runCommand runs the command named in args[0] instead of the server.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "export-index":
+		return exportIndexCommand(args[1:])
+	case "export-static":
+		return exportStaticCommand(args[1:])
+	case "check-nav":
+		return checkNavCommand(args[1:])
+	case "lint":
+		return lintCommand(args[1:])
+	case "spellcheck":
+		return spellcheckCommand(args[1:])
+	case "reindex":
+		return reindexCommand(args[1:])
+	case "route":
+		return routeCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command: %s", args[0])
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Config holds the settings read from the file provided via -config.
+type Config struct {
+	// Variables maps placeholder names used in topic content to their values.
+	Variables map[string]string `json:"variables"`
+
+	// RemoteVariables maps placeholder names to sources of their values.
+	RemoteVariables map[string]*RemoteVariable `json:"remote-variables"`
+
+	// Watchers notify webhooks of changes to selected topics.
+	Watchers []*Watcher `json:"watchers"`
+
+	// ChangeHooks receive JSON events about all documentation changes.
+	ChangeHooks []*ChangeHook `json:"change-hooks"`
+
+	// PageOverrides change how single pages are rendered, by topic ID
+	// or slug.
+	PageOverrides map[string]*PageOverride `json:"page-overrides"`
+
+	// ReferenceTables make the large tables of reference pages navigable,
+	// by topic ID or slug.
+	ReferenceTables map[string]*ReferenceTable `json:"reference-tables"`
+
+	// ContentChecks, if set, hold suspicious page updates for review.
+	ContentChecks *ContentChecks `json:"content-checks"`
+
+	// ContentLimits, if set, bound the size and complexity of pages.
+	ContentLimits *ContentLimits `json:"content-limits"`
+
+	// Lookup maps well-known keys to documentation pages for tools.
+	Lookup *Lookup `json:"lookup"`
+
+	// ReadingProgress shows a reading progress bar on pages and restores
+	// their scroll position when readers navigate back to them.
+	ReadingProgress bool `json:"reading-progress"`
+
+	// Bookmarks lets readers bookmark pages in their browser, listed at
+	// /bookmarks.
+	Bookmarks bool `json:"bookmarks"`
+
+	// Landing, if set, renders a landing page at / instead of the
+	// documentation outline.
+	Landing *Landing `json:"landing"`
+
+	// Rewrites are applied to URLs in topic content after the default ones.
+	Rewrites []*RewriteRule `json:"rewrites"`
+
+	// Strip rules remove boilerplate from topic content.
+	Strip []*StripRule `json:"strip"`
+
+	// Transforms change the elements of topic content matching CSS
+	// selectors, after the strip rules and URL rewrites.
+	Transforms []*TransformRule `json:"transforms"`
+
+	// ImageProxyHosts are additional hosts the image proxy may fetch from.
+	// Entries starting with a dot match any subdomain.
+	ImageProxyHosts []string `json:"image-proxy-hosts"`
+
+	// IndexTitle is the tab title of the main documentation outline.
+	IndexTitle string `json:"index-title"`
+
+	// Indexes are further outline topics shown as tabs in the sidebar.
+	Indexes []*Index `json:"indexes"`
+
+	// AutoNav, if set, generates the main outline from the category.
+	AutoNav *AutoNav `json:"auto-nav"`
+
+	// ErrorReporting, if set, sends errors to an error tracker.
+	ErrorReporting *ErrorReporting `json:"error-reporting"`
+
+	// Disable lists features to turn off: search, refresh, api, exports,
+	// and image-proxy.
+	Disable []string `json:"disable"`
+
+	// Namespaces serve further categories, or the documentation one,
+	// under URL prefixes.
+	Namespaces []*Namespace `json:"namespaces"`
+
+	// LegacyRedirects maps paths of the old docs.snapcraft.io site to
+	// the paths of the topics replacing them.
+	LegacyRedirects map[string]string `json:"legacy-redirects"`
+
+	// APIUsername is the forum user acting with the API key provided
+	// via $DISCOURSE_API_KEY. Defaults to "system".
+	APIUsername string `json:"api-username"`
+
+	// Access, if set, restricts who may read the site.
+	Access *Access `json:"access"`
+
+	// ACMEDNS, if set, makes -acme obtain the certificate via DNS-01
+	// challenges instead of HTTP-01 and TLS-ALPN-01 ones.
+	ACMEDNS *ACMEDNS `json:"acme-dns"`
+
+	// ACMECache, if set, selects where -acme stores certificates.
+	ACMECache *ACMECache `json:"acme-cache"`
+
+	// Certificates maps host names, or wildcards such as "*.example.com",
+	// to certificates served for them instead of the -cert or -acme ones.
+	Certificates map[string]*HostCertificate `json:"certificates"`
+
+	// Languages are translated documentation sets served under /LANG/.
+	Languages []*Language `json:"languages"`
+
+	// SearchAnalyzer, if set, configures stemming, stop words, and
+	// synonyms for the local search index.
+	SearchAnalyzer *SearchAnalyzer `json:"search-analyzer"`
+
+	// LoadShedding limits concurrent requests per route class: "topic",
+	// "search", "admin", "api", "static", or "other".
+	LoadShedding map[string]*RouteLimit `json:"load-shedding"`
+
+	// UpstreamLimits limits the requests sent to the forum for
+	// "interactive" and "crawl" fetches.
+	UpstreamLimits map[string]*UpstreamLimit `json:"upstream-limits"`
+
+	// Mirrors are other instances or static exports to fetch pages from,
+	// in order, when the forum fails and cached copies are too old.
+	Mirrors []*Mirror `json:"mirrors"`
+}
+
+var config Config
+
+// loadConfig reads the configuration file at path into config.
+func loadConfig(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read configuration: %v", err)
+	}
+	var c Config
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal configuration from %s: %v", path, err)
+	}
+	for name, v := range c.RemoteVariables {
+		if v == nil || v.URL == "" {
+			return fmt.Errorf("remote variable %q in %s has no URL", name, path)
+		}
+	}
+	for i, w := range c.Watchers {
+		if w == nil || w.Webhook == "" {
+			return fmt.Errorf("watcher #%d in %s has no webhook", i+1, path)
+		}
+	}
+	for i, h := range c.ChangeHooks {
+		if h == nil || h.URL == "" {
+			return fmt.Errorf("change hook #%d in %s has no URL", i+1, path)
+		}
+		if err := h.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for _, rule := range c.Rewrites {
+		if rule == nil {
+			return fmt.Errorf("empty rewrite rule in %s", path)
+		}
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for _, rule := range c.Strip {
+		if rule == nil {
+			return fmt.Errorf("empty strip rule in %s", path)
+		}
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for _, rule := range c.Transforms {
+		if rule == nil {
+			return fmt.Errorf("empty transform rule in %s", path)
+		}
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for i, idx := range c.Indexes {
+		if idx == nil || idx.Title == "" {
+			return fmt.Errorf("index #%d in %s has no title", i+1, path)
+		}
+		if err := idx.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.AutoNav != nil {
+		if err := c.AutoNav.check(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.ErrorReporting != nil {
+		if err := c.ErrorReporting.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for _, ns := range c.Namespaces {
+		if ns == nil {
+			return fmt.Errorf("empty namespace in %s", path)
+		}
+		if err := ns.check(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for _, l := range c.Languages {
+		if l == nil {
+			return fmt.Errorf("empty language in %s", path)
+		}
+		if err := l.check(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+		c.Namespaces = append(c.Namespaces, l.namespace())
+	}
+	if c.Access != nil {
+		if err := c.Access.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.ACMEDNS != nil {
+		if err := c.ACMEDNS.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.ACMECache != nil {
+		if err := c.ACMECache.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for key, o := range c.PageOverrides {
+		if o == nil {
+			return fmt.Errorf("empty page override for %s in %s", key, path)
+		}
+		if err := o.init(key); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for key, t := range c.ReferenceTables {
+		if t == nil {
+			return fmt.Errorf("empty reference table settings for %s in %s", key, path)
+		}
+		if err := t.init(key); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.ContentChecks != nil {
+		if err := c.ContentChecks.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.ContentLimits != nil {
+		if err := c.ContentLimits.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.Lookup != nil {
+		if err := c.Lookup.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.Landing != nil {
+		if err := c.Landing.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	if c.SearchAnalyzer != nil {
+		if err := c.SearchAnalyzer.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for class, limit := range c.LoadShedding {
+		if limit == nil {
+			return fmt.Errorf("empty load shedding for %s routes in %s", class, path)
+		}
+		if err := limit.init(class); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for kind, limit := range c.UpstreamLimits {
+		if limit == nil {
+			return fmt.Errorf("empty upstream limit for %s fetches in %s", kind, path)
+		}
+		if err := limit.init(kind); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	for i, m := range c.Mirrors {
+		if m == nil || m.URL == "" {
+			return fmt.Errorf("mirror #%d in %s has no URL", i+1, path)
+		}
+		if err := m.init(); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+	}
+	certificates := make(map[string]*HostCertificate)
+	for host, cert := range c.Certificates {
+		if cert == nil || cert.Cert == "" || cert.Key == "" {
+			return fmt.Errorf("certificate for %s in %s needs cert and key", host, path)
+		}
+		if err := cert.init(host); err != nil {
+			return fmt.Errorf("%v in %s", err, path)
+		}
+		certificates[strings.ToLower(host)] = cert
+	}
+	c.Certificates = certificates
+	if err := checkFeatures(c.Disable); err != nil {
+		return fmt.Errorf("%v in %s", err, path)
+	}
+	config = c
+	return nil
+}
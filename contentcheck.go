@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ContentChecks hold updates to pages that look like vandalism or
+// accidents, such as a blanked wiki topic, until an operator approves
+// them. Meanwhile the previous version is served.
+type ContentChecks struct {
+	// MaxShrink is the largest part of the text of a page, in percent,
+	// that an update may remove. Zero disables the check.
+	MaxShrink int `json:"max-shrink"`
+
+	// Structure holds updates removing all headings or code blocks.
+	Structure bool `json:"structure"`
+
+	// Webhook, if set, is told about held updates.
+	Webhook string `json:"webhook"`
+}
+
+func (c *ContentChecks) init() error {
+	if c.MaxShrink < 0 || c.MaxShrink > 100 {
+		return fmt.Errorf("content checks max-shrink must be between 0 and 100")
+	}
+	return nil
+}
+
+var (
+	contentHeading   = regexp.MustCompile(`<h[1-6][\s>]`)
+	contentCodeBlock = regexp.MustCompile(`<pre[\s>]`)
+)
+
+// check returns why the update from old to new should be held, or an
+// empty string if it looks fine.
+func (c *ContentChecks) check(old, new *Topic) string {
+	if c == nil {
+		return ""
+	}
+	oldContent, newContent := old.Content(), new.Content()
+	if c.MaxShrink > 0 {
+		before := utf8.RuneCountInString(plainText(oldContent))
+		after := utf8.RuneCountInString(plainText(newContent))
+		if before > 0 && (before-after)*100 > before*c.MaxShrink {
+			return fmt.Sprintf("the text shrank by %d%%", (before-after)*100/before)
+		}
+	}
+	if c.Structure {
+		if contentHeading.MatchString(oldContent) && !contentHeading.MatchString(newContent) {
+			return "all headings were removed"
+		}
+		if contentCodeBlock.MatchString(oldContent) && !contentCodeBlock.MatchString(newContent) {
+			return "all code blocks were removed"
+		}
+	}
+	return ""
+}
+
+// heldChange is an update to a page held for review.
+type heldChange struct {
+	Old      *Topic
+	New      *Topic
+	Reason   string
+	Time     time.Time
+	Rejected bool
+}
+
+var heldChanges struct {
+	mu   sync.Mutex
+	byID map[int]*heldChange
+}
+
+// holdChange records that the update from old to new is held, telling
+// the operators unless that version was held already.
+func holdChange(old, new *Topic, reason string) {
+	heldChanges.mu.Lock()
+	defer heldChanges.mu.Unlock()
+	if h, ok := heldChanges.byID[new.ID]; ok && h.New.Post.Version == new.Post.Version {
+		h.New = new
+		return
+	}
+	if heldChanges.byID == nil {
+		heldChanges.byID = make(map[int]*heldChange)
+	}
+	heldChanges.byID[new.ID] = &heldChange{Old: old, New: new, Reason: reason, Time: time.Now()}
+
+	log.Printf("Holding update to %s for review: %s.", new, reason)
+	if webhook := config.ContentChecks.Webhook; webhook != "" {
+		text := fmt.Sprintf("An update to %s was held for review because %s.", new.Title, reason)
+		if *baseURLFlag != "" {
+			text += "\n" + strings.TrimSuffix(*baseURLFlag, "/") + "/admin/held"
+		}
+		go func() {
+			if err := postWebhook(webhook, text); err != nil {
+				log.Printf("Cannot notify of held update to %s: %v", new, err)
+			}
+		}()
+	}
+}
+
+// releaseChange forgets any update held for the topic with id, as a
+// later version was accepted.
+func releaseChange(id int) {
+	heldChanges.mu.Lock()
+	delete(heldChanges.byID, id)
+	heldChanges.mu.Unlock()
+}
+
+// approveChange caches the update held for the topic with id.
+func (f *Forum) approveChange(id int) bool {
+	heldChanges.mu.Lock()
+	h, ok := heldChanges.byID[id]
+	delete(heldChanges.byID, id)
+	heldChanges.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	f.mu.Lock()
+	cache, ok := f.cache[id]
+	f.mu.Unlock()
+	if !ok {
+		return false
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if old := cache.topic; old != nil {
+		go notifyTopicChange(old, h.New)
+	}
+	cache.store(h.New)
+	return true
+}
+
+// rejectChange keeps serving the previous version of the topic with id
+// until the forum has a newer version than the held one.
+func rejectChange(id int) bool {
+	heldChanges.mu.Lock()
+	defer heldChanges.mu.Unlock()
+	h, ok := heldChanges.byID[id]
+	if ok {
+		h.Rejected = true
+	}
+	return ok
+}
+
+var adminHeldPattern = regexp.MustCompile("^/admin/held/([0-9]+)$")
+
+// serveHeld lists the updates held for review, and approves or rejects
+// one when posted to.
+func serveHeld(resp http.ResponseWriter, req *http.Request) {
+	if m := adminHeldPattern.FindStringSubmatch(req.URL.Path); m != nil && req.Method == "POST" {
+		id, _ := strconv.Atoi(m[1])
+		var ok bool
+		switch req.Form.Get("action") {
+		case "approve":
+			audit.Record(req, "approve-update", "/"+m[1])
+			ok = forum.approveChange(id)
+		case "reject":
+			audit.Record(req, "reject-update", "/"+m[1])
+			ok = rejectChange(id)
+		}
+		if !ok {
+			sendNotFound(resp, "No update held for page %d.", id)
+			return
+		}
+		resp.Header().Set("Location", "/admin/held")
+		resp.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	if req.URL.Path != "/admin/held" {
+		sendNotFound(resp, "Invalid held updates path: %s", req.URL.Path)
+		return
+	}
+
+	var held []*heldChange
+	heldChanges.mu.Lock()
+	for _, h := range heldChanges.byID {
+		if !h.Rejected {
+			held = append(held, h)
+		}
+	}
+	heldChanges.mu.Unlock()
+	sort.Slice(held, func(i, j int) bool { return held[i].Time.After(held[j].Time) })
+
+	var buf strings.Builder
+	err := heldTemplate.Execute(&buf, held)
+	if err != nil {
+		log.Printf("Cannot execute held updates template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Held updates", Content: buf.String()})
+}
+
+var heldTemplate = template.Must(template.New("held").Funcs(pageFuncs).Parse(`
+<p>Updates to pages that look like vandalism or accidents are held here, and the previous version is served until they are approved.
+Rejected updates stay held until the page is edited again on the forum.</p>
+{{if .}}
+<table class="traffic">
+<thead><tr><th>Page</th><th>Held</th><th>Reason</th><th></th></tr></thead>
+<tbody>
+{{range .}}<tr><td><a href="{{.Old}}">{{.Old.Title}}</a></td><td>{{formatTime .Time}}</td><td>{{.Reason}} (<a href="/admin/diff/{{.New.ID}}">changes</a>)</td>
+<td><form method="post" action="/admin/held/{{.New.ID}}"><button type="submit" name="action" value="approve">Approve</button> <button type="submit" name="action" value="reject">Reject</button></form></td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>No updates are held.</p>
+{{end}}
+`))
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crawlFullInterval is how often categories are listed in full, which
+// notices topics that were deleted or moved away.
+const crawlFullInterval = 24 * time.Hour
+
+// crawledCategory is what the last listings of a category found. Forum
+// listings are ordered by when topics were last bumped, so later
+// listings stop at the first page reaching the watermark.
+type crawledCategory struct {
+	Topics    []*Topic
+	Watermark time.Time // Latest bumped_at of the listed topics.
+	Full      time.Time // When the category was last listed in full.
+	Listed    time.Time // When the category was last listed at all.
+	Pages     int       // Pages fetched by the last listing.
+	Error     string    `json:",omitempty"`
+}
+
+var crawlState struct {
+	mu         sync.Mutex
+	categories map[int]*crawledCategory
+	pages      map[string]int64 // Listing pages fetched, by "full" or "incremental".
+}
+
+// listCategory lists the topics in category. Once it was listed in full,
+// only the pages with topics bumped since are fetched and merged with
+// the known topics, until a full listing is due again.
+func listCategory(category int) ([]*Topic, error) {
+	crawlState.mu.Lock()
+	known := crawlState.categories[category]
+	crawlState.mu.Unlock()
+
+	incremental := known != nil && time.Since(known.Full) < crawlFullInterval
+	var listed []*Topic
+	var pages int
+	complete := false
+	for page := 0; page < categoryMaxPages; page++ {
+		list, more, err := fetchCategoryPage(category, page)
+		if err != nil {
+			crawlState.mu.Lock()
+			if known != nil {
+				known.Error = err.Error()
+			}
+			crawlState.mu.Unlock()
+			return nil, err
+		}
+		pages++
+		listed = append(listed, list...)
+		if !more {
+			complete = true
+			break
+		}
+		if incremental && reachedWatermark(list, known.Watermark) {
+			break
+		}
+	}
+
+	kind := "incremental"
+	if complete || !incremental {
+		kind = "full"
+	}
+	log.Printf("Listed %d topics in %s of category %d (%s).", len(listed), pluralize(pages, "page"), category, kind)
+
+	topics := listed
+	if kind == "incremental" {
+		seen := make(map[int]bool, len(listed))
+		for _, topic := range listed {
+			seen[topic.ID] = true
+		}
+		for _, topic := range known.Topics {
+			if !seen[topic.ID] {
+				topics = append(topics, topic)
+			}
+		}
+	}
+
+	now := time.Now()
+	c := &crawledCategory{Topics: topics, Listed: now, Pages: pages}
+	if kind == "full" {
+		c.Full = now
+	} else {
+		c.Full = known.Full
+	}
+	for _, topic := range topics {
+		if topic.BumpedAt.After(c.Watermark) {
+			c.Watermark = topic.BumpedAt
+		}
+	}
+
+	crawlState.mu.Lock()
+	if crawlState.categories == nil {
+		crawlState.categories = make(map[int]*crawledCategory)
+		crawlState.pages = make(map[string]int64)
+	}
+	crawlState.categories[category] = c
+	crawlState.pages[kind] += int64(pages)
+	crawlState.mu.Unlock()
+
+	if *crawlStateFlag != "" {
+		if err := saveCrawlState(*crawlStateFlag); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return topics, nil
+}
+
+// reachedWatermark reports whether list has a topic bumped no later than
+// watermark. Pinned topics are listed first regardless, so they don't
+// count.
+func reachedWatermark(list []*Topic, watermark time.Time) bool {
+	for _, topic := range list {
+		if !topic.Pinned && !topic.BumpedAt.After(watermark) {
+			return true
+		}
+	}
+	return false
+}
+
+// forceFullCrawl makes the next listing of every category a full one.
+func forceFullCrawl() {
+	crawlState.mu.Lock()
+	for _, c := range crawlState.categories {
+		c.Full = time.Time{}
+	}
+	crawlState.mu.Unlock()
+}
+
+type crawlStateDump struct {
+	Categories map[int]*crawledCategory
+}
+
+func loadCrawlState(path string) error {
+	entry, err := readCacheFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		// Corrupted files are removed, and built again.
+		log.Printf("%v", err)
+		return nil
+	}
+	var dump crawlStateDump
+	err = json.Unmarshal(entry.Data, &dump)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal crawl state from %s: %v", path, err)
+	}
+	crawlState.mu.Lock()
+	crawlState.categories = dump.Categories
+	if crawlState.categories == nil {
+		crawlState.categories = make(map[int]*crawledCategory)
+	}
+	crawlState.pages = make(map[string]int64)
+	crawlState.mu.Unlock()
+	return nil
+}
+
+func saveCrawlState(path string) error {
+	crawlState.mu.Lock()
+	data, err := json.Marshal(&crawlStateDump{Categories: crawlState.categories})
+	crawlState.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot marshal crawl state: %v", err)
+	}
+	err = writeCacheFile(path, &cacheEntry{Data: data})
+	if err != nil {
+		return fmt.Errorf("cannot write crawl state: %v", err)
+	}
+	return nil
+}
+
+type crawlStatus struct {
+	ID   int
+	Name string
+	crawledCategory
+}
+
+// crawlStatuses returns what the last listings of each documentation
+// category found, in category order.
+func crawlStatuses() []*crawlStatus {
+	crawlState.mu.Lock()
+	defer crawlState.mu.Unlock()
+	var statuses []*crawlStatus
+	for id, c := range crawlState.categories {
+		s := &crawlStatus{ID: id, crawledCategory: *c}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// writeCrawlMetrics writes the crawl metrics in the Prometheus text
+// format.
+func writeCrawlMetrics(buf *strings.Builder) {
+	statuses := crawlStatuses()
+	crawlState.mu.Lock()
+	full, incremental := crawlState.pages["full"], crawlState.pages["incremental"]
+	crawlState.mu.Unlock()
+
+	buf.WriteString("# HELP snapdocs_crawl_topics Topics known in each documentation category.\n")
+	buf.WriteString("# TYPE snapdocs_crawl_topics gauge\n")
+	for _, s := range statuses {
+		fmt.Fprintf(buf, "snapdocs_crawl_topics{category=\"%d\"} %d\n", s.ID, len(s.Topics))
+	}
+	buf.WriteString("# HELP snapdocs_crawl_watermark_seconds Time the latest topic listed in each category was bumped.\n")
+	buf.WriteString("# TYPE snapdocs_crawl_watermark_seconds gauge\n")
+	for _, s := range statuses {
+		fmt.Fprintf(buf, "snapdocs_crawl_watermark_seconds{category=\"%d\"} %d\n", s.ID, s.Watermark.Unix())
+	}
+	buf.WriteString("# HELP snapdocs_crawl_listed_seconds Time each category was last listed.\n")
+	buf.WriteString("# TYPE snapdocs_crawl_listed_seconds gauge\n")
+	for _, s := range statuses {
+		fmt.Fprintf(buf, "snapdocs_crawl_listed_seconds{category=\"%d\"} %d\n", s.ID, s.Listed.Unix())
+	}
+	buf.WriteString("# HELP snapdocs_crawl_pages_total Topic list pages fetched, by full or incremental listing.\n")
+	buf.WriteString("# TYPE snapdocs_crawl_pages_total counter\n")
+	fmt.Fprintf(buf, "snapdocs_crawl_pages_total{kind=\"full\"} %d\n", full)
+	fmt.Fprintf(buf, "snapdocs_crawl_pages_total{kind=\"incremental\"} %d\n", incremental)
+}
+
+func serveCrawlStatus(resp http.ResponseWriter, req *http.Request) {
+	statuses := crawlStatuses()
+	names := categories()
+	for _, s := range statuses {
+		if c, ok := names[s.ID]; ok {
+			s.Name = c.Name
+		}
+	}
+	var buf strings.Builder
+	err := crawlStatusTemplate.Execute(&buf, statuses)
+	if err != nil {
+		log.Printf("Cannot execute crawl status template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Crawl status", Content: buf.String()})
+}
+
+var crawlStatusTemplate = template.Must(template.New("crawl").Funcs(pageFuncs).Parse(`
+<p>Documentation categories are listed in full once a day, and otherwise only up to the topics bumped since the last listing.</p>
+{{if .}}
+<table class="traffic">
+<thead><tr><th>Category</th><th>Topics</th><th>Latest bump</th><th>Last listed</th><th>Last full listing</th><th>Pages</th></tr></thead>
+<tbody>
+{{range .}}<tr><td>{{or .Name .ID}}</td><td>{{len .Topics}}</td><td>{{.Watermark.Format "2006-01-02 15:04 MST"}}</td><td>{{.Listed.Format "2006-01-02 15:04 MST"}}{{if .Error}} (the next listing failed: {{.Error}}){{end}}</td><td>{{if .Full.IsZero}}due{{else}}{{.Full.Format "2006-01-02 15:04 MST"}}{{end}}</td><td>{{.Pages}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>No categories were listed yet.</p>
+{{end}}
+`))
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type diffLine struct {
+	Op   string // " ", "-", or "+"
+	Text string
+}
+
+// The line diff below takes quadratic time, so beyond this many lines of
+// difference it gives up and reports everything as replaced.
+const diffMaxLines = 4000
+
+// diffLines returns a line-based diff turning a into b.
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{" ", a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffLine{{" ", a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	var middle []diffLine
+	if len(a) > diffMaxLines || len(b) > diffMaxLines {
+		middle = diffReplace(a, b, nil)
+	} else {
+		middle = diffSplit(a, b, nil)
+	}
+	return append(append(prefix, middle...), suffix...)
+}
+
+// diffReplace appends to out a diff removing all of a and adding all of b.
+func diffReplace(a, b []string, out []diffLine) []diffLine {
+	for _, line := range a {
+		out = append(out, diffLine{"-", line})
+	}
+	for _, line := range b {
+		out = append(out, diffLine{"+", line})
+	}
+	return out
+}
+
+// diffSplit appends to out a minimal diff turning a into b. It takes
+// space linear in the lines compared, by splitting a in halves and b
+// where the longest common subsequences of the halves meet (Hirschberg's
+// algorithm).
+func diffSplit(a, b []string, out []diffLine) []diffLine {
+	if len(a) == 0 || len(b) == 0 {
+		return diffReplace(a, b, out)
+	}
+	if len(a) == 1 {
+		for j, line := range b {
+			if line == a[0] {
+				out = diffReplace(nil, b[:j], out)
+				out = append(out, diffLine{" ", line})
+				return diffReplace(nil, b[j+1:], out)
+			}
+		}
+		return diffReplace(a, b, out)
+	}
+	mid := len(a) / 2
+	head := lcsLengths(a[:mid], b, false)
+	tail := lcsLengths(a[mid:], b, true)
+	split := 0
+	for j := range head {
+		if head[j]+tail[len(b)-j] > head[split]+tail[len(b)-split] {
+			split = j
+		}
+	}
+	out = diffSplit(a[:mid], b[:split], out)
+	return diffSplit(a[mid:], b[split:], out)
+}
+
+// lcsLengths returns the lengths of the longest common subsequences of a
+// and each prefix of b, by prefix length, or of their suffixes, by
+// suffix length, if backward is set.
+func lcsLengths(a, b []string, backward bool) []int {
+	at := func(s []string, i int) string {
+		if backward {
+			return s[len(s)-1-i]
+		}
+		return s[i]
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if at(a, i) == at(b, j) {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] >= cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
+
+type diffData struct {
+	Cached  *Topic
+	Live    *Topic
+	Lines   []diffLine
+	Changed bool
+}
+
+// serveDiff renders the difference between the cached content of the
+// topic at path and its live content on the forum.
+func serveDiff(resp http.ResponseWriter, req *http.Request, path string) {
+	id, err := topicPathID(path)
+	if err != nil {
+		sendNotFound(resp, "Invalid topic path: %s", path)
+		return
+	}
+
+	live, err := fetchTopic(context.Background(), path)
+	if err != nil {
+		log.Printf("Cannot obtain live content of %s for diff: %v", path, err)
+		resp.WriteHeader(http.StatusBadGateway)
+		resp.Write([]byte(err.Error()))
+		return
+	}
+
+	data := &diffData{
+		Cached: forum.Cached(id),
+		Live:   live,
+	}
+	var cached string
+	if data.Cached != nil {
+		cached = data.Cached.Content()
+	}
+	data.Lines = diffLines(strings.Split(cached, "\n"), strings.Split(live.Content(), "\n"))
+	for _, line := range data.Lines {
+		if line.Op != " " {
+			data.Changed = true
+			break
+		}
+	}
+
+	var buf bytes.Buffer
+	err = diffTemplate.Execute(&buf, data)
+	if err != nil {
+		log.Printf("Cannot execute diff template: %v", err)
+	}
+
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Changes to " + live.Title, Content: buf.String()})
+}
+
+var diffTemplate = template.Must(template.New("diff").Funcs(pageFuncs).Parse(`
+<style>
+.diff { max-height: none; }
+.diff .del { background-color: #fdd; }
+.diff .add { background-color: #dfd; }
+</style>
+<p>
+{{if .Cached}}Cached copy last updated on {{formatTime .Cached.LastUpdate}}{{else}}The topic is not cached{{end}};
+live copy last updated on {{formatTime .Live.LastUpdate}}.
+{{if not .Changed}}<strong>There are no changes.</strong>{{end}}
+</p>
+<pre class="diff">{{range .Lines}}{{if eq .Op "-"}}<div class="del">- {{.Text}}</div>{{else if eq .Op "+"}}<div class="add">+ {{.Text}}</div>{{else}}<div>  {{.Text}}</div>{{end}}{{end}}</pre>
+<form method="POST" action="/refresh"><input type="hidden" name="path" value="{{.Live}}"><button type="submit" class="btn btn-default">Refresh the cached copy</button> and view the page.</form>
+`))
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const digestPeriod = 7 * 24 * time.Hour
+
+type digestEntry struct {
+	Topic   *Topic
+	Created bool
+	Author  string
+
+	// Revisions, Added, and Removed measure the changes made in the
+	// period, if the version at its start is known, as set by Known.
+	Known     bool
+	Revisions int
+	Added     int
+	Removed   int
+}
+
+// measure compares the topic with its version at the start of the digest
+// period, which new topics didn't have, and older ones may have in their
+// snapshots.
+func (e *digestEntry) measure(since time.Time) {
+	var base string
+	version := 0
+	if !e.Created {
+		snapshot := snapshots.before(e.Topic.ID, since)
+		if snapshot == nil {
+			return
+		}
+		base, version = snapshot.Content, snapshot.Version
+	}
+	e.Known = true
+	e.Revisions = e.Topic.Post.Version - version
+	for _, line := range diffLines(strings.Split(base, "\n"), strings.Split(e.Topic.Content(), "\n")) {
+		switch line.Op {
+		case "+":
+			e.Added++
+		case "-":
+			e.Removed++
+		}
+	}
+}
+
+type digest struct {
+	Since   time.Time
+	Until   time.Time
+	Entries []*digestEntry
+}
+
+// buildDigest summarizes the documentation topics created or updated
+// in the digest period before until.
+//
+// Topics bumped or created in the period are fetched, while other topics
+// are only considered if they happen to be cached, so that building the
+// digest doesn't trigger a fetch of every single topic in the category.
+// Changes to topics created before the period can only be measured when
+// -snapshots keeps their version from its start. Fetches are limited by
+// budget, past which cached copies are used.
+func buildDigest(until time.Time, budget *upstreamBudget) (*digest, error) {
+	topics, err := forum.TopicsWithin(budget)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &digest{
+		Since: until.Add(-digestPeriod),
+		Until: until,
+	}
+
+	for _, listed := range topics {
+		if listed.ID == indexPageID {
+			continue
+		}
+		topic := forum.Cached(listed.ID)
+		if listed.BumpedAt.After(d.Since) || listed.CreatedAt.After(d.Since) {
+			fetched, err := forum.TopicWithin(listed.String(), budget)
+			if err != nil && err != errUpstreamBudget {
+				log.Printf("Cannot obtain %s for digest: %v", listed, err)
+			}
+			if err != nil {
+				continue
+			}
+			topic = fetched
+		}
+		if topic == nil || topic.Post == nil || !topic.LastUpdate().After(d.Since) {
+			continue
+		}
+		entry := &digestEntry{
+			Topic:   topic,
+			Created: listed.CreatedAt.After(d.Since),
+			Author:  topic.Post.Username,
+		}
+		entry.measure(d.Since)
+		d.Entries = append(d.Entries, entry)
+	}
+
+	sort.Slice(d.Entries, func(i, j int) bool {
+		return d.Entries[i].Topic.LastUpdate().After(d.Entries[j].Topic.LastUpdate())
+	})
+
+	return d, nil
+}
+
+func serveDigest(resp http.ResponseWriter, req *http.Request) {
+	d, err := buildDigest(time.Now(), requestBudget(req))
+	if err != nil {
+		log.Printf("Cannot send %s to %s: %v", req.URL, req.RemoteAddr, err)
+		resp.Header().Set("Location", "/")
+		resp.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = digestTemplate.Execute(&buf, d)
+	if err != nil {
+		log.Printf("Cannot execute digest template: %v", err)
+	}
+
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Documentation digest", Content: buf.String()})
+}
+
+var digestTemplate = template.Must(template.New("digest").Funcs(pageFuncs).Parse(`
+<p>Documentation changes from {{formatTime .Since}} until {{formatTime .Until}}.</p>
+{{if .Entries}}
+<table class="digest">
+<thead><tr><th>Page</th><th>Change</th><th>Author</th><th>Revisions</th><th>Lines</th><th>Last update</th></tr></thead>
+<tbody>
+{{range .Entries}}
+<tr>
+<td><a href="{{.Topic}}">{{.Topic.Title}}</a></td>
+<td>{{if .Created}}New{{else}}Updated{{end}}</td>
+<td>{{.Author}}</td>
+{{if .Known}}<td>{{.Revisions}}</td>
+<td>+{{.Added}} &minus;{{.Removed}}</td>{{else}}<td colspan="2">Unknown</td>{{end}}
+<td>{{formatTime .Topic.LastUpdate}}</td>
+</tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>No documentation pages were changed in this period.</p>
+{{end}}
+`))
+
+// digestText formats the digest as a plain text message suitable for
+// chat and mailing list webhooks.
+func digestText(d *digest) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Documentation digest for %s to %s\n", d.Since.Format("2006-01-02"), d.Until.Format("2006-01-02"))
+	if len(d.Entries) == 0 {
+		buf.WriteString("\nNo documentation pages were changed.\n")
+	}
+	for _, e := range d.Entries {
+		change := "updated"
+		if e.Created {
+			change = "created"
+		}
+		if e.Known {
+			change += fmt.Sprintf(" by %s, %s, %d lines added and %d removed", e.Author, pluralize(e.Revisions, "revision"), e.Added, e.Removed)
+		} else {
+			change += " by " + e.Author
+		}
+		fmt.Fprintf(&buf, "\n- %s (%s)\n  %s\n", e.Topic.Title, change, e.Topic.URL())
+	}
+	return buf.String()
+}
+
+// postDigests posts a digest to the webhook every Monday morning.
+func postDigests(webhook string) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), 8, 0, 0, 0, time.UTC)
+		for next.Weekday() != time.Monday || !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(next.Sub(now))
+
+		d, err := buildDigest(next, nil)
+		if err != nil {
+			log.Printf("Cannot build documentation digest: %v", err)
+			continue
+		}
+		err = postWebhook(webhook, digestText(d))
+		if err != nil {
+			log.Printf("Cannot post documentation digest: %v", err)
+			continue
+		}
+		log.Printf("Posted documentation digest with %d entries.", len(d.Entries))
+	}
+}
+
+// postWebhook posts text to a chat-style webhook, as a JSON object with
+// a "text" field which is understood by the common chat systems.
+func postWebhook(url, text string) error {
+	data, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %v status", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// docSearchRecord is a record in the format produced by the Algolia
+// DocSearch scraper, so the mirror may be plugged into DocSearch front-ends.
+type docSearchRecord struct {
+	ObjectID         string             `json:"objectID"`
+	URL              string             `json:"url"`
+	URLWithoutAnchor string             `json:"url_without_anchor"`
+	Anchor           *string            `json:"anchor"`
+	Content          *string            `json:"content"`
+	Type             string             `json:"type"`
+	Hierarchy        map[string]*string `json:"hierarchy"`
+	Weight           docSearchWeight    `json:"weight"`
+	Lang             string             `json:"lang"`
+}
+
+type docSearchWeight struct {
+	PageRank int `json:"pageRank"`
+	Level    int `json:"level"`
+	Position int `json:"position"`
+}
+
+var (
+	docSearchHeading = regexp.MustCompile(`(?s)<h([1-6])[^>]*>(.*?)</h[1-6]>`)
+	docSearchText    = regexp.MustCompile(`(?s)<(?:p|li|td)\b[^>]*>(.*?)</(?:p|li|td)>`)
+)
+
+// docSearchRecords returns the DocSearch records for topic, which is
+// listed under the given outline section.
+func docSearchRecords(topic *Topic, section string) []*docSearchRecord {
+	if section == "" {
+		section = "Documentation"
+	}
+	var records []*docSearchRecord
+	url := topic.URL()
+
+	// Levels 0 and 1 are the outline section and the topic title.
+	hierarchy := [7]string{section, topic.Title}
+	level := 1
+	var anchor string
+
+	add := func(kind string, content string) {
+		r := &docSearchRecord{
+			ObjectID:         fmt.Sprintf("%d-%d", topic.ID, len(records)),
+			URL:              url,
+			URLWithoutAnchor: url,
+			Type:             kind,
+			Hierarchy:        make(map[string]*string),
+			Weight:           docSearchWeight{Level: 100 - level*10, Position: len(records)},
+			Lang:             "en",
+		}
+		if kind == "content" {
+			r.Content = &content
+			r.Weight.Level = 0
+		}
+		if anchor != "" {
+			a := anchor
+			r.Anchor = &a
+			r.URL = url + "#" + anchor
+		}
+		for i := range hierarchy {
+			var value *string
+			if i <= level && hierarchy[i] != "" {
+				v := hierarchy[i]
+				value = &v
+			}
+			r.Hierarchy["lvl"+strconv.Itoa(i)] = value
+		}
+		records = append(records, r)
+	}
+
+	add("lvl1", "")
+
+	content := topic.Content()
+	headings := docSearchHeading.FindAllStringSubmatchIndex(content, -1)
+	last := 0
+	for i := 0; i <= len(headings); i++ {
+		end := len(content)
+		if i < len(headings) {
+			end = headings[i][0]
+		}
+		for _, m := range docSearchText.FindAllStringSubmatch(content[last:end], -1) {
+			if text := plainText(m[1]); text != "" {
+				add("content", text)
+			}
+		}
+		if i == len(headings) {
+			break
+		}
+		h := headings[i]
+		last = h[1]
+		// Topic content headings start at h1, while the title takes level 1.
+		level, _ = strconv.Atoi(content[h[2]:h[3]])
+		level++
+		if level > 6 {
+			level = 6
+		}
+		hierarchy[level] = plainText(content[h[4]:h[5]])
+		for j := level + 1; j < len(hierarchy); j++ {
+			hierarchy[j] = ""
+		}
+		anchor = ""
+		if a := headingAnchor.FindStringSubmatch(content[h[4]:h[5]]); a != nil {
+			anchor = a[1]
+		}
+		add("lvl"+strconv.Itoa(level), "")
+	}
+	return records
+}
+
+// docSearchIndex fetches every documentation topic and returns their
+// DocSearch records.
+func docSearchIndex() ([]*docSearchRecord, error) {
+	topics, err := forum.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	sections := outlineSectionTitles(nil)
+
+	var records []*docSearchRecord
+	for _, listed := range topics {
+		if listed.ID == indexPageID {
+			continue
+		}
+		topic, err := forum.Topic(listed.String())
+		if err != nil {
+			log.Printf("Cannot index %s: %v", listed, err)
+			continue
+		}
+		records = append(records, docSearchRecords(topic, sections[topic.ID])...)
+	}
+	return records, nil
+}
+
+func exportIndexCommand(args []string) error {
+	flags := flag.NewFlagSet("export-index", flag.ExitOnError)
+	format := flags.String("format", "docsearch", "Format of the exported index (only docsearch for now)")
+	output := flags.String("o", "", "Write the index to the given file instead of standard output")
+	push := flags.Bool("push", false, "Push the index to Algolia (see -algolia-app and -algolia-index)")
+	every := flags.Duration("every", 0, "Keep running and push the index again at the given interval")
+	algoliaApp := flags.String("algolia-app", "", "Algolia application ID to push the index to")
+	algoliaIndex := flags.String("algolia-index", "", "Algolia index name to push the index to")
+	flags.Parse(args)
+
+	if *format != "docsearch" {
+		return fmt.Errorf("unsupported index format: %s", *format)
+	}
+	if *baseURLFlag == "" {
+		return fmt.Errorf("export-index requires -base-url")
+	}
+	if *every > 0 && !*push {
+		return fmt.Errorf("cannot use -every without -push")
+	}
+	algoliaKey := os.Getenv("ALGOLIA_API_KEY")
+	if *push && (*algoliaApp == "" || *algoliaIndex == "" || algoliaKey == "") {
+		return fmt.Errorf("-push requires -algolia-app, -algolia-index, and $ALGOLIA_API_KEY")
+	}
+
+	for {
+		records, err := docSearchIndex()
+		if err != nil {
+			return err
+		}
+
+		if *output != "" || !*push {
+			data, err := json.MarshalIndent(records, "", "\t")
+			if err != nil {
+				return fmt.Errorf("cannot marshal index: %v", err)
+			}
+			if *output == "" {
+				os.Stdout.Write(data)
+			} else if err := ioutil.WriteFile(*output, data, 0644); err != nil {
+				return fmt.Errorf("cannot write index: %v", err)
+			}
+		}
+
+		if *push {
+			err = pushAlgolia(*algoliaApp, algoliaKey, *algoliaIndex, records)
+			if err != nil && *every == 0 {
+				return err
+			}
+			if err != nil {
+				log.Printf("Cannot push index to Algolia: %v", err)
+			} else {
+				log.Printf("Pushed %d records to Algolia index %s.", len(records), *algoliaIndex)
+			}
+		}
+
+		if *every == 0 {
+			return nil
+		}
+		// Cached topics expire on their own, so there's no need to reset them.
+		time.Sleep(*every)
+	}
+}
+
+// pushAlgolia replaces the content of the Algolia index with records,
+// by filling a temporary index and moving it over the live one.
+func pushAlgolia(app, key, index string, records []*docSearchRecord) error {
+	tmp := index + "_tmp"
+
+	type request struct {
+		Action string           `json:"action"`
+		Body   *docSearchRecord `json:"body"`
+	}
+	const batchSize = 1000
+	for i := 0; i < len(records); i += batchSize {
+		var batch struct {
+			Requests []request `json:"requests"`
+		}
+		end := i + batchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		for _, r := range records[i:end] {
+			batch.Requests = append(batch.Requests, request{"addObject", r})
+		}
+		if err := algoliaPost(app, key, "/1/indexes/"+tmp+"/batch", &batch); err != nil {
+			return err
+		}
+	}
+
+	move := map[string]string{"operation": "move", "destination": index}
+	return algoliaPost(app, key, "/1/indexes/"+tmp+"/operation", move)
+}
+
+func algoliaPost(app, key, path string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", "https://"+app+".algolia.net"+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Algolia-Application-Id", app)
+	req.Header.Set("X-Algolia-API-Key", key)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot post to Algolia: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("cannot post to Algolia: got %v status: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
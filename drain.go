@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// drainShutdownTimeout is how long in-flight requests are given to
+// finish once the servers stop accepting new ones.
+const drainShutdownTimeout = 30 * time.Second
+
+var draining int32
+
+// isDraining returns whether the server is about to shut down, so load
+// balancers should stop sending it requests.
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// drainOnSignal shuts servers down gracefully on SIGTERM or interrupt,
+// sending nil to done once state is saved. First health checks fail for
+// -drain, so load balancers take the server out of rotation while it's
+// still serving.
+func drainOnSignal(servers []*http.Server, done chan<- error) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-sig
+		log.Printf("Draining: failing health checks for %v...", *drainFlag)
+		atomic.StoreInt32(&draining, 1)
+		for _, server := range servers {
+			server.SetKeepAlivesEnabled(false)
+		}
+		time.Sleep(*drainFlag)
+
+		log.Printf("Draining: finishing in-flight requests...")
+		ctx, cancel := context.WithTimeout(context.Background(), drainShutdownTimeout)
+		defer cancel()
+		var wg sync.WaitGroup
+		for _, server := range servers {
+			wg.Add(1)
+			go func(server *http.Server) {
+				defer wg.Done()
+				if err := server.Shutdown(ctx); err != nil {
+					log.Printf("Cannot finish in-flight requests on %s: %v", server.Addr, err)
+				}
+			}(server)
+		}
+		wg.Wait()
+
+		saveState()
+		log.Printf("Drained.")
+		done <- nil
+	}()
+}
+
+// saveState persists what would otherwise be lost on exit.
+func saveState() {
+	if *statsFileFlag != "" {
+		if err := stats.Save(*statsFileFlag); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	if *localSearchFlag && *searchIndexFlag != "" {
+		if err := searchIndex.Save(*searchIndexFlag); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type embedData struct {
+	Topic   *Topic
+	Content string
+	Style   bool
+}
+
+// serveEmbed serves the processed content of a topic alone, without the
+// site navigation, for other sites to show in frames. The minimal styling
+// may be disabled with ?style=none so the embedding site may style it.
+func serveEmbed(resp http.ResponseWriter, req *http.Request) {
+	path, _ := stripNamespace(strings.TrimPrefix(req.URL.Path, "/embed"))
+	if !pagePathPattern.MatchString(path) {
+		sendNotFound(resp, "Invalid page path.")
+		return
+	}
+	budget := requestBudget(req)
+	topic, err := forum.TopicWithin(path, budget)
+	if err != nil && sendForumError(resp, err) {
+		return
+	}
+	if err != nil || !isDocCategory(topic.Category) || topic.ID == indexPageID {
+		sendNotFound(resp, "Documentation page not found.")
+		return
+	}
+
+	if req.Method != "HEAD" {
+		stats.View(topic)
+	}
+
+	if notModified(resp, req, topic.LastUpdate()) {
+		return
+	}
+
+	content := editorsNote.ReplaceAllString(topic.Content(), "")
+	data := &embedData{
+		Topic:   topic,
+		Content: processContent(topic, content, budget),
+		Style:   req.Form.Get("style") != "none",
+	}
+
+	resp.Header().Set("Content-Type", "text/html")
+	resp.Header().Set("Content-Security-Policy", "frame-ancestors "+*embedFlag)
+	err = embedTemplate.Execute(resp, data)
+	if err != nil {
+		log.Printf("Cannot execute embed template: %v", err)
+	}
+}
+
+var embedTemplate = template.Must(template.New("embed").Funcs(pageFuncs).Parse(`<!DOCTYPE html>
+<html lang="{{.Topic.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Topic.Title}} - Snap Docs</title>
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<base target="_blank">
+{{if .Style}}
+<style>
+body {
+	margin: 10px;
+	font-family: Helvetica, Arial, sans-serif;
+	font-size: 14px;
+	line-height: 1.4;
+	color: #333;
+}
+code {
+	background-color: #f5f5f5;
+}
+pre, pre code {
+	font-family: Consolas, Menlo, Monaco, "Liberation Mono", "DejaVu Sans Mono", "Courier New", monospace;
+	overflow: auto;
+}
+img {
+	max-width: 100%;
+}
+img.emoji {
+	width: 20px;
+	height: 20px;
+	vertical-align: top;
+}
+.glossary-term {
+	color: inherit;
+	text-decoration: underline dotted;
+}
+</style>
+{{end}}
+</head>
+<body>
+<article>
+{{html .Content}}
+</article>
+<footer><a href="{{.Topic.URL}}">View the full page</a></footer>
+</body>
+</html>
+`))
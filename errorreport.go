@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorReporting configures sending panics, template failures, and
+// repeated forum errors to a Sentry-compatible error tracker.
+type ErrorReporting struct {
+	DSN         string `json:"dsn"`
+	Environment string `json:"environment"`
+	Release     string `json:"release"` // Defaults to the VCS revision of the build.
+
+	storeURL string
+	key      string
+}
+
+func (r *ErrorReporting) init() error {
+	u, err := url.Parse(r.DSN)
+	if err != nil || u.User == nil || u.Host == "" {
+		return fmt.Errorf("invalid error reporting DSN")
+	}
+	project := strings.Trim(u.Path, "/")
+	if project == "" {
+		return fmt.Errorf("invalid error reporting DSN: missing project")
+	}
+	r.key = u.User.Username()
+	r.storeURL = fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project)
+	if r.Release == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					r.Release = setting.Value
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var reportClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// reportError sends an event about err to the error tracker, if one is
+// configured. The request, if not nil, provides context for the event.
+func reportError(req *http.Request, level string, err error, stack []byte) {
+	r := config.ErrorReporting
+	if r == nil {
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	event := map[string]interface{}{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"level":     level,
+		"logger":    "snapdocs",
+		"platform":  "go",
+		"message":   err.Error(),
+	}
+	if r.Release != "" {
+		event["release"] = r.Release
+	}
+	if r.Environment != "" {
+		event["environment"] = r.Environment
+	}
+	extra := map[string]string{}
+	if stack != nil {
+		extra["stack"] = string(stack)
+	}
+	if req != nil {
+		event["request"] = map[string]interface{}{
+			"url":    siteURL(req, req.URL.Path),
+			"method": req.Method,
+			"query":  req.URL.RawQuery,
+			"headers": map[string]string{
+				"User-Agent": req.UserAgent(),
+				"Referer":    req.Referer(),
+			},
+		}
+		extra["remote_addr"] = req.RemoteAddr
+	}
+	event["extra"] = extra
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Cannot marshal error report: %v", err)
+		return
+	}
+	go func() {
+		post, err := http.NewRequest("POST", r.storeURL, bytes.NewReader(data))
+		if err != nil {
+			log.Printf("Cannot report error: %v", err)
+			return
+		}
+		post.Header.Set("Content-Type", "application/json")
+		post.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=snapdocs/1.0, sentry_key=%s", r.key))
+		resp, err := reportClient.Do(post)
+		if err != nil {
+			log.Printf("Cannot report error: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			log.Printf("Cannot report error: got %v status", resp.StatusCode)
+		}
+	}()
+}
+
+// recoverHandler wraps h so that panics are reported and answered with
+// an internal error rather than a dropped connection.
+func recoverHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				stack := debug.Stack()
+				log.Printf("Panic serving %s to %s: %v\n%s", req.URL, req.RemoteAddr, v, stack)
+				reportError(req, "fatal", fmt.Errorf("panic: %v", v), stack)
+				resp.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		h(resp, req)
+	}
+}
+
+const (
+	upstreamErrorWindow    = 5 * time.Minute
+	upstreamErrorThreshold = 5
+)
+
+// upstreamTransport reports repeated failures talking to the forum, as
+// single failures are common and not worth reporting.
+type upstreamTransport struct {
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	start time.Time
+	count int
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if req.URL.Host != "forum.snapcraft.io" {
+		return resp, err
+	}
+	switch {
+	case err != nil:
+		t.failed(err)
+	case resp.StatusCode >= 500:
+		t.failed(fmt.Errorf("got %v status from %s", resp.StatusCode, req.URL))
+	}
+	return resp, err
+}
+
+func (t *upstreamTransport) failed(err error) {
+	t.mu.Lock()
+	now := time.Now()
+	if t.start.Add(upstreamErrorWindow).Before(now) {
+		t.start = now
+		t.count = 0
+	}
+	t.count++
+	report := t.count == upstreamErrorThreshold
+	t.mu.Unlock()
+	if report {
+		reportError(nil, "error", fmt.Errorf("%d forum errors in %v, latest: %v", upstreamErrorThreshold, upstreamErrorWindow, err), nil)
+	}
+}
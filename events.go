@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// topicChangeHandlers are called whenever a topic is fetched again and
+// its content changed from the previously cached copy.
+var topicChangeHandlers []func(old, new *Topic)
+
+func init() {
+	// Set at init time as the handlers end up calling notifyTopicChange.
+	topicChangeHandlers = []func(old, new *Topic){
+		events.topicChanged,
+		notifyWatchers,
+		broadcastTopicChange,
+		emitTopicChange,
+	}
+}
+
+func notifyTopicChange(old, new *Topic) {
+	log.Printf("Content of %s changed.", new)
+	for _, handler := range topicChangeHandlers {
+		handler(old, new)
+	}
+}
+
+type topicEvent struct {
+	ID    int    `json:"id"`
+	Path  string `json:"path"`
+	Title string `json:"title"`
+}
+
+// eventHub fans topic events out to the /events subscribers.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan *topicEvent]bool
+}
+
+var events eventHub
+
+func (h *eventHub) subscribe() chan *topicEvent {
+	ch := make(chan *topicEvent, 16)
+	h.mu.Lock()
+	if h.subscribers == nil {
+		h.subscribers = make(map[chan *topicEvent]bool)
+	}
+	h.subscribers[ch] = true
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan *topicEvent) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) topicChanged(old, new *Topic) {
+	event := &topicEvent{ID: new.ID, Path: new.String(), Title: new.Title}
+	h.mu.Lock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// Slow subscriber. Rather drop the event than block.
+		}
+	}
+	h.mu.Unlock()
+}
+
+const eventsKeepAlive = 20 * time.Second
+
+// serveEvents streams topic-updated server-sent events, optionally only
+// for the topic ID provided in the "topic" parameter.
+func serveEvents(resp http.ResponseWriter, req *http.Request) {
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var topicID int
+	if s := req.Form.Get("topic"); s != "" {
+		var err error
+		topicID, err = strconv.Atoi(s)
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Method == "HEAD" {
+		resp.Header().Set("Content-Type", "text/event-stream")
+		resp.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+
+	// Streams outlive the server's write timeout.
+	http.NewResponseController(resp).SetWriteDeadline(time.Time{})
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+	fmt.Fprintf(resp, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprintf(resp, ": keep-alive\n\n")
+		case event := <-ch:
+			if topicID != 0 && event.ID != topicID {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(resp, "event: topic-updated\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
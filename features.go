@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// features are the subsystems that may be disabled via the configuration,
+// for deployments that want the smallest possible surface.
+var features = map[string]bool{
+	"search":      true, // The search page, search API, and search widget.
+	"refresh":     true, // The refresh button and query parameter.
+	"api":         true, // The JSON and GraphQL APIs, and badges.
+	"exports":     true, // Corpus exports, page bundles, and mirroring.
+	"image-proxy": true,
+}
+
+func checkFeatures(names []string) error {
+	for _, name := range names {
+		if !features[name] {
+			return fmt.Errorf("cannot disable unknown feature %q", name)
+		}
+	}
+	return nil
+}
+
+// disabled returns whether feature was disabled in the configuration.
+func disabled(feature string) bool {
+	for _, name := range config.Disable {
+		if name == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// pathFeature returns the feature serving path, or an empty string if
+// the path is served regardless of the configuration.
+func pathFeature(path string) string {
+	switch {
+	case path == "/search" || path == "/api/v1/search" || path == "/widget.js":
+		return "search"
+	case strings.HasPrefix(path, "/api/") || path == "/graphql" || strings.HasPrefix(path, "/badge/"):
+		return "api"
+	case path == "/llms.txt" || path == "/llms-full.txt" || strings.HasPrefix(path, "/export/") || strings.HasSuffix(path, ".zip") ||
+		strings.HasPrefix(path, "/mirror/"):
+		return "exports"
+	case path == "/image":
+		return "image-proxy"
+	case path == "/refresh" || path == "/static/refresh.js":
+		return "refresh"
+	}
+	return ""
+}
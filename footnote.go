@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	footnoteRef     = regexp.MustCompile(`(?s)<sup class="footnote-ref">\s*<a href="#([^"]*)"[^>]*>(.*?)</a>\s*</sup>`)
+	footnoteList    = regexp.MustCompile(`(?s)(?:<hr class="footnotes-sep"/?>\s*)?(?:<section class="footnotes">\s*)?<ol class="footnotes-list">(.*?)</ol>(?:\s*</section>)?`)
+	footnoteItem    = regexp.MustCompile(`(?s)<li([^>]*)class="footnote-item"[^>]*>(.*?)</li>`)
+	footnoteID      = regexp.MustCompile(`\bid="([^"]*)"`)
+	footnoteBackref = regexp.MustCompile(`(?s)\s*<a [^>]*class="footnote-backref"[^>]*>.*?</a>`)
+	footnoteNumber  = regexp.MustCompile(`([0-9]+)(?::[0-9]+)?$`)
+)
+
+// renderFootnotes replaces the markup of the Discourse footnote plugin,
+// which relies on scripts on the forum, with plain footnotes listed at
+// the end of the content, each linking back to where it is referenced.
+// Element IDs are derived from post so included pages do not clash.
+func renderFootnotes(content string, post int) string {
+	lists := footnoteList.FindAllStringSubmatch(content, -1)
+	if len(lists) == 0 && !footnoteRef.MatchString(content) {
+		return content
+	}
+
+	// Notes are numbered by their position unless their ID says otherwise.
+	notes := make(map[int]string)
+	var order []int
+	for _, list := range lists {
+		for _, item := range footnoteItem.FindAllStringSubmatch(list[1], -1) {
+			n := len(order) + 1
+			if m := footnoteID.FindStringSubmatch(item[1]); m != nil {
+				if nm := footnoteNumber.FindStringSubmatch(m[1]); nm != nil {
+					n, _ = strconv.Atoi(nm[1])
+				}
+			}
+			if _, ok := notes[n]; ok {
+				continue
+			}
+			notes[n] = strings.TrimSpace(footnoteBackref.ReplaceAllString(item[2], ""))
+			order = append(order, n)
+		}
+	}
+	content = footnoteList.ReplaceAllString(content, "")
+
+	refs := make(map[int]int)
+	content = footnoteRef.ReplaceAllStringFunc(content, func(ref string) string {
+		m := footnoteRef.FindStringSubmatch(ref)
+		nm := footnoteNumber.FindStringSubmatch(m[1])
+		if nm == nil {
+			nm = footnoteNumber.FindStringSubmatch(strings.Trim(plainText(m[2]), "[]"))
+		}
+		if nm == nil {
+			return ref
+		}
+		n, _ := strconv.Atoi(nm[1])
+		if _, ok := notes[n]; !ok {
+			return fmt.Sprintf(`<sup class="footnote-ref">%d</sup>`, n)
+		}
+		refs[n]++
+		return fmt.Sprintf(`<sup class="footnote-ref" id="%s"><a href="#footnote-%d-%d" role="doc-noteref">%d</a></sup>`, footnoteRefID(post, n, refs[n]), post, n, n)
+	})
+	if len(order) == 0 {
+		return content
+	}
+
+	var buf strings.Builder
+	buf.WriteString(content)
+	buf.WriteString("\n<section class=\"footnotes\" role=\"doc-endnotes\">\n<hr>\n<ol>\n")
+	for _, n := range order {
+		// Backlinks go at the end of the last paragraph of the note.
+		note, end := notes[n], ""
+		if strings.HasSuffix(note, "</p>") {
+			note, end = strings.TrimSuffix(note, "</p>"), "</p>"
+		}
+		fmt.Fprintf(&buf, `<li id="footnote-%d-%d" value="%d">%s`, post, n, n, note)
+		for i := 1; i <= refs[n]; i++ {
+			fmt.Fprintf(&buf, ` <a href="#%s" class="footnote-backref" role="doc-backlink" aria-label="Back to reference %d">&#x21a9;&#xfe0e;</a>`, footnoteRefID(post, n, i), n)
+		}
+		buf.WriteString(end + "</li>\n")
+	}
+	buf.WriteString("</ol>\n</section>\n")
+	return buf.String()
+}
+
+// footnoteRefID returns the element ID of the i-th reference to footnote n.
+func footnoteRefID(post, n, i int) string {
+	if i == 1 {
+		return fmt.Sprintf("footnote-ref-%d-%d", post, n)
+	}
+	return fmt.Sprintf("footnote-ref-%d-%d-%d", post, n, i)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ForumErrorKind classifies the failures of forum calls, which call for
+// different status codes, retries, and cache behavior.
+type ForumErrorKind int
+
+const (
+	// Unavailable is a forum that cannot be reached or fails to answer.
+	Unavailable ForumErrorKind = iota
+
+	// NotFound is a page that does not exist or is not public.
+	NotFound
+
+	// RateLimited is a forum refusing calls until RetryAfter passes.
+	RateLimited
+
+	// Malformed is an answer that cannot be understood.
+	Malformed
+)
+
+func (k ForumErrorKind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case RateLimited:
+		return "rate limited"
+	case Malformed:
+		return "malformed"
+	}
+	return "unavailable"
+}
+
+// ForumError is the error returned by Forum.Topic and Forum.Search when
+// the forum fails.
+type ForumError struct {
+	Kind       ForumErrorKind
+	RetryAfter time.Duration // Set by RateLimited errors, if known.
+	Err        error
+}
+
+func (e *ForumError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ForumError) Unwrap() error {
+	return e.Err
+}
+
+// forumErrorf returns a ForumError of the given kind with a formatted
+// message.
+func forumErrorf(kind ForumErrorKind, format string, args ...interface{}) *ForumError {
+	return &ForumError{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// forumStatusError returns the error for an unexpected forum response
+// to the operation described by what.
+func forumStatusError(what string, resp *http.Response) *ForumError {
+	err := forumErrorf(Unavailable, "cannot obtain %s: got %v status", what, resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err.Kind = RateLimited
+		if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs > 0 {
+			err.RetryAfter = time.Duration(secs) * time.Second
+		}
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		err.Kind = NotFound
+	}
+	return err
+}
+
+// IsForumError reports whether err is a ForumError of the given kind.
+func IsForumError(err error, kind ForumErrorKind) bool {
+	var ferr *ForumError
+	return errors.As(err, &ferr) && ferr.Kind == kind
+}
+
+// sendForumError responds to a request that failed on a forum error,
+// reporting whether err was one.
+func sendForumError(resp http.ResponseWriter, err error) bool {
+	var ferr *ForumError
+	if !errors.As(err, &ferr) {
+		return false
+	}
+	switch ferr.Kind {
+	case NotFound:
+		sendNotFound(resp, "Documentation page not found.")
+	case Malformed:
+		resp.WriteHeader(http.StatusBadGateway)
+		resp.Write([]byte("The forum sent an invalid answer. Please try again later."))
+	default:
+		retry := ferr.RetryAfter
+		if retry <= 0 {
+			retry = time.Minute
+		}
+		resp.Header().Set("Retry-After", strconv.Itoa(int((retry+time.Second-1)/time.Second)))
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		resp.Write([]byte("The forum is unavailable. Please try again later."))
+	}
+	return true
+}
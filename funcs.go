@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// truncate shortens s to at most n characters, cutting at a word boundary
+// when possible and appending an ellipsis. The argument order allows
+// using it in pipelines, as in {{.Blurb | truncate 100}}.
+func truncate(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	cut := n
+	for i := n; i > n/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace) + "…"
+}
+
+var slugSeparators = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// slugify returns s in lowercase with runs of anything but letters and
+// digits replaced by a single dash, as used in topic URLs and anchors.
+func slugify(s string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// relTime returns how long ago t was, as in "3 days ago".
+func relTime(t time.Time) string {
+	return ageString(t, time.Now())
+}
+
+// pluralize returns n followed by singular, or by the plural form when n
+// is not one. The plural defaults to singular with an "s" appended.
+func pluralize(n int, singular string, plural ...string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	if len(plural) > 0 {
+		return fmt.Sprintf("%d %s", n, plural[0])
+	}
+	return fmt.Sprintf("%d %ss", n, singular)
+}
+
+// dict builds a map from key and value pairs, for passing several values
+// to a nested template:
+//
+//	{{template "item" dict "Topic" .Topic "Compact" true}}
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict needs an even number of arguments")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %T", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+var (
+	mdHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	mdBullet      = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdNumbered    = regexp.MustCompile(`^\s*[0-9]+[.)]\s+(.*)$`)
+	mdCode        = regexp.MustCompile("`([^`]+)`")
+	mdLink        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdStrong      = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdEmphasis    = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	mdPlaceholder = regexp.MustCompile("\x00([0-9]+)\x00")
+	mdTask        = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+	mdTableDelim  = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	mdAutolink    = regexp.MustCompile(`(^|[\s(])(https?://[^\s<>"]*[^\s<>".,;:!?)])`)
+)
+
+// markdown renders the common subset of Markdown used in short texts such
+// as descriptions and notes: paragraphs, headings, lists, fenced code
+// blocks, and inline code, emphasis, and links. Raw HTML is escaped.
+// Tables, task lists, fenced code languages, and bare URLs are rendered
+// with the same markup as forum content, so that its styles apply.
+func markdown(s string) template.HTML {
+	var buf strings.Builder
+	var para []string
+	list := ""
+	flushPara := func() {
+		if len(para) > 0 {
+			buf.WriteString("<p>" + markdownInline(strings.Join(para, " ")) + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			buf.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(tag string) {
+		if list != tag {
+			closeList()
+			buf.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+	}
+
+	lines := strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			flushPara()
+			closeList()
+			if lang := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "```")); lang != "" {
+				buf.WriteString(`<pre><code class="lang-` + html.EscapeString(lang) + `">`)
+			} else {
+				buf.WriteString("<pre><code>")
+			}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				buf.WriteString(html.EscapeString(lines[i]) + "\n")
+			}
+			buf.WriteString("</code></pre>\n")
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flushPara()
+			closeList()
+			continue
+		}
+		if m := mdHeading.FindStringSubmatch(line); m != nil {
+			flushPara()
+			closeList()
+			level := len(m[1])
+			fmt.Fprintf(&buf, "<h%d>%s</h%d>\n", level, markdownInline(m[2]), level)
+			continue
+		}
+		if strings.Contains(line, "|") && i+1 < len(lines) && mdTableDelim.MatchString(lines[i+1]) {
+			flushPara()
+			closeList()
+			buf.WriteString("<div class=\"md-table\">\n<table>\n<thead>\n")
+			markdownTableRow(&buf, "th", line)
+			buf.WriteString("</thead>\n<tbody>\n")
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|") && strings.TrimSpace(lines[i]) != ""; i++ {
+				markdownTableRow(&buf, "td", lines[i])
+			}
+			i--
+			buf.WriteString("</tbody>\n</table>\n</div>\n")
+			continue
+		}
+		if m := mdBullet.FindStringSubmatch(line); m != nil {
+			flushPara()
+			openList("ul")
+			if t := mdTask.FindStringSubmatch(m[1]); t != nil {
+				buf.WriteString(`<li class="task-item">` + taskBox(t[1] != " ") + " " + markdownInline(t[2]) + "</li>\n")
+				continue
+			}
+			buf.WriteString("<li>" + markdownInline(m[1]) + "</li>\n")
+			continue
+		}
+		if m := mdNumbered.FindStringSubmatch(line); m != nil {
+			flushPara()
+			openList("ol")
+			buf.WriteString("<li>" + markdownInline(m[1]) + "</li>\n")
+			continue
+		}
+		closeList()
+		para = append(para, strings.TrimSpace(line))
+	}
+	flushPara()
+	closeList()
+	return template.HTML(buf.String())
+}
+
+// markdownTableRow writes a row of a pipe table with cells of the given tag.
+func markdownTableRow(buf *strings.Builder, tag, line string) {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	buf.WriteString("<tr>")
+	for _, cell := range strings.Split(line, "|") {
+		buf.WriteString("<" + tag + ">" + markdownInline(strings.TrimSpace(cell)) + "</" + tag + ">")
+	}
+	buf.WriteString("</tr>\n")
+}
+
+// markdownInline renders the inline Markdown in s as HTML. Code spans,
+// and then link targets and bare URLs, are set aside so that their
+// content is not formatted. NUL characters, which mark what was set aside,
+// are dropped from the input.
+func markdownInline(s string) string {
+	s = strings.Replace(s, "\x00", "", -1)
+	var codes []string
+	s = mdCode.ReplaceAllStringFunc(s, func(code string) string {
+		codes = append(codes, "<code>"+html.EscapeString(code[1:len(code)-1])+"</code>")
+		return fmt.Sprintf("\x00%d\x00", len(codes)-1)
+	})
+	s = html.EscapeString(s)
+	s = mdLink.ReplaceAllStringFunc(s, func(link string) string {
+		m := mdLink.FindStringSubmatch(link)
+		url := html.UnescapeString(m[2])
+		lower := strings.ToLower(url)
+		if strings.Contains(lower, ":") && !strings.HasPrefix(lower, "http:") && !strings.HasPrefix(lower, "https:") && !strings.HasPrefix(lower, "mailto:") {
+			return m[1]
+		}
+		codes = append(codes, `<a href="`+html.EscapeString(url)+`">`)
+		return fmt.Sprintf("\x00%d\x00", len(codes)-1) + m[1] + "</a>"
+	})
+	s = mdAutolink.ReplaceAllStringFunc(s, func(link string) string {
+		m := mdAutolink.FindStringSubmatch(link)
+		codes = append(codes, `<a href="`+m[2]+`">`+m[2]+`</a>`)
+		return m[1] + fmt.Sprintf("\x00%d\x00", len(codes)-1)
+	})
+	s = mdStrong.ReplaceAllString(s, "<strong>$1$2</strong>")
+	s = mdEmphasis.ReplaceAllString(s, "<em>$1$2</em>")
+	return mdPlaceholder.ReplaceAllStringFunc(s, func(ph string) string {
+		var i int
+		fmt.Sscanf(ph[1:], "%d", &i)
+		if i < 0 || i >= len(codes) {
+			return ph
+		}
+		return codes[i]
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var markdownTests = []struct {
+	text   string
+	result string
+}{
+	{"", ""},
+	{"Hello *world*.", "<p>Hello <em>world</em>.</p>\n"},
+	{"Some **bold** and __strong__ text", "<p>Some <strong>bold</strong> and <strong>strong</strong> text</p>\n"},
+	{"line one\nline two\n\nnext", "<p>line one line two</p>\n<p>next</p>\n"},
+	{"## Title ##", "<h2>Title</h2>\n"},
+	{"- one\n- two\n1. first", "<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n<ol>\n<li>first</li>\n</ol>\n"},
+	{"<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+	{"Run `snap *install*`", "<p>Run <code>snap *install*</code></p>\n"},
+	{"```yaml\nname: <x>\n```", "<pre><code class=\"lang-yaml\">name: &lt;x&gt;\n</code></pre>\n"},
+	{"[docs](https://snapcraft.io/docs_a_b)", "<p><a href=\"https://snapcraft.io/docs_a_b\">docs</a></p>\n"},
+	{"[bad](javascript:void)", "<p>bad</p>\n"},
+	{"See https://snapcraft.io/a_b_c.", "<p>See <a href=\"https://snapcraft.io/a_b_c\">https://snapcraft.io/a_b_c</a>.</p>\n"},
+	{"| a | b |\n|---|---|\n| 1 | 2 |", "<div class=\"md-table\">\n<table>\n<thead>\n<tr><th>a</th><th>b</th></tr>\n</thead>\n<tbody>\n<tr><td>1</td><td>2</td></tr>\n</tbody>\n</table>\n</div>\n"},
+
+	// Placeholders forged in the input must neither panic nor reveal
+	// what was set aside.
+	{"\x009\x00", "<p>9</p>\n"},
+	{"`a` \x000\x00 \x0099999999999999999999\x00", "<p><code>a</code> 0 99999999999999999999</p>\n"},
+}
+
+func TestMarkdown(t *testing.T) {
+	for _, test := range markdownTests {
+		result := string(markdown(test.text))
+		if result != test.result {
+			t.Errorf("markdown(%q):\ngot  %q\nwant %q", test.text, result, test.result)
+		}
+	}
+}
+
+func TestMarkdownInlinePlaceholders(t *testing.T) {
+	// Placeholders can only come from markdownInline itself, and must
+	// always be in range.
+	for _, s := range []string{"\x00", "\x00\x00", "\x001\x00", "x\x00-1\x00", "`\x000\x00`"} {
+		result := markdownInline(s)
+		if strings.Contains(result, "\x00") {
+			t.Errorf("markdownInline(%q) = %q, which holds a NUL", s, result)
+		}
+	}
+}
+
+var truncateTests = []struct {
+	n      int
+	text   string
+	result string
+}{
+	{10, "short", "short"},
+	{0, "not truncated", "not truncated"},
+	{11, "hello world and more", "hello world…"},
+	{12, "hello big world", "hello big…"},
+	// Words longer than half the length are cut.
+	{10, "hello world", "hello worl…"},
+	{5, "abcdefghij", "abcde…"},
+	{4, "ééééé", "éééé…"},
+}
+
+func TestTruncate(t *testing.T) {
+	for _, test := range truncateTests {
+		if result := truncate(test.n, test.text); result != test.result {
+			t.Errorf("truncate(%d, %q) = %q, want %q", test.n, test.text, result, test.result)
+		}
+	}
+}
+
+var slugifyTests = []struct {
+	text   string
+	result string
+}{
+	{"Hello World", "hello-world"},
+	{"  The snap format: a guide!  ", "the-snap-format-a-guide"},
+	{"Créer un snap", "créer-un-snap"},
+	{"a--b__c", "a-b-c"},
+	{"---", ""},
+}
+
+func TestSlugify(t *testing.T) {
+	for _, test := range slugifyTests {
+		if result := slugify(test.text); result != test.result {
+			t.Errorf("slugify(%q) = %q, want %q", test.text, result, test.result)
+		}
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		result string
+		want   string
+	}{
+		{pluralize(0, "page"), "0 pages"},
+		{pluralize(1, "page"), "1 page"},
+		{pluralize(2, "page"), "2 pages"},
+		{pluralize(1, "entry", "entries"), "1 entry"},
+		{pluralize(3, "entry", "entries"), "3 entries"},
+	}
+	for _, test := range tests {
+		if test.result != test.want {
+			t.Errorf("pluralize returned %q, want %q", test.result, test.want)
+		}
+	}
+}
+
+func TestDict(t *testing.T) {
+	m, err := dict("a", 1, "b", true)
+	if err != nil {
+		t.Fatalf("dict failed: %v", err)
+	}
+	if want := map[string]interface{}{"a": 1, "b": true}; !reflect.DeepEqual(m, want) {
+		t.Errorf("dict returned %v, want %v", m, want)
+	}
+	if _, err := dict("a"); err == nil {
+		t.Errorf("dict succeeded with an odd number of arguments")
+	}
+	if _, err := dict(1, "a"); err == nil {
+		t.Errorf("dict succeeded with a non-string key")
+	}
+}
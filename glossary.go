@@ -0,0 +1,147 @@
+package main
+
+import (
+	"html"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type glossaryEntry struct {
+	Term       string
+	Definition string
+	Anchor     string
+}
+
+type glossary struct {
+	entries map[string]*glossaryEntry // By lowercased term.
+	pattern *regexp.Regexp
+}
+
+var (
+	glossaryItem      = regexp.MustCompile(`(?s)<h[2-6][^>]*>(.*?)</h[2-6]>\s*<p>(.*?)</p>`)
+	headingAnchor     = regexp.MustCompile(`<a\b[^>]*\sname="([^"]+)"`)
+	glossarySkipTags  = regexp.MustCompile(`^</?(a|code|pre|h[1-6]|script|style)\b`)
+	contentTagPattern = regexp.MustCompile(`<[^>]*>`)
+)
+
+// parseGlossary extracts the glossary entries from the content of the
+// glossary topic. Each entry is a heading holding the term, followed by
+// a paragraph holding its definition.
+func parseGlossary(content string) *glossary {
+	g := &glossary{entries: make(map[string]*glossaryEntry)}
+	var terms []string
+	for _, m := range glossaryItem.FindAllStringSubmatch(content, -1) {
+		entry := &glossaryEntry{
+			Term:       plainText(m[1]),
+			Definition: plainText(m[2]),
+		}
+		if a := headingAnchor.FindStringSubmatch(m[1]); a != nil {
+			entry.Anchor = a[1]
+		}
+		key := strings.ToLower(entry.Term)
+		if key == "" || g.entries[key] != nil {
+			continue
+		}
+		g.entries[key] = entry
+		terms = append(terms, regexp.QuoteMeta(html.EscapeString(entry.Term)))
+	}
+	if len(terms) == 0 {
+		return g
+	}
+	// Longest first, so that the longest of overlapping terms wins.
+	sort.Slice(terms, func(i, j int) bool { return len(terms[i]) > len(terms[j]) })
+	g.pattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(terms, "|") + `)\b`)
+	return g
+}
+
+// apply wraps the first occurrence of each glossary term in content with
+// a link to its glossary entry, leaving alone the text of links, code
+// blocks, and headings.
+func (g *glossary) apply(content string) string {
+	if g.pattern == nil {
+		return content
+	}
+
+	var buf strings.Builder
+	used := make(map[string]bool)
+	skip := 0
+	last := 0
+	for _, tag := range contentTagPattern.FindAllStringIndex(content, -1) {
+		text := content[last:tag[0]]
+		if skip > 0 {
+			buf.WriteString(text)
+		} else {
+			buf.WriteString(g.applyText(text, used))
+		}
+		if m := glossarySkipTags.FindString(content[tag[0]:tag[1]]); m != "" && !strings.HasSuffix(content[tag[0]:tag[1]], "/>") {
+			if strings.HasPrefix(m, "</") {
+				if skip > 0 {
+					skip--
+				}
+			} else {
+				skip++
+			}
+		}
+		buf.WriteString(content[tag[0]:tag[1]])
+		last = tag[1]
+	}
+	buf.WriteString(g.applyText(content[last:], used))
+	return buf.String()
+}
+
+func (g *glossary) applyText(text string, used map[string]bool) string {
+	return g.pattern.ReplaceAllStringFunc(text, func(match string) string {
+		key := strings.ToLower(html.UnescapeString(match))
+		entry := g.entries[key]
+		if entry == nil || used[key] {
+			return match
+		}
+		used[key] = true
+		href := *glossaryFlag
+		if entry.Anchor != "" {
+			href += "#" + entry.Anchor
+		}
+		return `<a class="glossary-term" href="` + html.EscapeString(href) + `" title="` + html.EscapeString(entry.Definition) + `">` + match + `</a>`
+	})
+}
+
+// applyGlossary links the glossary terms in the content of topic.
+func applyGlossary(topic *Topic, content string, budget *upstreamBudget) string {
+	if id, err := topicPathID(*glossaryFlag); err != nil || id == topic.ID {
+		return content
+	}
+	if g := currentGlossary(budget); g != nil {
+		return g.apply(content)
+	}
+	return content
+}
+
+var glossaryCache struct {
+	mu       sync.Mutex
+	topic    *Topic
+	glossary *glossary
+}
+
+// currentGlossary returns the glossary parsed from the glossary topic,
+// or nil if there's no glossary topic or it cannot be obtained within
+// budget.
+func currentGlossary(budget *upstreamBudget) *glossary {
+	if *glossaryFlag == "" {
+		return nil
+	}
+	topic, err := forum.TopicWithin(*glossaryFlag, budget)
+	if err != nil {
+		log.Printf("Cannot obtain glossary: %v", err)
+		return nil
+	}
+	glossaryCache.mu.Lock()
+	defer glossaryCache.mu.Unlock()
+	if glossaryCache.topic != topic {
+		glossaryCache.topic = topic
+		glossaryCache.glossary = parseGlossary(topic.Content())
+	}
+	return glossaryCache.glossary
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const graphqlSchemaString = `
+schema {
+	query: Query
+}
+
+type Query {
+	# The documentation topic with the given ID.
+	topic(id: Int!): Topic
+	# All documentation topics, alphabetically.
+	topics: [Topic!]!
+	# The sections of the documentation outline.
+	sections: [Section!]!
+	# Topics matching the search query.
+	search(query: String!): [Topic!]!
+	# Topics updated in the given number of days, most recent first.
+	recentChanges(days: Int = 7): [Topic!]!
+}
+
+type Topic {
+	id: Int!
+	slug: String!
+	title: String!
+	path: String!
+	url: String!
+	forumURL: String!
+	lastUpdate: String!
+	author: String
+	blurb: String
+	content: String
+	markdown: String
+}
+
+type Section {
+	title: String!
+	topics: [Topic!]!
+}
+`
+
+var graphqlSchema = graphql.MustParseSchema(graphqlSchemaString, &graphqlResolver{})
+
+type graphqlResolver struct{}
+
+func (*graphqlResolver) Topic(ctx context.Context, args struct{ ID int32 }) (*topicResolver, error) {
+	topic, err := forum.TopicWithin("/"+strconv.Itoa(int(args.ID)), contextBudget(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if !isDocCategory(topic.Category) {
+		return nil, nil
+	}
+	return &topicResolver{topic}, nil
+}
+
+func (*graphqlResolver) Topics(ctx context.Context) ([]*topicResolver, error) {
+	topics, err := forum.TopicsWithin(contextBudget(ctx))
+	if err != nil {
+		return nil, err
+	}
+	topics = append([]*Topic(nil), topics...)
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Title < topics[j].Title })
+	return topicResolvers(topics), nil
+}
+
+func (*graphqlResolver) Sections(ctx context.Context) ([]*sectionResolver, error) {
+	topics, err := forum.TopicsWithin(contextBudget(ctx))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*Topic, len(topics))
+	for _, topic := range topics {
+		byID[topic.ID] = topic
+	}
+	var sections []*sectionResolver
+	for _, section := range outlineSections(indexOutline(contextBudget(ctx))) {
+		r := &sectionResolver{title: section.Title}
+		for _, id := range section.TopicIDs {
+			if topic, ok := byID[id]; ok {
+				r.topics = append(r.topics, &topicResolver{topic})
+			}
+		}
+		sections = append(sections, r)
+	}
+	return sections, nil
+}
+
+func (*graphqlResolver) Search(ctx context.Context, args struct{ Query string }) ([]*topicResolver, error) {
+	topics, err := forum.SearchWithin(args.Query, contextBudget(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return topicResolvers(topics), nil
+}
+
+func (*graphqlResolver) RecentChanges(ctx context.Context, args struct{ Days int32 }) ([]*topicResolver, error) {
+	topics, err := forum.TopicsWithin(contextBudget(ctx))
+	if err != nil {
+		return nil, err
+	}
+	since := time.Now().AddDate(0, 0, -int(args.Days))
+	var recent []*Topic
+	for _, topic := range topics {
+		if topic.LastUpdate().After(since) {
+			recent = append(recent, topic)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i].LastUpdate().After(recent[j].LastUpdate()) })
+	return topicResolvers(recent), nil
+}
+
+type sectionResolver struct {
+	title  string
+	topics []*topicResolver
+}
+
+func (r *sectionResolver) Title() string            { return r.title }
+func (r *sectionResolver) Topics() []*topicResolver { return r.topics }
+
+// topicResolver resolves topic fields. Topics from listings and search
+// results carry no content, so it's fetched when asked for.
+type topicResolver struct {
+	topic *Topic
+}
+
+func topicResolvers(topics []*Topic) []*topicResolver {
+	resolvers := make([]*topicResolver, len(topics))
+	for i, topic := range topics {
+		resolvers[i] = &topicResolver{topic}
+	}
+	return resolvers
+}
+
+func (r *topicResolver) full(ctx context.Context) (*Topic, error) {
+	if r.topic.Post == nil || r.topic.raw == nil {
+		topic, err := forum.TopicWithin(r.topic.String(), contextBudget(ctx))
+		if err != nil {
+			return nil, err
+		}
+		r.topic = topic
+	}
+	return r.topic, nil
+}
+
+func (r *topicResolver) ID() int32          { return int32(r.topic.ID) }
+func (r *topicResolver) Slug() string       { return r.topic.Slug }
+func (r *topicResolver) Title() string      { return r.topic.Title }
+func (r *topicResolver) Path() string       { return r.topic.String() }
+func (r *topicResolver) URL() string        { return r.topic.URL() }
+func (r *topicResolver) ForumURL() string   { return r.topic.ForumURL() }
+func (r *topicResolver) LastUpdate() string { return r.topic.LastUpdate().UTC().Format(time.RFC3339) }
+
+func (r *topicResolver) Author(ctx context.Context) (*string, error) {
+	topic, err := r.full(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &topic.Post.Username, nil
+}
+
+func (r *topicResolver) Blurb() *string {
+	if blurb := r.topic.Blurb(); blurb != "" {
+		return &blurb
+	}
+	return nil
+}
+
+func (r *topicResolver) Content(ctx context.Context) (*string, error) {
+	topic, err := r.full(ctx)
+	if err != nil {
+		return nil, err
+	}
+	content := topic.Content()
+	return &content, nil
+}
+
+func (r *topicResolver) Markdown(ctx context.Context) (*string, error) {
+	topic, err := r.full(ctx)
+	if err != nil {
+		return nil, err
+	}
+	markdown := topic.Markdown()
+	return &markdown, nil
+}
+
+// serveGraphQL serves GraphQL queries provided either as a JSON body in
+// a POST request or as query parameters in a GET request.
+func serveGraphQL(resp http.ResponseWriter, req *http.Request) {
+	var params struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if req.Method == "POST" {
+		err := json.NewDecoder(req.Body).Decode(&params)
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			resp.Write([]byte("cannot decode GraphQL request: " + err.Error()))
+			return
+		}
+	} else {
+		params.Query = req.Form.Get("query")
+		params.OperationName = req.Form.Get("operationName")
+		if variables := req.Form.Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &params.Variables)
+			if err != nil {
+				resp.WriteHeader(http.StatusBadRequest)
+				resp.Write([]byte("cannot decode GraphQL variables: " + err.Error()))
+				return
+			}
+		}
+	}
+
+	result := graphqlSchema.Exec(withBudget(req.Context(), requestBudget(req)), params.Query, params.OperationName, params.Variables)
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Cannot marshal GraphQL response: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
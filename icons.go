@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	faviconBytes   []byte
+	touchIconBytes []byte
+	manifestBytes  = []byte(manifestString)
+)
+
+const manifestString = `{
+	"name": "Snap Docs",
+	"short_name": "Snap Docs",
+	"start_url": "/",
+	"scope": "/",
+	"display": "standalone",
+	"background_color": "#ffffff",
+	"theme_color": "#82bea0",
+	"icons": [
+		{"src": "/icon32.png", "sizes": "32x32", "type": "image/png"},
+		{"src": "/apple-touch-icon.png", "sizes": "180x180", "type": "image/png"}
+	]
+}
+`
+
+const touchIconSize = 180
+
+// loadIcons prepares the favicon and touch icon from the embedded icon,
+// and replaces them and the manifest by the files provided via flags.
+func loadIcons() error {
+	var err error
+	faviconBytes = icoFromPNG(iconBytes, 32)
+	touchIconBytes, err = scalePNG(iconBytes, touchIconSize)
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		path string
+		data *[]byte
+	}{
+		{*faviconFlag, &faviconBytes},
+		{*touchIconFlag, &touchIconBytes},
+		{*manifestFlag, &manifestBytes},
+	} {
+		if file.path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			return fmt.Errorf("cannot read icon file: %v", err)
+		}
+		*file.data = data
+	}
+	return nil
+}
+
+// serveIcon serves the favicon, touch icon, and web app manifest.
+func serveIcon(resp http.ResponseWriter, req *http.Request) {
+	var data []byte
+	switch req.URL.Path {
+	case "/favicon.ico":
+		data = faviconBytes
+		resp.Header().Set("Content-Type", "image/x-icon")
+	case "/apple-touch-icon.png":
+		data = touchIconBytes
+		resp.Header().Set("Content-Type", "image/png")
+	case "/manifest.webmanifest":
+		data = manifestBytes
+		resp.Header().Set("Content-Type", "application/manifest+json")
+	default:
+		resp.WriteHeader(http.StatusNotFound)
+		return
+	}
+	resp.Header().Set("Cache-Control", "public, max-age=86400")
+	resp.Write(data)
+}
+
+// icoFromPNG returns an ICO file holding the square PNG image in data,
+// which all current browsers support.
+func icoFromPNG(data []byte, size int) []byte {
+	var buf bytes.Buffer
+	// ICONDIR: reserved, type (1 for icons), image count.
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, 1})
+	// ICONDIRENTRY: width, height, colors, reserved, planes, bits per
+	// pixel, data size, data offset.
+	buf.Write([]byte{byte(size), byte(size), 0, 0})
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(data)), 6 + 16})
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// scalePNG returns the PNG image in data scaled to size by size pixels.
+func scalePNG(data []byte, size int) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode icon: %v", err)
+	}
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/size
+			sy := bounds.Min.Y + y*bounds.Dy()/size
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("cannot encode icon: %v", err)
+	}
+	return buf.Bytes(), nil
+}
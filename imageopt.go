@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	imageMaxWidth  = 4096
+	imageWidthStep = 100
+	imageQuality   = 85
+
+	// imageMaxPixels bounds the size of images decoded for scaling, as a
+	// small file may hold a huge image, which is decoded and then copied.
+	imageMaxPixels = 16 << 20
+
+	// imageMaxResizes bounds the images scaled at once, so that a burst
+	// of proxied images doesn't hold many decoded copies in memory.
+	imageMaxResizes = 4
+)
+
+var imageResizes = make(chan struct{}, imageMaxResizes)
+
+// imageEncoder encodes images in a format served to clients that accept
+// it, in place of PNG and JPEG.
+type imageEncoder struct {
+	mediaType string
+	encode    func(img image.Image) ([]byte, error)
+}
+
+// imageEncoders are the alternative image formats, in order of
+// preference. The standard library encodes neither WebP nor AVIF, so
+// there are none yet.
+var imageEncoders []*imageEncoder
+
+// imageFormat returns the media type of the preferred alternative image
+// format accepted by req, or an empty string if none is. Responses
+// negotiated this way must vary on Accept.
+func imageFormat(req *http.Request) string {
+	for _, enc := range imageEncoders {
+		if acceptsImage(req, enc.mediaType) {
+			return enc.mediaType
+		}
+	}
+	return ""
+}
+
+// acceptsImage returns whether the Accept header of req names the image
+// media type explicitly, as wildcards don't tell which formats are
+// supported.
+func acceptsImage(req *http.Request, mediaType string) bool {
+	for _, field := range strings.Split(req.Header.Get("Accept"), ",") {
+		parts := strings.Split(field, ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), mediaType) {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func imageEncoderFor(format string) *imageEncoder {
+	for _, enc := range imageEncoders {
+		if enc.mediaType == format {
+			return enc
+		}
+	}
+	return nil
+}
+
+// imageWidth parses the width requested from the image proxy, rounded up
+// so that few variants of each image are cached. Zero means any width.
+func imageWidth(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	w, err := strconv.Atoi(s)
+	if err != nil || w < 1 || w > imageMaxWidth {
+		return 0, fmt.Errorf("invalid image width %q", s)
+	}
+	return (w + imageWidthStep - 1) / imageWidthStep * imageWidthStep, nil
+}
+
+var rasterImage = regexp.MustCompile(`(?i)(<img\b[^>]*?\ssrc=")(https?://[^"]+\.(?:png|jpe?g|gif))(")`)
+
+// proxyRasterImages makes raster images in content be served via the
+// image proxy, scaled down to -image-width.
+func proxyRasterImages(content string) string {
+	return rasterImage.ReplaceAllStringFunc(content, func(tag string) string {
+		m := rasterImage.FindStringSubmatch(tag)
+		u, err := url.Parse(m[2])
+		if err != nil || !imageProxyAllowed(u) {
+			return tag
+		}
+		return m[1] + imageProxyURL(m[2]) + "&amp;w=" + strconv.Itoa(*imageWidthFlag) + m[3]
+	})
+}
+
+// optimizeImage scales the raster image in data down to width, if it's
+// wider, and drops metadata such as EXIF. Images other than GIFs, which
+// would lose their animation, are transcoded to format, if set. Unknown
+// formats are returned unchanged.
+func optimizeImage(data []byte, mediaType string, width int, format string) ([]byte, string, error) {
+	if mediaType != "image/png" && mediaType != "image/jpeg" && mediaType != "image/gif" {
+		return data, mediaType, nil
+	}
+	var encoder *imageEncoder
+	if mediaType != "image/gif" {
+		encoder = imageEncoderFor(format)
+	}
+	if width > 0 || encoder != nil {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot decode image: %v", err)
+		}
+		scale := width > 0 && config.Width > width
+		if (scale || encoder != nil) && config.Width*config.Height <= imageMaxPixels {
+			imageResizes <- struct{}{}
+			defer func() { <-imageResizes }()
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, "", fmt.Errorf("cannot decode image: %v", err)
+			}
+			if scale {
+				height := config.Height * width / config.Width
+				if height < 1 {
+					height = 1
+				}
+				img = scaleImage(img, width, height)
+			}
+			// Re-encoding leaves all metadata behind.
+			if encoder != nil {
+				encoded, err := encoder.encode(img)
+				if err != nil {
+					return nil, "", fmt.Errorf("cannot encode image: %v", err)
+				}
+				return encoded, encoder.mediaType, nil
+			}
+			return encodeImage(img, mediaType)
+		}
+	}
+	switch mediaType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data), mediaType, nil
+	case "image/png":
+		return stripPNGMetadata(data), mediaType, nil
+	}
+	return data, mediaType, nil
+}
+
+func encodeImage(img image.Image, mediaType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var err error
+	if mediaType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: imageQuality})
+	} else {
+		// Scaled GIFs lose their animation, and PNG keeps the colors.
+		mediaType = "image/png"
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot encode image: %v", err)
+	}
+	return buf.Bytes(), mediaType, nil
+}
+
+// scaleImage scales img down to width by height, averaging the source
+// pixels covered by each destination pixel.
+func scaleImage(img image.Image, width, height int) image.Image {
+	src := image.NewNRGBA(img.Bounds())
+	draw.Draw(src, src.Rect, img, img.Bounds().Min, draw.Src)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*sh/height, (y+1)*sh/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*sw/width, (x+1)*sw/width
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					// Weigh colors by alpha so transparent pixels don't darken edges.
+					r += int(p[0]) * int(p[3])
+					g += int(p[1]) * int(p[3])
+					b += int(p[2]) * int(p[3])
+					a += int(p[3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			if a > 0 {
+				dst.Pix[i] = uint8(r / a)
+				dst.Pix[i+1] = uint8(g / a)
+				dst.Pix[i+2] = uint8(b / a)
+			}
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// stripJPEGMetadata removes the EXIF, XMP, and comment segments from the
+// JPEG in data, keeping the image data untouched.
+func stripJPEGMetadata(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	out := append([]byte(nil), data[:2]...)
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return data
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			// Start of scan: the rest is image data.
+			return append(out, data[i:]...)
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return data
+		}
+		// APP1 holds EXIF and XMP, and COM holds comments. APP0 (JFIF)
+		// and APP2 (ICC color profiles) affect how the image looks.
+		if marker != 0xE1 && marker != 0xFE {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return data
+}
+
+// pngMetadataChunks are PNG chunks dropped as they don't affect how the
+// image looks.
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// stripPNGMetadata removes the metadata chunks from the PNG in data.
+func stripPNGMetadata(data []byte) []byte {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return data
+	}
+	out := append([]byte(nil), signature...)
+	for i := len(signature); i < len(data); {
+		if i+12 > len(data) {
+			return data
+		}
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + size
+		if size < 0 || end > len(data) {
+			return data
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
+
+// imageCachePath returns where the proxied image at imageURL scaled to
+// width, and transcoded to format if set, is cached on disk.
+func imageCachePath(imageURL string, width int, format string) string {
+	key := imageURL + "\n" + strconv.Itoa(width)
+	if format != "" {
+		key += "\n" + format
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(*imageCacheFlag, fmt.Sprintf("%x", sum[:16]))
+}
+
+// cachedImage returns the image cached at path, if any.
+func cachedImage(path string) (data []byte, mediaType string, ok bool) {
+	entry, err := readCacheFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("%v", err)
+		}
+		return nil, "", false
+	}
+	mediaType = entry.Header["type"]
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, "", false
+	}
+	return entry.Data, mediaType, true
+}
+
+// cacheImage stores the image at path, together with its media type.
+func cacheImage(path string, data []byte, mediaType string) error {
+	err := writeCacheFile(path, &cacheEntry{Header: map[string]string{"type": mediaType}, Data: data})
+	if err != nil {
+		return fmt.Errorf("cannot cache image: %v", err)
+	}
+	return nil
+}
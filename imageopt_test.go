@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+)
+
+func testImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodeTestJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(16, 8), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeTestPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(16, 8)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegSegment returns a JPEG segment with the given marker and payload.
+func jpegSegment(marker byte, payload string) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// pngChunk returns a PNG chunk with the given type and data.
+func pngChunk(kind, data string) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, kind+data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE([]byte(kind+data)))
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	plain := encodeTestJPEG(t)
+	exif := jpegSegment(0xE1, "Exif\x00\x00GPS data")
+	comment := jpegSegment(0xFE, "a comment")
+	icc := jpegSegment(0xE2, "ICC_PROFILE\x00profile")
+	// Metadata before and after the JFIF segment that follows SOI.
+	jfif := 4 + int(binary.BigEndian.Uint16(plain[4:]))
+	tagged := concat(plain[:2], exif, icc, plain[2:jfif], comment, plain[jfif:])
+
+	stripped := stripJPEGMetadata(tagged)
+	if bytes.Contains(stripped, []byte("GPS data")) || bytes.Contains(stripped, []byte("a comment")) {
+		t.Errorf("EXIF or comment left in stripped JPEG")
+	}
+	if !bytes.Contains(stripped, []byte("ICC_PROFILE")) {
+		t.Errorf("color profile removed from stripped JPEG")
+	}
+	if want := concat(plain[:2], icc, plain[2:]); !bytes.Equal(stripped, want) {
+		t.Errorf("stripped JPEG differs from the original with its color profile")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("cannot decode stripped JPEG: %v", err)
+	}
+	if stripped := stripJPEGMetadata(plain); !bytes.Equal(stripped, plain) {
+		t.Errorf("JPEG without metadata changed when stripped")
+	}
+}
+
+func TestStripJPEGMetadataInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("not a jpeg"),
+		{0xFF, 0xD8},
+		{0xFF, 0xD8, 0xFF, 0xE1, 0xFF, 0xFF, 'x'},                     // Segment past the end.
+		{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 'x'},                     // Segment size too small.
+		{0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x02, 'x'},                     // Not a marker.
+		concat([]byte{0xFF, 0xD8}, jpegSegment(0xFE, "unterminated")), // No scan.
+	} {
+		if stripped := stripJPEGMetadata(data); !bytes.Equal(stripped, data) {
+			t.Errorf("invalid JPEG %q changed when stripped to %q", data, stripped)
+		}
+	}
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	plain := encodeTestPNG(t)
+	const signatureAndHeader = 8 + 12 + 13
+	end := len(plain) - 12
+	text := pngChunk("tEXt", "Author\x00someone")
+	exif := pngChunk("eXIf", "MM\x00*GPS data")
+	gamma := pngChunk("gAMA", "\x00\x00\xb1\x8f")
+	tagged := concat(plain[:signatureAndHeader], text, gamma, plain[signatureAndHeader:end], exif, plain[end:])
+
+	stripped := stripPNGMetadata(tagged)
+	if want := concat(plain[:signatureAndHeader], gamma, plain[signatureAndHeader:]); !bytes.Equal(stripped, want) {
+		t.Errorf("stripped PNG differs from the original with its gamma")
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("cannot decode stripped PNG: %v", err)
+	}
+	if stripped := stripPNGMetadata(plain); !bytes.Equal(stripped, plain) {
+		t.Errorf("PNG without metadata changed when stripped")
+	}
+}
+
+func TestStripPNGMetadataInvalid(t *testing.T) {
+	plain := encodeTestPNG(t)
+	for _, data := range [][]byte{
+		nil,
+		[]byte("not a png"),
+		plain[:len(plain)-1],
+		plain[:len(plain)-12+4],
+		concat(plain[:8], []byte{0xFF, 0xFF, 0xFF, 0xFF}, []byte("tEXtabcd")),
+	} {
+		if stripped := stripPNGMetadata(data); !bytes.Equal(stripped, data) {
+			t.Errorf("invalid PNG %q changed when stripped to %q", data, stripped)
+		}
+	}
+}
+
+// withImageEncoder runs f with an alternative image format encoded as PNG.
+func withImageEncoder(mediaType string, f func()) {
+	saved := imageEncoders
+	imageEncoders = []*imageEncoder{{
+		mediaType: mediaType,
+		encode: func(img image.Image) ([]byte, error) {
+			var buf bytes.Buffer
+			err := png.Encode(&buf, img)
+			return buf.Bytes(), err
+		},
+	}}
+	defer func() { imageEncoders = saved }()
+	f()
+}
+
+var imageFormatTests = []struct {
+	accept string
+	format string
+}{
+	{"", ""},
+	{"image/*,*/*;q=0.8", ""},
+	{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", "image/webp"},
+	{"image/WebP;q=0.5", "image/webp"},
+	{"image/webp;q=0", ""},
+	{"image/png, image/webp ; q=0.9", "image/webp"},
+}
+
+func TestImageFormat(t *testing.T) {
+	req := httptest.NewRequest("GET", "/_image?url=x", nil)
+	req.Header.Set("Accept", "image/webp")
+	if format := imageFormat(req); format != "" {
+		t.Errorf("imageFormat without encoders = %q", format)
+	}
+	withImageEncoder("image/webp", func() {
+		for _, test := range imageFormatTests {
+			req.Header.Set("Accept", test.accept)
+			if format := imageFormat(req); format != test.format {
+				t.Errorf("imageFormat with Accept %q = %q, want %q", test.accept, format, test.format)
+			}
+		}
+	})
+}
+
+func TestOptimizeImage(t *testing.T) {
+	jpg := encodeTestJPEG(t)
+	for _, test := range []struct {
+		width     int
+		format    string
+		mediaType string
+		size      string
+	}{
+		{0, "", "image/jpeg", "16x8"},
+		{32, "", "image/jpeg", "16x8"},
+		{8, "", "image/jpeg", "8x4"},
+		{0, "image/webp", "image/webp", "16x8"},
+		{8, "image/webp", "image/webp", "8x4"},
+		{0, "image/unknown", "image/jpeg", "16x8"},
+	} {
+		withImageEncoder("image/webp", func() {
+			data, mediaType, err := optimizeImage(jpg, "image/jpeg", test.width, test.format)
+			if err != nil {
+				t.Fatalf("optimizeImage to width %d and format %q failed: %v", test.width, test.format, err)
+			}
+			config, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("cannot decode optimized image: %v", err)
+			}
+			size := fmt.Sprintf("%dx%d", config.Width, config.Height)
+			if mediaType != test.mediaType || size != test.size {
+				t.Errorf("optimizeImage to width %d and format %q returned %s %s, want %s %s",
+					test.width, test.format, mediaType, size, test.mediaType, test.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const imageProxyMaxSize = 10 << 20
+
+// defaultImageProxyHosts are the hosts the image proxy fetches from, in
+// addition to the ones configured. Entries starting with a dot match any
+// subdomain.
+var defaultImageProxyHosts = []string{
+	"forum.snapcraft.io",
+	".discourse-cdn.com",
+}
+
+func imageProxyAllowed(u *url.URL) bool {
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range append(defaultImageProxyHosts, config.ImageProxyHosts...) {
+		if host == allowed || strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageProxyURL returns the URL for serving the image at imageURL via
+// the image proxy.
+func imageProxyURL(imageURL string) string {
+	return "/image?url=" + url.QueryEscape(imageURL)
+}
+
+var svgImage = regexp.MustCompile(`(<img\b[^>]*?\ssrc=")(https?://[^"]+\.svg)(")`)
+
+// proxySVGImages makes SVG images in content be served via the image
+// proxy, so they are sanitized and served from this site.
+func proxySVGImages(content string) string {
+	return svgImage.ReplaceAllStringFunc(content, func(tag string) string {
+		m := svgImage.FindStringSubmatch(tag)
+		u, err := url.Parse(m[2])
+		if err != nil || !imageProxyAllowed(u) {
+			return tag
+		}
+		return m[1] + imageProxyURL(m[2]) + m[3]
+	})
+}
+
+// serveImageProxy serves the image at the URL in the "url" parameter,
+// which must be in one of the allowed hosts, scaled down to the width in
+// the "w" parameter, if any.
+func serveImageProxy(resp http.ResponseWriter, req *http.Request) {
+	u, err := url.Parse(req.Form.Get("url"))
+	if err != nil || !imageProxyAllowed(u) {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	width, err := imageWidth(req.Form.Get("w"))
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		resp.Write([]byte(err.Error()))
+		return
+	}
+	resp.Header().Add("Vary", "Accept")
+	data, mediaType, err := proxyImage(u, width, imageFormat(req))
+	if err != nil {
+		sendImageError(resp, u, err)
+		return
+	}
+	sendImage(resp, data, mediaType)
+}
+
+// imageProxyError is an error obtaining an image via the proxy, along
+// with the status to respond with.
+type imageProxyError struct {
+	status int
+	msg    string
+}
+
+func (e *imageProxyError) Error() string {
+	return e.msg
+}
+
+func sendImageError(resp http.ResponseWriter, u *url.URL, err error) {
+	log.Printf("Cannot proxy image %s: %v", u, err)
+	status := http.StatusBadGateway
+	if e, ok := err.(*imageProxyError); ok {
+		status = e.status
+	}
+	resp.WriteHeader(status)
+}
+
+// proxyImage obtains the image at u for serving. SVG images are
+// sanitized, and raster images are stripped of metadata, scaled down to
+// width, if set, and transcoded to format, if set. Results are cached on
+// disk with -image-cache.
+func proxyImage(u *url.URL, width int, format string) ([]byte, string, error) {
+	var cachePath string
+	if *imageCacheFlag != "" {
+		cachePath = imageCachePath(u.String(), width, format)
+		if data, mediaType, ok := cachedImage(cachePath); ok {
+			return data, mediaType, nil
+		}
+	}
+
+	upstream, err := httpClient.Get(u.String())
+	if err != nil {
+		return nil, "", err
+	}
+	defer upstream.Body.Close()
+	if upstream.StatusCode != 200 {
+		return nil, "", fmt.Errorf("got %v status", upstream.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(upstream.Body, imageProxyMaxSize+1))
+	if err != nil || len(data) > imageProxyMaxSize {
+		return nil, "", fmt.Errorf("too large or unreadable")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(upstream.Header.Get("Content-Type"))
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType = http.DetectContentType(data)
+		if strings.HasSuffix(u.Path, ".svg") {
+			mediaType = "image/svg+xml"
+		}
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, "", &imageProxyError{http.StatusUnsupportedMediaType, "not an image: " + mediaType}
+	}
+	if mediaType == "image/svg+xml" {
+		data, err = sanitizeSVG(data)
+	} else {
+		data, mediaType, err = optimizeImage(data, mediaType, width, format)
+	}
+	if err != nil {
+		return nil, "", &imageProxyError{http.StatusUnsupportedMediaType, err.Error()}
+	}
+
+	if cachePath != "" {
+		if err := cacheImage(cachePath, data, mediaType); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	return data, mediaType, nil
+}
+
+func sendImage(resp http.ResponseWriter, data []byte, mediaType string) {
+	if mediaType == "image/svg+xml" {
+		resp.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src data:")
+	}
+	resp.Header().Set("Content-Type", mediaType)
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.Header().Set("Cache-Control", "public, max-age=86400")
+	resp.Write(data)
+}
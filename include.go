@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Topics may include a section of another topic with a comment such as:
+//
+//	<!-- include:/some-topic/1234#section-anchor -->
+//
+// Includes are expanded when the page is rendered, out of the topic cache,
+// so the included content is always as fresh as the included topic's cache
+// entry, and refreshing a page also refreshes the topics it includes.
+var includePattern = regexp.MustCompile(`<!--\s*include:(/[a-z0-9-]*/?[0-9]+)(?:#([^\s>]+))?\s*-->`)
+
+const includeMaxDepth = 5
+
+// includePaths returns the paths of the topics included by content.
+func includePaths(content string) []string {
+	var paths []string
+	for _, m := range includePattern.FindAllStringSubmatch(content, -1) {
+		paths = append(paths, m[1])
+	}
+	return paths
+}
+
+type includeRef struct {
+	id      int
+	section string
+}
+
+// expandIncludes replaces the include comments in the content of topic
+// with the included content, obtained within budget.
+func expandIncludes(topic *Topic, content string, budget *upstreamBudget) string {
+	return expandIncludesIn(content, []includeRef{{id: topic.ID}}, budget)
+}
+
+func expandIncludesIn(content string, stack []includeRef, budget *upstreamBudget) string {
+	return includePattern.ReplaceAllStringFunc(content, func(comment string) string {
+		m := includePattern.FindStringSubmatch(comment)
+		id, err := topicPathID(m[1])
+		if err != nil {
+			return comment
+		}
+		ref := includeRef{id, m[2]}
+		for _, r := range stack {
+			// Including a whole topic conflicts with any of its sections.
+			if r.id == ref.id && (r.section == "" || ref.section == "" || r.section == ref.section) {
+				log.Printf("Include cycle in topic %d: %s#%s", stack[0].id, m[1], m[2])
+				return "<!-- include cycle: " + m[1] + " -->"
+			}
+		}
+		if len(stack) > includeMaxDepth {
+			log.Printf("Includes nested too deeply in topic %d: %s#%s", stack[0].id, m[1], m[2])
+			return "<!-- include too deep: " + m[1] + " -->"
+		}
+
+		included, err := forum.TopicWithin(m[1], budget)
+		if err != nil {
+			log.Printf("Cannot include %s in topic %d: %v", m[1], stack[0].id, err)
+			return comment
+		}
+		section := included.Content()
+		if ref.section != "" {
+			var ok bool
+			section, ok = contentSection(section, ref.section)
+			if !ok {
+				log.Printf("Cannot include %s#%s in topic %d: section not found", m[1], m[2], stack[0].id)
+				return comment
+			}
+		}
+		return expandIncludesIn(section, append(stack[:len(stack):len(stack)], ref), budget)
+	})
+}
+
+var (
+	headingPattern = regexp.MustCompile(`<h([1-6])[^>]*>`)
+	anchorPattern  = regexp.MustCompile(`<a\b[^>]*\sname="([^"]*)"`)
+)
+
+// contentSection returns the content under the heading holding the
+// given anchor name, up to the next heading of the same or higher level.
+func contentSection(content, anchor string) (section string, ok bool) {
+	start := -1
+	for _, a := range anchorPattern.FindAllStringSubmatchIndex(content, -1) {
+		if content[a[2]:a[3]] == anchor {
+			start = a[0]
+			break
+		}
+	}
+	if start < 0 {
+		return "", false
+	}
+	var level string
+	for _, h := range headingPattern.FindAllStringSubmatchIndex(content[:start], -1) {
+		level = content[h[2]:h[3]]
+	}
+	if level == "" {
+		return "", false
+	}
+	end := strings.Index(content[start:], "</h"+level+">")
+	if end < 0 {
+		return "", false
+	}
+	content = content[start+end+len("</h"+level+">"):]
+	for _, h := range headingPattern.FindAllStringSubmatchIndex(content, -1) {
+		if content[h[2]:h[3]] <= level {
+			return content[:h[0]], true
+		}
+	}
+	return content, true
+}
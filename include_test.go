@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+const includeTestContent = `<p>intro</p>
+<h2><a name="setup" class="anchor" href="#setup"></a>Setup</h2>
+<p>setup</p>
+<h3><a name="setup-linux" class="anchor" href="#setup-linux"></a>Linux</h3>
+<p>linux</p>
+<h2><a name="usage" class="anchor" href="#usage"></a>Usage</h2>
+<p>usage</p>
+`
+
+var contentSectionTests = []struct {
+	anchor  string
+	section string
+	ok      bool
+}{
+	{"setup", "\n<p>setup</p>\n<h3><a name=\"setup-linux\" class=\"anchor\" href=\"#setup-linux\"></a>Linux</h3>\n<p>linux</p>\n", true},
+	{"setup-linux", "\n<p>linux</p>\n", true},
+	{"usage", "\n<p>usage</p>\n", true},
+	{"missing", "", false},
+	{"set", "", false},
+	{"setup.*", "", false},
+}
+
+func TestContentSection(t *testing.T) {
+	for _, test := range contentSectionTests {
+		section, ok := contentSection(includeTestContent, test.anchor)
+		if section != test.section || ok != test.ok {
+			t.Errorf("contentSection(%q) = %q, %v, want %q, %v", test.anchor, section, ok, test.section, test.ok)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Index is an outline topic listing documentation pages. Besides the main
+// documentation outline, further indexes may be configured, in which case
+// they are shown as tabs above the sidebar, each with its own tree.
+type Index struct {
+	Title string `json:"title"`
+	Path  string `json:"path"`
+
+	id int
+}
+
+func (idx *Index) init() error {
+	id, err := topicPathID(idx.Path)
+	if err != nil {
+		return fmt.Errorf("invalid index path %q: %v", idx.Path, err)
+	}
+	idx.id = id
+	return nil
+}
+
+// URL returns where the index is served on this site.
+func (idx *Index) URL() string {
+	if idx.id == indexPageID {
+		return "/"
+	}
+	return idx.Path
+}
+
+// outline returns the outline part of the index topic, or an empty string
+// if it cannot be obtained. The main index outline is generated instead
+// when automatic navigation is configured. After a failure the index is
+// fetched again in the background, so pages don't wait on a failing forum.
+// The index is only fetched if that fits in budget, which may be nil.
+func (idx *Index) outline(budget *upstreamBudget) string {
+	if idx.id == indexPageID && config.AutoNav != nil {
+		return autoNavOutline(budget)
+	}
+	if indexRetries.pending(idx.Path) {
+		return ""
+	}
+	topic, err := forum.TopicWithin(idx.Path, budget)
+	if err == errUpstreamBudget {
+		return ""
+	}
+	if err != nil {
+		log.Printf("Cannot obtain index %s: %v", idx.Path, err)
+		indexRetries.start(idx.Path)
+		return ""
+	}
+	content := topic.Content()
+	if sep := strings.Index(content, indexPageSep); sep >= 0 {
+		return content[sep+len(indexPageSep):]
+	}
+	return content
+}
+
+// outlineLists returns whether outline links to the topic with id.
+func outlineLists(outline string, id int) bool {
+	for _, section := range outlineSections(outline) {
+		for _, listed := range section.TopicIDs {
+			if listed == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// siteIndexes returns the main documentation index followed by the
+// configured ones.
+func siteIndexes() []*Index {
+	title := config.IndexTitle
+	if title == "" {
+		title = "Documentation"
+	}
+	main := &Index{Title: title, Path: indexPagePath, id: indexPageID}
+	return append([]*Index{main}, config.Indexes...)
+}
+
+type indexTab struct {
+	Title  string
+	URL    string
+	Active bool
+}
+
+// indexTabs returns the tabs for the configured indexes, with the one
+// listing topic active, along with the outline of the active index. No
+// tabs are returned when there is a single index.
+func indexTabs(topic *Topic, budget *upstreamBudget) (tabs []*indexTab, outline string) {
+	indexes := siteIndexes()
+	outlines := make([]string, len(indexes))
+	active := -1
+	for i, idx := range indexes {
+		outlines[i] = idx.outline(budget)
+		if active < 0 && topic != nil && (topic.ID == idx.id || outlineLists(outlines[i], topic.ID)) {
+			active = i
+		}
+	}
+	if active < 0 {
+		active = 0
+	}
+	if len(indexes) == 1 {
+		return nil, outlines[0]
+	}
+	for i, idx := range indexes {
+		tabs = append(tabs, &indexTab{Title: idx.Title, URL: idx.URL(), Active: i == active})
+	}
+	return tabs, outlines[active]
+}
+
+var outlineLink = regexp.MustCompile(`<a\b[^>]*?\shref="((?:/[a-z0-9-]+)+/([0-9]+))"`)
+
+// outlineFragment is an outline prepared once for rendering in every
+// page, with the editor's notes removed and its links located.
+type outlineFragment struct {
+	html  string
+	links map[int][]int // Offsets of the links to each topic ID.
+}
+
+const outlineFragmentsMax = 32
+
+var outlineFragments struct {
+	mu    sync.Mutex
+	byRaw map[string]*outlineFragment
+}
+
+// preparedOutline returns the prepared form of outline, reusing it for
+// as long as the outline doesn't change.
+func preparedOutline(outline string) *outlineFragment {
+	outlineFragments.mu.Lock()
+	defer outlineFragments.mu.Unlock()
+	if f, ok := outlineFragments.byRaw[outline]; ok {
+		return f
+	}
+	f := &outlineFragment{html: linkOutlineHeadings(editorsNote.ReplaceAllString(outline, "")), links: make(map[int][]int)}
+	for _, m := range outlineLink.FindAllStringSubmatchIndex(f.html, -1) {
+		id, _ := strconv.Atoi(f.html[m[4]:m[5]])
+		f.links[id] = append(f.links[id], m[0])
+	}
+	// Outlines change rarely, so stale ones are simply dropped together.
+	if len(outlineFragments.byRaw) >= outlineFragmentsMax {
+		outlineFragments.byRaw = nil
+	}
+	if outlineFragments.byRaw == nil {
+		outlineFragments.byRaw = make(map[string]*outlineFragment)
+	}
+	outlineFragments.byRaw[outline] = f
+	return f
+}
+
+// current returns the outline with the links to the topic with id marked
+// as the current page, for assistive technologies and styling.
+func (f *outlineFragment) current(id int) string {
+	offsets := f.links[id]
+	if len(offsets) == 0 {
+		return f.html
+	}
+	const attr = `aria-current="page" `
+	var buf strings.Builder
+	buf.Grow(len(f.html) + len(offsets)*len(attr))
+	last := 0
+	for _, offset := range offsets {
+		offset += len("<a ")
+		buf.WriteString(f.html[last:offset])
+		buf.WriteString(attr)
+		last = offset
+	}
+	buf.WriteString(f.html[last:])
+	return buf.String()
+}
+
+// isIndex returns whether topic is the outline topic of any index.
+func isIndex(topic *Topic) bool {
+	for _, idx := range siteIndexes() {
+		if topic.ID == idx.id {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	indexRetryMin = 5 * time.Second
+	indexRetryMax = 5 * time.Minute
+)
+
+type indexRetrier struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+var indexRetries indexRetrier
+
+// pending returns whether the index at path is being retried.
+func (r *indexRetrier) pending(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paths[path]
+}
+
+// start fetches the index at path in the background, backing off
+// exponentially, until it succeeds.
+func (r *indexRetrier) start(path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paths[path] {
+		return
+	}
+	if r.paths == nil {
+		r.paths = make(map[string]bool)
+	}
+	r.paths[path] = true
+	go func() {
+		for delay := indexRetryMin; ; {
+			time.Sleep(delay)
+			_, err := forum.Topic(path)
+			if err == nil {
+				break
+			}
+			if delay *= 2; delay > indexRetryMax {
+				delay = indexRetryMax
+			}
+			log.Printf("Cannot obtain index %s, retrying in %v: %v", path, delay, err)
+		}
+		r.mu.Lock()
+		delete(r.paths, path)
+		r.mu.Unlock()
+	}()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	janitorInterval = time.Hour
+
+	// artifactMaxSize bounds the memory held by generated artifacts.
+	artifactMaxSize = 256 << 20
+)
+
+// janitorStats counts what the janitor reclaimed, by cache.
+type janitorStats struct {
+	mu        sync.Mutex
+	size      map[string]int64 // Bytes currently held.
+	reclaimed map[string]int64 // Bytes removed.
+	removed   map[string]int64 // Entries removed.
+}
+
+var janitor = &janitorStats{
+	size:      make(map[string]int64),
+	reclaimed: make(map[string]int64),
+	removed:   make(map[string]int64),
+}
+
+func (s *janitorStats) record(cache string, size, reclaimed, removed int64) {
+	s.mu.Lock()
+	s.size[cache] = size
+	s.reclaimed[cache] += reclaimed
+	s.removed[cache] += removed
+	s.mu.Unlock()
+}
+
+// janitorLoop expires stale entries of the disk caches and of the
+// generated artifacts, so that their usage cannot grow forever.
+func janitorLoop() {
+	for {
+		sweepDiskCaches()
+		sweepArtifacts()
+		time.Sleep(janitorInterval)
+	}
+}
+
+type cachedFile struct {
+	cache string
+	path  string
+	size  int64
+	time  time.Time
+}
+
+// sweepDiskCaches removes the files of the disk caches older than
+// -cache-max-age, and then the oldest ones until all fit in
+// -cache-max-size.
+func sweepDiskCaches() {
+	dirs := map[string]string{"images": *imageCacheFlag, "og-images": *ogImagesFlag}
+	var files []*cachedFile
+	for cache, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			log.Printf("Cannot list %s cache: %v", cache, err)
+			continue
+		}
+		for _, info := range infos {
+			// Temporary files are being written.
+			if info.Mode().IsRegular() && !strings.Contains(info.Name(), ".tmp") {
+				files = append(files, &cachedFile{cache, filepath.Join(dir, info.Name()), info.Size(), info.ModTime()})
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].time.Before(files[j].time) })
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	size := make(map[string]int64)
+	reclaimed := make(map[string]int64)
+	removed := make(map[string]int64)
+	maxSize := int64(*cacheMaxSizeFlag) << 20
+	now := time.Now()
+	for _, f := range files {
+		expired := *cacheMaxAgeFlag > 0 && now.Sub(f.time) > *cacheMaxAgeFlag
+		if expired || maxSize > 0 && total > maxSize {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				log.Printf("Cannot remove stale cache file: %v", err)
+			} else {
+				total -= f.size
+				reclaimed[f.cache] += f.size
+				removed[f.cache]++
+				continue
+			}
+		}
+		size[f.cache] += f.size
+	}
+	for cache, dir := range dirs {
+		if dir != "" {
+			janitor.record(cache, size[cache], reclaimed[cache], removed[cache])
+		}
+	}
+	if n := removed["images"] + removed["og-images"]; n > 0 {
+		log.Printf("Removed %d stale cache files, reclaiming %d bytes.", n, reclaimed["images"]+reclaimed["og-images"])
+	}
+}
+
+// sweepArtifacts drops the expired artifacts, and then the oldest ones
+// until all fit in artifactMaxSize.
+func sweepArtifacts() {
+	artifacts.mu.Lock()
+	defer artifacts.mu.Unlock()
+	names := make([]string, 0, len(artifacts.m))
+	var total int64
+	for name, a := range artifacts.m {
+		names = append(names, name)
+		total += a.size()
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return artifacts.m[names[i]].checked.Before(artifacts.m[names[j]].checked)
+	})
+	var reclaimed, removed int64
+	now := time.Now()
+	for _, name := range names {
+		a := artifacts.m[name]
+		if a.checked.Add(artifactTimeout).Before(now) || total > artifactMaxSize {
+			delete(artifacts.m, name)
+			total -= a.size()
+			reclaimed += a.size()
+			removed++
+		}
+	}
+	janitor.record("artifacts", total, reclaimed, removed)
+}
+
+func writeJanitorMetrics(buf *strings.Builder) {
+	janitor.mu.Lock()
+	defer janitor.mu.Unlock()
+	caches := make([]string, 0, len(janitor.size))
+	for cache := range janitor.size {
+		caches = append(caches, cache)
+	}
+	sort.Strings(caches)
+
+	buf.WriteString("# HELP snapdocs_cache_size_bytes Size of cached files and artifacts, as of the last sweep.\n")
+	buf.WriteString("# TYPE snapdocs_cache_size_bytes gauge\n")
+	for _, cache := range caches {
+		fmt.Fprintf(buf, "snapdocs_cache_size_bytes{cache=%q} %d\n", cache, janitor.size[cache])
+	}
+	buf.WriteString("# HELP snapdocs_cache_reclaimed_bytes_total Bytes reclaimed by removing stale cached files and artifacts.\n")
+	buf.WriteString("# TYPE snapdocs_cache_reclaimed_bytes_total counter\n")
+	for _, cache := range caches {
+		fmt.Fprintf(buf, "snapdocs_cache_reclaimed_bytes_total{cache=%q} %d\n", cache, janitor.reclaimed[cache])
+	}
+	buf.WriteString("# HELP snapdocs_cache_removed_total Stale cached files and artifacts removed.\n")
+	buf.WriteString("# TYPE snapdocs_cache_removed_total counter\n")
+	for _, cache := range caches {
+		fmt.Fprintf(buf, "snapdocs_cache_removed_total{cache=%q} %d\n", cache, janitor.removed[cache])
+	}
+}
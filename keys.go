@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+)
+
+func serveKeyboardScript(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/javascript")
+	resp.Header().Set("Cache-Control", "max-age=3600")
+	resp.Write([]byte(keyboardScript))
+}
+
+// keyboardScript makes "/" focus the search box, "j" and "k" move through
+// the links in the index, and Ctrl-K (or Cmd-K) open a palette searching
+// pages as the reader types. The palette is left out when the script tag
+// has data-search="false".
+const keyboardScript = `(function() {
+	var search = document.currentScript.getAttribute("data-search") !== "false";
+
+	function typing(e) {
+		var t = e.target;
+		return t.isContentEditable || t.tagName === "INPUT" || t.tagName === "TEXTAREA" || t.tagName === "SELECT";
+	}
+
+	function indexLinks() {
+		return Array.prototype.slice.call(document.querySelectorAll(".sidebar .index-outline a[href]"));
+	}
+
+	function move(delta) {
+		var links = indexLinks();
+		if (!links.length) {
+			return;
+		}
+		var i = links.indexOf(document.activeElement);
+		if (i < 0) {
+			for (var j = 0; j < links.length; j++) {
+				if (links[j].pathname === location.pathname) {
+					i = j;
+				}
+			}
+		}
+		i = i < 0 ? 0 : Math.max(0, Math.min(links.length - 1, i + delta));
+		links[i].focus();
+		links[i].scrollIntoView({block: "nearest"});
+	}
+
+	var palette, input, list, selected = -1, timer, seq = 0;
+
+	function select(i) {
+		var items = list.children;
+		if (!items.length) {
+			return;
+		}
+		if (selected >= 0 && items[selected]) {
+			items[selected].className = "";
+		}
+		selected = Math.max(0, Math.min(items.length - 1, i));
+		items[selected].className = "selected";
+		items[selected].scrollIntoView({block: "nearest"});
+	}
+
+	function query() {
+		var q = input.value.trim();
+		var n = ++seq;
+		if (!q) {
+			list.innerHTML = "";
+			return;
+		}
+		fetch("/api/v1/search?q=" + encodeURIComponent(q)).then(function(resp) {
+			return resp.ok ? resp.json() : [];
+		}).then(function(results) {
+			if (n !== seq) {
+				return;
+			}
+			list.innerHTML = "";
+			selected = -1;
+			results.slice(0, 10).forEach(function(r) {
+				var li = document.createElement("li");
+				var a = document.createElement("a");
+				a.href = r.url;
+				a.textContent = r.section ? r.title + " § " + r.section : r.title;
+				li.appendChild(a);
+				list.appendChild(li);
+			});
+			select(0);
+		}).catch(function() {});
+	}
+
+	function openPalette() {
+		if (!palette) {
+			palette = document.createElement("div");
+			palette.className = "command-palette";
+			palette.innerHTML = '<div class="command-palette-box"><input type="search" placeholder="Go to page..."><ul></ul></div>';
+			input = palette.querySelector("input");
+			list = palette.querySelector("ul");
+			input.addEventListener("input", function() {
+				clearTimeout(timer);
+				timer = setTimeout(query, 200);
+			});
+			input.addEventListener("keydown", function(e) {
+				if (e.key === "ArrowDown") {
+					select(selected + 1);
+				} else if (e.key === "ArrowUp") {
+					select(selected - 1);
+				} else if (e.key === "Enter" && list.children[selected]) {
+					location.href = list.children[selected].firstChild.href;
+				} else if (e.key === "Escape") {
+					closePalette();
+				} else {
+					return;
+				}
+				e.preventDefault();
+			});
+			palette.addEventListener("click", function(e) {
+				if (e.target === palette) {
+					closePalette();
+				}
+			});
+			document.body.appendChild(palette);
+		}
+		palette.style.display = "block";
+		input.focus();
+		input.select();
+	}
+
+	function closePalette() {
+		palette.style.display = "none";
+	}
+
+	document.addEventListener("keydown", function(e) {
+		if (search && e.key === "k" && (e.ctrlKey || e.metaKey)) {
+			e.preventDefault();
+			openPalette();
+			return;
+		}
+		if (typing(e) || e.ctrlKey || e.metaKey || e.altKey) {
+			return;
+		}
+		if (e.key === "/") {
+			var box = document.querySelector(".search input[type=search]");
+			if (box) {
+				e.preventDefault();
+				box.focus();
+			}
+		} else if (e.key === "j") {
+			move(1);
+		} else if (e.key === "k") {
+			move(-1);
+		}
+	});
+})();
+`
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Landing configures a landing page served at / instead of the
+// documentation outline, with the sections of the outline as cards,
+// a search box, and the recently updated pages.
+type Landing struct {
+	// Title is the heading of the page.
+	Title string `json:"title"`
+
+	// Tagline is shown below the title.
+	Tagline string `json:"tagline"`
+
+	// Recent is how many recently updated pages are listed, 5 by
+	// default.
+	Recent int `json:"recent"`
+
+	// Template is an HTML file replacing the landing page content.
+	Template string `json:"template"`
+
+	template *template.Template
+}
+
+const landingSectionPages = 4
+
+func (l *Landing) init() error {
+	if l.Title == "" {
+		l.Title = "Snap documentation"
+	}
+	if l.Recent == 0 {
+		l.Recent = 5
+	}
+	if l.Recent < 0 {
+		return fmt.Errorf("landing page recent count must not be negative")
+	}
+	text := landingTemplateString
+	if l.Template != "" {
+		data, err := ioutil.ReadFile(l.Template)
+		if err != nil {
+			return fmt.Errorf("cannot read landing page template: %v", err)
+		}
+		text = string(data)
+	}
+	t, err := template.New("landing").Funcs(pageFuncs).Parse(text)
+	if err != nil {
+		return fmt.Errorf("cannot parse landing page template: %v", err)
+	}
+	l.template = t
+	return nil
+}
+
+type landingData struct {
+	*Landing
+	Sections []*landingSection
+	Recent   []*Topic
+	NoSearch bool
+}
+
+type landingSection struct {
+	Title  string
+	Link   string
+	Count  int
+	Topics []*Topic
+}
+
+// landingSections returns the sections of the outline with their first
+// few pages, as far as budget allows.
+func landingSections(topics []*Topic, budget *upstreamBudget) []*landingSection {
+	byID := make(map[int]*Topic, len(topics))
+	for _, topic := range topics {
+		byID[topic.ID] = topic
+	}
+	var sections []*landingSection
+	for _, section := range outlineSections(indexOutline(budget)) {
+		s := &landingSection{Title: section.Title}
+		for _, id := range section.TopicIDs {
+			topic, ok := byID[id]
+			if !ok {
+				continue
+			}
+			s.Count++
+			if len(s.Topics) < landingSectionPages {
+				s.Topics = append(s.Topics, topic)
+			}
+		}
+		if s.Count == 0 {
+			continue
+		}
+		s.Link = s.Topics[0].String()
+		if section.Title != "" {
+			s.Link = sectionPath("", section.Title)
+		}
+		sections = append(sections, s)
+	}
+	return sections
+}
+
+// recentTopics returns the n topics bumped last, leaving out the index.
+func recentTopics(topics []*Topic, n int) []*Topic {
+	var recent []*Topic
+	for _, topic := range topics {
+		if topic.ID != indexPageID && !isIndex(topic) {
+			recent = append(recent, topic)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i].BumpedAt.After(recent[j].BumpedAt) })
+	if len(recent) > n {
+		recent = recent[:n]
+	}
+	return recent
+}
+
+func serveLanding(resp http.ResponseWriter, req *http.Request) {
+	l := config.Landing
+	budget := requestBudget(req)
+	topics, err := forum.TopicsWithin(budget)
+	if err != nil {
+		log.Printf("Cannot list topics for landing page: %v", err)
+	}
+	data := &landingData{
+		Landing:  l,
+		Sections: landingSections(topics, budget),
+		Recent:   recentTopics(topics, l.Recent),
+		NoSearch: disabled("search"),
+	}
+
+	var buf strings.Builder
+	err = l.template.Execute(&buf, data)
+	if err != nil {
+		log.Printf("Cannot execute landing page template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{
+		Title:    l.Title,
+		Content:  buf.String(),
+		override: &PageOverride{FullWidth: true, Class: "landing"},
+	})
+}
+
+const landingTemplateString = `
+{{with .Tagline}}<p class="lead">{{.}}</p>{{end}}
+{{if not .NoSearch}}
+<form class="landing-search" method="GET" action="/search" role="search">
+	<input type="search" name="q" class="form-control input-lg" placeholder="Search the documentation" aria-label="Search the documentation">
+</form>
+{{end}}
+<div class="landing-sections">
+{{range .Sections}}<section class="landing-section">
+	<h2><a href="{{.Link}}">{{.Title}}</a></h2>
+	<ul>
+	{{range .Topics}}<li><a href="{{.}}">{{.Title}}</a></li>
+	{{end}}
+	</ul>
+	{{if gt .Count (len .Topics)}}<p class="more"><a href="{{.Link}}">All {{pluralize .Count "page"}}</a></p>{{end}}
+</section>
+{{end}}
+</div>
+{{if .Recent}}
+<h2>Recently updated</h2>
+<ul class="landing-recent">
+{{range .Recent}}<li><a href="{{.}}">{{.Title}}</a> <span class="text-muted">{{relTime .BumpedAt}}</span></li>
+{{end}}
+</ul>
+{{end}}
+`
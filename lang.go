@@ -0,0 +1,80 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// langStopWords are frequent words telling languages apart in
+// documentation text.
+var langStopWords = map[string][]string{
+	"en": {"the", "and", "is", "of", "to", "with", "this", "that", "you", "for"},
+	"es": {"el", "la", "los", "las", "y", "es", "de", "para", "con", "que"},
+	"pt": {"o", "os", "as", "e", "é", "do", "da", "para", "com", "não"},
+	"fr": {"le", "la", "les", "et", "est", "des", "pour", "avec", "vous", "une"},
+	"de": {"der", "die", "das", "und", "ist", "mit", "für", "nicht", "sie", "ein"},
+	"it": {"il", "gli", "le", "e", "è", "di", "per", "con", "che", "una"},
+}
+
+var langWord = regexp.MustCompile(`[\pL]+`)
+
+const (
+	langMinWords = 30
+	langMinRatio = 1.5
+)
+
+// detectLang guesses the language of the text in content from the
+// frequency of common words, returning an empty string if there is not
+// enough text or no language stands out.
+func detectLang(content string) string {
+	words := langWord.FindAllString(strings.ToLower(plainText(content)), -1)
+	if len(words) < langMinWords {
+		return ""
+	}
+	scores := make(map[string]int)
+	for lang, stopWords := range langStopWords {
+		set := make(map[string]bool, len(stopWords))
+		for _, w := range stopWords {
+			set[w] = true
+		}
+		for _, w := range words {
+			if set[w] {
+				scores[lang]++
+			}
+		}
+	}
+	best, second := "", 0
+	for lang, score := range scores {
+		if best == "" || score > scores[best] {
+			if best != "" {
+				second = scores[best]
+			}
+			best = lang
+		} else if score > second {
+			second = score
+		}
+	}
+	if best == "" || scores[best] == 0 || float64(scores[best]) < langMinRatio*float64(second) {
+		return ""
+	}
+	return best
+}
+
+// Lang returns the language of the topic: the one set in the page
+// metadata, of its translated documentation set, in the forum locale of
+// the topic, or detected from its text, falling back to the -lang one.
+func (t *Topic) Lang() string {
+	if t.Meta != nil && t.Meta.Lang != "" {
+		return t.Meta.Lang
+	}
+	if l := topicLanguage(t); l != nil {
+		return l.Lang
+	}
+	if t.Locale != "" {
+		return t.Locale
+	}
+	if t.lang != "" {
+		return t.lang
+	}
+	return *langFlag
+}
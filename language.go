@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// Language is a translated documentation set, served under /LANG/. Its
+// topics are the ones in Category, or the ones in the documentation
+// categories with Tag. A translated topic names the topic it translates
+// with "translation-of" in its page metadata, for the language switcher
+// to map between them.
+type Language struct {
+	Lang     string `json:"lang"`
+	Name     string `json:"name"`
+	Category int    `json:"category"`
+	Tag      string `json:"tag"`
+
+	// Index is the path of the outline topic of the language, which the
+	// switcher links to when a page has no translation.
+	Index string `json:"index"`
+}
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]+)*$`)
+
+// langNames are the default names of languages in the switcher.
+var langNames = map[string]string{
+	"de": "Deutsch",
+	"en": "English",
+	"es": "Español",
+	"fr": "Français",
+	"it": "Italiano",
+	"ja": "日本語",
+	"pt": "Português",
+	"zh": "中文",
+}
+
+func (l *Language) check() error {
+	if !languagePattern.MatchString(l.Lang) {
+		return fmt.Errorf("invalid language %q", l.Lang)
+	}
+	if (l.Category == 0) == (l.Tag == "") {
+		return fmt.Errorf("language %q needs either a category or a tag", l.Lang)
+	}
+	if l.Name == "" {
+		l.Name = langName(l.Lang)
+	}
+	if l.Index != "" {
+		if _, err := topicPathID(l.Index); err != nil {
+			return fmt.Errorf("invalid index path %q for language %q: %v", l.Index, l.Lang, err)
+		}
+	}
+	return nil
+}
+
+func langName(lang string) string {
+	if name, ok := langNames[lang]; ok {
+		return name
+	}
+	return lang
+}
+
+// namespace returns the namespace serving the topics of the language.
+func (l *Language) namespace() *Namespace {
+	return &Namespace{Category: l.Category, Prefix: "/" + l.Lang, tag: l.Tag}
+}
+
+// indexPath returns the path of the language index under its prefix.
+func (l *Language) indexPath() string {
+	rest, _ := stripNamespace(l.Index)
+	return "/" + l.Lang + rest
+}
+
+// languageRoot returns the language served under the namespace prefix,
+// if any.
+func languageRoot(prefix string) *Language {
+	for _, l := range config.Languages {
+		if "/"+l.Lang == prefix {
+			return l
+		}
+	}
+	return nil
+}
+
+// topicLanguage returns the configured language topic is in, or nil if
+// it's in the main documentation set.
+func topicLanguage(topic *Topic) *Language {
+	for _, l := range config.Languages {
+		if l.Tag != "" && hasTag(topic, l.Tag) {
+			return l
+		}
+	}
+	for _, l := range config.Languages {
+		if l.Category != 0 && l.Category == topic.Category {
+			return l
+		}
+	}
+	return nil
+}
+
+// translationSource returns the ID of the topic that topic translates,
+// or its own ID if it's not a translation.
+func translationSource(topic *Topic) int {
+	if topic.Meta == nil || topic.Meta.TranslationOf == "" {
+		return topic.ID
+	}
+	source := topic.Meta.TranslationOf
+	if id, err := strconv.Atoi(source); err == nil {
+		return id
+	}
+	if id, err := topicPathID(source); err == nil {
+		return id
+	}
+	return topic.ID
+}
+
+// langAlternate is an entry in the language switcher.
+type langAlternate struct {
+	Lang    string
+	Name    string
+	URL     string
+	Current bool
+
+	// Translated is whether URL is the counterpart of the current page,
+	// rather than the language index.
+	Translated bool
+}
+
+// languageAlternates returns the language switcher entries for topic,
+// starting with the main documentation set. Translations are found among
+// the cached topics, as their metadata is only known once fetched.
+func languageAlternates(req *http.Request, topic *Topic) []*langAlternate {
+	source := translationSource(topic)
+	current := topicLanguage(topic)
+
+	counterparts := make(map[*Language]*Topic)
+	var original *Topic
+	if source == topic.ID {
+		original = topic
+	} else {
+		original = forum.Cached(source)
+	}
+	for _, cached := range forum.CachedTopics() {
+		if cached.ID != source && translationSource(cached) == source {
+			if l := topicLanguage(cached); l != nil {
+				counterparts[l] = cached
+			}
+		}
+	}
+	if current != nil {
+		counterparts[current] = topic
+	}
+
+	main := &langAlternate{Lang: *langFlag, Name: langName(*langFlag), Current: current == nil}
+	if original != nil && topicLanguage(original) == nil {
+		main.URL, main.Translated = siteURL(req, original.String()), true
+	} else {
+		main.URL = siteURL(req, "/")
+	}
+	alternates := []*langAlternate{main}
+	for _, l := range config.Languages {
+		alt := &langAlternate{Lang: l.Lang, Name: l.Name, Current: l == current}
+		if t, ok := counterparts[l]; ok {
+			alt.URL, alt.Translated = siteURL(req, t.String()), true
+		} else if l.Index != "" {
+			alt.URL = siteURL(req, l.indexPath())
+		} else {
+			continue
+		}
+		alternates = append(alternates, alt)
+	}
+	return alternates
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// legacyPathPattern matches paths in the scheme of the old
+// docs.snapcraft.io site, such as /core/usage or /build-snaps/languages.
+var legacyPathPattern = regexp.MustCompile(`^(/[a-z0-9-]+){1,3}(?:\.html)?/?$`)
+
+// legacySections are the top-level sections of the old documentation
+// site. Only paths under them, or under the prefix of a configured
+// redirect, are guessed to be legacy pages.
+var legacySections = []string{"build-snaps", "core", "publish", "reference", "snapcraft-overview"}
+
+// isLegacySection reports whether section is a top-level section of the
+// old documentation site.
+func isLegacySection(section string) bool {
+	for _, s := range legacySections {
+		if s == section {
+			return true
+		}
+	}
+	for path := range config.LegacyRedirects {
+		if strings.SplitN(strings.Trim(path, "/"), "/", 2)[0] == section {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLegacy returns the path of the topic replacing the page at the
+// given path of the old documentation site, and the status to redirect
+// with. The configured mapping is tried first, and redirects permanently.
+// Under the sections of the old site, topics whose slug matches the path
+// and finally a search for the words in its last element are tried, as
+// far as budget allows, and redirect temporarily as they are guesses.
+func resolveLegacy(path string, budget *upstreamBudget) (string, int) {
+	if !legacyPathPattern.MatchString(path) {
+		return "", 0
+	}
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".html")
+	if target, ok := config.LegacyRedirects[path]; ok {
+		return target, http.StatusMovedPermanently
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if !isLegacySection(parts[0]) {
+		return "", 0
+	}
+	last := parts[len(parts)-1]
+	slugs := []string{strings.Join(parts, "-"), last}
+	if topics, err := forum.TopicsWithin(budget); err == nil {
+		for _, slug := range slugs {
+			for _, topic := range topics {
+				if topic.Slug == slug {
+					return topic.String(), http.StatusFound
+				}
+			}
+		}
+	}
+
+	results, err := forum.SearchWithin(strings.Replace(last, "-", " ", -1), budget)
+	if err != nil {
+		log.Printf("Cannot search for legacy path %s: %v", path, err)
+		return "", 0
+	}
+	if len(results) > 0 {
+		return results[0].String(), http.StatusFound
+	}
+	return "", 0
+}
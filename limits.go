@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ContentLimits bound the content of pages, protecting render latency and
+// memory from pathological topics. Content beyond a limit is left out,
+// with a notice linking to the full page on the forum. Zero disables a
+// limit.
+type ContentLimits struct {
+	// MaxSize is the largest size of the content of a page, in bytes.
+	MaxSize int `json:"max-size"`
+
+	// MaxImages is the largest number of images shown in a page. Further
+	// images become links.
+	MaxImages int `json:"max-images"`
+
+	// MaxTableCells is the largest number of cells shown in a table.
+	MaxTableCells int `json:"max-table-cells"`
+}
+
+func (l *ContentLimits) init() error {
+	if l.MaxSize < 0 || l.MaxImages < 0 || l.MaxTableCells < 0 {
+		return fmt.Errorf("content limits cannot be negative")
+	}
+	return nil
+}
+
+// apply returns the content of topic within the limits.
+func (l *ContentLimits) apply(topic *Topic, content string) string {
+	if l == nil {
+		return content
+	}
+	if l.MaxImages > 0 || l.MaxTableCells > 0 {
+		content = transformContent(content, l.pass(topic))
+	}
+	if l.MaxSize > 0 && len(content) > l.MaxSize {
+		content = l.truncate(topic, content)
+	}
+	return content
+}
+
+// limitNotice returns a paragraph telling readers that what precedes is
+// incomplete, linking to the full page on the forum.
+func limitNotice(topic *Topic, text string) *html.Node {
+	p := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P, Attr: []html.Attribute{{Key: "class", Val: "content-truncated"}}}
+	p.AppendChild(&html.Node{Type: html.TextNode, Data: text + " "})
+	a := &html.Node{Type: html.ElementNode, Data: "a", DataAtom: atom.A, Attr: []html.Attribute{{Key: "href", Val: topic.ForumURL()}}}
+	a.AppendChild(&html.Node{Type: html.TextNode, Data: "View the full page on the forum."})
+	p.AppendChild(a)
+	return p
+}
+
+// pass replaces the images past MaxImages with links to them, and drops
+// the rows of tables past MaxTableCells.
+func (l *ContentLimits) pass(topic *Topic) contentPass {
+	return func(root *html.Node) {
+		images := 0
+		var extra []*html.Node
+		walkElements(root, func(n *html.Node) bool {
+			switch n.DataAtom {
+			case atom.Img:
+				if images++; l.MaxImages > 0 && images > l.MaxImages {
+					extra = append(extra, n)
+				}
+			case atom.Table:
+				if l.MaxTableCells > 0 {
+					limitTable(topic, n, l.MaxTableCells)
+				}
+			}
+			return true
+		})
+		for _, n := range extra {
+			text := nodeAttr(n, "alt")
+			if text == "" {
+				text = "Image"
+			}
+			link := &html.Node{Type: html.ElementNode, Data: "a", DataAtom: atom.A, Attr: []html.Attribute{{Key: "href", Val: nodeAttr(n, "src")}}}
+			link.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+			n.Parent.InsertBefore(link, n)
+			n.Parent.RemoveChild(n)
+		}
+	}
+}
+
+// limitTable drops the rows of table from the one taking it past max
+// cells, noting how many were left out after it.
+func limitTable(topic *Topic, table *html.Node, max int) {
+	var rows []*html.Node
+	walkElements(table, func(n *html.Node) bool {
+		if n.DataAtom == atom.Tr {
+			rows = append(rows, n)
+			return false
+		}
+		return n == table || n.DataAtom == atom.Thead || n.DataAtom == atom.Tbody || n.DataAtom == atom.Tfoot
+	})
+	cells := 0
+	for i, row := range rows {
+		for c := row.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Td || c.DataAtom == atom.Th {
+				cells++
+			}
+		}
+		if cells > max {
+			for _, r := range rows[i:] {
+				r.Parent.RemoveChild(r)
+			}
+			note := limitNotice(topic, fmt.Sprintf("%s of this table not shown.", pluralize(len(rows)-i, "row")))
+			table.Parent.InsertBefore(note, table.NextSibling)
+			return
+		}
+	}
+}
+
+// truncate cuts content at the last top-level element fitting in MaxSize,
+// adding a notice.
+func (l *ContentLimits) truncate(topic *Topic, content string) string {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		log.Printf("Cannot parse content of %s to truncate: %v", topic, err)
+		nodes = nil
+	}
+	var buf, node bytes.Buffer
+	for _, n := range nodes {
+		node.Reset()
+		html.Render(&node, n)
+		if buf.Len()+node.Len() > l.MaxSize {
+			break
+		}
+		buf.Write(node.Bytes())
+	}
+	log.Printf("Truncated content of %s from %d to %d bytes.", topic, len(content), buf.Len())
+	html.Render(&buf, limitNotice(topic, "This page is too long to show in full."))
+	return buf.String()
+}
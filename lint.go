@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lintIssue is a structural problem found in a documentation page.
+type lintIssue struct {
+	Path    string `json:"path"`
+	Title   string `json:"title"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+var (
+	lintHeading   = regexp.MustCompile(`<h([1-6])\b[^>]*>(.*?)</h[1-6]>`)
+	lintAnchor    = regexp.MustCompile(`(?s)<a\b([^>]*)>(.*?)</a>`)
+	lintHref      = regexp.MustCompile(`\shref="([^"]*)"`)
+	lintImage     = regexp.MustCompile(`<img\b[^>]*>`)
+	lintImageAlt  = regexp.MustCompile(`\salt="\s*[^"\s][^"]*"`)
+	lintCodeBlock = regexp.MustCompile(`(?s)<pre[^>]*><code[^>]*>(.*?)</code></pre>`)
+	lintImageSrc  = regexp.MustCompile(`\ssrc="([^"]*)"`)
+	lintTarget    = regexp.MustCompile(`\s(?:id|name)="([^"]+)"`)
+)
+
+// lintContent checks the rendered content of a page for structural
+// problems, calling report for each of them.
+func lintContent(content string, maxCodeLine int, report func(rule, message string)) {
+	headings := lintHeading.FindAllStringSubmatch(content, -1)
+	hasH1 := false
+	last := 0
+	for _, m := range headings {
+		level, _ := strconv.Atoi(m[1])
+		if level == 1 {
+			hasH1 = true
+		}
+		if last > 0 && level > last+1 {
+			report("heading-skip", fmt.Sprintf("heading %q is h%d after h%d", plainText(m[2]), level, last))
+		}
+		last = level
+	}
+	if !hasH1 {
+		report("missing-h1", "page has no h1 heading")
+	}
+
+	targets := make(map[string]bool)
+	for _, m := range lintTarget.FindAllStringSubmatch(content, -1) {
+		targets[html.UnescapeString(m[1])] = true
+	}
+	for _, m := range lintAnchor.FindAllStringSubmatch(content, -1) {
+		attrs, text := m[1], plainText(m[2])
+		href := lintHref.FindStringSubmatch(attrs)
+		if href == nil {
+			continue
+		}
+		target := html.UnescapeString(href[1])
+		if strings.HasPrefix(target, "#") && len(target) > 1 && !targets[target[1:]] {
+			report("dead-anchor", fmt.Sprintf("link to missing anchor %s", target))
+		}
+		// Discourse links bare URLs with the URL as text. URLs alone in
+		// a line become oneboxes, which are fine.
+		if (strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")) && text == target && !strings.Contains(attrs, "onebox") {
+			report("bare-url", fmt.Sprintf("bare URL %s", target))
+		}
+	}
+
+	for _, img := range lintImage.FindAllString(content, -1) {
+		if !lintImageAlt.MatchString(img) {
+			src := ""
+			if m := lintImageSrc.FindStringSubmatch(img); m != nil {
+				src = " " + m[1]
+			}
+			report("image-alt", "image without alt text"+src)
+		}
+	}
+
+	if maxCodeLine > 0 {
+		for _, m := range lintCodeBlock.FindAllStringSubmatch(content, -1) {
+			for _, line := range strings.Split(html.UnescapeString(htmlTag.ReplaceAllString(m[1], "")), "\n") {
+				if n := len([]rune(line)); n > maxCodeLine {
+					report("long-code-line", fmt.Sprintf("code line with %d characters: %s", n, truncate(60, strings.TrimSpace(line))))
+				}
+			}
+		}
+	}
+}
+
+func lintCommand(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	format := flags.String("format", "text", "Output format: text, or json for one issue object per line")
+	maxCodeLine := flags.Int("max-code-line", 100, "Maximum length of lines in code blocks, or 0 for no limit")
+	flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unsupported lint format: %s", *format)
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		topics, err := forum.Topics()
+		if err != nil {
+			return err
+		}
+		for _, topic := range topics {
+			if !isIndex(topic) {
+				paths = append(paths, topic.String())
+			}
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	issues, failed := 0, 0
+	for _, path := range paths {
+		topic, err := forum.Topic(path)
+		if err != nil {
+			log.Printf("Cannot lint %s: %v", path, err)
+			failed++
+			continue
+		}
+		lintContent(topic.Content(), *maxCodeLine, func(rule, message string) {
+			issues++
+			if *format == "json" {
+				encoder.Encode(&lintIssue{Path: topic.String(), Title: topic.Title, Rule: rule, Message: message})
+			} else {
+				fmt.Printf("%s: %s: %s\n", topic, rule, message)
+			}
+		})
+	}
+	if failed > 0 {
+		return fmt.Errorf("cannot lint %d of %d pages", failed, len(paths))
+	}
+	if issues > 0 {
+		return fmt.Errorf("found %d issues in %d pages", issues, len(paths))
+	}
+	return nil
+}
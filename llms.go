@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+type corpusEntry struct {
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	Section  string `json:"section,omitempty"`
+	Updated  string `json:"updated"`
+	Markdown string `json:"markdown"`
+}
+
+// corpusEntries returns the cached documentation topics with known
+// markdown, ordered as in the outline, followed by the remaining ones
+// ordered by title. Topics are never fetched for the corpus, so that
+// serving it is cheap and can't be used to hammer the forum.
+func corpusEntries() []*corpusEntry {
+	order := make(map[int]int)
+	sections := make(map[int]string)
+	for _, section := range outlineSections(indexOutline(cacheOnlyBudget())) {
+		for _, id := range section.TopicIDs {
+			if _, ok := order[id]; !ok {
+				order[id] = len(order)
+				sections[id] = section.Title
+			}
+		}
+	}
+
+	var topics []*Topic
+	for _, topic := range forum.CachedTopics() {
+		if isDocCategory(topic.Category) && topic.ID != indexPageID && topic.Markdown() != "" {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		oi, iok := order[topics[i].ID]
+		oj, jok := order[topics[j].ID]
+		if iok != jok {
+			return iok
+		}
+		if iok {
+			return oi < oj
+		}
+		return topics[i].Title < topics[j].Title
+	})
+
+	entries := make([]*corpusEntry, len(topics))
+	for i, topic := range topics {
+		entries[i] = &corpusEntry{
+			ID:       topic.ID,
+			Title:    topic.Title,
+			URL:      topic.URL(),
+			Section:  sections[topic.ID],
+			Updated:  formatTime(topic.LastUpdate()),
+			Markdown: topic.Markdown(),
+		}
+	}
+	return entries
+}
+
+// serveCorpus serves the documentation for consumption by language models
+// and similar tools, at /llms.txt (an index, see llmstxt.org),
+// /llms-full.txt (all the content), and /export/corpus.jsonl (one JSON
+// object per topic).
+func serveCorpus(resp http.ResponseWriter, req *http.Request) {
+	contentType := "text/plain; charset=utf-8"
+	if req.URL.Path == "/export/corpus.jsonl" {
+		contentType = "application/x-ndjson"
+	}
+	a, err := cachedArtifact(req.URL.Path, func() ([]byte, error) {
+		return corpus(req.URL.Path), nil
+	})
+	if err != nil {
+		log.Printf("Cannot generate %s: %v", req.URL.Path, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	a.serve(resp, req, contentType)
+}
+
+// corpus generates the corpus served at path.
+func corpus(path string) []byte {
+	entries := corpusEntries()
+
+	var buf bytes.Buffer
+	switch path {
+	case "/llms.txt":
+		buf.WriteString("# Snap documentation\n\n")
+		buf.WriteString("> Documentation for snaps, snapcraft, and snapd, mirrored from the Snapcraft forum.\n\n")
+		section := "\x00"
+		for _, e := range entries {
+			if e.Section != section {
+				section = e.Section
+				title := section
+				if title == "" {
+					title = "Other"
+				}
+				fmt.Fprintf(&buf, "\n## %s\n\n", title)
+			}
+			fmt.Fprintf(&buf, "- [%s](%s)\n", e.Title, e.URL)
+		}
+	case "/llms-full.txt":
+		for _, e := range entries {
+			fmt.Fprintf(&buf, "# %s\n\nSource: %s\n\n%s\n\n", e.Title, e.URL, e.Markdown)
+		}
+	default:
+		encoder := json.NewEncoder(&buf)
+		for _, e := range entries {
+			if err := encoder.Encode(e); err != nil {
+				log.Printf("Cannot encode corpus entry for topic %d: %v", e.ID, err)
+			}
+		}
+	}
+	return buf.Bytes()
+}
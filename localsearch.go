@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+const (
+	searchIndexFlushInterval = 5 * time.Minute
+	searchIndexMaxResults    = 50
+
+	// searchTitleWeight is how much more a term in the title counts than
+	// one in the content.
+	searchTitleWeight = 3
+
+	// Matches of words starting with a search term, or within typo
+	// distance of it, count less than exact matches.
+	searchPrefixFactor = 0.6
+	searchFuzzyFactor  = 0.4
+	searchPrefixMinLen = 3
+)
+
+// searchDoc is a documentation page in the local search index.
+type searchDoc struct {
+	ID          int            `json:"id"`
+	Slug        string         `json:"slug"`
+	Title       string         `json:"title"`
+	Category    int            `json:"category"`
+	Tags        []string       `json:"tags,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Updated     time.Time      `json:"updated"`
+	Terms       map[string]int `json:"terms"`
+}
+
+// topic returns a topic for presenting doc in search results.
+func (doc *searchDoc) topic() *Topic {
+	return &Topic{
+		ID:       doc.ID,
+		Slug:     doc.Slug,
+		Title:    doc.Title,
+		Category: doc.Category,
+		Tags:     doc.Tags,
+		BumpedAt: doc.Updated,
+		Post: &Post{
+			TopicID:   doc.ID,
+			UpdatedAt: doc.Updated,
+			Blurb:     html.EscapeString(doc.Description),
+		},
+	}
+}
+
+// localIndex is an inverted index of the documentation pages, updated as
+// topics are fetched from the forum.
+type localIndex struct {
+	mu       sync.RWMutex
+	docs     map[int]*searchDoc
+	postings map[string]map[int]int
+	built    time.Time
+
+	dirty    int32
+	building int32
+}
+
+var searchIndex localIndex
+
+type searchIndexDump struct {
+	Built    time.Time    `json:"built"`
+	Analyzer string       `json:"analyzer,omitempty"`
+	Docs     []*searchDoc `json:"docs"`
+}
+
+// searchTokens splits text into lowercase words for indexing and search.
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// newSearchDoc returns the index entry for topic.
+func newSearchDoc(topic *Topic) *searchDoc {
+	doc := &searchDoc{
+		ID:          topic.ID,
+		Slug:        topic.Slug,
+		Title:       topic.Title,
+		Category:    topic.Category,
+		Tags:        topic.Tags,
+		Description: topic.Description(),
+		Updated:     topic.LastUpdate(),
+		Terms:       make(map[string]int),
+	}
+	for _, term := range searchTerms(topic.Title) {
+		doc.Terms[term] += searchTitleWeight
+	}
+	for _, term := range searchTerms(plainText(topic.Content())) {
+		doc.Terms[term]++
+	}
+	return doc
+}
+
+// add indexes doc, replacing any previous entry for the same topic. The
+// caller must hold the lock.
+func (idx *localIndex) add(doc *searchDoc) {
+	if idx.docs == nil {
+		idx.docs = make(map[int]*searchDoc)
+		idx.postings = make(map[string]map[int]int)
+	}
+	if old, ok := idx.docs[doc.ID]; ok {
+		for term := range old.Terms {
+			delete(idx.postings[term], doc.ID)
+			if len(idx.postings[term]) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+	idx.docs[doc.ID] = doc
+	for term, weight := range doc.Terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[int]int)
+		}
+		idx.postings[term][doc.ID] = weight
+	}
+}
+
+// update indexes the freshly fetched topic, if it's a documentation page.
+func (idx *localIndex) update(topic *Topic) {
+	if topic.Post == nil || topic.Post.Version == 0 || isIndex(topic) || !isDocCategory(topic.Category) {
+		return
+	}
+	doc := newSearchDoc(topic)
+	idx.mu.Lock()
+	idx.add(doc)
+	idx.mu.Unlock()
+	atomic.StoreInt32(&idx.dirty, 1)
+}
+
+// size returns the number of indexed pages.
+func (idx *localIndex) size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Search returns the indexed pages containing all the terms in query,
+// best matches first.
+func (idx *localIndex) Search(query string) []*Topic {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	total := float64(len(idx.docs))
+	scores := make(map[int]float64)
+	for i, term := range terms {
+		matched := make(map[int]float64)
+		for alt, factor := range idx.alternatives(term) {
+			postings := idx.postings[alt]
+			idf := math.Log(1 + total/float64(len(postings)+1))
+			for id, weight := range postings {
+				if _, ok := scores[id]; i > 0 && !ok {
+					continue
+				}
+				w := float64(weight)
+				if score := factor * idf * w / (w + 1.2); score > matched[id] {
+					matched[id] = score
+				}
+			}
+		}
+		for id := range matched {
+			matched[id] += scores[id]
+		}
+		scores = matched
+	}
+
+	ids := make([]int, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > searchIndexMaxResults {
+		ids = ids[:searchIndexMaxResults]
+	}
+	topics := make([]*Topic, len(ids))
+	for i, id := range ids {
+		topics[i] = idx.docs[id].topic()
+	}
+	return topics
+}
+
+// alternatives returns the indexed terms matching term along with how
+// much a match counts. Unless fuzzy search is enabled, only term itself
+// matches.
+func (idx *localIndex) alternatives(term string) map[string]float64 {
+	alts := map[string]float64{term: 1}
+	if !*fuzzySearchFlag {
+		return alts
+	}
+	distance := fuzzyDistance(term)
+	for indexed := range idx.postings {
+		if indexed == term {
+			continue
+		}
+		if len(term) >= searchPrefixMinLen && strings.HasPrefix(indexed, term) {
+			alts[indexed] = searchPrefixFactor
+		} else if distance > 0 && abs(len(indexed)-len(term)) <= distance {
+			if d := editDistance(term, indexed); d <= distance {
+				alts[indexed] = searchFuzzyFactor / float64(d)
+			}
+		}
+	}
+	return alts
+}
+
+// fuzzyDistance returns how many typos are tolerated in term: none in
+// short words, where they'd match too much, and up to two in long ones.
+func fuzzyDistance(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Rebuild indexes all documentation pages again from scratch, replacing
+// the index once done. It returns false without doing anything if a
+// rebuild is already running.
+func (idx *localIndex) Rebuild() (bool, error) {
+	if !atomic.CompareAndSwapInt32(&idx.building, 0, 1) {
+		return false, nil
+	}
+	defer atomic.StoreInt32(&idx.building, 0)
+
+	topics, err := forum.Topics()
+	if err != nil {
+		return true, fmt.Errorf("cannot rebuild search index: %v", err)
+	}
+	fresh := &localIndex{}
+	for _, listed := range topics {
+		if isIndex(listed) {
+			continue
+		}
+		topic, err := forum.TopicWithin(listed.String(), crawlBudget())
+		if err != nil {
+			log.Printf("Cannot index %s: %v", listed, err)
+			continue
+		}
+		fresh.add(newSearchDoc(topic))
+	}
+	idx.mu.Lock()
+	idx.docs, idx.postings, idx.built = fresh.docs, fresh.postings, time.Now()
+	idx.mu.Unlock()
+	atomic.StoreInt32(&idx.dirty, 1)
+	log.Printf("Rebuilt search index with %d pages.", len(fresh.docs))
+	return true, nil
+}
+
+func (idx *localIndex) Load(path string) error {
+	entry, err := readCacheFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		// Corrupted files are removed, and built again.
+		log.Printf("%v", err)
+		return nil
+	}
+	var dump searchIndexDump
+	err = json.Unmarshal(entry.Data, &dump)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal search index from %s: %v", path, err)
+	}
+	if dump.Analyzer != searchAnalyzerSignature() {
+		log.Printf("Search analyzer settings changed, rebuilding search index.")
+		return nil
+	}
+	idx.mu.Lock()
+	idx.docs, idx.postings, idx.built = nil, nil, dump.Built
+	for _, doc := range dump.Docs {
+		idx.add(doc)
+	}
+	idx.mu.Unlock()
+	return nil
+}
+
+// Save writes the index to path if it changed since last saved.
+func (idx *localIndex) Save(path string) error {
+	if !atomic.CompareAndSwapInt32(&idx.dirty, 1, 0) {
+		return nil
+	}
+	idx.mu.RLock()
+	dump := searchIndexDump{
+		Built:    idx.built,
+		Analyzer: searchAnalyzerSignature(),
+		Docs:     make([]*searchDoc, 0, len(idx.docs)),
+	}
+	for _, doc := range idx.docs {
+		dump.Docs = append(dump.Docs, doc)
+	}
+	data, err := json.Marshal(&dump)
+	idx.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("cannot marshal search index: %v", err)
+	}
+	err = writeCacheFile(path, &cacheEntry{Data: data})
+	if err != nil {
+		atomic.StoreInt32(&idx.dirty, 1)
+		return fmt.Errorf("cannot write search index: %v", err)
+	}
+	return nil
+}
+
+func (idx *localIndex) flushLoop(path string) {
+	for range time.Tick(searchIndexFlushInterval) {
+		if err := idx.Save(path); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
+
+// startLocalSearch loads the persisted index, if any, and builds it in
+// the background if there is nothing to load.
+func startLocalSearch() error {
+	if *searchIndexFlag != "" {
+		if err := searchIndex.Load(*searchIndexFlag); err != nil {
+			return err
+		}
+		go searchIndex.flushLoop(*searchIndexFlag)
+	}
+	if searchIndex.size() == 0 {
+		go func() {
+			if _, err := searchIndex.Rebuild(); err != nil {
+				log.Printf("%v", err)
+			}
+		}()
+	}
+	return nil
+}
+
+type searchIndexData struct {
+	Pages    int
+	Built    time.Time
+	Building bool
+}
+
+// serveSearchIndex serves the status of the local search index, and
+// rebuilds it when posted to.
+func serveSearchIndex(resp http.ResponseWriter, req *http.Request) {
+	if !*localSearchFlag {
+		sendNotFound(resp, "Local search is disabled.")
+		return
+	}
+	if req.Method == "POST" {
+		audit.Record(req, "rebuild-search-index", "")
+		go func() {
+			if _, err := searchIndex.Rebuild(); err != nil {
+				log.Printf("%v", err)
+			}
+		}()
+		resp.Header().Set("Location", "/admin/search-index")
+		resp.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	searchIndex.mu.RLock()
+	data := &searchIndexData{
+		Pages:    len(searchIndex.docs),
+		Built:    searchIndex.built,
+		Building: atomic.LoadInt32(&searchIndex.building) == 1,
+	}
+	searchIndex.mu.RUnlock()
+
+	var buf strings.Builder
+	err := searchIndexTemplate.Execute(&buf, data)
+	if err != nil {
+		log.Printf("Cannot execute search index template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Search index", Content: buf.String()})
+}
+
+var searchIndexTemplate = template.Must(template.New("search-index").Funcs(pageFuncs).Parse(`
+<p>The local search index has {{.Pages}} documentation pages{{if not .Built.IsZero}}, last fully built {{.Built.Format "2006-01-02 15:04 MST"}}{{end}}.
+Pages are indexed again as they are refreshed from the forum.</p>
+{{if .Building}}<p>A full rebuild is in progress.</p>
+{{else}}<form method="post" action="/admin/search-index"><button type="submit">Rebuild the index</button> from all documentation pages.</form>
+{{end}}
+`))
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// logFile is a log output that may be rotated when it grows too large,
+// and reopened so that external tools such as logrotate may rotate it.
+type logFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// openLog makes the log be written to the file at path.
+func openLog(path string, maxSize int64, keep int) error {
+	l := &logFile{path: path, maxSize: maxSize, keep: keep}
+	if err := l.open(); err != nil {
+		return err
+	}
+	log.SetOutput(l)
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			l.mu.Lock()
+			err := l.open()
+			l.mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("cannot open log file: %v", err)
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func (l *logFile) Write(data []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size+int64(len(data)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames the log file to path.1, shifting older ones up to
+// path.N where N is the number of files to keep, and opens a new one.
+func (l *logFile) rotate() error {
+	for i := l.keep; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", l.path, i-1)
+		if i == 1 {
+			from = l.path
+		}
+		err := os.Rename(from, fmt.Sprintf("%s.%d", l.path, i))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot rotate log file: %v", err)
+		}
+	}
+	if l.keep == 0 {
+		if err := os.Remove(l.path); err != nil {
+			return fmt.Errorf("cannot rotate log file: %v", err)
+		}
+	}
+	return l.open()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Lookup maps well-known keys, such as snapcraft.yaml fields or interface
+// names, to documentation pages, so tools may deep-link into the docs.
+type Lookup struct {
+	// Topic is the path of a topic holding the mapping, as table rows or
+	// list items with the key in code followed by a link to the page.
+	Topic string `json:"topic"`
+
+	// Keys maps further keys to page paths, optionally with an anchor.
+	// They take precedence over the mapping topic.
+	Keys map[string]string `json:"keys"`
+}
+
+func (l *Lookup) init() error {
+	if l.Topic != "" {
+		if _, err := topicPathID(l.Topic); err != nil {
+			return fmt.Errorf("invalid lookup topic %q: %v", l.Topic, err)
+		}
+	}
+	keys := make(map[string]string, len(l.Keys))
+	for key, path := range l.Keys {
+		if _, err := topicPathID(strings.SplitN(path, "#", 2)[0]); err != nil {
+			return fmt.Errorf("invalid lookup path %q for key %q", path, key)
+		}
+		keys[lookupKey(key)] = path
+	}
+	l.Keys = keys
+	return nil
+}
+
+// lookupKey normalizes key for matching.
+func lookupKey(key string) string {
+	return strings.ToLower(strings.Join(strings.Fields(key), " "))
+}
+
+// lookupMapping parses the mapping topic content into keys and paths.
+func lookupMapping(content string) map[string]string {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		log.Printf("Cannot parse lookup topic: %v", err)
+		return nil
+	}
+	mapping := make(map[string]string)
+	for _, n := range nodes {
+		walkElements(n, func(n *html.Node) bool {
+			if n.DataAtom != atom.Tr && n.DataAtom != atom.Li {
+				return true
+			}
+			var key, path string
+			walkElements(n, func(c *html.Node) bool {
+				switch {
+				case key == "" && c.DataAtom == atom.Code:
+					key = nodeText(c)
+				case path == "" && c.DataAtom == atom.A:
+					path = nodeAttr(c, "href")
+				}
+				return true
+			})
+			if id, err := topicPathID(strings.SplitN(path, "#", 2)[0]); key != "" && err == nil && id > 0 {
+				if _, ok := mapping[lookupKey(key)]; !ok {
+					mapping[lookupKey(key)] = path
+				}
+			}
+			return false
+		})
+	}
+	return mapping
+}
+
+// lookupPath returns the page path, with any anchor, that key maps to,
+// obtaining the lookup topic within budget.
+func lookupPath(key string, budget *upstreamBudget) (string, error) {
+	l := config.Lookup
+	key = lookupKey(key)
+	if path, ok := l.Keys[key]; ok {
+		return path, nil
+	}
+	if l.Topic == "" {
+		return "", nil
+	}
+	topic, err := forum.TopicWithin(l.Topic, budget)
+	if err != nil {
+		return "", err
+	}
+	return lookupMapping(topic.Content())[key], nil
+}
+
+type lookupResult struct {
+	Key     string `json:"key"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Extract string `json:"extract,omitempty"`
+}
+
+// serveLookup returns the page documenting the key parameter, with a
+// short extract of the section linked to.
+func serveLookup(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Access-Control-Allow-Origin", "*")
+	if config.Lookup == nil {
+		sendNotFound(resp, "Lookup is not configured.")
+		return
+	}
+	key := req.Form.Get("key")
+	if key == "" {
+		resp.WriteHeader(http.StatusBadRequest)
+		resp.Write([]byte("missing key"))
+		return
+	}
+	budget := requestBudget(req)
+	path, err := lookupPath(key, budget)
+	if err != nil {
+		log.Printf("Cannot obtain lookup topic %s: %v", config.Lookup.Topic, err)
+		if !sendForumError(resp, err) {
+			resp.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	if path == "" {
+		sendNotFound(resp, "Unknown lookup key: %s", key)
+		return
+	}
+	page, anchor := path, ""
+	if i := strings.Index(path, "#"); i >= 0 {
+		page, anchor = path[:i], path[i+1:]
+	}
+	topic, err := forum.TopicWithin(page, budget)
+	if err != nil {
+		log.Printf("Cannot obtain %s for lookup of %q: %v", page, key, err)
+		if !sendForumError(resp, err) {
+			resp.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+
+	result := &lookupResult{
+		Key:     key,
+		Title:   topic.Title,
+		URL:     siteURL(req, topic.String()),
+		Extract: topic.Description(),
+	}
+	if anchor != "" {
+		result.URL += "#" + anchor
+		if section, ok := contentSection(topic.Content(), anchor); ok {
+			result.Extract = contentDescription(section)
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Cannot marshal lookup result: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
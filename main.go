@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -8,18 +10,20 @@ import (
 	"fmt"
 	"github.com/golang/snappy"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
-	"golang.org/x/crypto/acme/autocert"
 	"io/ioutil"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -29,6 +33,76 @@ var (
 	keyFlag     = flag.String("key", "", "Use the provided TLS key")
 	acmeFlag    = flag.String("acme", "", "Auto-request TLS certs and store in given directory")
 	domainsFlag = flag.String("domains", "", "Comma-separated domain list for TLS")
+	baseURLFlag = flag.String("base-url", "", "Public base URL of the site, for links sent elsewhere")
+	configFlag  = flag.String("config", "", "Read configuration from the given JSON file")
+
+	acmeHTTPFlag = flag.String("acme-http", ":80", "Answer ACME HTTP-01 challenges at given address, or only TLS-ALPN-01 ones if empty")
+
+	templatesFlag = flag.String("templates", "", "Override page template partials with the NAME.html files in the given directory")
+
+	digestWebhookFlag = flag.String("digest-webhook", "", "Post the weekly documentation digest to the given webhook URL")
+
+	statsFileFlag = flag.String("stats-file", "", "Persist page view statistics in the given file")
+	popularFlag   = flag.Int("popular", 0, "Show the given number of most read pages in the sidebar")
+
+	adminTokenFlag = flag.String("admin-token", "", "Enable the /admin/ pages for requests providing the given token")
+	glossaryFlag   = flag.String("glossary", "", "Link terms defined in the glossary topic at the given path")
+	imageProxyFlag = flag.Bool("image-proxy", false, "Serve images from allowed hosts via /image, sanitizing SVGs")
+	embedFlag      = flag.String("embed-ancestors", "*", "Space-separated origins allowed to frame /embed/ pages")
+	liveFlag       = flag.Bool("live-updates", false, "Offer readers to reload pages updated while open")
+	noindexFlag    = flag.Bool("noindex", false, "Ask search engines not to index any page, for staging sites and mirrors")
+	offlineFlag    = flag.Bool("offline", false, "Install a service worker keeping recently read pages available offline")
+
+	logFileFlag    = flag.String("log-file", "", "Write logs to the given file rather than to stderr, reopening it on SIGUSR1")
+	logMaxSizeFlag = flag.Int("log-max-size", 0, "Rotate the log file when it exceeds the given size in megabytes")
+	logKeepFlag    = flag.Int("log-keep", 5, "Keep the given number of rotated log files")
+
+	auditLogFlag = flag.String("audit-log", "", "Append administrative actions to the given file")
+
+	peersFlag        = flag.String("peers", "", "Comma-separated base URLs of other replicas to share cache invalidations with")
+	clusterTokenFlag = flag.String("cluster-token", "", "Shared secret authenticating messages between replicas")
+	clusterSelfFlag  = flag.String("cluster-self", "", "Base URL of this replica as listed in the -peers of the others, to fetch pages from the replicas owning them")
+
+	botRateFlag = flag.Int("bot-rate", 30, "Requests per minute allowed from each crawler address")
+
+	drainFlag = flag.Duration("drain", 0, "On SIGTERM, fail health checks for the given time, then finish in-flight requests, save state, and exit")
+
+	probeIntervalFlag = flag.Duration("probe-interval", time.Minute, "Check the health of the forum at the given interval for the status page, or never if zero")
+
+	crawlStateFlag = flag.String("crawl-state", "", "Persist the topic lists crawled from the forum in the given file, so restarts only list topics bumped since")
+
+	upstreamDeadlineFlag = flag.Duration("upstream-deadline", 0, "Give up on forum calls made for a page after the given time, serving cached copies instead")
+	upstreamCallsFlag    = flag.Int("upstream-calls", 0, "Make at most the given number of forum calls for a page, serving cached copies beyond that")
+	hedgeFlag            = flag.Bool("hedge", false, "Fetch pages a second time when the forum takes longer than usual to answer, using whichever answer comes first")
+
+	keyboardFlag = flag.Bool("keyboard", true, "Enable keyboard shortcuts and the Ctrl-K command palette")
+
+	langFlag = flag.String("lang", "en", "Language of the documentation, for pages whose language is not detected")
+
+	localSearchFlag = flag.Bool("local-search", false, "Search a local index of the documentation instead of the forum")
+	searchIndexFlag = flag.String("search-index", "", "Persist the local search index in the given file")
+	fuzzySearchFlag = flag.Bool("fuzzy-search", false, "Also match misspelled and partial words in local search")
+
+	imageCacheFlag = flag.String("image-cache", "", "Cache images optimized by the image proxy in the given directory")
+	imageWidthFlag = flag.Int("image-width", 0, "Serve raster images in pages via the image proxy, scaled down to the given width")
+
+	ogImagesFlag = flag.String("og-images", "", "Render social preview images of pages, caching them in the given directory (requires -base-url)")
+
+	thumbnailsFlag = flag.Bool("thumbnails", false, "Show thumbnails of the first image of pages in search results and link previews (requires -image-proxy)")
+
+	privacyStrictFlag = flag.Bool("privacy-strictmode", false, "Serve pages that make no third-party requests and send no referrers, proxying external images")
+
+	faviconFlag   = flag.String("favicon", "", "Serve the given file as /favicon.ico")
+	touchIconFlag = flag.String("touch-icon", "", "Serve the given PNG file as /apple-touch-icon.png")
+	manifestFlag  = flag.String("manifest", "", "Serve the given JSON file as the web app manifest")
+
+	snapshotsFlag     = flag.String("snapshots", "", "Keep recent versions of pages in the given directory, so pages may be pinned to one of them")
+	snapshotCountFlag = flag.Int("snapshot-count", 5, "Number of versions of each page kept with -snapshots")
+
+	refreshRateFlag = flag.Int("refresh-rate", 10, "Refreshes per hour allowed from each reader address")
+
+	cacheMaxAgeFlag  = flag.Duration("cache-max-age", 30*24*time.Hour, "Remove images and preview images cached on disk for longer than the given time, or never if zero")
+	cacheMaxSizeFlag = flag.Int("cache-max-size", 0, "Keep the images and preview images cached on disk within the given number of megabytes, removing the oldest first")
 )
 
 var httpClient = &http.Client{
@@ -42,7 +116,7 @@ var httpServer = &http.Server{
 
 func main() {
 	if err := run(); err != nil {
-		fmt.Errorf("error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
@@ -50,14 +124,91 @@ func main() {
 func run() error {
 	flag.Parse()
 
-	http.HandleFunc("/", handler)
+	if *logFileFlag != "" {
+		if err := openLog(*logFileFlag, int64(*logMaxSizeFlag)<<20, *logKeepFlag); err != nil {
+			return err
+		}
+	}
+
+	if *configFlag != "" {
+		if err := loadConfig(*configFlag); err != nil {
+			return err
+		}
+	}
+
+	if err := loadIcons(); err != nil {
+		return err
+	}
+
+	if *templatesFlag != "" {
+		t, err := parsePageTemplate(*templatesFlag)
+		if err != nil {
+			return err
+		}
+		pageTemplate = t
+	}
+	if err := parsePageOverrides(); err != nil {
+		return err
+	}
+
+	if flag.NArg() > 0 {
+		return runCommand(flag.Args())
+	}
+
+	transport := http.DefaultTransport
+	if key := os.Getenv("DISCOURSE_API_KEY"); key != "" {
+		username := config.APIUsername
+		if username == "" {
+			username = "system"
+		}
+		transport = &forumAuthTransport{base: transport, key: key, username: username}
+	}
+	if config.ErrorReporting != nil {
+		transport = &upstreamTransport{base: transport}
+	}
+	if len(config.UpstreamLimits) > 0 {
+		transport = &politeTransport{base: transport}
+	}
+	httpClient.Transport = transport
+	http.HandleFunc("/", metricsHandler(shedHandler(recoverHandler(handler))))
 
 	if *httpFlag == "" && *httpsFlag == "" {
 		return fmt.Errorf("must provide -http and/or -https")
 	}
+	if *peersFlag != "" && *clusterTokenFlag == "" {
+		return fmt.Errorf("cannot use -peers without -cluster-token")
+	}
+	if *clusterSelfFlag != "" && *peersFlag == "" {
+		return fmt.Errorf("cannot use -cluster-self without -peers")
+	}
 	if *acmeFlag != "" && *httpsFlag == "" {
 		return fmt.Errorf("cannot use -acme without -https")
 	}
+	if config.ACMEDNS != nil && (*acmeFlag == "" || strings.Trim(*domainsFlag, ", ") == "") {
+		return fmt.Errorf("acme-dns configuration requires -acme and -domains")
+	}
+	if *privacyStrictFlag {
+		// External images may only be loaded via the proxy.
+		*imageProxyFlag = true
+	}
+	if (*imageCacheFlag != "" || *imageWidthFlag != 0 || *thumbnailsFlag) && !*imageProxyFlag {
+		return fmt.Errorf("-image-cache, -image-width, and -thumbnails require -image-proxy")
+	}
+	if *snapshotsFlag != "" && *snapshotCountFlag < 1 {
+		return fmt.Errorf("-snapshot-count must be at least 1")
+	}
+	if *ogImagesFlag != "" && *baseURLFlag == "" {
+		return fmt.Errorf("-og-images requires -base-url")
+	}
+	if *cacheMaxSizeFlag < 0 || *cacheMaxAgeFlag < 0 {
+		return fmt.Errorf("-cache-max-size and -cache-max-age cannot be negative")
+	}
+	if *imageWidthFlag < 0 || *imageWidthFlag > imageMaxWidth {
+		return fmt.Errorf("-image-width must be between 0 and %d", imageMaxWidth)
+	}
+	if (*searchIndexFlag != "" || *fuzzySearchFlag) && !*localSearchFlag {
+		return fmt.Errorf("-search-index and -fuzzy-search require -local-search")
+	}
 	if *acmeFlag != "" && (*certFlag != "" || *keyFlag != "") {
 		return fmt.Errorf("cannot provide -acme with -key or -cert")
 	}
@@ -65,9 +216,61 @@ func run() error {
 		return fmt.Errorf("-https -cert and -key must be used together")
 	}
 
+	if *digestWebhookFlag != "" {
+		go postDigests(*digestWebhookFlag)
+	}
+
+	if *statsFileFlag != "" {
+		if err := stats.Load(*statsFileFlag); err != nil {
+			return err
+		}
+		go stats.flushLoop(*statsFileFlag)
+	}
+
+	if *crawlStateFlag != "" {
+		if err := loadCrawlState(*crawlStateFlag); err != nil {
+			return err
+		}
+	}
+
+	go janitorLoop()
+
+	if *probeIntervalFlag > 0 {
+		go health.probeLoop(*probeIntervalFlag)
+	}
+
+	if *localSearchFlag {
+		if err := startLocalSearch(); err != nil {
+			return err
+		}
+	}
+
 	ch := make(chan error, 2)
+	var servers []*http.Server
 
-	if *acmeFlag != "" {
+	if *imageCacheFlag != "" {
+		if err := os.MkdirAll(*imageCacheFlag, 0755); err != nil {
+			return err
+		}
+		if err := cleanCacheDir(*imageCacheFlag); err != nil {
+			return err
+		}
+	}
+	if *ogImagesFlag != "" {
+		if err := os.MkdirAll(*ogImagesFlag, 0755); err != nil {
+			return err
+		}
+	}
+	if *snapshotsFlag != "" {
+		if err := os.MkdirAll(*snapshotsFlag, 0755); err != nil {
+			return err
+		}
+		if err := snapshots.loadPins(); err != nil {
+			return err
+		}
+	}
+
+	if *acmeFlag != "" && (config.ACMECache == nil || config.ACMECache.Type == "dir") {
 		// So a potential error is seen upfront.
 		if err := os.MkdirAll(*acmeFlag, 0700); err != nil {
 			return err
@@ -77,6 +280,7 @@ func run() error {
 	if *httpFlag != "" && (*httpsFlag == "" || *acmeFlag == "") {
 		server := *httpServer
 		server.Addr = *httpFlag
+		servers = append(servers, &server)
 		go func() {
 			ch <- server.ListenAndServe()
 		}()
@@ -84,33 +288,43 @@ func run() error {
 	if *httpsFlag != "" {
 		server := *httpServer
 		server.Addr = *httpsFlag
-		if *acmeFlag != "" {
-			domains := append([]string{"localhost"}, strings.Split(*domainsFlag, ",")...)
-			m := autocert.Manager{
-				Prompt:      autocert.AcceptTOS,
-				Cache:       autocert.DirCache(*acmeFlag),
-				RenewBefore: 24 * 30 * time.Hour,
-				HostPolicy:  autocert.HostWhitelist(domains...),
-				Email:       "gustavo@niemeyer.net",
-			}
-			server.TLSConfig = &tls.Config{
-				GetCertificate: m.GetCertificate,
+		servers = append(servers, &server)
+		if *acmeFlag != "" && config.ACMEDNS != nil {
+			m := newDNSCertManager()
+			go m.loop()
+			server.TLSConfig = &tls.Config{GetCertificate: m.GetCertificate}
+		} else if *acmeFlag != "" {
+			m := acmeManager()
+			server.TLSConfig = m.TLSConfig()
+			if *acmeHTTPFlag != "" {
+				challenges := &http.Server{Addr: *acmeHTTPFlag, Handler: m.HTTPHandler(nil)}
+				servers = append(servers, challenges)
+				go func() {
+					ch <- challenges.ListenAndServe()
+				}()
 			}
-			go func() {
-				ch <- http.ListenAndServe(":80", m.HTTPHandler(nil))
-			}()
 		}
+		server.TLSConfig = withHostCertificates(server.TLSConfig)
 		go func() {
 			ch <- server.ListenAndServeTLS(*certFlag, *keyFlag)
 		}()
 	}
+	if *drainFlag > 0 {
+		drainOnSignal(servers, ch)
+	}
 	log.Printf("Started!")
-	return <-ch
+	for {
+		// Servers report being closed as soon as draining shuts them down.
+		if err := <-ch; err != http.ErrServerClosed {
+			return err
+		}
+	}
 }
 
 var pagePathPattern = regexp.MustCompile("^(?:/([a-z0-9-]+))?/([0-9]+)(?:/[0-9]+)?$")
 
 func topicPathID(path string) (int, error) {
+	path, _ = stripNamespace(path)
 	m := pagePathPattern.FindStringSubmatch(path)
 	if m == nil {
 		return 0, fmt.Errorf("unsupported URL path")
@@ -131,21 +345,51 @@ func sendNotFound(resp http.ResponseWriter, msg string, args ...interface{}) {
 }
 
 func handler(resp http.ResponseWriter, req *http.Request) {
-	if req.Method != "GET" {
+	// Responses to HEAD are sent without a body by net/http itself.
+	post := req.URL.Path == "/graphql" || req.URL.Path == "/cluster/message" || req.URL.Path == "/admin/reindex" || req.URL.Path == "/admin/search-index" || req.URL.Path == "/refresh" ||
+		strings.HasPrefix(req.URL.Path, "/admin/snapshots/") || strings.HasPrefix(req.URL.Path, "/admin/held/")
+	if req.Method != "GET" && req.Method != "HEAD" && !(req.Method == "POST" && post) {
+		if post {
+			resp.Header().Set("Allow", "GET, HEAD, POST")
+		} else {
+			resp.Header().Set("Allow", "GET, HEAD")
+		}
 		resp.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	if *noindexFlag {
+		resp.Header().Set("X-Robots-Tag", "noindex")
+	}
+	setRouteHint(resp, req)
+	if *privacyStrictFlag {
+		setPrivacyHeaders(resp)
+	}
 	if req.URL.Path == "/icon32.png" {
 		resp.Header().Set("Content-Type", "image/png")
 		resp.Write(iconBytes)
 		return
 	}
+	if req.URL.Path == "/sw.js" && *offlineFlag {
+		serveServiceWorker(resp, req)
+		return
+	}
 	if req.URL.Path == "/health-check" {
+		if isDraining() {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			resp.Write([]byte("draining"))
+			return
+		}
 		resp.Write([]byte("ok"))
 		return
 	}
-	if req.URL.Path == "/favicon.ico" {
-		resp.WriteHeader(http.StatusNotFound)
+	if req.URL.Path != "/cluster/message" && !checkAccess(resp, req) {
+		return
+	}
+	if !checkBot(resp, req) {
+		return
+	}
+	if req.URL.Path == "/favicon.ico" || req.URL.Path == "/apple-touch-icon.png" || req.URL.Path == "/manifest.webmanifest" {
+		serveIcon(resp, req)
 		return
 	}
 	if strings.HasPrefix(req.URL.Path, "/t/") {
@@ -157,83 +401,351 @@ func handler(resp http.ResponseWriter, req *http.Request) {
 
 	log.Printf("Got request for %s from %s", req.URL, req.RemoteAddr)
 
+	if req.URL.Path == "/" && config.Landing != nil {
+		serveLanding(resp, req)
+		return
+	}
 	if req.URL.Path == "/" {
 		req.URL.Path = indexPagePath
 	}
+	var namespace string
+	req.URL.Path, namespace = stripNamespace(req.URL.Path)
+
+	if l := languageRoot(namespace); l != nil && (req.URL.Path == "" || req.URL.Path == "/") {
+		target := "/"
+		if l.Index != "" {
+			target = l.indexPath()
+		}
+		resp.Header().Set("Location", target)
+		resp.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
 
 	req.ParseForm()
 
+	if feature := pathFeature(req.URL.Path); feature != "" && disabled(feature) {
+		sendNotFound(resp, "This feature is disabled.")
+		return
+	}
+
+	if strings.HasPrefix(req.URL.Path, "/section/") {
+		serveSection(resp, req, namespace)
+		return
+	}
+	if req.URL.Path == "/all" {
+		serveAll(resp, req)
+		return
+	}
+	if req.URL.Path == "/digest" {
+		serveDigest(resp, req)
+		return
+	}
+	if req.URL.Path == "/metrics" {
+		serveMetrics(resp, req)
+		return
+	}
+	if req.URL.Path == "/api/v1/stats" {
+		serveStats(resp, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/embed/") {
+		serveEmbed(resp, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/badge/") {
+		serveBadge(resp, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/og-image/") && *ogImagesFlag != "" {
+		serveOGImage(resp, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/thumbnail/") && *thumbnailsFlag {
+		serveThumbnail(resp, req)
+		return
+	}
+	if req.URL.Path == "/image" && *imageProxyFlag {
+		serveImageProxy(resp, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/reference/") {
+		serveReferenceRow(resp, req)
+		return
+	}
+	if req.URL.Path == "/static/reference.js" {
+		serveReferenceScript(resp, req)
+		return
+	}
+	if config.Bookmarks && req.URL.Path == "/bookmarks" {
+		serveBookmarks(resp, req)
+		return
+	}
+	if strings.HasPrefix(req.URL.Path, "/mirror/") {
+		serveMirror(resp, req)
+		return
+	}
+	if req.URL.Path == "/status" {
+		serveStatus(resp, req)
+		return
+	}
+	if req.URL.Path == "/api/v1/status" {
+		serveStatusAPI(resp, req)
+		return
+	}
+	if config.Bookmarks && req.URL.Path == "/api/v1/bookmarks" {
+		serveBookmarksAPI(resp, req)
+		return
+	}
+	if config.Bookmarks && req.URL.Path == "/static/bookmarks.js" {
+		serveBookmarksScript(resp, req)
+		return
+	}
+	if req.URL.Path == "/static/bootstrap.min.css" && *privacyStrictFlag {
+		serveBootstrapCSS(resp, req)
+		return
+	}
+	if req.URL.Path == "/refresh" {
+		serveRefresh(resp, req)
+		return
+	}
+	if req.URL.Path == "/static/refresh.js" {
+		serveRefreshScript(resp, req)
+		return
+	}
+	if req.URL.Path == "/static/progress.js" {
+		serveProgressScript(resp, req)
+		return
+	}
+	if req.URL.Path == "/static/keys.js" {
+		serveKeyboardScript(resp, req)
+		return
+	}
+	if req.URL.Path == "/widget.js" {
+		serveWidget(resp, req)
+		return
+	}
+	if req.URL.Path == "/api/v1/search" {
+		serveSearchAPI(resp, req)
+		return
+	}
+	if req.URL.Path == "/api/v1/lookup" {
+		serveLookup(resp, req)
+		return
+	}
+	if req.URL.Path == "/api/v1/nav" {
+		serveNavAPI(resp, req)
+		return
+	}
+	if req.URL.Path == "/api/v1/topics" {
+		serveTopicsAPI(resp, req)
+		return
+	}
+	if req.URL.Path == "/events" {
+		serveEvents(resp, req)
+		return
+	}
+	if req.URL.Path == "/cluster/message" {
+		serveClusterMessage(resp, req)
+		return
+	}
+	if req.URL.Path == "/graphql" {
+		serveGraphQL(resp, req)
+		return
+	}
+	if req.URL.Path == "/llms.txt" || req.URL.Path == "/llms-full.txt" || req.URL.Path == "/export/corpus.jsonl" {
+		serveCorpus(resp, req)
+		return
+	}
+	if req.URL.Path == "/admin" || strings.HasPrefix(req.URL.Path, "/admin/") {
+		serveAdmin(resp, req)
+		return
+	}
+	if strings.HasSuffix(req.URL.Path, ".zip") && pagePathPattern.MatchString(strings.TrimSuffix(req.URL.Path, ".zip")) {
+		serveBundle(resp, req, strings.TrimSuffix(req.URL.Path, ".zip"))
+		return
+	}
+
 	var results []*Topic
 	var topic *Topic
+	var notice string
 	var err error
 
+	// Bots are served from the cache only, by every route.
+	bot := isBot(req)
+	budget := requestBudget(req)
 	if req.URL.Path == "/search" {
-		results, err = forum.Search(req.Form.Get("q"))
-	} else if m := pagePathPattern.FindStringSubmatch(req.URL.Path); m != nil {
-		if len(req.Form["refresh"]) > 0 {
-			forum.Refresh(req.URL.Path)
+		results, err = forum.SearchWithin(req.Form.Get("q"), budget)
+	} else if m := pagePathPattern.FindStringSubmatch(req.URL.Path); m != nil && bot {
+		id, _ := topicPathID(req.URL.Path)
+		if topic = forum.Cached(id); topic == nil {
+			sendUncached(resp, req.URL.Path)
+			return
+		}
+	} else if m != nil {
+		// Readers refresh pages via the rate-limited refresh button.
+		if len(req.Form["refresh"]) > 0 && !disabled("refresh") && isAdmin(req) {
+			audit.Record(req, "refresh", req.URL.Path)
+			_, notice = refreshPage(req.URL.Path)
+		} else if code := req.Form.Get("refreshed"); code != "" {
+			notice = refreshNotices[code]
+		}
+		if req.Header.Get(routeHeader) != "" {
+			budget = budget.routedHere()
 		}
-		topic, err = forum.Topic(req.URL.Path)
+		topic, err = forum.TopicWithin(req.URL.Path, budget)
+	} else if target, status := resolveLegacy(req.URL.Path, budget); target != "" {
+		log.Printf("Redirecting legacy path %s to %s", req.URL.Path, target)
+		resp.Header().Set("Location", target)
+		resp.WriteHeader(status)
+		return
 	} else {
 		err = fmt.Errorf("invalid URL pattern")
 	}
 	if err != nil {
 		log.Printf("Cannot send %s to %s: %v", req.URL, req.RemoteAddr, err)
+		if sendForumError(resp, err) {
+			return
+		}
 		resp.Header().Set("Location", "/")
 		resp.WriteHeader(http.StatusTemporaryRedirect)
 		return
 	}
 
-	if topic != nil && topic.Category != docCategory {
+	if topic != nil && topic.snapshot && notice == "" {
+		notice = pinnedNotice(topic)
+	}
+
+	if topic != nil && !isDocCategory(topic.Category) {
 		log.Printf("Cannot send %s to %s: %v", req.URL, req.RemoteAddr, err)
 		resp.Header().Set("Location", topic.ForumURL())
 		resp.WriteHeader(http.StatusTemporaryRedirect)
 		return
 	}
 
+	if topic != nil && topic.ID != indexPageID && namespacePrefix(topic) != namespace {
+		resp.Header().Set("Location", topic.String())
+		resp.WriteHeader(http.StatusMovedPermanently)
+		return
+	}
+
+	if topic != nil && notice == "" && notModified(resp, req, topic.LastUpdate()) {
+		return
+	}
+
+	if topic != nil {
+		if req.Method != "HEAD" {
+			stats.View(topic)
+		}
+		if topic.Meta != nil && topic.Meta.NoIndex {
+			resp.Header().Set("X-Robots-Tag", "noindex")
+		}
+	}
+
 	resp.Header().Set("Content-Type", "text/html")
-	renderPage(resp, req, topic, results)
+	renderPage(resp, req, &pageData{Topic: topic, Results: searchHits(results, req.Form.Get("q")), Notice: notice, budget: budget})
 }
 
 const docCategory = 15
 
 type Topic struct {
-	ID       int       `json:"id"`
-	Slug     string    `json:"slug"`
-	Title    string    `json:"title"`
-	Category int       `json:"category_id"`
-	BumpedAt time.Time `json:"bumped_at"`
+	ID        int       `json:"id"`
+	Slug      string    `json:"slug"`
+	Title     string    `json:"title"`
+	Category  int       `json:"category_id"`
+	BumpedAt  time.Time `json:"bumped_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Pinned    bool      `json:"pinned"`
+	Tags      []string  `json:"tags"`
+	Locale    string    `json:"locale"`
 
 	Post    *Post
+	Meta    *PageMeta `json:"-"`
 	content []byte
+	raw     []byte
+
+	description string
+	lang        string
+	etag        string
+	image       string
+	snapshot    bool // Whether this is a pinned earlier version.
 }
 
 func (t *Topic) String() string {
-	return fmt.Sprintf("/%s/%d", t.Slug, t.ID)
+	return fmt.Sprintf("%s/%s/%d", namespacePrefix(t), t.Slug, t.ID)
 }
 
 func (t *Topic) ForumURL() string {
 	return fmt.Sprintf("https://forum.snapcraft.io/t/%s/%d", t.Slug, t.ID)
 }
 
+// URL returns the absolute URL of the topic on this site, or the forum
+// URL if the site's base URL is unknown.
+func (t *Topic) URL() string {
+	if *baseURLFlag == "" {
+		return t.ForumURL()
+	}
+	return strings.TrimSuffix(*baseURLFlag, "/") + t.String()
+}
+
 func (t *Topic) setPost(post *Post) {
 	t.Post = post
 	content := t.Post.Cooked
 	t.Post.Cooked = ""
-	content = strings.Replace(content, `href="/`, `href="https://forum.snapcraft.io/`, -1)
-	content = strings.Replace(content, `href="https://forum.snapcraft.io/t/`, `href="/`, -1)
+	if t.Post.Raw != "" {
+		t.Meta = parsePageMeta(t.Post.Raw)
+		if t.Meta != nil {
+			content = stripPageMeta(content)
+		}
+	}
+	content = stripContent(content)
+	content = transformContent(content, contentPasses()...)
+	content = config.ContentLimits.apply(t, content)
+	content = sanitizeInlineSVGs(content)
+	content = renderFootnotes(content, t.Post.ID)
+	content = renderTaskLists(content)
+	content = renderAdmonitions(content)
+	t.image = firstImage(content)
+	if *imageProxyFlag {
+		content = proxySVGImages(content)
+		if *imageWidthFlag > 0 {
+			content = proxyRasterImages(content)
+		}
+	}
+	t.description = contentDescription(content)
+	t.lang = detectLang(content)
 	t.content = snappy.Encode(nil, []byte(content))
+	if t.Post.Raw != "" {
+		t.raw = snappy.Encode(nil, []byte(t.Post.Raw))
+		t.Post.Raw = ""
+	}
 }
 
 func (t *Topic) Content() string {
 	content, err := snappy.Decode(nil, t.content)
 	if err != nil {
+		// Callers may hold the lock of the topic cache, so it's healed
+		// in the background.
 		log.Printf("internal error: cannot decompress content of %s: %v", t, err)
-		return "Internal error: cannot decompress content. Please report!"
+		go forum.heal(t)
+		return "Internal error: cannot decompress content. Please try again shortly."
 	}
 	return string(content)
 }
 
+// Markdown returns the markdown source of the topic, or an empty string
+// if it's not known, as is the case for search results.
+func (t *Topic) Markdown() string {
+	if t.raw == nil {
+		return ""
+	}
+	raw, err := snappy.Decode(nil, t.raw)
+	if err != nil {
+		log.Printf("internal error: cannot decompress markdown of %s: %v", t, err)
+		return ""
+	}
+	return string(raw)
+}
+
 func (t *Topic) LastUpdate() time.Time {
 	if t.Post == nil || t.Post.UpdatedAt.IsZero() {
 		// Search results do not include updated_at. That's the next best thing.
@@ -242,6 +754,22 @@ func (t *Topic) LastUpdate() time.Time {
 	return t.Post.UpdatedAt
 }
 
+// notModified sets the Last-Modified header to lastModified and, if the
+// client's copy is still current, responds with 304 Not Modified and
+// returns true.
+func notModified(resp http.ResponseWriter, req *http.Request, lastModified time.Time) bool {
+	if lastModified.IsZero() {
+		return false
+	}
+	lastModified = lastModified.UTC().Truncate(time.Second)
+	resp.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	if since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(since) {
+		resp.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
 func (t *Topic) Blurb() string {
 	if t.Post != nil {
 		return t.Post.Blurb
@@ -250,11 +778,14 @@ func (t *Topic) Blurb() string {
 }
 
 type Post struct {
+	ID        int       `json:"id"`
 	Username  string    `json:"username"`
 	Cooked    string    `json:"cooked"`
 	UpdatedAt time.Time `json:"updated_at"`
 	TopicID   int       `json:"topic_id"`
 	Blurb     string    `json:"blurb"`
+	Version   int       `json:"version"`
+	Raw       string    `json:"raw"`
 }
 
 var forum Forum
@@ -262,44 +793,255 @@ var forum Forum
 type Forum struct {
 	cache map[int]*topicCache
 	mu    sync.Mutex
+
+	category categoryCache
+	searches searchCache
+}
+
+type categoryCache struct {
+	mu     sync.Mutex
+	time   time.Time
+	topics []*Topic
 }
 
 type topicCache struct {
 	mu    sync.Mutex
 	time  time.Time
 	topic *Topic
+
+	// last holds the topic and time as of their last change, so they can
+	// be read without waiting on mu, which is held while fetching.
+	last atomic.Value
+}
+
+type topicCacheState struct {
+	topic *Topic
+	time  time.Time
+}
+
+// set changes the cached topic and its time. The caller must hold c.mu.
+func (c *topicCache) set(topic *Topic, t time.Time) {
+	c.topic = topic
+	c.time = t
+	c.last.Store(topicCacheState{topic, t})
+}
+
+// peek returns the cached topic and its time without waiting on c.mu.
+func (c *topicCache) peek() (*Topic, time.Time) {
+	state, _ := c.last.Load().(topicCacheState)
+	return state.topic, state.time
 }
 
 const topicCacheTimeout = 1 * time.Hour
 const topicCacheFallback = 7 * 24 * time.Hour
 
+// heal evicts t from the cache, as its content is corrupted, and fetches
+// a fresh copy.
+func (f *Forum) heal(t *Topic) {
+	f.mu.Lock()
+	cache, ok := f.cache[t.ID]
+	f.mu.Unlock()
+	if ok {
+		cache.mu.Lock()
+		if cache.topic == t {
+			cache.set(nil, time.Time{})
+		}
+		cache.mu.Unlock()
+	}
+	if _, err := f.Topic(t.String()); err != nil {
+		log.Printf("Cannot refetch %s with corrupted content: %v", t, err)
+	}
+}
+
+// Refresh expires the cached copy of the topic at path, so that it's
+// fetched again next time. The expired copy is kept to detect changes
+// and to serve as a fallback in case fetching fails.
 func (f *Forum) Refresh(path string) {
 	id, err := topicPathID(path)
 	if err == nil {
 		f.mu.Lock()
-		if _, ok := f.cache[id]; ok {
-			log.Printf("Asked to refresh %s: discarding topic cache", path)
+		cache, ok := f.cache[id]
+		f.mu.Unlock()
+		if ok {
+			log.Printf("Asked to refresh %s: expiring topic cache", path)
+			cache.mu.Lock()
+			cache.set(cache.topic, time.Time{})
+			cache.mu.Unlock()
 		} else {
 			log.Printf("Asked to refresh %s: topic was not cached", path)
 		}
-		delete(f.cache, id)
-		f.mu.Unlock()
 	}
 }
 
-func (f *Forum) Search(query string) ([]*Topic, error) {
-	query = strings.TrimSpace(query)
-	if query == "" {
-		return nil, nil
+// Cached returns the cached topic with the given ID, or its pinned
+// snapshot, or nil if the topic is not cached. It never contacts the
+// forum, nor waits on fetches.
+func (f *Forum) Cached(id int) *Topic {
+	f.mu.Lock()
+	cache, ok := f.cache[id]
+	f.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	topic, _ := cache.peek()
+	if topic == nil {
+		return nil
 	}
+	return snapshots.pinned(topic)
+}
+
+// CachedTopics returns all topics currently cached.
+func (f *Forum) CachedTopics() []*Topic {
+	f.mu.Lock()
+	caches := make([]*topicCache, 0, len(f.cache))
+	for _, cache := range f.cache {
+		caches = append(caches, cache)
+	}
+	f.mu.Unlock()
 
+	var topics []*Topic
+	for _, cache := range caches {
+		if topic, _ := cache.peek(); topic != nil {
+			topics = append(topics, snapshots.pinned(topic))
+		}
+	}
+	return topics
+}
+
+// fetchSearch obtains the results for query from the forum.
+func (f *Forum) fetchSearch(query string) ([]*Topic, error) {
 	log.Printf("Fetching search results for: %s", query)
 
-	q := url.Values{"q": []string{"#doc @wiki " + query}}.Encode()
+	q := url.Values{"q": []string{"#doc @wiki " + query}}.Encode()
+
+	resp, err := httpClient.Get("https://forum.snapcraft.io/search.json?" + q)
+	if err != nil {
+		return nil, forumErrorf(Unavailable, "cannot obtain search results: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		// ok
+	default:
+		return nil, forumStatusError("search results", resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, forumErrorf(Unavailable, "cannot read search results: %v", err)
+	}
+
+	var result struct {
+		Posts  []*Post
+		Topics []*Topic
+	}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, forumErrorf(Malformed, "cannot unmarshal search results: %v", err)
+	}
+
+	topicID := make(map[int]*Topic, len(result.Topics))
+	for _, topic := range result.Topics {
+		topicID[topic.ID] = topic
+	}
+
+	var topics []*Topic
+	for _, post := range result.Posts {
+		if topic, ok := topicID[post.TopicID]; ok && topic.ID != indexPageID {
+			topic.setPost(post)
+			topics = append(topics, topic)
+		}
+	}
+
+	// Take the chance we have the content at hand and replace all cached posts.
+	now := time.Now()
+	caches := make([]*topicCache, len(topics))
+	f.mu.Lock()
+	if f.cache == nil {
+		f.cache = make(map[int]*topicCache)
+	}
+	for i, topic := range topics {
+		cache, ok := f.cache[topic.ID]
+		if !ok {
+			cache = &topicCache{}
+			f.cache[topic.ID] = cache
+		}
+		caches[i] = cache
+	}
+	f.mu.Unlock()
+	for i, cache := range caches {
+		// Topics being fetched are left to the fetcher, which caches them whole.
+		if cache.mu.TryLock() {
+			cache.set(topics[i], now)
+			cache.mu.Unlock()
+		}
+	}
+
+	return topics, nil
+}
+
+const categoryMaxPages = 50
+
+// Topics returns all topics in the documentation categories, as listed by the forum.
+func (f *Forum) Topics() ([]*Topic, error) {
+	return f.TopicsWithin(nil)
+}
+
+// TopicsWithin returns the documentation topics like Topics does,
+// fetching them only if that fits in budget. A cached list of any age is
+// served otherwise.
+func (f *Forum) TopicsWithin(budget *upstreamBudget) ([]*Topic, error) {
+	now := time.Now()
+
+	cache := &f.category
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.time.Add(topicCacheTimeout).After(now) {
+		return cache.topics, nil
+	}
+	if !budget.spend() {
+		if cache.topics != nil {
+			return cache.topics, nil
+		}
+		return nil, errUpstreamBudget
+	}
+
+	var topics []*Topic
+	seen := make(map[int]bool)
+	for _, category := range docCategories() {
+		log.Printf("Fetching topic list for category %d...", category)
+
+		list, err := listCategory(category)
+		if err != nil {
+			if cache.topics != nil && cache.time.Add(topicCacheFallback).After(now) {
+				log.Printf("Cannot refresh topic list, using cached copy: %v", err)
+				return cache.topics, nil
+			}
+			return nil, err
+		}
+		for _, topic := range list {
+			if isDocCategory(topic.Category) && !seen[topic.ID] {
+				seen[topic.ID] = true
+				topics = append(topics, topic)
+			}
+		}
+	}
+
+	if cache.topics != nil {
+		go emitListingChanges(cache.topics, topics)
+	}
+	cache.topics = topics
+	cache.time = time.Now()
+
+	return topics, nil
+}
 
-	resp, err := httpClient.Get("https://forum.snapcraft.io/search.json?" + q)
+func fetchCategoryPage(category, page int) (topics []*Topic, more bool, err error) {
+	resp, err := httpClient.Get(fmt.Sprintf("https://forum.snapcraft.io/c/%d.json?page=%d", category, page))
 	if err != nil {
-		return nil, fmt.Errorf("cannot obtain search results: %v", err)
+		return nil, false, forumErrorf(Unavailable, "cannot obtain topic list: %v", err)
 	}
 	defer resp.Body.Close()
 
@@ -307,54 +1049,49 @@ func (f *Forum) Search(query string) ([]*Topic, error) {
 	case 200:
 		// ok
 	default:
-		return nil, fmt.Errorf("cannot obtain search results: got %v status", resp.StatusCode)
+		return nil, false, forumStatusError("topic list", resp)
 	}
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read search results: %v", err)
+		return nil, false, forumErrorf(Unavailable, "cannot read topic list: %v", err)
 	}
 
 	var result struct {
-		Posts  []*Post
-		Topics []*Topic
+		TopicList struct {
+			Topics        []*Topic
+			MoreTopicsURL string `json:"more_topics_url"`
+		} `json:"topic_list"`
 	}
 	err = json.Unmarshal(data, &result)
 	if err != nil {
-		return nil, fmt.Errorf("cannot unmarshal search results: %v", err)
+		return nil, false, forumErrorf(Malformed, "cannot unmarshal topic list: %v", err)
 	}
 
-	topicID := make(map[int]*Topic, len(result.Topics))
-	for _, topic := range result.Topics {
-		topicID[topic.ID] = topic
-	}
+	topics = result.TopicList.Topics
+	return topics, len(topics) > 0 && result.TopicList.MoreTopicsURL != "", nil
+}
 
-	var topics []*Topic
-	for _, post := range result.Posts {
-		if topic, ok := topicID[post.TopicID]; ok && topic.ID != indexPageID {
-			topic.setPost(post)
-			topics = append(topics, topic)
-		}
-	}
+// Topic returns the topic at path, from the cache when fresh enough.
+// Forum failures are reported as a *ForumError.
+func (f *Forum) Topic(path string) (*Topic, error) {
+	return f.TopicWithin(path, nil)
+}
 
-	// Take the chance we have the content at hand and replace all cached posts.
-	now := time.Now()
-	f.mu.Lock()
-	if f.cache == nil {
-		f.cache = make(map[int]*topicCache)
-	}
-	for _, topic := range topics {
-		f.cache[topic.ID] = &topicCache{
-			topic: topic,
-			time:  now,
-		}
+// TopicWithin returns the topic at path like Topic does, fetching it only
+// if that fits in budget. Cached copies of any age are served otherwise.
+// Topics pinned to a snapshot are returned as the snapshot.
+func (f *Forum) TopicWithin(path string, budget *upstreamBudget) (*Topic, error) {
+	topic, err := f.liveTopicWithin(path, budget)
+	if err != nil {
+		return nil, err
 	}
-	f.mu.Unlock()
-
-	return topics, nil
+	return snapshots.pinned(topic), nil
 }
 
-func (f *Forum) Topic(path string) (topic *Topic, err error) {
+// liveTopicWithin returns the topic at path like TopicWithin does, as it
+// is on the forum even if pinned to a snapshot.
+func (f *Forum) liveTopicWithin(path string, budget *upstreamBudget) (topic *Topic, err error) {
 	id, err := topicPathID(path)
 	if err != nil {
 		return nil, err
@@ -372,7 +1109,14 @@ func (f *Forum) Topic(path string) (topic *Topic, err error) {
 	}
 	f.mu.Unlock()
 
-	cache.mu.Lock()
+	// Another caller may be fetching the topic, without a budget or paced
+	// as a crawl, so budgeted callers don't wait past theirs for it.
+	if !cache.lockWithin(budget) {
+		if topic, _ := cache.peek(); topic != nil {
+			return topic, nil
+		}
+		return nil, errUpstreamBudget
+	}
 	defer cache.mu.Unlock()
 
 	if cache.time.Add(topicCacheTimeout).After(now) {
@@ -380,11 +1124,22 @@ func (f *Forum) Topic(path string) (topic *Topic, err error) {
 	}
 
 	defer func() {
+		// Pages gone from the forum are not served from anywhere else, while
+		// cached copies of any age are served when told to back off.
+		gone := IsForumError(err, NotFound)
+		backoff := err == errUpstreamBudget || IsForumError(err, RateLimited)
+		if err != nil && err != errUpstreamBudget && !gone && len(config.Mirrors) > 0 && budget.mayMirror() &&
+			(cache.topic == nil || !cache.time.Add(topicCacheFallback).After(now)) {
+			if mirrored, merr := fetchMirrored(id, budget); merr == nil {
+				cache.store(mirrored)
+				topic, err = cache.topic, nil
+			}
+		}
 		if err != nil {
-			if cache.topic != nil && cache.time.Add(topicCacheFallback).After(now) {
+			if cache.topic != nil && !gone && (backoff || cache.time.Add(topicCacheFallback).After(now)) {
 				topic = cache.topic
 				err = nil
-			} else {
+			} else if err != errUpstreamBudget {
 				f.mu.Lock()
 				delete(f.cache, id)
 				f.mu.Unlock()
@@ -392,27 +1147,97 @@ func (f *Forum) Topic(path string) (topic *Topic, err error) {
 		}
 	}()
 
+	if !budget.spend() {
+		return nil, errUpstreamBudget
+	}
+
 	log.Printf("Fetching content for %s...", path)
 
-	resp, err := httpClient.Get("https://forum.snapcraft.io/t/" + strings.Trim(path, "/") + ".json")
+	if topic = budget.fetchFromOwner(id); topic == nil {
+		topic, err = budget.fetchTopic(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cache.replace(topic)
+	return cache.topic, nil
+}
+
+// replace caches the freshly fetched topic, announcing whether its
+// content changed, unless the change is held for review by the content
+// checks. It returns whether the topic was cached. The caller must hold
+// c.mu.
+func (c *topicCache) replace(topic *Topic) bool {
+	// Topics cached from search results have no version and partial content.
+	if old := c.topic; old != nil && old.Post.Version > 0 && !bytes.Equal(old.content, topic.content) {
+		if reason := config.ContentChecks.check(old, topic); reason != "" {
+			holdChange(old, topic, reason)
+			c.set(c.topic, time.Now())
+			return false
+		}
+		go notifyTopicChange(old, topic)
+	}
+	releaseChange(topic.ID)
+	c.store(topic)
+	return true
+}
+
+// store caches topic as is. The caller must hold c.mu.
+func (c *topicCache) store(topic *Topic) {
+	c.set(topic, time.Now())
+
+	if *localSearchFlag {
+		go searchIndex.update(topic)
+	}
+	if *snapshotsFlag != "" {
+		go snapshots.save(topic)
+	}
+}
+
+var (
+	errNotModified   = fmt.Errorf("documentation page not modified")
+	errTopicNotFound = forumErrorf(NotFound, "documentation page not found")
+)
+
+// fetchTopic obtains the topic at path from the forum, bypassing the cache.
+func fetchTopic(ctx context.Context, path string) (*Topic, error) {
+	return fetchTopicIf(ctx, path, "")
+}
+
+// fetchTopicIf obtains the topic at path from the forum like fetchTopic,
+// unless it still has the given ETag, in which case errNotModified is
+// returned.
+func fetchTopicIf(ctx context.Context, path, etag string) (*Topic, error) {
+	path, _ = stripNamespace(path)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://forum.snapcraft.io/t/"+strings.Trim(path, "/")+".json?include_raw=true", nil)
 	if err != nil {
 		return nil, fmt.Errorf("cannot obtain documentation page: %v", err)
 	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, forumErrorf(Unavailable, "cannot obtain documentation page: %v", err)
+	}
 	defer resp.Body.Close()
 
 	switch resp.StatusCode {
 	case 200:
 		// ok
-	case 401, 404:
-		return nil, fmt.Errorf("documentation page not found")
+	case 304:
+		return nil, errNotModified
+	case 401, 403, 404:
+		return nil, errTopicNotFound
 
 	default:
-		return nil, fmt.Errorf("cannot obtain documentation page: got %v status", resp.StatusCode)
+		return nil, forumStatusError("documentation page", resp)
 	}
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("cannot read documentation page: %v", err)
+		return nil, forumErrorf(Unavailable, "cannot read documentation page: %v", err)
 	}
 
 	var result struct {
@@ -423,28 +1248,64 @@ func (f *Forum) Topic(path string) (topic *Topic, err error) {
 	}
 	err = json.Unmarshal(data, &result)
 	if err != nil {
-		return nil, fmt.Errorf("cannot unmarshal documentation page: %v", err)
+		return nil, forumErrorf(Malformed, "cannot unmarshal documentation page: %v", err)
 	}
 
 	if result.Topic == nil || len(result.PostStream.Posts) == 0 {
-		return nil, fmt.Errorf("internal error: documentation page seems empty!?", err)
+		return nil, forumErrorf(Malformed, "documentation page seems empty")
 	}
 
 	result.Topic.setPost(result.PostStream.Posts[0])
-
-	cache.topic = result.Topic
-	cache.time = time.Now()
+	result.Topic.etag = resp.Header.Get("ETag")
 
 	return result.Topic, nil
 }
 
 type pageData struct {
 	Index   string
+	Tabs    []*indexTab
 	Topic   *Topic
+	Title   string
 	Content string
 	Query   string
-	Results []*Topic
+	Results []*searchHit
+	Notice  string
 	Logo    string
+	Popular []*topicStats
+
+	LiveUpdates  bool
+	Offline      bool
+	NoIndex      bool
+	NoSearch     bool
+	IndexMissing bool
+	Keyboard     bool
+	Progress     bool
+	Bookmarks    bool
+	Private      bool
+	Refresh      bool
+	Lang         string
+	Languages    []*langAlternate
+
+	// budget bounds the forum calls made to render the page.
+	budget *upstreamBudget
+
+	flusher  http.Flusher
+	prepare  func()
+	override *PageOverride
+}
+
+// Stream sends the page rendered so far, so readers get the sidebar
+// while the article is prepared, and then prepares it. It renders
+// nothing itself.
+func (data *pageData) Stream() string {
+	if data.flusher != nil {
+		data.flusher.Flush()
+	}
+	if data.prepare != nil {
+		data.prepare()
+		data.prepare = nil
+	}
+	return ""
 }
 
 var (
@@ -452,7 +1313,7 @@ var (
 	indexPageID    = 0
 	indexPageSep   = "<h1>Content</h1>"
 	indexPageTitle = "Welcome"
-	editorsNote = regexp.MustCompile(`(?s)<blockquote.*?<img[^>]+title=":construction:".*?</blockquote>`)
+	editorsNote    = regexp.MustCompile(`(?s)<blockquote.*?<img[^>]+title=":construction:".*?</blockquote>`)
 )
 
 func init() {
@@ -463,48 +1324,103 @@ func init() {
 	}
 }
 
-func renderPage(resp http.ResponseWriter, req *http.Request, topic *Topic, results []*Topic) {
-	index, err := forum.Topic(indexPagePath)
-	if err != nil {
-		log.Printf("Cannot obtain documentation index: %v", err)
+// renderPage renders the page described by data. Pages that are not
+// backed by a topic or a search must set both Title and Content.
+func renderPage(resp io.Writer, req *http.Request, data *pageData) {
+	topic := data.Topic
+
+	if data.budget == nil {
+		data.budget = requestBudget(req)
+	}
+	data.Tabs, data.Index = indexTabs(topic, data.budget)
+	data.IndexMissing = data.Index == ""
+	data.Query = req.Form.Get("q")
+	data.Logo = logoString
+	data.LiveUpdates = *liveFlag
+	data.Offline = *offlineFlag
+	data.Keyboard = *keyboardFlag
+	data.Progress = config.ReadingProgress && topic != nil
+	data.Bookmarks = config.Bookmarks
+	data.Private = *privacyStrictFlag
+	data.Refresh = topic != nil && !disabled("refresh")
+	data.Lang = *langFlag
+	if topic != nil {
+		data.Lang = topic.Lang()
+		if len(config.Languages) > 0 {
+			data.Languages = languageAlternates(req, topic)
+		}
+	}
+	data.NoSearch = disabled("search")
+	data.NoIndex = *noindexFlag || topic != nil && topic.Meta != nil && topic.Meta.NoIndex
+
+	if *popularFlag > 0 {
+		data.Popular = stats.Popular(*popularFlag)
 	}
 
-	data := &pageData{
-		Index:   index.Content(),
-		Query:   req.Form.Get("q"),
-		Results: results,
-		Logo:    logoString,
+	if topic != nil && isIndex(topic) && topic.ID == indexPageID {
+		topic.Title = indexPageTitle
 	}
 
+	current := 0
 	if topic != nil {
-		data.Topic = topic
-		data.Content = topic.Content()
+		current = topic.ID
+	}
+	data.Index = preparedOutline(data.Index).current(current)
+	if data.override == nil {
+		data.override = pageOverride(topic)
 	}
 
-	sep := strings.Index(data.Index, indexPageSep)
-	if sep >= 0 {
-		data.Index = data.Index[sep+len(indexPageSep):]
-		if topic != nil && topic.ID == index.ID {
-			index.Title = indexPageTitle
-			data.Content = data.Content[:sep]
+	// The article is prepared once the page up to the sidebar is sent.
+	data.flusher, _ = resp.(http.Flusher)
+	data.prepare = func() {
+		if topic != nil {
+			data.Content = topic.Content()
+		}
+		if topic != nil && isIndex(topic) {
+			if sep := strings.Index(data.Content, indexPageSep); sep >= 0 {
+				data.Content = data.Content[:sep]
+			}
+		}
+		data.Content = editorsNote.ReplaceAllString(data.Content, "")
+		if topic != nil && !isIndex(topic) {
+			data.Content = processContent(topic, data.Content, data.budget)
 		}
 	}
 
-	data.Content = editorsNote.ReplaceAllString(data.Content, "")
-	data.Index = editorsNote.ReplaceAllString(data.Index, "")
-
-	err = pageTemplate.Execute(resp, data)
+	t := pageTemplate
+	if data.override != nil && data.override.template != nil {
+		t = data.override.template
+	}
+	err := t.Execute(resp, data)
 	if err != nil {
 		log.Printf("Cannot execute page template: %v", err)
+		reportError(req, "error", fmt.Errorf("cannot execute page template: %v", err), nil)
 	}
 }
 
+// processContent applies the render-time processing passes to the
+// content of topic, obtaining the pages they need within budget.
+func processContent(topic *Topic, content string, budget *upstreamBudget) string {
+	content = expandIncludes(topic, content, budget)
+	content = expandVariables(content)
+	content = applyGlossary(topic, content, budget)
+	content = namespaceLinks(content)
+	content = applyReferenceTables(topic, content)
+	return content
+}
+
 var pageTemplate *template.Template
 
 var pageFuncs = template.FuncMap{
 	"html":          unescapeHTML,
 	"formatTime":    formatTime,
 	"stringBetween": stringBetween,
+	"markdown":      markdown,
+	"truncate":      truncate,
+	"slugify":       slugify,
+	"relTime":       relTime,
+	"pluralize":     pluralize,
+	"dict":          dict,
 }
 
 func unescapeHTML(s string) template.HTML {
@@ -545,24 +1461,101 @@ func stringBetween(after, until, content string) string {
 
 func init() {
 	var err error
-	pageTemplate, err = template.New("page").Funcs(pageFuncs).Parse(pageTemplateString)
+	pageTemplate, err = parsePageTemplate("")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fatal: parsing page template failed: %s\n", err)
 		os.Exit(1)
 	}
 }
 
+// pagePartials are the templates defined by pageTemplateString that
+// may be overridden by a NAME.html file in the templates directory.
+var pagePartials = []string{"head", "sidebar", "article", "footer"}
+
+// parsePageTemplate parses the page template, replacing its partials with
+// the ones found in overrideDir, if any. Overrides may use the original
+// partial, renamed to "base-NAME", to extend rather than replace it.
+func parsePageTemplate(overrideDir string) (*template.Template, error) {
+	t, err := template.New("page").Funcs(pageFuncs).Parse(pageTemplateString)
+	if err != nil || overrideDir == "" {
+		return t, err
+	}
+	for _, name := range pagePartials {
+		data, err := ioutil.ReadFile(filepath.Join(overrideDir, name+".html"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read template partial: %v", err)
+		}
+		if _, err := t.AddParseTree("base-"+name, t.Lookup(name).Tree); err != nil {
+			return nil, fmt.Errorf("cannot parse template partial %s: %v", name, err)
+		}
+		if _, err := t.New(name).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("cannot parse template partial %s: %v", name, err)
+		}
+	}
+	return t, nil
+}
+
 const pageTemplateString = `<!DOCTYPE html>
-<html>
+<html lang="{{.Lang}}">
 
 <head>
+{{template "head" .}}
+</head>
+
+<body{{with .BodyClass}} class="{{.}}"{{end}}>
 
+<a class="skip-link" href="#content">Skip to content</a>
+
+<div class="container">
+	<div class="row">
+		{{if not .FullWidth}}{{template "sidebar" .}}{{end}}{{.Stream}}
+		<div class="content {{if .FullWidth}}col-sm-12{{else}}col-sm-9 col-sm-offset-3{{end}}">
+			<main id="content" tabindex="-1">
+			{{template "article" .}}
+			</main>
+			<footer>
+			{{template "footer" .}}
+			</footer>
+		</div>
+	</div>
+</div>
+
+</body>
+
+</html>
+
+{{define "head"}}
 <meta charset="utf-8">
-<title>{{if .Topic}}{{.Topic.Title}}{{else if .Query}}{{.Query}}{{else}}Search Results{{end}} - Snap Docs</title>
+<title>{{if .Topic}}{{.Topic.Title}}{{else if .Title}}{{.Title}}{{else if .Query}}{{.Query}}{{else}}Search Results{{end}} - Snap Docs</title>
 <meta name="viewport" content="width=device-width, initial-scale=1.0, minimum-scale=1.0, maximum-scale=1.0, user-scalable=no">
-<link href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-BVYiiSIFeK1dGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">
+{{if .Private}}<meta name="referrer" content="no-referrer">
+<link href="/static/bootstrap.min.css" rel="stylesheet">
+{{else}}<link href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-BVYiiSIFeK1dGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">{{end}}
 <link rel="icon" type="image/png" href="/icon32.png" />
-
+<link rel="apple-touch-icon" href="/apple-touch-icon.png" />
+<link rel="manifest" href="/manifest.webmanifest" />
+{{with .Topic}}
+{{with .Description}}<meta name="description" content="{{.}}">
+<meta property="og:description" content="{{.}}">{{end}}
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:type" content="article">
+<meta property="og:url" content="{{.URL}}">
+<meta property="og:site_name" content="Snap Docs">
+{{if .OGImageURL}}<meta property="og:image" content="{{.OGImageURL}}">
+<meta property="og:image:width" content="1200">
+<meta property="og:image:height" content="630">
+<meta name="twitter:card" content="summary_large_image">
+{{else}}{{with .ThumbnailURL}}<meta property="og:image" content="{{.}}">{{end}}{{end}}
+{{with .Meta}}
+{{if .Keywords}}<meta name="keywords" content="{{.Keywords}}">{{end}}
+{{if .Canonical}}<link rel="canonical" href="{{.Canonical}}">{{end}}
+{{end}}{{end}}
+{{if .NoIndex}}<meta name="robots" content="noindex">{{end}}
+{{range .Languages}}{{if .Translated}}<link rel="alternate" hreflang="{{.Lang}}" href="{{.URL}}">
+{{end}}{{end}}
 <!--<link href="https://maxcdn.bootstrapcdn.com/font-awesome/4.7.0/css/font-awesome.min.css" rel="stylesheet">-->
 
 <style>
@@ -609,6 +1602,37 @@ pre, pre code {
 	font-size: 1.2em;
 }
 
+.page-toc {
+	margin: 10px 0 20px;
+	padding: 10px 15px;
+	border-left: 3px solid #eee;
+	font-size: 0.9em;
+}
+.page-toc h2 {
+	margin: 0 0 5px;
+	font-size: 1em;
+	font-weight: bold;
+}
+.page-toc ul {
+	margin: 0;
+	padding: 0;
+	list-style: none;
+}
+.page-toc .toc-level-3 {
+	padding-left: 15px;
+}
+.hide-toc .page-toc {
+	display: none;
+}
+@media (min-width: 992px) {
+	/* Full-width pages have room for the contents beside the article. */
+	.full-width .page-toc {
+		float: right;
+		width: 25%;
+		margin: 0 0 20px 20px;
+	}
+}
+
 .page-footer {
 	margin-bottom: 100px;
 }
@@ -630,6 +1654,15 @@ pre, pre code {
 	display: block;
 }
 
+.index .index-tabs {
+	display: block;
+	margin-top: 20px;
+}
+.index .index-tabs li {
+	display: list-item;
+	clear: none;
+}
+
 .sidebar {
 	position: fixed;
 	top: 0;
@@ -665,6 +1698,86 @@ input[type=search] {
 	width: 100%;
 }
 
+.index .language-switcher {
+	display: block;
+	margin-top: 10px;
+}
+.index .language-switcher li {
+	display: inline-block;
+	clear: none;
+}
+.index .language-switcher li.active a {
+	font-weight: bold;
+	color: inherit;
+}
+
+.skip-link {
+	position: absolute;
+	top: -100px;
+	left: 10px;
+	z-index: 3000;
+	padding: 8px 16px;
+	background-color: white;
+	border: 1px solid #ccc;
+}
+.skip-link:focus {
+	top: 10px;
+}
+
+a:focus, input:focus, main:focus {
+	outline: 2px solid #e95420;
+	outline-offset: 2px;
+}
+main:focus:not(:focus-visible) {
+	outline: none;
+}
+
+.index-outline a[aria-current] {
+	font-weight: bold;
+}
+
+.command-palette {
+	display: none;
+	position: fixed;
+	top: 0;
+	right: 0;
+	bottom: 0;
+	left: 0;
+	z-index: 2000;
+	background-color: rgba(0,0,0,.3);
+}
+.command-palette-box {
+	max-width: 600px;
+	margin: 10vh auto 0;
+	padding: 10px;
+	background-color: white;
+	border-radius: 4px;
+	box-shadow: 0 4px 16px rgba(0,0,0,.3);
+}
+.command-palette input {
+	width: 100%;
+	font-size: 1.2em;
+}
+.command-palette ul {
+	list-style: none;
+	margin: 10px 0 0;
+	padding: 0;
+	max-height: 60vh;
+	overflow-y: auto;
+}
+.command-palette li a {
+	display: block;
+	padding: 4px 10px;
+}
+.command-palette li.selected a {
+	background-color: #eee;
+}
+
+.sidebar.collapsed {
+	position: relative;
+	border-right: none;
+}
+
 @media (max-width: 768px) {
 	.sidebar {
 		position: relative;
@@ -676,6 +1789,12 @@ input[type=search] {
 	font-size: 14px;
 }
 
+.glossary-term {
+	color: inherit;
+	text-decoration: underline dotted;
+	cursor: help;
+}
+
 img.emoji {
 	width: 20px;
 	height: 20px;
@@ -708,67 +1827,332 @@ table td {
 	padding: 3px 3px 3px 10px;
 }
 
-</style>
+.update-toast {
+	position: fixed;
+	right: 20px;
+	bottom: 20px;
+	z-index: 2000;
+	padding: 10px 20px;
+	border-radius: 4px;
+	color: white;
+	background-color: #333;
+}
 
-</head>
+.update-toast a {
+	color: #82bea0;
+}
 
-<body>
+.content-truncated {
+	font-style: italic;
+}
 
-<div class="container">
-	<div class="row">
-		<div class="index sidebar col-sm-3">
-			<div class="logo">{{html .Logo}}</div>
-			<div class="search">
-				<form method="GET" action="/search">
-					<input type="search" name="q" placeholder="&#x1f50d; Search" value="{{.Query}}">
-					<input type="submit" style="position: absolute; left: -9999px; width: 1px; height: 1px;" tabindex="-1"/>
-				</form>
-			</div>
-			<div>
-			{{html .Index}}
-			</div>
-		</div>
-		<div class="content col-sm-9 col-sm-offset-3">
-			<div class="page-header">
-				<h1>{{if .Topic}}{{.Topic.Title}}{{else}}Search{{end}}</h1>
-			</div>
-			<div class="alert alert-info" role="alert">This content is <strong>experimental</strong>. Make sure to visit the <a href="https://docs.snapcraft.io/">official site</a>.</div>
-			<div class="page-body">
-				{{if .Topic}}
-				{{html .Content}}
-				{{else}}
-				<div class="search">
-					<form method="GET" action="/search">
-						<input type="search" name="q" placeholder="&#x1f50d; Terms to search for" value="{{.Query}}">
-						<input type="submit" style="position: absolute; left: -9999px; width: 1px; height: 1px;" tabindex="-1"/>
-					</form>
-				</div>
-				{{range .Results}}
-				<h1 class="result-title"><a href="{{.}}">{{.Title}}</a></h1>
-				<div class="result-blurb">{{html .Blurb}}</div>
-				{{else}}
-				{{if .Query}}<h3>Cannot find any documents matching <code>{{.Query}}</code> right now.</h3>{{end}}
-				{{end}}
-				{{end}}
-			</div>
-			<div class="page-footer">
-				<hr>
-				<div class="text-muted credit">
-				{{if .Topic}}
-				<div>For questions and comments see <a href="{{.Topic.ForumURL}}">the forum topic</a>.</div>
-				<div>Last update on {{formatTime .Topic.LastUpdate}}.</div>
-				{{else if .Query}}
-				<div>{{if .Results}}Cannot find what you are looking for? {{end}}Consider asking about it <a href="https://forum.snapcraft.io/">in the forum</a>.</div>
-				{{end}}
-				</div>
-			</div>
-		</div>
+.refresh-form .btn-link {
+	padding: 0;
+	color: inherit;
+	text-decoration: underline;
+}
+
+.result-thumbnail {
+	float: right;
+	max-width: 120px;
+	max-height: 80px;
+	margin: 20px 0 10px 15px;
+	border-radius: 3px;
+}
+
+.index-outline h1 a, .index-outline h2 a, .index-outline h3 a {
+	color: inherit;
+}
+
+.section-pages {
+	list-style: none;
+	padding: 0;
+}
+
+.section-pages li {
+	margin-bottom: 15px;
+}
+
+.section-pages p {
+	margin: 5px 0 0 0;
+}
+
+.footnotes {
+	font-size: 90%;
+}
+
+.footnote-ref a {
+	text-decoration: none;
+}
+
+.footnotes li:target, .footnote-ref:target {
+	background-color: #fff8e1;
+}
+
+li.task-item {
+	list-style: none;
+}
+
+.task-box {
+	display: inline-block;
+	width: 1em;
+	height: 1em;
+	margin: 0 0.3em 0 -1.3em;
+	border: 1px solid #666;
+	border-radius: 2px;
+	line-height: 1em;
+	text-align: center;
+	vertical-align: -0.1em;
+}
+
+.task-box.checked {
+	border-color: #0e8420;
+	color: white;
+	background-color: #0e8420;
+}
+
+.admonition {
+	margin: 0 0 20px 0;
+	padding: 10px 15px;
+	border-left: 4px solid #2c7fb8;
+	border-radius: 3px;
+	background-color: #eef5fb;
+}
+
+.admonition > :last-child {
+	margin-bottom: 0;
+}
+
+.admonition-title {
+	font-weight: bold;
+}
+
+.admonition-tip {
+	border-color: #0e8420;
+	background-color: #eef7ef;
+}
+
+.admonition-important {
+	border-color: #772953;
+	background-color: #f6eef2;
+}
+
+.admonition-warning {
+	border-color: #f99b11;
+	background-color: #fef5e7;
+}
+
+.admonition-danger {
+	border-color: #c7162b;
+	background-color: #fbeced;
+}
+
+.reference-filter {
+	max-width: 300px;
+	margin: 10px 0;
+}
+
+.reference-link {
+	margin-left: 5px;
+	color: #ccc;
+	text-decoration: none;
+}
+
+tr:target {
+	background-color: #fff8e1;
+}
+
+.reference-row dt {
+	margin-top: 10px;
+}
+
+.reading-progress {
+	position: fixed;
+	top: 0;
+	left: 0;
+	z-index: 2000;
+	width: 0;
+	height: 3px;
+	background-color: #e95420;
+}
+
+@media print {
+	.reading-progress {
+		display: none;
+	}
+}
+
+.bookmark-controls {
+	float: right;
+	margin-top: 25px;
+}
+
+.bookmark-toggle, .bookmark-remove {
+	padding: 2px 8px;
+	border: 1px solid #ccc;
+	border-radius: 3px;
+	background: none;
+}
+
+.landing-search {
+	margin: 20px 0 30px 0;
+}
+
+.landing-sections {
+	display: flex;
+	flex-wrap: wrap;
+	margin: 0 -10px;
+}
+
+.landing-section {
+	flex: 1 1 280px;
+	margin: 10px;
+	padding: 5px 20px;
+	border: 1px solid #ddd;
+	border-top: 3px solid #82bea0;
+	border-radius: 3px;
+}
+
+.landing-section .more {
+	color: #666;
+}
+
+</style>
+{{end}}
+
+{{define "sidebar"}}
+<nav class="index sidebar col-sm-3{{if .IndexMissing}} collapsed{{end}}" aria-label="Documentation">
+	<div class="logo">{{html .Logo}}</div>
+	{{if .Languages}}
+	<ul class="language-switcher" aria-label="Language">
+	{{range .Languages}}<li{{if .Current}} class="active"{{end}}><a href="{{.URL}}" hreflang="{{.Lang}}" lang="{{.Lang}}"{{if .Current}} aria-current="true"{{end}}>{{.Name}}</a></li>
+	{{end}}
+	</ul>
+	{{end}}
+	{{if not .NoSearch}}
+	<div class="search">
+		<form method="GET" action="/search" role="search">
+			<input type="search" name="q" placeholder="&#x1f50d; Search" aria-label="Search the documentation" value="{{.Query}}">
+			<input type="submit" style="position: absolute; left: -9999px; width: 1px; height: 1px;" tabindex="-1"/>
+		</form>
+	</div>
+	{{end}}
+	{{if .Popular}}
+	<div class="popular">
+		<h4>Most read</h4>
+		<ul>
+		{{range .Popular}}<li><a href="{{.Path}}">{{.Title}}</a></li>
+		{{end}}
+		</ul>
+	</div>
+	{{end}}
+	{{if .Tabs}}
+	<ul class="nav nav-tabs index-tabs">
+	{{range .Tabs}}<li{{if .Active}} class="active"{{end}}><a href="{{.URL}}"{{if .Active}} aria-current="true"{{end}}>{{.Title}}</a></li>
+	{{end}}
+	</ul>
+	{{end}}
+	{{if .IndexMissing}}
+	<div class="alert alert-warning" role="alert">The documentation index is temporarily unavailable.</div>
+	{{else}}
+	<div class="index-outline">
+	{{html .Index}}
+	</div>
+	{{end}}
+</nav>
+{{end}}
+
+{{define "article"}}
+{{with .Breadcrumb}}<ol class="breadcrumb">
+	<li><a href="{{.Root}}">Documentation</a></li>
+	<li><a href="{{.Path}}">{{.Title}}</a></li>
+</ol>{{end}}
+<div class="page-header">
+	{{if and .Bookmarks .Topic}}<div class="bookmark-controls"><button type="button" class="bookmark-toggle" data-id="{{.Topic.ID}}" hidden>&#x2606; Bookmark</button> <a href="/bookmarks">Bookmarks</a></div>{{end}}
+	<h1>{{if .Topic}}{{.Topic.Title}}{{else if .Title}}{{.Title}}{{else}}Search{{end}}</h1>
+</div>
+<div class="alert alert-info" role="alert">This content is <strong>experimental</strong>. Make sure to visit the <a href="https://docs.snapcraft.io/">official site</a>.</div>
+{{with .Notice}}<div class="alert alert-success" role="status">{{.}}</div>{{end}}
+{{with .TOC}}<nav class="page-toc" aria-label="On this page">
+	<h2>On this page</h2>
+	<ul>
+	{{range .}}<li class="toc-level-{{.Level}}"><a href="#{{.Anchor}}">{{.Title}}</a></li>
+	{{end}}</ul>
+</nav>{{end}}
+<div class="page-body">
+	{{if or .Topic .Title}}
+	{{html .Content}}
+	{{else}}
+	<div class="search">
+		<form method="GET" action="/search" role="search">
+			<input type="search" name="q" placeholder="&#x1f50d; Terms to search for" aria-label="Search the documentation" value="{{.Query}}">
+			<input type="submit" style="position: absolute; left: -9999px; width: 1px; height: 1px;" tabindex="-1"/>
+		</form>
+	</div>
+	{{range .Results}}
+	{{if .Thumbnail}}<a href="{{.Link}}" tabindex="-1" aria-hidden="true"><img class="result-thumbnail" src="{{.Thumbnail}}" alt="" loading="lazy"></a>{{end}}
+	<h1 class="result-title"{{if ne .Lang $.Lang}} lang="{{.Lang}}"{{end}}><a href="{{.Link}}">{{.Title}}</a>{{if .Section}} <small class="result-section"><a href="{{.Link}}">&sect; {{.Section}}</a></small>{{end}}</h1>
+	<div class="result-blurb"{{if ne .Lang $.Lang}} lang="{{.Lang}}"{{end}}>{{html .Blurb}}</div>
+	{{else}}
+	{{if .Query}}<h3>Cannot find any documents matching <code>{{.Query}}</code> right now.</h3>{{end}}
+	{{end}}
+	{{end}}
+</div>
+{{end}}
+
+{{define "footer"}}
+<div class="page-footer">
+	<hr>
+	<div class="text-muted credit">
+	{{if .Topic}}
+	<div>For questions and comments see <a href="{{.Topic.ForumURL}}">the forum topic</a>.</div>
+	<div>Last update on {{formatTime .Topic.LastUpdate}}.</div>
+	{{if .Refresh}}<form class="refresh-form" method="POST" action="/refresh"><input type="hidden" name="path" value="{{.Topic}}"><button type="submit" class="btn btn-link">Check for updates</button></form>{{end}}
+	{{else if .Query}}
+	<div>{{if .Results}}Cannot find what you are looking for? {{end}}Consider asking about it <a href="https://forum.snapcraft.io/">in the forum</a>.</div>
+	{{end}}
 	</div>
 </div>
 
-</body>
+{{if and .LiveUpdates .Topic}}
+<div class="update-toast" id="update-toast" style="display: none">This page was just updated. <a href="">Reload?</a></div>
+<script>
+if (window.EventSource) {
+	new EventSource("/events?topic={{.Topic.ID}}").addEventListener("topic-updated", function() {
+		document.getElementById("update-toast").style.display = "block";
+	});
+}
+</script>
+{{end}}
 
-</html>
+{{if .ReferenceTables}}
+<script src="/static/reference.js" defer></script>
+{{end}}
+
+{{if .Bookmarks}}
+<script src="/static/bookmarks.js" defer></script>
+{{end}}
+
+{{if .Progress}}
+<script src="/static/progress.js" defer></script>
+{{end}}
+
+{{if .Refresh}}
+<script src="/static/refresh.js" defer></script>
+{{end}}
+
+{{if .Keyboard}}
+<script src="/static/keys.js" data-search="{{not .NoSearch}}" defer></script>
+{{end}}
+
+{{if .Offline}}
+<script>
+if (navigator.serviceWorker) {
+	navigator.serviceWorker.register("/sw.js");
+}
+</script>
+{{end}}
+{{end}}
 `
 
 var iconString = `
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// testOutline is the outline of the index page used by the tests.
+const testOutline = `
+<h2>Guides</h2>
+<ul>
+<li><a href="/t/snap-format/100">The snap format</a></li>
+<li><a href="/t/snap-confinement/101">Snap confinement</a></li>
+</ul>`
+
+// withTestForum runs f with the forum cache holding the documentation
+// outline and the given topics, as fresh copies.
+func withTestForum(outline string, topics []*Topic, f func()) {
+	index := &Topic{ID: indexPageID, Slug: "documentation-outline", Title: "Documentation outline", Category: docCategory}
+	index.setPost(&Post{ID: 1, TopicID: indexPageID, Cooked: "<p>Welcome.</p>" + indexPageSep + outline, Version: 1, UpdatedAt: time.Now()})
+
+	cache := make(map[int]*topicCache)
+	for _, topic := range append(topics, index) {
+		c := &topicCache{}
+		c.mu.Lock()
+		c.set(topic, time.Now())
+		c.mu.Unlock()
+		cache[topic.ID] = c
+	}
+	forum.mu.Lock()
+	saved := forum.cache
+	forum.cache = cache
+	forum.mu.Unlock()
+	defer func() {
+		forum.mu.Lock()
+		forum.cache = saved
+		forum.mu.Unlock()
+	}()
+	f()
+}
+
+// testTopic returns a documentation topic with the given cooked content.
+func testTopic(id int, slug, title, cooked string) *Topic {
+	topic := &Topic{ID: id, Slug: slug, Title: title, Category: docCategory}
+	topic.setPost(&Post{ID: id * 10, TopicID: id, Cooked: cooked, Version: 1, UpdatedAt: time.Now()})
+	return topic
+}
+
+// renderTestPage renders the page of topic from the cache alone.
+func renderTestPage(topic *Topic) string {
+	req := httptest.NewRequest("GET", topic.String(), nil)
+	req.ParseForm()
+	rec := httptest.NewRecorder()
+	renderPage(rec, req, &pageData{Topic: topic, budget: cacheOnlyBudget()})
+	return rec.Body.String()
+}
+
+// findElements returns the elements within n for which match is true.
+func findElements(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	walkElements(n, func(n *html.Node) bool {
+		if match(n) {
+			found = append(found, n)
+		}
+		return true
+	})
+	return found
+}
+
+func TestRenderPageAccessibility(t *testing.T) {
+	topic := testTopic(100, "snap-format", "The snap format", `<h2>Layout</h2><p>A snap is a SquashFS file.</p>`)
+	withTestForum(testOutline, []*Topic{topic}, func() {
+		doc, err := html.Parse(strings.NewReader(renderTestPage(topic)))
+		if err != nil {
+			t.Fatalf("cannot parse page: %v", err)
+		}
+		byTag := func(tag string) []*html.Node {
+			return findElements(doc, func(n *html.Node) bool { return n.Data == tag })
+		}
+
+		// Landmarks.
+		mains := byTag("main")
+		if len(mains) != 1 || nodeAttr(mains[0], "id") != "content" {
+			t.Fatalf("page has %d main elements, want one with id content", len(mains))
+		}
+		if !strings.Contains(nodeText(mains[0]), "A snap is a SquashFS file.") {
+			t.Errorf("main element doesn't hold the page content")
+		}
+		navs := byTag("nav")
+		if len(navs) == 0 {
+			t.Errorf("page has no nav element")
+		}
+		for _, nav := range navs {
+			if nodeAttr(nav, "aria-label") == "" {
+				t.Errorf("nav element without aria-label")
+			}
+		}
+		if len(byTag("footer")) != 1 {
+			t.Errorf("page has no footer element")
+		}
+		searches := findElements(doc, func(n *html.Node) bool { return nodeAttr(n, "role") == "search" })
+		if len(searches) == 0 {
+			t.Errorf("page has no search landmark")
+		}
+
+		// The skip link is the first link, and leads to the content.
+		links := byTag("a")
+		if len(links) == 0 || nodeAttr(links[0], "class") != "skip-link" || nodeAttr(links[0], "href") != "#content" {
+			t.Errorf("first link on the page isn't a skip link to #content")
+		}
+
+		// The outline marks the link to the current page, and only it.
+		var current []string
+		for _, a := range links {
+			if nodeAttr(a, "aria-current") == "page" {
+				current = append(current, nodeAttr(a, "href"))
+			}
+		}
+		if len(current) != 1 || current[0] != topic.String() {
+			t.Errorf("links with aria-current=page: %v, want %s", current, topic.String())
+		}
+	})
+}
+
+// benchmarkOutline returns an outline with sections of links to topics
+// numbered from 1000, and a note for editors in each section.
+func benchmarkOutline(sections, links int) string {
+	var buf strings.Builder
+	id := 1000
+	for i := 0; i < sections; i++ {
+		fmt.Fprintf(&buf, "<h2>Section %d</h2>\n", i)
+		buf.WriteString(`<blockquote><p><img src="/images/emoji/construction.png" title=":construction:"> Editors only.</p></blockquote>` + "\n<ul>\n")
+		for j := 0; j < links; j++ {
+			fmt.Fprintf(&buf, "<li><a href=\"/t/page-%d/%d\">Page %d</a></li>\n", id, id, id)
+			id++
+		}
+		buf.WriteString("</ul>\n")
+	}
+	return buf.String()
+}
+
+// BenchmarkRenderPage renders a page with a large outline, either
+// preparing the outline once as done when serving, or from the raw
+// outline for every page.
+func BenchmarkRenderPage(b *testing.B) {
+	topic := testTopic(1000, "page-1000", "Page 1000", `<h2>Heading</h2><p>Content.</p>`)
+	withTestForum(benchmarkOutline(30, 50), []*Topic{topic}, func() {
+		b.Run("prepared", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				renderTestPage(topic)
+			}
+		})
+		b.Run("raw", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				outlineFragments.mu.Lock()
+				outlineFragments.byRaw = nil
+				outlineFragments.mu.Unlock()
+				renderTestPage(topic)
+			}
+		})
+	})
+}
+
+func TestRenderPageTOC(t *testing.T) {
+	content := `<h2><a name="install" class="anchor" href="#install"></a>Install</h2><p>a</p>
+<h3><a name="install-linux" class="anchor" href="#install-linux"></a>On Linux</h3><p>b</p>
+<h2><a name="usage" class="anchor" href="#usage"></a>Usage &amp; more</h2><p>c</p>`
+	topic := testTopic(100, "snap-format", "The snap format", content)
+	withTestForum(testOutline, []*Topic{topic}, func() {
+		page := renderTestPage(topic)
+		for _, want := range []string{
+			`<nav class="page-toc" aria-label="On this page">`,
+			`<li class="toc-level-2"><a href="#install">Install</a></li>`,
+			`<li class="toc-level-3"><a href="#install-linux">On Linux</a></li>`,
+			`<li class="toc-level-2"><a href="#usage">Usage &amp; more</a></li>`,
+		} {
+			if !strings.Contains(page, want) {
+				t.Errorf("page has no %s", want)
+			}
+		}
+		if strings.Contains(page, "<body class=") {
+			t.Errorf("page without overrides has body classes")
+		}
+
+		topic.Meta = &PageMeta{HideTOC: true}
+		if page := renderTestPage(topic); !strings.Contains(page, `<body class="hide-toc">`) {
+			t.Errorf("page with hide-toc has no hide-toc body class")
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// PageMeta holds the per-page settings authors may provide in a metadata
+// block at the start of the topic's first post, either as an HTML comment
+// or as a code block fenced as "meta":
+//
+//	<!-- meta
+//	description: How to install snaps on Ubuntu.
+//	keywords: install, ubuntu
+//	noindex: true
+//	hide-toc: true
+//	canonical: https://snapcraft.io/docs/installing-snapd
+//	lang: en
+//	translation-of: /installing-snapd/6735
+//	-->
+type PageMeta struct {
+	Description string
+	Keywords    string
+	NoIndex     bool
+	HideTOC     bool
+	Canonical   string
+	Lang        string
+
+	// TranslationOf is the path or ID of the topic this one translates.
+	TranslationOf string
+}
+
+var (
+	metaComment   = regexp.MustCompile(`(?s)^\s*<!--\s*meta\s*\n(.*?)-->`)
+	metaFence     = regexp.MustCompile("(?s)^\\s*```meta\\s*\n(.*?)\n```")
+	metaCodeBlock = regexp.MustCompile(`(?s)^\s*<pre><code class="lang-meta">.*?</code></pre>`)
+)
+
+// parsePageMeta parses the metadata block at the start of the raw post
+// markdown, if any.
+func parsePageMeta(raw string) *PageMeta {
+	m := metaComment.FindStringSubmatch(raw)
+	if m == nil {
+		m = metaFence.FindStringSubmatch(raw)
+	}
+	if m == nil {
+		return nil
+	}
+	meta := &PageMeta{}
+	for _, line := range strings.Split(m[1], "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		flag := value == "" || value == "true" || value == "yes"
+		switch key {
+		case "description":
+			meta.Description = value
+		case "keywords":
+			meta.Keywords = value
+		case "noindex":
+			meta.NoIndex = flag
+		case "hide-toc":
+			meta.HideTOC = flag
+		case "canonical":
+			if strings.HasPrefix(value, "https://") || strings.HasPrefix(value, "http://") {
+				meta.Canonical = value
+			}
+		case "lang":
+			meta.Lang = value
+		case "translation-of":
+			meta.TranslationOf = value
+		default:
+			log.Printf("Ignoring unknown page metadata %q", key)
+		}
+	}
+	return meta
+}
+
+// stripPageMeta removes the rendered fenced metadata block from the start
+// of the cooked content. Comments are not rendered and need no stripping.
+func stripPageMeta(content string) string {
+	return metaCodeBlock.ReplaceAllString(content, "")
+}
+
+const descriptionMaxLength = 160
+
+var contentParagraph = regexp.MustCompile(`(?s)<p>(.*?)</p>`)
+
+// contentDescription returns the plain text of the first paragraph with
+// text in content, truncated to a length fit for search snippets.
+func contentDescription(content string) string {
+	for _, m := range contentParagraph.FindAllStringSubmatch(content, -1) {
+		text := strings.Join(strings.Fields(plainText(m[1])), " ")
+		if text != "" {
+			return truncate(descriptionMaxLength, text)
+		}
+	}
+	return ""
+}
+
+// Description returns the description set in the page metadata, or
+// otherwise one derived from the first paragraph of the topic.
+func (t *Topic) Description() string {
+	if t.Meta != nil && t.Meta.Description != "" {
+		return t.Meta.Description
+	}
+	return t.description
+}
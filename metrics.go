@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsTopTopics is how many of the most viewed topics are exposed
+// individually, keeping the number of series bounded.
+const metricsTopTopics = 20
+
+var metricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type routeMetrics struct {
+	mu       sync.Mutex
+	requests map[string]int64 // By status code.
+	buckets  []int64
+	count    int64
+	sum      float64
+}
+
+var metrics struct {
+	mu     sync.Mutex
+	routes map[string]*routeMetrics
+}
+
+// routeClass returns the class of route serving path, for labelling
+// request metrics.
+func routeClass(path string) string {
+	switch {
+	case path == "/" || pagePathPattern.MatchString(path):
+		return "topic"
+	case path == "/search":
+		return "search"
+	case path == "/admin" || strings.HasPrefix(path, "/admin/"):
+		return "admin"
+	case strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/export/") || path == "/graphql" ||
+		path == "/events" || path == "/llms.txt" || path == "/llms-full.txt":
+		return "api"
+	case path == "/icon32.png" || path == "/favicon.ico" || path == "/apple-touch-icon.png" ||
+		path == "/manifest.webmanifest" || path == "/widget.js" || path == "/sw.js" || path == "/image" ||
+		strings.HasPrefix(path, "/badge/") || strings.HasPrefix(path, "/thumbnail/") ||
+		strings.HasPrefix(path, "/og-image/"):
+		return "static"
+	}
+	return "other"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to reach the original writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// metricsHandler wraps h so that requests are counted and timed per
+// route class.
+func metricsHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		class := routeClass(req.URL.Path)
+		rec := &statusRecorder{ResponseWriter: resp}
+		defer func() {
+			status := rec.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			observeRequest(class, status, time.Since(start))
+		}()
+		h(rec, req)
+	}
+}
+
+func observeRequest(class string, status int, duration time.Duration) {
+	metrics.mu.Lock()
+	if metrics.routes == nil {
+		metrics.routes = make(map[string]*routeMetrics)
+	}
+	m, ok := metrics.routes[class]
+	if !ok {
+		m = &routeMetrics{requests: make(map[string]int64), buckets: make([]int64, len(metricsBuckets))}
+		metrics.routes[class] = m
+	}
+	metrics.mu.Unlock()
+
+	seconds := duration.Seconds()
+	m.mu.Lock()
+	m.requests[fmt.Sprint(status)]++
+	for i, bound := range metricsBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.count++
+	m.sum += seconds
+	m.mu.Unlock()
+}
+
+// serveMetrics serves the request metrics, the views of the most viewed
+// topics, the crawl progress, and the cache usage in the Prometheus text
+// format.
+func serveMetrics(resp http.ResponseWriter, req *http.Request) {
+	var buf strings.Builder
+
+	metrics.mu.Lock()
+	classes := make([]string, 0, len(metrics.routes))
+	for class := range metrics.routes {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	routes := make([]*routeMetrics, len(classes))
+	for i, class := range classes {
+		routes[i] = metrics.routes[class]
+	}
+	metrics.mu.Unlock()
+
+	buf.WriteString("# HELP snapdocs_requests_total Requests served, by route class and status code.\n")
+	buf.WriteString("# TYPE snapdocs_requests_total counter\n")
+	for i, class := range classes {
+		m := routes[i]
+		m.mu.Lock()
+		codes := make([]string, 0, len(m.requests))
+		for code := range m.requests {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(&buf, "snapdocs_requests_total{route=%q,code=%q} %d\n", class, code, m.requests[code])
+		}
+		m.mu.Unlock()
+	}
+
+	buf.WriteString("# HELP snapdocs_request_duration_seconds Time taken to serve requests, by route class.\n")
+	buf.WriteString("# TYPE snapdocs_request_duration_seconds histogram\n")
+	for i, class := range classes {
+		m := routes[i]
+		m.mu.Lock()
+		for i, bound := range metricsBuckets {
+			fmt.Fprintf(&buf, "snapdocs_request_duration_seconds_bucket{route=%q,le=\"%g\"} %d\n", class, bound, m.buckets[i])
+		}
+		fmt.Fprintf(&buf, "snapdocs_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", class, m.count)
+		fmt.Fprintf(&buf, "snapdocs_request_duration_seconds_sum{route=%q} %g\n", class, m.sum)
+		fmt.Fprintf(&buf, "snapdocs_request_duration_seconds_count{route=%q} %d\n", class, m.count)
+		m.mu.Unlock()
+	}
+
+	buf.WriteString("# HELP snapdocs_topic_views_total Views of the most viewed topics.\n")
+	buf.WriteString("# TYPE snapdocs_topic_views_total counter\n")
+	for _, ts := range stats.Popular(metricsTopTopics) {
+		fmt.Fprintf(&buf, "snapdocs_topic_views_total{topic=%q} %d\n", ts.Path, ts.Views)
+	}
+
+	writeCrawlMetrics(&buf)
+	writeJanitorMetrics(&buf)
+
+	resp.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	resp.Write([]byte(buf.String()))
+}
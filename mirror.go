@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+)
+
+// Mirror is another snapdocs instance, or a static export of one, from
+// which pages are fetched when the forum cannot be reached and the cached
+// copies are too old to be served. Mirrors are tried in order.
+type Mirror struct {
+	URL string `json:"url"`
+}
+
+func (m *Mirror) init() error {
+	u, err := url.Parse(m.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid mirror URL %q", m.URL)
+	}
+	m.URL = strings.TrimSuffix(m.URL, "/")
+	return nil
+}
+
+// mirrorTopic is a page as served to other instances at /mirror/ID.json,
+// with its content already processed.
+type mirrorTopic struct {
+	*Topic
+	Content string `json:"content"`
+	Raw     string `json:"raw,omitempty"`
+}
+
+// fetchMirrored obtains the topic with the given ID from the first mirror
+// having it, giving up on them past the deadline of budget.
+func fetchMirrored(id int, budget *upstreamBudget) (*Topic, error) {
+	ctx, cancel := budget.context()
+	defer cancel()
+	err := fmt.Errorf("no mirrors")
+	for _, m := range config.Mirrors {
+		if ctx.Err() != nil {
+			return nil, errUpstreamBudget
+		}
+		var topic *Topic
+		topic, err = m.fetchTopic(ctx, id)
+		if err == nil {
+			log.Printf("Fetched topic %d from mirror %s.", id, m.URL)
+			return topic, nil
+		}
+		log.Printf("Cannot fetch topic %d from mirror %s: %v", id, m.URL, err)
+	}
+	return nil, fmt.Errorf("cannot obtain documentation page from mirrors: %v", err)
+}
+
+func (m *Mirror) fetchTopic(ctx context.Context, id int) (*Topic, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/mirror/%d.json", m.URL, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %v status", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := mirrorTopic{Topic: &Topic{}}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal mirrored page: %v", err)
+	}
+	if result.ID != id || result.Post == nil || result.Content == "" {
+		return nil, fmt.Errorf("mirrored page is incomplete")
+	}
+	result.Topic.setMirrored(result.Content, result.Raw)
+	return result.Topic, nil
+}
+
+// setMirrored sets the content of a topic obtained from a mirror, which
+// processed it already.
+func (t *Topic) setMirrored(content, raw string) {
+	t.Post.Cooked = ""
+	t.Post.Raw = ""
+	if raw != "" {
+		t.Meta = parsePageMeta(raw)
+		t.raw = snappy.Encode(nil, []byte(raw))
+	}
+	t.image = firstImage(content)
+	t.description = contentDescription(content)
+	t.lang = detectLang(content)
+	t.content = snappy.Encode(nil, []byte(content))
+}
+
+// mirrorData returns the topic as served to other instances.
+func mirrorData(topic *Topic) ([]byte, error) {
+	return json.Marshal(&mirrorTopic{Topic: topic, Content: topic.Content(), Raw: topic.Markdown()})
+}
+
+var mirrorPattern = regexp.MustCompile(`^/mirror/([0-9]+)\.json$`)
+
+// serveMirror serves a page to other instances having this one as their
+// mirror. Pages are never fetched from the mirrors of this instance in
+// turn, so that mirrors of each other cannot loop.
+func serveMirror(resp http.ResponseWriter, req *http.Request) {
+	m := mirrorPattern.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		sendNotFound(resp, "Invalid mirror path: %s", req.URL.Path)
+		return
+	}
+	id, _ := strconv.Atoi(m[1])
+	budget := mirrorBudget()
+	if isBot(req) {
+		budget = cacheOnlyBudget()
+		budget.mirrored = true
+	}
+	topic, err := forum.TopicWithin("/"+m[1], budget)
+	if err != nil && sendForumError(resp, err) {
+		return
+	}
+	if err != nil || !isDocCategory(topic.Category) {
+		sendNotFound(resp, "Cannot find page %d.", id)
+		return
+	}
+	data, err := mirrorData(topic)
+	if err != nil {
+		log.Printf("Cannot marshal mirrored page %s: %v", topic, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Namespace maps the topics of a category to a URL prefix, so that
+// several documentation categories may be served without their topics
+// colliding under the root.
+type Namespace struct {
+	Category int    `json:"category"`
+	Prefix   string `json:"prefix"`
+
+	// tag selects the topics by tag instead, for tag-based languages.
+	tag string
+}
+
+var namespacePrefixPattern = regexp.MustCompile(`^(/[a-z0-9-]+)+$`)
+
+func (ns *Namespace) check() error {
+	ns.Prefix = strings.TrimSuffix(ns.Prefix, "/")
+	if ns.Category == 0 && ns.tag == "" {
+		return fmt.Errorf("namespace %q has no category", ns.Prefix)
+	}
+	if ns.Prefix != "" && !namespacePrefixPattern.MatchString(ns.Prefix) {
+		return fmt.Errorf("invalid namespace prefix %q", ns.Prefix)
+	}
+	return nil
+}
+
+// namespacePrefix returns the URL prefix for topic, which is empty for
+// the ones served under the root. Namespaces selecting topics by tag take
+// precedence over the ones for their category.
+func namespacePrefix(topic *Topic) string {
+	for _, ns := range config.Namespaces {
+		if ns.tag != "" && hasTag(topic, ns.tag) {
+			return ns.Prefix
+		}
+	}
+	for _, ns := range config.Namespaces {
+		if ns.tag == "" && ns.Category == topic.Category {
+			return ns.Prefix
+		}
+	}
+	return ""
+}
+
+func hasTag(topic *Topic, tag string) bool {
+	for _, t := range topic.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// stripNamespace returns path without the namespace prefix it starts
+// with, if any, along with the prefix.
+func stripNamespace(path string) (rest, prefix string) {
+	for _, ns := range config.Namespaces {
+		if ns.Prefix != "" && strings.HasPrefix(path, ns.Prefix+"/") && len(ns.Prefix) > len(prefix) {
+			prefix = ns.Prefix
+		}
+	}
+	return path[len(prefix):], prefix
+}
+
+// docCategories returns the documentation category followed by the ones
+// of the configured namespaces.
+func docCategories() []int {
+	categories := []int{docCategory}
+	for _, ns := range config.Namespaces {
+		if ns.Category != docCategory && ns.Category != 0 {
+			categories = append(categories, ns.Category)
+		}
+	}
+	return categories
+}
+
+var namespaceLink = regexp.MustCompile(`(\shref=")(/[a-z0-9-]+/([0-9]+))([#?"])`)
+
+// namespaceLinks prefixes the links in content to cached topics served
+// under a namespace, sparing readers a redirect.
+func namespaceLinks(content string) string {
+	if len(config.Namespaces) == 0 {
+		return content
+	}
+	return namespaceLink.ReplaceAllStringFunc(content, func(link string) string {
+		m := namespaceLink.FindStringSubmatch(link)
+		id, _ := strconv.Atoi(m[3])
+		topic := forum.Cached(id)
+		if topic == nil {
+			return link
+		}
+		return m[1] + namespacePrefix(topic) + m[2] + m[4]
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// topicLink matches links to topics in content, after the default rewrites
+// made them relative to this site.
+var topicLink = regexp.MustCompile(`\shref="(/[a-z0-9-]+/[0-9]+)(?:/[0-9]+)?[#?"]`)
+
+// orphanPage is a documentation page not listed in any index outline.
+type orphanPage struct {
+	Topic *Topic
+
+	// LinkedFrom holds the pages that link to the orphan, if any.
+	LinkedFrom []*Topic
+}
+
+// orphanPages compares the documentation topics against the index
+// outlines, returning the topics not listed in any of them.
+func orphanPages() ([]*orphanPage, error) {
+	topics, err := forum.Topics()
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[int]bool)
+	for _, idx := range siteIndexes() {
+		outline := idx.outline(nil)
+		if outline == "" {
+			return nil, fmt.Errorf("cannot obtain outline of index %s", idx.Path)
+		}
+		for _, section := range outlineSections(outline) {
+			for _, id := range section.TopicIDs {
+				listed[id] = true
+			}
+		}
+	}
+
+	orphans := make(map[int]*orphanPage)
+	var result []*orphanPage
+	for _, topic := range topics {
+		if !listed[topic.ID] && !isIndex(topic) {
+			orphans[topic.ID] = &orphanPage{Topic: topic}
+			result = append(result, orphans[topic.ID])
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	for _, listedTopic := range topics {
+		topic, err := forum.Topic(listedTopic.String())
+		if err != nil {
+			log.Printf("Cannot obtain %s to check its links: %v", listedTopic, err)
+			continue
+		}
+		seen := make(map[int]bool)
+		for _, m := range topicLink.FindAllStringSubmatch(topic.Content(), -1) {
+			id, err := topicPathID(m[1])
+			if err != nil || id == topic.ID || seen[id] {
+				continue
+			}
+			seen[id] = true
+			if orphan, ok := orphans[id]; ok {
+				orphan.LinkedFrom = append(orphan.LinkedFrom, topic)
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Topic.Title) < strings.ToLower(result[j].Topic.Title)
+	})
+	return result, nil
+}
+
+func serveOrphans(resp http.ResponseWriter, req *http.Request) {
+	orphans, err := orphanPages()
+	if err != nil {
+		log.Printf("Cannot send %s to %s: %v", req.URL, req.RemoteAddr, err)
+		resp.WriteHeader(http.StatusBadGateway)
+		resp.Write([]byte("Cannot check navigation: " + err.Error()))
+		return
+	}
+	var buf bytes.Buffer
+	err = orphansTemplate.Execute(&buf, orphans)
+	if err != nil {
+		log.Printf("Cannot execute orphans template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Orphan pages", Content: buf.String()})
+}
+
+var orphansTemplate = template.Must(template.New("orphans").Parse(`
+{{if .}}
+<p>These documentation pages are not listed in any index outline.</p>
+<table class="orphans">
+<thead><tr><th>Page</th><th>Linked from</th></tr></thead>
+<tbody>
+{{range .}}<tr><td><a href="{{.Topic}}">{{.Topic.Title}}</a></td><td>{{range $i, $t := .LinkedFrom}}{{if $i}}, {{end}}<a href="{{$t}}">{{$t.Title}}</a>{{else}}<em>Nothing</em>{{end}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>All documentation pages are listed in the index.</p>
+{{end}}
+`))
+
+func checkNavCommand(args []string) error {
+	flags := flag.NewFlagSet("check-nav", flag.ExitOnError)
+	flags.Parse(args)
+
+	orphans, err := orphanPages()
+	if err != nil {
+		return err
+	}
+	for _, orphan := range orphans {
+		var linkedFrom []string
+		for _, topic := range orphan.LinkedFrom {
+			linkedFrom = append(linkedFrom, topic.String())
+		}
+		if len(linkedFrom) == 0 {
+			linkedFrom = append(linkedFrom, "-")
+		}
+		fmt.Fprintf(os.Stdout, "%s\t%s\t%s\n", orphan.Topic, orphan.Topic.Title, strings.Join(linkedFrom, ","))
+	}
+	if len(orphans) > 0 {
+		return fmt.Errorf("found %d pages not listed in the index", len(orphans))
+	}
+	return nil
+}
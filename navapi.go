@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+type navAPIResult struct {
+	Indexes []*navIndex `json:"indexes"`
+}
+
+type navIndex struct {
+	Title    string        `json:"title"`
+	URL      string        `json:"url"`
+	Sections []*navSection `json:"sections"`
+}
+
+type navSection struct {
+	Title string     `json:"title"`
+	URL   string     `json:"url,omitempty"`
+	Pages []*navPage `json:"pages"`
+}
+
+type navPage struct {
+	ID    int        `json:"id"`
+	Title string     `json:"title"`
+	Path  string     `json:"path"`
+	URL   string     `json:"url"`
+	Pages []*navPage `json:"pages,omitempty"`
+}
+
+var navTopicPath = regexp.MustCompile(`^(?:/[a-z0-9-]+)+/[0-9]+$`)
+
+// navTree parses an index outline into its sections and their pages, in
+// order, with the pages nested as in the lists of the outline.
+func navTree(req *http.Request, outline string) []*navSection {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(outline), context)
+	if err != nil {
+		log.Printf("Cannot parse outline: %v", err)
+		return nil
+	}
+	var sections []*navSection
+	current := func() *navSection {
+		if len(sections) == 0 {
+			sections = append(sections, &navSection{})
+		}
+		return sections[len(sections)-1]
+	}
+	// add appends the page linked to by a, if any, to pages.
+	add := func(pages *[]*navPage, a *html.Node) *navPage {
+		path := nodeAttr(a, "href")
+		if !navTopicPath.MatchString(path) {
+			return nil
+		}
+		id, err := topicPathID(path)
+		if err != nil {
+			return nil
+		}
+		page := &navPage{ID: id, Title: strings.Join(strings.Fields(nodeText(a)), " "), Path: path, URL: siteURL(req, path)}
+		*pages = append(*pages, page)
+		return page
+	}
+	var walk func(n *html.Node, pages *[]*navPage)
+	walk = func(n *html.Node, pages *[]*navPage) {
+		if n.Type != html.ElementNode {
+			return
+		}
+		switch n.DataAtom {
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			title := strings.Join(strings.Fields(nodeText(n)), " ")
+			section := &navSection{Title: title, Pages: []*navPage{}}
+			if title != "" {
+				section.URL = siteURL(req, sectionPath("", title))
+			}
+			sections = append(sections, section)
+			return
+		case atom.A:
+			if pages == nil {
+				pages = &current().Pages
+			}
+			add(pages, n)
+			return
+		case atom.Li:
+			if pages == nil {
+				pages = &current().Pages
+			}
+			// The first link of the item is its page, and the pages of
+			// nested lists are its children.
+			var page *navPage
+			walkElements(n, func(c *html.Node) bool {
+				if c.DataAtom == atom.Ul || c.DataAtom == atom.Ol {
+					return false
+				}
+				if page == nil && c.DataAtom == atom.A {
+					page = add(pages, c)
+				}
+				return page == nil
+			})
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.DataAtom == atom.Ul || c.DataAtom == atom.Ol {
+					if page != nil {
+						walk(c, &page.Pages)
+					} else {
+						walk(c, pages)
+					}
+				}
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, pages)
+		}
+	}
+	for _, n := range nodes {
+		walk(n, nil)
+	}
+	for _, section := range sections {
+		if section.Pages == nil {
+			section.Pages = []*navPage{}
+		}
+	}
+	return sections
+}
+
+// serveNavAPI returns the navigation trees of the site indexes as JSON,
+// so other frontends may reuse the official navigation.
+func serveNavAPI(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Access-Control-Allow-Origin", "*")
+
+	var result navAPIResult
+	budget := requestBudget(req)
+	for _, idx := range siteIndexes() {
+		outline := idx.outline(budget)
+		if outline == "" {
+			log.Printf("Cannot obtain outline of index %s for navigation API", idx.Path)
+			resp.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		result.Indexes = append(result.Indexes, &navIndex{
+			Title:    idx.Title,
+			URL:      siteURL(req, idx.URL()),
+			Sections: navTree(req, editorsNote.ReplaceAllString(outline, "")),
+		})
+	}
+
+	data, err := json.Marshal(&result)
+	if err != nil {
+		log.Printf("Cannot marshal navigation: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
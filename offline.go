@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const offlineMaxPages = 50
+
+// serveServiceWorker serves the service worker that keeps the app shell
+// and the most recently read pages available offline. It must be served
+// from the root so that its scope covers the whole site.
+func serveServiceWorker(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/javascript")
+	resp.Header().Set("Cache-Control", "no-cache")
+	script := strings.Replace(serviceWorkerScript, "OFFLINE_MAX_PAGES", fmt.Sprint(offlineMaxPages), -1)
+	// Changing the version discards the caches of previous versions.
+	script = strings.Replace(script, "OFFLINE_VERSION", offlineVersion, -1)
+	if *privacyStrictFlag {
+		script = strings.Replace(script, bootstrapURL, "/static/bootstrap.min.css", -1)
+	}
+	resp.Write([]byte(script))
+}
+
+// offlineVersion identifies the app shell cached by service workers.
+const offlineVersion = "1"
+
+const serviceWorkerScript = `var shellCache = "shell-vOFFLINE_VERSION";
+var pagesCache = "pages-vOFFLINE_VERSION";
+var shell = [
+	"/",
+	"/icon32.png",
+	"/manifest.webmanifest",
+	"https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css"
+];
+
+self.addEventListener("install", function(event) {
+	event.waitUntil(caches.open(shellCache).then(function(cache) {
+		return cache.addAll(shell);
+	}).then(function() {
+		return self.skipWaiting();
+	}));
+});
+
+self.addEventListener("activate", function(event) {
+	event.waitUntil(caches.keys().then(function(names) {
+		return Promise.all(names.filter(function(name) {
+			return name !== shellCache && name !== pagesCache;
+		}).map(function(name) {
+			return caches.delete(name);
+		}));
+	}).then(function() {
+		return self.clients.claim();
+	}));
+});
+
+// trimPages drops the least recently stored pages beyond the limit.
+function trimPages(cache) {
+	return cache.keys().then(function(keys) {
+		return Promise.all(keys.slice(0, Math.max(0, keys.length - OFFLINE_MAX_PAGES)).map(function(key) {
+			return cache.delete(key);
+		}));
+	});
+}
+
+self.addEventListener("fetch", function(event) {
+	var req = event.request;
+	if (req.method !== "GET") {
+		return;
+	}
+	if (req.mode === "navigate") {
+		// Pages are fetched from the network when possible, so readers
+		// see the latest content, and stored for reading offline.
+		event.respondWith(fetch(req).then(function(resp) {
+			if (resp.ok) {
+				var copy = resp.clone();
+				caches.open(pagesCache).then(function(cache) {
+					return cache.delete(req).then(function() {
+						return cache.put(req, copy);
+					}).then(function() {
+						return trimPages(cache);
+					});
+				});
+			}
+			return resp;
+		}).catch(function() {
+			return caches.match(req).then(function(resp) {
+				return resp || caches.match("/");
+			});
+		}));
+		return;
+	}
+	event.respondWith(caches.match(req).then(function(resp) {
+		return resp || fetch(req);
+	}));
+});
+`
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	ogImageWidth  = 1200
+	ogImageHeight = 630
+	ogImageMargin = 80
+
+	// ogImageVersion is part of the cache key, so changing how images
+	// look renders them again.
+	ogImageVersion = "1"
+)
+
+var (
+	ogBackground = color.NRGBA{0x46, 0x46, 0x46, 0xff}
+	ogTitleColor = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	ogAccent     = color.NRGBA{0x82, 0xbe, 0xa0, 0xff}
+	ogStripe     = color.NRGBA{0xfa, 0x64, 0x41, 0xff}
+)
+
+// OGImageURL returns the absolute URL of the social preview image of the
+// topic, or an empty string if they're disabled.
+func (t *Topic) OGImageURL() string {
+	if *ogImagesFlag == "" || *baseURLFlag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(*baseURLFlag, "/") + "/og-image/" + strconv.Itoa(t.ID) + ".png"
+}
+
+// outlineSectionTitle returns the title of the outline section the
+// topic is listed under, or the name of its category if it's not listed.
+func outlineSectionTitle(topic *Topic, budget *upstreamBudget) string {
+	if title := listingSectionTitle(topic, budget); title != "" {
+		return title
+	}
+	if c, ok := categories()[topic.Category]; ok {
+		return c.Name
+	}
+	return ""
+}
+
+var ogImagePattern = regexp.MustCompile(`^/og-image/([0-9]+)\.png$`)
+
+// serveOGImage serves the social preview image of the documentation page
+// whose ID is in the path, rendering it unless it's cached.
+func serveOGImage(resp http.ResponseWriter, req *http.Request) {
+	m := ogImagePattern.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		sendNotFound(resp, "Invalid preview image path: %s", req.URL.Path)
+		return
+	}
+	budget := requestBudget(req)
+	topic, err := forum.TopicWithin("/"+m[1], budget)
+	if err != nil && sendForumError(resp, err) {
+		return
+	}
+	if err != nil || !isDocCategory(topic.Category) {
+		sendNotFound(resp, "No preview image for page %s.", m[1])
+		return
+	}
+	section := outlineSectionTitle(topic, budget)
+	sum := sha256.Sum256([]byte(ogImageVersion + "\n" + topic.Title + "\n" + section))
+	path := filepath.Join(*ogImagesFlag, fmt.Sprintf("%x.png", sum[:16]))
+
+	entry, err := readCacheFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("%v", err)
+		}
+		entry = &cacheEntry{Header: map[string]string{"type": "image/png"}}
+		entry.Data, err = renderOGImage(topic.Title, section)
+		if err != nil {
+			log.Printf("Cannot render preview image for %s: %v", topic, err)
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if err := writeCacheFile(path, entry); err != nil {
+			log.Printf("Cannot cache preview image for %s: %v", topic, err)
+		}
+	}
+	sendImage(resp, entry.Data, "image/png")
+}
+
+// renderOGImage renders a social preview image with the title and
+// section of a page over the site colors, as a PNG.
+func renderOGImage(title, section string) ([]byte, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, ogImageWidth, ogImageHeight))
+	draw.Draw(img, img.Rect, image.NewUniform(ogBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, ogImageHeight-16, ogImageWidth, ogImageHeight), image.NewUniform(ogStripe), image.Point{}, draw.Src)
+
+	width := ogImageWidth - 2*ogImageMargin
+	y := ogImageMargin
+	if section != "" {
+		lines := wrapText(asciiText(section), width/ogGlyphAdvance(4))
+		drawText(img, ogImageMargin, y, 4, ogAccent, lines[0])
+		y += 2 * ogLineHeight(4)
+	}
+
+	// Use the largest scale that fits the title in three lines.
+	scale := 10
+	var lines []string
+	for ; scale >= 5; scale-- {
+		lines = wrapText(asciiText(title), width/ogGlyphAdvance(scale))
+		if len(lines) <= 3 {
+			break
+		}
+	}
+	if len(lines) > 3 {
+		scale = 5
+		lines = append(lines[:2], strings.TrimSuffix(lines[2], ".")+"...")
+	}
+	for _, line := range lines {
+		drawText(img, ogImageMargin, y, scale, ogTitleColor, line)
+		y += ogLineHeight(scale)
+	}
+
+	icon, err := png.Decode(bytes.NewReader(iconBytes))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode icon: %v", err)
+	}
+	const iconSize = 96
+	iconTop := ogImageHeight - ogImageMargin - iconSize
+	iconRect := image.Rect(ogImageMargin, iconTop, ogImageMargin+iconSize, iconTop+iconSize)
+	draw.Draw(img, iconRect, scaleImage(icon, iconSize, iconSize), image.Point{}, draw.Over)
+	drawText(img, iconRect.Max.X+32, iconTop+(iconSize-7*5)/2, 5, ogTitleColor, "Snap Docs")
+
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("cannot encode image: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func ogGlyphAdvance(scale int) int { return 6 * scale }
+func ogLineHeight(scale int) int   { return 11 * scale }
+
+var asciiReplacer = strings.NewReplacer("‘", "'", "’", "'", "“", `"`, "”", `"`, "–", "-", "—", "-", "…", "...", " ", " ")
+
+// asciiText approximates s with the characters the bitmap font has,
+// dropping accents and replacing other characters with "?".
+func asciiText(s string) string {
+	s = norm.NFD.String(asciiReplacer.Replace(s))
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// wrapText breaks s into lines of at most width characters, between
+// words where possible.
+func wrapText(s string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(s) {
+		for len(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			lines = append(lines, word[:width])
+			word = word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawText draws the ASCII text s with its top left corner at x, y, with
+// each font pixel being scale by scale pixels.
+func drawText(img *image.NRGBA, x, y, scale int, c color.NRGBA, s string) {
+	src := image.NewUniform(c)
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch < ' ' || ch > '~' {
+			ch = '?'
+		}
+		glyph := ogFont[ch-' ']
+		gx := x + i*ogGlyphAdvance(scale)
+		for row, bits := range glyph {
+			for col := 0; col < 5; col++ {
+				if bits&(0x10>>uint(col)) == 0 {
+					continue
+				}
+				px := gx + col*scale
+				py := y + row*scale
+				draw.Draw(img, image.Rect(px, py, px+scale, py+scale), src, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// ogFont is a 5x7 pixel font of the printable ASCII characters, with one
+// row per byte and the leftmost pixel in the fifth bit.
+var ogFont = [95][7]byte{
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // ' '
+	{0x04, 0x04, 0x04, 0x04, 0x04, 0x00, 0x04}, // !
+	{0x0a, 0x0a, 0x0a, 0x00, 0x00, 0x00, 0x00}, // "
+	{0x0a, 0x0a, 0x1f, 0x0a, 0x1f, 0x0a, 0x0a}, // #
+	{0x04, 0x0f, 0x14, 0x0e, 0x05, 0x1e, 0x04}, // $
+	{0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03}, // %
+	{0x0c, 0x12, 0x14, 0x08, 0x15, 0x12, 0x0d}, // &
+	{0x0c, 0x04, 0x08, 0x00, 0x00, 0x00, 0x00}, // '
+	{0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02}, // (
+	{0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08}, // )
+	{0x00, 0x04, 0x15, 0x0e, 0x15, 0x04, 0x00}, // *
+	{0x00, 0x04, 0x04, 0x1f, 0x04, 0x04, 0x00}, // +
+	{0x00, 0x00, 0x00, 0x00, 0x0c, 0x04, 0x08}, // ,
+	{0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00}, // -
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c}, // .
+	{0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00}, // /
+	{0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e}, // 0
+	{0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e}, // 1
+	{0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f}, // 2
+	{0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e}, // 3
+	{0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02}, // 4
+	{0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e}, // 5
+	{0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e}, // 6
+	{0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08}, // 7
+	{0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e}, // 8
+	{0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c}, // 9
+	{0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00}, // :
+	{0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x04, 0x08}, // ;
+	{0x02, 0x04, 0x08, 0x10, 0x08, 0x04, 0x02}, // <
+	{0x00, 0x00, 0x1f, 0x00, 0x1f, 0x00, 0x00}, // =
+	{0x08, 0x04, 0x02, 0x01, 0x02, 0x04, 0x08}, // >
+	{0x0e, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04}, // ?
+	{0x0e, 0x11, 0x01, 0x0d, 0x15, 0x15, 0x0e}, // @
+	{0x0e, 0x11, 0x11, 0x11, 0x1f, 0x11, 0x11}, // A
+	{0x1e, 0x11, 0x11, 0x1e, 0x11, 0x11, 0x1e}, // B
+	{0x0e, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0e}, // C
+	{0x1c, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1c}, // D
+	{0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x1f}, // E
+	{0x1f, 0x10, 0x10, 0x1e, 0x10, 0x10, 0x10}, // F
+	{0x0e, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0f}, // G
+	{0x11, 0x11, 0x11, 0x1f, 0x11, 0x11, 0x11}, // H
+	{0x0e, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e}, // I
+	{0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0c}, // J
+	{0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11}, // K
+	{0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1f}, // L
+	{0x11, 0x1b, 0x15, 0x15, 0x11, 0x11, 0x11}, // M
+	{0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11}, // N
+	{0x0e, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e}, // O
+	{0x1e, 0x11, 0x11, 0x1e, 0x10, 0x10, 0x10}, // P
+	{0x0e, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0d}, // Q
+	{0x1e, 0x11, 0x11, 0x1e, 0x14, 0x12, 0x11}, // R
+	{0x0f, 0x10, 0x10, 0x0e, 0x01, 0x01, 0x1e}, // S
+	{0x1f, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04}, // T
+	{0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0e}, // U
+	{0x11, 0x11, 0x11, 0x11, 0x11, 0x0a, 0x04}, // V
+	{0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0a}, // W
+	{0x11, 0x11, 0x0a, 0x04, 0x0a, 0x11, 0x11}, // X
+	{0x11, 0x11, 0x11, 0x0a, 0x04, 0x04, 0x04}, // Y
+	{0x1f, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1f}, // Z
+	{0x0e, 0x08, 0x08, 0x08, 0x08, 0x08, 0x0e}, // [
+	{0x00, 0x10, 0x08, 0x04, 0x02, 0x01, 0x00}, // \
+	{0x0e, 0x02, 0x02, 0x02, 0x02, 0x02, 0x0e}, // ]
+	{0x04, 0x0a, 0x11, 0x00, 0x00, 0x00, 0x00}, // ^
+	{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1f}, // _
+	{0x08, 0x04, 0x02, 0x00, 0x00, 0x00, 0x00}, // `
+	{0x00, 0x00, 0x0e, 0x01, 0x0f, 0x11, 0x0f}, // a
+	{0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x1e}, // b
+	{0x00, 0x00, 0x0e, 0x10, 0x10, 0x11, 0x0e}, // c
+	{0x01, 0x01, 0x0d, 0x13, 0x11, 0x11, 0x0f}, // d
+	{0x00, 0x00, 0x0e, 0x11, 0x1f, 0x10, 0x0e}, // e
+	{0x06, 0x09, 0x08, 0x1c, 0x08, 0x08, 0x08}, // f
+	{0x00, 0x0f, 0x11, 0x11, 0x0f, 0x01, 0x0e}, // g
+	{0x10, 0x10, 0x16, 0x19, 0x11, 0x11, 0x11}, // h
+	{0x04, 0x00, 0x0c, 0x04, 0x04, 0x04, 0x0e}, // i
+	{0x02, 0x00, 0x06, 0x02, 0x02, 0x12, 0x0c}, // j
+	{0x10, 0x10, 0x12, 0x14, 0x18, 0x14, 0x12}, // k
+	{0x0c, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0e}, // l
+	{0x00, 0x00, 0x1a, 0x15, 0x15, 0x11, 0x11}, // m
+	{0x00, 0x00, 0x16, 0x19, 0x11, 0x11, 0x11}, // n
+	{0x00, 0x00, 0x0e, 0x11, 0x11, 0x11, 0x0e}, // o
+	{0x00, 0x00, 0x1e, 0x11, 0x1e, 0x10, 0x10}, // p
+	{0x00, 0x00, 0x0d, 0x13, 0x0f, 0x01, 0x01}, // q
+	{0x00, 0x00, 0x16, 0x19, 0x10, 0x10, 0x10}, // r
+	{0x00, 0x00, 0x0e, 0x10, 0x0e, 0x01, 0x1e}, // s
+	{0x08, 0x08, 0x1c, 0x08, 0x08, 0x09, 0x06}, // t
+	{0x00, 0x00, 0x11, 0x11, 0x11, 0x13, 0x0d}, // u
+	{0x00, 0x00, 0x11, 0x11, 0x11, 0x0a, 0x04}, // v
+	{0x00, 0x00, 0x11, 0x11, 0x15, 0x15, 0x0a}, // w
+	{0x00, 0x00, 0x11, 0x0a, 0x04, 0x0a, 0x11}, // x
+	{0x00, 0x00, 0x11, 0x11, 0x0f, 0x01, 0x0e}, // y
+	{0x00, 0x00, 0x1f, 0x02, 0x04, 0x08, 0x1f}, // z
+	{0x02, 0x04, 0x04, 0x08, 0x04, 0x04, 0x02}, // {
+	{0x04, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04}, // |
+	{0x08, 0x04, 0x04, 0x02, 0x04, 0x04, 0x08}, // }
+	{0x00, 0x00, 0x08, 0x15, 0x02, 0x00, 0x00}, // ~
+}
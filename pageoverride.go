@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PageOverride changes how a single page is rendered, for special pages
+// such as large reference tables. Overrides are keyed by topic ID or
+// slug in the configuration.
+type PageOverride struct {
+	// Template is an HTML file replacing the "article" partial of the
+	// page. It may use the usual partial as "default-article".
+	Template string `json:"template"`
+
+	// FullWidth renders the article across the page, without the
+	// sidebar.
+	FullWidth bool `json:"full-width"`
+
+	// HideTOC hides the table of contents of the page, like the hide-toc
+	// page metadata, by setting the hide-toc body class.
+	HideTOC bool `json:"hide-toc"`
+
+	// Class is added to the classes of the page body.
+	Class string `json:"class"`
+
+	article  string
+	template *template.Template
+}
+
+var pageOverrideKey = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+func (o *PageOverride) init(key string) error {
+	if !pageOverrideKey.MatchString(key) {
+		return fmt.Errorf("invalid page override key %q, must be a topic ID or slug", key)
+	}
+	if o.Template != "" {
+		data, err := ioutil.ReadFile(o.Template)
+		if err != nil {
+			return fmt.Errorf("cannot read template for page %s: %v", key, err)
+		}
+		o.article = string(data)
+	}
+	return nil
+}
+
+// parsePageOverrides parses the override templates on top of the page
+// template, including any -templates partials.
+func parsePageOverrides() error {
+	for key, o := range config.PageOverrides {
+		if o.article == "" {
+			continue
+		}
+		t, err := pageTemplate.Clone()
+		if err == nil {
+			_, err = t.AddParseTree("default-article", t.Lookup("article").Tree)
+		}
+		if err == nil {
+			_, err = t.New("article").Parse(o.article)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot parse template for page %s: %v", key, err)
+		}
+		o.template = t
+	}
+	return nil
+}
+
+// pageOverride returns the override for topic, if any.
+func pageOverride(topic *Topic) *PageOverride {
+	if topic == nil || len(config.PageOverrides) == 0 {
+		return nil
+	}
+	if o, ok := config.PageOverrides[strconv.Itoa(topic.ID)]; ok {
+		return o
+	}
+	return config.PageOverrides[topic.Slug]
+}
+
+// BodyClass returns the CSS classes of the page body.
+func (d *pageData) BodyClass() string {
+	var classes []string
+	o := d.override
+	if d.Topic != nil && d.Topic.Meta != nil && d.Topic.Meta.HideTOC || o != nil && o.HideTOC {
+		classes = append(classes, "hide-toc")
+	}
+	if o != nil && o.FullWidth {
+		classes = append(classes, "full-width")
+	}
+	if o != nil && o.Class != "" {
+		classes = append(classes, o.Class)
+	}
+	return strings.Join(classes, " ")
+}
+
+// FullWidth reports whether the page is rendered without the sidebar.
+func (d *pageData) FullWidth() bool {
+	return d.override != nil && d.override.FullWidth
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UpstreamLimit bounds the requests sent to the forum for one kind of
+// fetch, so that crawls and cache warming don't degrade the forum for
+// the people using it.
+type UpstreamLimit struct {
+	// Concurrency is how many requests may be in flight at once. Zero
+	// means any number.
+	Concurrency int `json:"concurrency"`
+
+	// Interval is the minimum time between the start of requests, such
+	// as "250ms".
+	Interval string `json:"interval"`
+
+	slots    chan struct{}
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// Upstream fetch kinds, as configured in upstream-limits. Fetches for
+// reindexing and rebuilding the search index are crawls, and all others
+// are interactive, including topic lists that readers may be waiting on.
+const (
+	fetchInteractive = "interactive"
+	fetchCrawl       = "crawl"
+)
+
+func (l *UpstreamLimit) init(kind string) error {
+	if kind != fetchInteractive && kind != fetchCrawl {
+		return fmt.Errorf("invalid upstream fetch kind %q, must be %q or %q", kind, fetchInteractive, fetchCrawl)
+	}
+	if l.Concurrency < 0 {
+		return fmt.Errorf("upstream limit for %s fetches has a negative concurrency", kind)
+	}
+	if l.Interval != "" {
+		var err error
+		l.interval, err = time.ParseDuration(l.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid upstream interval for %s fetches: %v", kind, err)
+		}
+	}
+	if l.Concurrency > 0 {
+		l.slots = make(chan struct{}, l.Concurrency)
+	}
+	return nil
+}
+
+// acquire waits for a turn to send a request, until ctx is done.
+func (l *UpstreamLimit) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		start := l.next
+		if start.Before(now) {
+			start = now
+		}
+		l.next = start.Add(l.interval)
+		l.mu.Unlock()
+
+		timer := time.NewTimer(start.Sub(now))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			l.release()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (l *UpstreamLimit) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+type crawlKey struct{}
+
+// withCrawl marks forum requests made with ctx as part of a crawl.
+func withCrawl(ctx context.Context) context.Context {
+	return context.WithValue(ctx, crawlKey{}, true)
+}
+
+func fetchKind(ctx context.Context) string {
+	if crawl, _ := ctx.Value(crawlKey{}).(bool); crawl {
+		return fetchCrawl
+	}
+	return fetchInteractive
+}
+
+// politeTransport applies the configured upstream limits to requests to
+// the forum. A request holds its turn until its response body is closed.
+type politeTransport struct {
+	base http.RoundTripper
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limit := config.UpstreamLimits[fetchKind(req.Context())]
+	if req.URL.Host != "forum.snapcraft.io" || limit == nil {
+		return t.base.RoundTrip(req)
+	}
+	if err := limit.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		limit.release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: limit.release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const privacyPolicy = "default-src 'self'; img-src 'self' data:; style-src 'self' 'unsafe-inline'; script-src 'self' 'unsafe-inline'; " +
+	"connect-src 'self'; frame-src 'none'; object-src 'none'; form-action 'self'"
+
+// setPrivacyHeaders forbids browsers from sending referrers and from
+// loading anything from other sites.
+func setPrivacyHeaders(resp http.ResponseWriter) {
+	resp.Header().Set("Referrer-Policy", "no-referrer")
+	resp.Header().Set("Content-Security-Policy", privacyPolicy)
+}
+
+// privacyPass makes the images in content load via the image proxy, and
+// replaces other embedded content from elsewhere, and images the proxy
+// won't serve, with links to it.
+func privacyPass(root *html.Node) {
+	var replace []*html.Node
+	walkElements(root, func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.Img:
+			removeNodeAttr(n, "srcset")
+			u, ok := externalURL(nodeAttr(n, "src"))
+			if !ok {
+				return true
+			}
+			if imageProxyAllowed(u) {
+				setNodeAttr(n, "src", imageProxyURL(u.String()))
+			} else {
+				replace = append(replace, n)
+			}
+		case atom.Iframe, atom.Video, atom.Audio, atom.Embed, atom.Object, atom.Picture:
+			replace = append(replace, n)
+			return false
+		}
+		return true
+	})
+	for _, n := range replace {
+		src := nodeAttr(n, "src")
+		if src == "" {
+			src = nodeAttr(n, "data")
+		}
+		if src == "" {
+			walkElements(n, func(c *html.Node) bool {
+				if src == "" && (c.DataAtom == atom.Source || c.DataAtom == atom.Img) {
+					src = nodeAttr(c, "src")
+					if fields := strings.Fields(nodeAttr(c, "srcset")); src == "" && len(fields) > 0 {
+						src = fields[0]
+					}
+				}
+				return src == ""
+			})
+		}
+		if u, ok := externalURL(src); ok && n.DataAtom == atom.Picture && imageProxyAllowed(u) {
+			img := &html.Node{Type: html.ElementNode, Data: "img", DataAtom: atom.Img, Attr: []html.Attribute{{Key: "src", Val: imageProxyURL(u.String())}}}
+			n.Parent.InsertBefore(img, n)
+			n.Parent.RemoveChild(n)
+			continue
+		}
+		text := nodeAttr(n, "alt")
+		if text == "" {
+			text = nodeAttr(n, "title")
+		}
+		if text == "" && n.DataAtom == atom.Img {
+			text = "External image"
+		} else if text == "" {
+			text = "External content"
+		}
+		link := &html.Node{Type: html.ElementNode, Data: "a", DataAtom: atom.A, Attr: []html.Attribute{{Key: "class", Val: "external-content"}}}
+		if src != "" {
+			link.Attr = append(link.Attr, html.Attribute{Key: "href", Val: src})
+		}
+		link.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+		n.Parent.InsertBefore(link, n)
+		n.Parent.RemoveChild(n)
+	}
+}
+
+// externalURL parses s, returning whether it is an absolute URL on the web.
+func externalURL(s string) (*url.URL, bool) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, false
+	}
+	if u.Scheme == "" && strings.HasPrefix(s, "//") {
+		u.Scheme = "https"
+	}
+	return u, (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+const (
+	bootstrapURL       = "https://maxcdn.bootstrapcdn.com/bootstrap/3.3.7/css/bootstrap.min.css"
+	bootstrapIntegrity = "sha384-BVYiiSIFeK1dGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u"
+)
+
+var bootstrapCSS struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+// bootstrapStylesheet returns the stylesheet otherwise loaded from a CDN,
+// fetching it once and checking it against its known digest.
+func bootstrapStylesheet() ([]byte, error) {
+	bootstrapCSS.mu.Lock()
+	defer bootstrapCSS.mu.Unlock()
+	if bootstrapCSS.data == nil {
+		data, err := fetchBootstrapCSS()
+		if err != nil {
+			return nil, err
+		}
+		bootstrapCSS.data = data
+	}
+	return bootstrapCSS.data, nil
+}
+
+func serveBootstrapCSS(resp http.ResponseWriter, req *http.Request) {
+	data, err := bootstrapStylesheet()
+	if err != nil {
+		log.Printf("Cannot fetch stylesheet: %v", err)
+		resp.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	resp.Header().Set("Content-Type", "text/css")
+	resp.Header().Set("Cache-Control", "max-age=86400")
+	resp.Write(data)
+}
+
+func fetchBootstrapCSS() ([]byte, error) {
+	resp, err := httpClient.Get(bootstrapURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got %v status", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha512.Sum384(data)
+	if "sha384-"+base64.StdEncoding.EncodeToString(sum[:]) != bootstrapIntegrity {
+		return nil, fmt.Errorf("stylesheet does not match its integrity digest")
+	}
+	return data, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+)
+
+func serveProgressScript(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/javascript")
+	resp.Header().Set("Cache-Control", "max-age=3600")
+	resp.Write([]byte(progressScript))
+}
+
+// progressScript shows how far the reader is through the article in a
+// bar at the top of the page, and restores the scroll position when the
+// reader comes back to the page, which browsers may fail to do as the
+// article is streamed after the sidebar.
+const progressScript = `(function() {
+	var bar = document.createElement("div");
+	bar.className = "reading-progress";
+	bar.setAttribute("aria-hidden", "true");
+	document.body.appendChild(bar);
+
+	var key = "scroll:" + location.pathname;
+	var pending = false;
+	function update() {
+		pending = false;
+		var doc = document.documentElement;
+		var height = doc.scrollHeight - doc.clientHeight;
+		bar.style.width = (height > 0 ? Math.min(100, 100 * doc.scrollTop / height) : 0) + "%";
+		try {
+			sessionStorage.setItem(key, String(doc.scrollTop));
+		} catch (e) {}
+	}
+	window.addEventListener("scroll", function() {
+		if (!pending) {
+			pending = true;
+			window.requestAnimationFrame(update);
+		}
+	}, {passive: true});
+	window.addEventListener("resize", update);
+
+	var nav = performance.getEntriesByType ? performance.getEntriesByType("navigation")[0] : null;
+	if (nav && nav.type === "back_forward" && !location.hash) {
+		if ("scrollRestoration" in history) {
+			history.scrollRestoration = "manual";
+		}
+		var saved = null;
+		try {
+			saved = sessionStorage.getItem(key);
+		} catch (e) {}
+		if (saved !== null) {
+			window.scrollTo(0, parseInt(saved, 10));
+		}
+	}
+	update();
+})();
+`
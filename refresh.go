@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// recheckResult tells what checking a topic against the forum found.
+type recheckResult struct {
+	Changed bool
+	Held    bool // The change is held for review.
+	Added   int  // Lines added to the content.
+	Removed int  // Lines removed from the content.
+}
+
+// Recheck checks the topic at path against the forum, conditionally if
+// the cached copy has an ETag, and caches the fetched copy, announcing it
+// as a change only if its content changed. Unlike Refresh, an unchanged
+// topic stays cached and is not fetched again by the next request.
+func (f *Forum) Recheck(path string) (*recheckResult, error) {
+	id, err := topicPathID(path)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	cache, ok := f.cache[id]
+	f.mu.Unlock()
+	if !ok {
+		// Nothing to compare with, so fetch it as usual.
+		if _, err := f.Topic(path); err != nil {
+			return nil, err
+		}
+		return &recheckResult{Changed: true}, nil
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	old := cache.topic
+	etag := ""
+	if old != nil && old.Post.Version > 0 {
+		etag = old.etag
+	}
+	log.Printf("Checking content of %s for changes...", path)
+	topic, err := fetchTopicIf(context.Background(), path, etag)
+	if err == errNotModified {
+		cache.set(cache.topic, time.Now())
+		return &recheckResult{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if old != nil && bytes.Equal(old.content, topic.content) {
+		// The title, category or ETag may have changed nonetheless.
+		cache.set(topic, time.Now())
+		return &recheckResult{}, nil
+	}
+	if !cache.replace(topic) {
+		return &recheckResult{Held: true}, nil
+	}
+
+	result := &recheckResult{Changed: true}
+	if old != nil && old.Post.Version > 0 {
+		for _, line := range diffLines(strings.Split(old.Content(), "\n"), strings.Split(topic.Content(), "\n")) {
+			switch line.Op {
+			case "+":
+				result.Added++
+			case "-":
+				result.Removed++
+			}
+		}
+	}
+	return result, nil
+}
+
+// refreshPage checks the page at path and the pages it includes for
+// changes, and returns what was found with a note telling readers about
+// it. The result is nil if the page could not be checked.
+func refreshPage(path string) (*recheckResult, string) {
+	result, err := forum.Recheck(path)
+	if err != nil {
+		log.Printf("Cannot check %s for changes: %v", path, err)
+		return nil, refreshNotices["failed"]
+	}
+	if result.Changed {
+		broadcastInvalidate(path)
+	}
+
+	var includes int
+	if topic, err := forum.Topic(path); err == nil {
+		for _, include := range includePaths(topic.Content()) {
+			r, err := forum.Recheck(include)
+			if err != nil {
+				log.Printf("Cannot check included %s for changes: %v", include, err)
+				continue
+			}
+			if r.Changed {
+				broadcastInvalidate(include)
+				includes++
+			}
+		}
+	}
+
+	var note string
+	switch {
+	case result.Changed && result.Added+result.Removed > 0:
+		note = fmt.Sprintf("This page was updated: %s added and %s removed.", pluralize(result.Added, "line"), pluralize(result.Removed, "line"))
+	case result.Changed:
+		note = refreshNotices["updated"]
+	case result.Held:
+		note = refreshNotices["held"]
+	default:
+		note = refreshNotices["current"]
+	}
+	if includes > 0 {
+		note += fmt.Sprintf(" %s it includes changed as well.", pluralize(includes, "page"))
+	}
+	return result, note
+}
+
+// refreshNotices are the notes shown on pages reloaded after a refresh
+// without scripts, by the code in their refreshed query parameter.
+var refreshNotices = map[string]string{
+	"updated": "This page was updated.",
+	"held":    "This page was updated, but the update is held for review.",
+	"current": "This page was already up to date.",
+	"failed":  "Cannot check this page for changes right now.",
+	"limited": "This page was checked for updates too often. Please try again later.",
+}
+
+var refreshes = &clientLimiter{rate: refreshRateFlag, per: time.Hour}
+
+type refreshReply struct {
+	Changed bool   `json:"changed"`
+	Held    bool   `json:"held"`
+	Note    string `json:"note"`
+}
+
+// serveRefresh checks the page given by the path form value for changes
+// on behalf of a reader, who is limited to a few refreshes per hour
+// unless they hold the administration token. Scripts asking for JSON are
+// told what was found, and forms are sent back to the page, which shows
+// a note on what was found.
+func serveRefresh(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		resp.Header().Set("Allow", "POST")
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := req.Form.Get("path")
+	if !pagePathPattern.MatchString(path) {
+		resp.WriteHeader(http.StatusBadRequest)
+		resp.Write([]byte("invalid page path"))
+		return
+	}
+	wantsJSON := strings.Contains(req.Header.Get("Accept"), "application/json")
+
+	var reply refreshReply
+	status := http.StatusOK
+	code := "current"
+	if !isAdmin(req) && (*refreshRateFlag <= 0 || !refreshes.allow(req)) {
+		log.Printf("Rate limiting refresh of %s from %s", path, req.RemoteAddr)
+		resp.Header().Set("Retry-After", refreshes.retryAfter())
+		status = http.StatusTooManyRequests
+		code = "limited"
+		reply.Note = refreshNotices[code]
+	} else {
+		audit.Record(req, "refresh", path)
+		var result *recheckResult
+		result, reply.Note = refreshPage(path)
+		switch {
+		case result == nil:
+			status = http.StatusBadGateway
+			code = "failed"
+		case result.Changed:
+			code = "updated"
+		case result.Held:
+			code = "held"
+		}
+		if result != nil {
+			reply.Changed = result.Changed
+			reply.Held = result.Held
+		}
+	}
+
+	if !wantsJSON {
+		resp.Header().Set("Location", path+"?refreshed="+code)
+		resp.WriteHeader(http.StatusSeeOther)
+		return
+	}
+	data, err := json.Marshal(&reply)
+	if err != nil {
+		log.Printf("Cannot marshal refresh reply: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.WriteHeader(status)
+	resp.Write(data)
+}
+
+func serveRefreshScript(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/javascript")
+	resp.Header().Set("Cache-Control", "max-age=3600")
+	resp.Write([]byte(refreshScript))
+}
+
+// refreshScript submits the refresh form in the background, and tells
+// the reader what was found in a toast, offering to reload the page if
+// it changed.
+const refreshScript = `(function() {
+	var form = document.querySelector("form.refresh-form");
+	if (!form || !window.fetch) {
+		return;
+	}
+	var button = form.querySelector("button");
+	var toast = document.createElement("div");
+	toast.className = "update-toast";
+	toast.setAttribute("role", "status");
+	toast.style.display = "none";
+	document.body.appendChild(toast);
+	var timer = null;
+
+	function show(note, changed) {
+		toast.textContent = note + " ";
+		if (changed) {
+			var reload = document.createElement("a");
+			reload.href = "";
+			reload.textContent = "Reload?";
+			toast.appendChild(reload);
+		}
+		toast.style.display = "block";
+		clearTimeout(timer);
+		timer = setTimeout(function() {
+			toast.style.display = "none";
+		}, changed ? 15000 : 5000);
+	}
+
+	form.addEventListener("submit", function(event) {
+		event.preventDefault();
+		button.disabled = true;
+		fetch(form.action, {
+			method: "POST",
+			headers: {"Accept": "application/json"},
+			body: new URLSearchParams(new FormData(form))
+		}).then(function(resp) {
+			return resp.json();
+		}).then(function(reply) {
+			show(reply.note, reply.changed);
+		}, function() {
+			show("Cannot check this page for changes right now.", false);
+		}).then(function() {
+			button.disabled = false;
+		});
+	});
+})();
+`
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ReferenceTable makes the large tables of a reference page, such as the
+// list of interfaces, navigable: their rows get anchors and a page of
+// their own, and a box above each table filters its rows.
+type ReferenceTable struct {
+	// MinRows is the number of rows from which a table is treated as a
+	// reference table, 10 by default.
+	MinRows int `json:"min-rows"`
+}
+
+func (t *ReferenceTable) init(key string) error {
+	if !pageOverrideKey.MatchString(key) {
+		return fmt.Errorf("invalid reference table key %q, must be a topic ID or slug", key)
+	}
+	if t.MinRows == 0 {
+		t.MinRows = 10
+	}
+	if t.MinRows < 1 {
+		return fmt.Errorf("reference table min-rows for %s must be positive", key)
+	}
+	return nil
+}
+
+// referenceTable returns the reference table settings for topic, if any.
+func referenceTable(topic *Topic) *ReferenceTable {
+	if topic == nil || len(config.ReferenceTables) == 0 {
+		return nil
+	}
+	if t, ok := config.ReferenceTables[strconv.Itoa(topic.ID)]; ok {
+		return t
+	}
+	return config.ReferenceTables[topic.Slug]
+}
+
+// refTable is a table parsed out of a reference page.
+type refTable struct {
+	node    *html.Node
+	Headers []string
+	Rows    []*refRow
+}
+
+type refRow struct {
+	node  *html.Node
+	Slug  string
+	Title string
+	Cells []template.HTML
+}
+
+// parseRefTables returns the tables within root with at least minRows
+// rows. Rows are identified by the slug of their first cell, made unique
+// within the page.
+func parseRefTables(root *html.Node, minRows int) []*refTable {
+	var tables []*refTable
+	used := make(map[string]bool)
+	walkElements(root, func(n *html.Node) bool {
+		if n.DataAtom != atom.Table {
+			return true
+		}
+		t := &refTable{node: n}
+		walkElements(n, func(tr *html.Node) bool {
+			if tr.DataAtom != atom.Tr {
+				return true
+			}
+			var cells []*html.Node
+			header := false
+			for c := tr.FirstChild; c != nil; c = c.NextSibling {
+				if c.DataAtom == atom.Th || c.DataAtom == atom.Td {
+					cells = append(cells, c)
+					header = header || c.DataAtom == atom.Th
+				}
+			}
+			if header && t.Headers == nil {
+				for _, c := range cells {
+					t.Headers = append(t.Headers, strings.Join(strings.Fields(nodeText(c)), " "))
+				}
+				return false
+			}
+			if len(cells) == 0 {
+				return false
+			}
+			row := &refRow{node: tr, Title: strings.Join(strings.Fields(nodeText(cells[0])), " ")}
+			for _, c := range cells {
+				var buf bytes.Buffer
+				for cc := c.FirstChild; cc != nil; cc = cc.NextSibling {
+					html.Render(&buf, cc)
+				}
+				row.Cells = append(row.Cells, template.HTML(buf.String()))
+			}
+			t.Rows = append(t.Rows, row)
+			return false
+		})
+		if len(t.Rows) < minRows {
+			return false
+		}
+		for _, row := range t.Rows {
+			slug := slugify(row.Title)
+			if slug == "" {
+				slug = "row"
+			}
+			base := slug
+			for i := 2; used[slug]; i++ {
+				slug = fmt.Sprintf("%s-%d", base, i)
+			}
+			used[slug] = true
+			row.Slug = slug
+		}
+		tables = append(tables, t)
+		return false
+	})
+	return tables
+}
+
+// refTablesPass anchors the rows of the reference tables of topic, links
+// each to its own page, and marks the tables for the filter script.
+func refTablesPass(topic *Topic, settings *ReferenceTable) contentPass {
+	return func(root *html.Node) {
+		for _, t := range parseRefTables(root, settings.MinRows) {
+			setNodeAttr(t.node, "class", strings.TrimSpace(nodeAttr(t.node, "class")+" reference-table"))
+			filter := &html.Node{Type: html.ElementNode, Data: "input", DataAtom: atom.Input, Attr: []html.Attribute{
+				{Key: "type", Val: "search"},
+				{Key: "class", Val: "reference-filter form-control"},
+				{Key: "placeholder", Val: fmt.Sprintf("Filter %d rows", len(t.Rows))},
+				{Key: "aria-label", Val: "Filter the table below"},
+			}}
+			t.node.Parent.InsertBefore(filter, t.node)
+			for _, row := range t.Rows {
+				setNodeAttr(row.node, "id", "row-"+row.Slug)
+				link := &html.Node{Type: html.ElementNode, Data: "a", DataAtom: atom.A, Attr: []html.Attribute{
+					{Key: "href", Val: fmt.Sprintf("/reference/%d/%s", topic.ID, row.Slug)},
+					{Key: "class", Val: "reference-link"},
+					{Key: "title", Val: "Open in its own page"},
+				}}
+				link.AppendChild(&html.Node{Type: html.TextNode, Data: "¶"})
+				for c := row.node.FirstChild; c != nil; c = c.NextSibling {
+					if c.DataAtom == atom.Td || c.DataAtom == atom.Th {
+						c.AppendChild(link)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+// applyReferenceTables processes the reference tables in the content of
+// topic, if it is configured to have any.
+func applyReferenceTables(topic *Topic, content string) string {
+	settings := referenceTable(topic)
+	if settings == nil {
+		return content
+	}
+	return transformContent(content, refTablesPass(topic, settings))
+}
+
+// ReferenceTables reports whether the page may hold reference tables.
+func (d *pageData) ReferenceTables() bool {
+	return referenceTable(d.Topic) != nil
+}
+
+var refRowPattern = regexp.MustCompile(`^/reference/([0-9]+)/([a-z0-9-]+)$`)
+
+type refRowData struct {
+	Topic   *Topic
+	Table   *refTable
+	Row     *refRow
+	Headers []string
+}
+
+// serveReferenceRow renders a single row of a reference table as a page.
+func serveReferenceRow(resp http.ResponseWriter, req *http.Request) {
+	m := refRowPattern.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		sendNotFound(resp, "Invalid reference path: %s", req.URL.Path)
+		return
+	}
+	topic, err := forum.TopicWithin("/"+m[1], requestBudget(req))
+	if err != nil {
+		log.Printf("Cannot obtain topic %s for reference row: %v", m[1], err)
+		if sendForumError(resp, err) {
+			return
+		}
+		sendNotFound(resp, "Cannot find reference page %s.", m[1])
+		return
+	}
+	settings := referenceTable(topic)
+	if settings == nil {
+		sendNotFound(resp, "Page %s has no reference tables.", m[1])
+		return
+	}
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(topic.Content()), context)
+	if err != nil {
+		log.Printf("Cannot parse reference page %s: %v", topic, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	var data *refRowData
+	for _, t := range parseRefTables(root, settings.MinRows) {
+		for _, row := range t.Rows {
+			if row.Slug == m[2] {
+				data = &refRowData{Topic: topic, Table: t, Row: row}
+			}
+		}
+	}
+	if data == nil {
+		sendNotFound(resp, "Cannot find %s in %s.", m[2], topic.Title)
+		return
+	}
+	for i := range data.Row.Cells {
+		header := ""
+		if i < len(data.Table.Headers) {
+			header = data.Table.Headers[i]
+		}
+		data.Headers = append(data.Headers, header)
+	}
+
+	var buf bytes.Buffer
+	err = refRowTemplate.Execute(&buf, data)
+	if err != nil {
+		log.Printf("Cannot execute reference row template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: data.Row.Title, Content: buf.String()})
+}
+
+var refRowTemplate = template.Must(template.New("refrow").Parse(`
+<p>From <a href="{{.Topic}}#row-{{.Row.Slug}}">{{.Topic.Title}}</a>.</p>
+<dl class="reference-row">
+{{range $i, $cell := .Row.Cells}}{{if $i}}<dt>{{index $.Headers $i}}</dt>
+<dd>{{$cell}}</dd>
+{{end}}{{end}}
+</dl>
+`))
+
+func serveReferenceScript(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/javascript")
+	resp.Header().Set("Cache-Control", "max-age=3600")
+	resp.Write([]byte(referenceScript))
+}
+
+// referenceScript filters the rows of each reference table by the text
+// typed in the box above it.
+const referenceScript = `(function() {
+	var filters = document.querySelectorAll("input.reference-filter");
+	Array.prototype.forEach.call(filters, function(input) {
+		var table = input.nextElementSibling;
+		if (!table) {
+			return;
+		}
+		input.addEventListener("input", function() {
+			var terms = input.value.toLowerCase().split(/\s+/).filter(Boolean);
+			Array.prototype.forEach.call(table.querySelectorAll("tr[id]"), function(row) {
+				var text = row.textContent.toLowerCase();
+				row.hidden = !terms.every(function(term) { return text.indexOf(term) >= 0; });
+			});
+		});
+	});
+})();
+`
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type reindexStatus struct {
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	Pages    int
+	Failed   int
+	Error    string
+}
+
+var reindexState struct {
+	mu     sync.Mutex
+	status reindexStatus
+}
+
+// expireAll expires the cached categories, topic list, search results,
+// and topics, so they're all fetched again next time. Expired topics are
+// kept to detect changes and as a fallback in case fetching fails.
+func (f *Forum) expireAll() {
+	categoryTree.mu.Lock()
+	categoryTree.time = time.Time{}
+	categoryTree.mu.Unlock()
+
+	f.category.mu.Lock()
+	f.category.time = time.Time{}
+	f.category.mu.Unlock()
+
+	f.searches.mu.Lock()
+	f.searches.entries = nil
+	f.searches.mu.Unlock()
+
+	f.mu.Lock()
+	caches := make([]*topicCache, 0, len(f.cache))
+	for _, cache := range f.cache {
+		caches = append(caches, cache)
+	}
+	f.mu.Unlock()
+	for _, cache := range caches {
+		cache.mu.Lock()
+		cache.set(cache.topic, time.Time{})
+		cache.mu.Unlock()
+	}
+}
+
+// reindex crawls the documentation categories again, refreshing every
+// cached page, and rebuilds the local search index. It returns false
+// without doing anything if a reindex is already running.
+func reindex() (bool, error) {
+	reindexState.mu.Lock()
+	if reindexState.status.Running {
+		reindexState.mu.Unlock()
+		return false, nil
+	}
+	reindexState.status = reindexStatus{Running: true, Started: time.Now()}
+	reindexState.mu.Unlock()
+
+	pages, failed, err := crawl()
+
+	reindexState.mu.Lock()
+	status := &reindexState.status
+	status.Running = false
+	status.Finished = time.Now()
+	status.Pages = pages
+	status.Failed = failed
+	if err != nil {
+		status.Error = err.Error()
+	}
+	reindexState.mu.Unlock()
+	return true, err
+}
+
+func crawl() (pages, failed int, err error) {
+	log.Printf("Reindexing all documentation pages...")
+	forum.expireAll()
+	forceFullCrawl()
+
+	topics, err := forum.Topics()
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot reindex: %v", err)
+	}
+	for _, idx := range siteIndexes() {
+		idx.outline(nil)
+	}
+	for _, listed := range topics {
+		if _, err := forum.TopicWithin(listed.String(), crawlBudget()); err != nil {
+			log.Printf("Cannot reindex %s: %v", listed, err)
+			failed++
+			continue
+		}
+		pages++
+	}
+	if *localSearchFlag {
+		if _, err := searchIndex.Rebuild(); err != nil {
+			return pages, failed, err
+		}
+	}
+	log.Printf("Reindexed %d documentation pages (%d failed).", pages, failed)
+	return pages, failed, nil
+}
+
+// serveReindex starts a reindex when posted to, as done by webhooks, and
+// otherwise shows how the last one went.
+func serveReindex(resp http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		audit.Record(req, "reindex", "")
+		go func() {
+			if _, err := reindex(); err != nil {
+				log.Printf("%v", err)
+			}
+		}()
+		resp.WriteHeader(http.StatusAccepted)
+		resp.Write([]byte("Reindex started.\n"))
+		return
+	}
+
+	reindexState.mu.Lock()
+	status := reindexState.status
+	reindexState.mu.Unlock()
+
+	var buf bytes.Buffer
+	err := reindexTemplate.Execute(&buf, &status)
+	if err != nil {
+		log.Printf("Cannot execute reindex template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Reindex", Content: buf.String()})
+}
+
+var reindexTemplate = template.Must(template.New("reindex").Parse(`
+<p>Reindexing crawls the documentation categories again, refreshes every cached page, and rebuilds the search index.
+Webhooks may start it by posting to this page with the administration token.</p>
+{{if .Running}}<p>A reindex started at {{.Started.Format "2006-01-02 15:04 MST"}} is in progress.</p>
+{{else if not .Finished.IsZero}}<p>The last reindex finished at {{.Finished.Format "2006-01-02 15:04 MST"}} with {{.Pages}} pages refreshed{{if .Failed}} and {{.Failed}} failed{{end}}.{{if .Error}} It failed: {{.Error}}{{end}}</p>
+{{end}}
+<form method="post" action="/admin/reindex"><button type="submit"{{if .Running}} disabled{{end}}>Reindex now</button></form>
+`))
+
+// reindexCommand asks a running server to reindex, or reindexes in
+// process, which is useful to write a fresh -search-index file.
+func reindexCommand(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	server := flags.String("server", "", "Ask the server at the given URL to reindex, using -admin-token")
+	flags.Parse(args)
+
+	if *server != "" {
+		if *adminTokenFlag == "" {
+			return fmt.Errorf("reindex -server requires -admin-token")
+		}
+		req, err := http.NewRequest("POST", strings.TrimSuffix(*server, "/")+"/admin/reindex", nil)
+		if err != nil {
+			return fmt.Errorf("cannot request reindex: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+*adminTokenFlag)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("cannot request reindex: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("cannot request reindex: got %v status", resp.StatusCode)
+		}
+		return nil
+	}
+
+	if *searchIndexFlag != "" {
+		*localSearchFlag = true
+	}
+	if _, err := reindex(); err != nil {
+		return err
+	}
+	if *searchIndexFlag != "" {
+		return searchIndex.Save(*searchIndexFlag)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// RewriteRule rewrites URLs in the attributes of topic content, when the
+// content is cached. Each URL matching Pattern is replaced by Replacement,
+// which may refer to submatches as $1, ${name}, etc.
+type RewriteRule struct {
+	Pattern     string   `json:"pattern"`
+	Replacement string   `json:"replacement"`
+	Attributes  []string `json:"attributes"` // Defaults to href, src, and srcset.
+
+	regexp *regexp.Regexp
+}
+
+func (r *RewriteRule) compile() error {
+	var err error
+	r.regexp, err = regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid rewrite pattern %q: %v", r.Pattern, err)
+	}
+	if len(r.Attributes) == 0 {
+		r.Attributes = []string{"href", "src", "srcset"}
+	}
+	return nil
+}
+
+func (r *RewriteRule) appliesTo(attr string) bool {
+	for _, a := range r.Attributes {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRewrites make links relative to the forum absolute, and then
+// links to forum topics relative to this site.
+var defaultRewrites = []*RewriteRule{{
+	Pattern:     `^/`,
+	Replacement: `https://forum.snapcraft.io/`,
+	Attributes:  []string{"href"},
+}, {
+	Pattern:     `^https://forum\.snapcraft\.io/t/`,
+	Replacement: `/`,
+	Attributes:  []string{"href"},
+}}
+
+func init() {
+	for _, rule := range defaultRewrites {
+		if err := rule.compile(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// rewritePass applies the default rewrite rules followed by the
+// configured ones to the URLs in content.
+func rewritePass(root *html.Node) {
+	rules := append(defaultRewrites[:len(defaultRewrites):len(defaultRewrites)], config.Rewrites...)
+	walkElements(root, func(n *html.Node) bool {
+		for i, a := range n.Attr {
+			if a.Namespace != "" || a.Key != "href" && a.Key != "src" && a.Key != "srcset" {
+				continue
+			}
+			for _, rule := range rules {
+				if !rule.appliesTo(a.Key) {
+					continue
+				}
+				if a.Key == "srcset" {
+					n.Attr[i].Val = rewriteSrcset(rule, n.Attr[i].Val)
+				} else {
+					n.Attr[i].Val = rule.regexp.ReplaceAllString(n.Attr[i].Val, rule.Replacement)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// rewriteSrcset applies rule to each of the URLs in a srcset value,
+// leaving their width or density descriptors alone.
+func rewriteSrcset(rule *RewriteRule, srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = rule.regexp.ReplaceAllString(fields[0], rule.Replacement)
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+)
+
+// withRewrites runs f with rules configured as the rewrite rules.
+func withRewrites(t *testing.T, rules []*RewriteRule, f func()) {
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := config.Rewrites
+	config.Rewrites = rules
+	defer func() { config.Rewrites = saved }()
+	f()
+}
+
+var rewriteTests = []struct {
+	rules   []*RewriteRule
+	content string
+	result  string
+}{{
+	// Default rules: forum-relative links become absolute, and then
+	// links to forum topics become relative to this site.
+	content: `<a href="/u/someone">user</a> <a href="/t/snap-format/698">topic</a>`,
+	result:  `<a href="https://forum.snapcraft.io/u/someone">user</a> <a href="/snap-format/698">topic</a>`,
+}, {
+	// Default rules apply to href only.
+	content: `<img src="/uploads/a.png"/>`,
+	result:  `<img src="/uploads/a.png"/>`,
+}, {
+	rules:   []*RewriteRule{{Pattern: `^/uploads/`, Replacement: `https://cdn.example.com/`}},
+	content: `<img src="/uploads/a.png"/>`,
+	result:  `<img src="https://cdn.example.com/a.png"/>`,
+}, {
+	rules:   []*RewriteRule{{Pattern: `^https://old\.example\.com/(\w+)`, Replacement: `https://new.example.com/${1}/`}},
+	content: `<a href="https://old.example.com/docs">docs</a>`,
+	result:  `<a href="https://new.example.com/docs/">docs</a>`,
+}, {
+	// Each URL in srcset is rewritten, keeping its descriptor.
+	rules:   []*RewriteRule{{Pattern: `^http:`, Replacement: `https:`}},
+	content: `<img srcset="http://a.example.com/1.png 1x,http://a.example.com/2.png  2x"/>`,
+	result:  `<img srcset="https://a.example.com/1.png 1x, https://a.example.com/2.png 2x"/>`,
+}, {
+	// Configured rules apply after the defaults, in order, each to the
+	// result of the previous ones.
+	rules: []*RewriteRule{
+		{Pattern: `^https://forum\.snapcraft\.io/u/`, Replacement: `/users/`},
+		{Pattern: `^/users/`, Replacement: `/people/`},
+	},
+	content: `<a href="/u/someone">user</a>`,
+	result:  `<a href="/people/someone">user</a>`,
+}, {
+	// Rules apply only to their attributes.
+	rules:   []*RewriteRule{{Pattern: `example`, Replacement: `sample`, Attributes: []string{"src"}}},
+	content: `<a href="https://example.com/">link</a><img src="https://example.com/a.png"/>`,
+	result:  `<a href="https://example.com/">link</a><img src="https://sample.com/a.png"/>`,
+}, {
+	// Attributes other than href, src, and srcset are left alone.
+	rules:   []*RewriteRule{{Pattern: `example`, Replacement: `sample`, Attributes: []string{"title"}}},
+	content: `<a href="https://example.com/" title="example">link</a>`,
+	result:  `<a href="https://example.com/" title="example">link</a>`,
+}}
+
+func TestRewritePass(t *testing.T) {
+	for _, test := range rewriteTests {
+		withRewrites(t, test.rules, func() {
+			result := transformContent(test.content, rewritePass)
+			if result != test.result {
+				t.Errorf("rewriting %s\ngot  %s\nwant %s", test.content, result, test.result)
+			}
+		})
+	}
+}
+
+func TestRewriteRuleAppliesTo(t *testing.T) {
+	rule := &RewriteRule{Pattern: `x`}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range []string{"href", "src", "srcset"} {
+		if !rule.appliesTo(attr) {
+			t.Errorf("rule without attributes doesn't apply to %s", attr)
+		}
+	}
+	if rule.appliesTo("title") {
+		t.Errorf("rule without attributes applies to title")
+	}
+
+	rule = &RewriteRule{Pattern: `x`, Attributes: []string{"src"}}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if !rule.appliesTo("src") || rule.appliesTo("href") {
+		t.Errorf("rule for src applies to %v", rule.Attributes)
+	}
+}
+
+func TestRewriteRuleInvalid(t *testing.T) {
+	rule := &RewriteRule{Pattern: `(`}
+	if err := rule.compile(); err == nil {
+		t.Errorf("compiling invalid pattern succeeded")
+	}
+}
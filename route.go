@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Routing hints let a front proxy send the requests for a topic to the
+// same replica, so each page is cached by one replica rather than all of
+// them, without any shared storage. The contract is as follows:
+//
+// In cluster mode, responses for documentation pages carry the
+// Snapdocs-Route header with the route hash of the topic ID, as 16
+// hexadecimal digits. The hash is the 64-bit FNV-1a hash of the topic ID
+// in decimal, so it's the same on every replica and for every path of
+// the topic, with or without slug or namespace. Proxies may route on it
+// directly, or compute it themselves from the ID.
+//
+// A proxy routing by the hint forwards the header on requests. Replicas
+// then take the page to be theirs and fetch it from the forum when not
+// cached. Requests without the header are served as well, but with
+// -cluster-self the replica owning the page by rendezvous hashing over
+// all replicas is asked for it first, so it's still fetched once.
+const routeHeader = "Snapdocs-Route"
+
+// routeHash returns the route hash of the topic with the given ID.
+func routeHash(id int) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.Itoa(id)))
+	return h.Sum64()
+}
+
+// mix64 scrambles the bits of x, as FNV hashes of similar inputs differ
+// in few bits.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// routeHint returns the value of the routing hint header for the topic.
+func routeHint(id int) string {
+	return fmt.Sprintf("%016x", routeHash(id))
+}
+
+// routeTopicID returns the ID of the topic served at path, if any.
+func routeTopicID(path string) (int, bool) {
+	if m := mirrorPattern.FindStringSubmatch(path); m != nil {
+		id, err := strconv.Atoi(m[1])
+		return id, err == nil
+	}
+	id, err := topicPathID(path)
+	return id, err == nil
+}
+
+// setRouteHint adds the routing hint to the response for a page when
+// running with other replicas.
+func setRouteHint(resp http.ResponseWriter, req *http.Request) {
+	if *peersFlag == "" {
+		return
+	}
+	if id, ok := routeTopicID(req.URL.Path); ok {
+		resp.Header().Set(routeHeader, routeHint(id))
+	}
+}
+
+// routeOwner returns the base URL of the replica owning the topic with
+// the given ID, or an empty string if it's this one or unknown.
+func routeOwner(id int) string {
+	if *clusterSelfFlag == "" {
+		return ""
+	}
+	owner := ""
+	var best uint64
+	for _, replica := range append(clusterPeers(), *clusterSelfFlag) {
+		h := fnv.New64a()
+		h.Write([]byte(replica))
+		if score := mix64(h.Sum64() ^ routeHash(id)); owner == "" || score > best {
+			owner, best = replica, score
+		}
+	}
+	if owner == *clusterSelfFlag {
+		return ""
+	}
+	return owner
+}
+
+// routedHere returns b for a request a front proxy routed to this replica
+// by its hint, whose page is not asked to other replicas.
+func (b *upstreamBudget) routedHere() *upstreamBudget {
+	if b == nil {
+		b = &upstreamBudget{calls: -1}
+	}
+	b.routed = true
+	return b
+}
+
+// fetchFromOwner fetches the topic with the given ID from the replica
+// owning it within the budget deadline, returning nil if that is this
+// replica or fails.
+func (b *upstreamBudget) fetchFromOwner(id int) *Topic {
+	if b != nil && (b.routed || b.mirrored) {
+		return nil
+	}
+	owner := routeOwner(id)
+	if owner == "" {
+		return nil
+	}
+	ctx, cancel := b.context()
+	defer cancel()
+	topic, err := (&Mirror{URL: owner}).fetchTopic(ctx, id)
+	if err != nil {
+		log.Printf("Cannot fetch topic %d from replica %s: %v", id, owner, err)
+		return nil
+	}
+	return topic
+}
+
+// routeCommand prints the routing hint and the owning replica of topics.
+func routeCommand(args []string) error {
+	flags := flag.NewFlagSet("route", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("route requires topic paths or IDs")
+	}
+	for _, arg := range flags.Args() {
+		path := arg
+		if _, err := strconv.Atoi(arg); err == nil {
+			path = "/" + arg
+		}
+		id, ok := routeTopicID(path)
+		if !ok {
+			return fmt.Errorf("invalid topic path: %s", arg)
+		}
+		owner := routeOwner(id)
+		if owner == "" && *clusterSelfFlag != "" {
+			owner = *clusterSelfFlag
+		}
+		if owner == "" {
+			fmt.Printf("%s\t%s\n", arg, routeHint(id))
+		} else {
+			fmt.Printf("%s\t%s\t%s\n", arg, routeHint(id), owner)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	searchCacheTimeout    = 5 * time.Minute
+	searchCacheFallback   = 24 * time.Hour
+	searchCacheMaxEntries = 1000
+
+	// searchSlowTimeout is how long to wait for the forum before serving
+	// cached results that are no longer fresh.
+	searchSlowTimeout = 3 * time.Second
+)
+
+type searchCache struct {
+	mu      sync.Mutex
+	entries map[string]*searchCacheEntry
+}
+
+type searchCacheEntry struct {
+	time   time.Time
+	topics []*Topic
+}
+
+// normalizeQuery returns the form of query used to identify equivalent
+// searches, or an empty string if there is nothing to search for.
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// Search returns the documentation topics matching query. Results are
+// cached per normalized query, and cached results are still served for a
+// while when the forum search is slow or failing. The local index is
+// searched instead when enabled and built. Forum failures are reported as
+// a *ForumError.
+func (f *Forum) Search(query string) ([]*Topic, error) {
+	return f.SearchWithin(query, nil)
+}
+
+// SearchWithin returns the topics matching query like Search does,
+// asking the forum only if that fits in budget. Cached results still fit
+// to be served are used otherwise. Topics pinned to a snapshot are
+// returned as the snapshot.
+func (f *Forum) SearchWithin(query string, budget *upstreamBudget) ([]*Topic, error) {
+	topics, err := f.searchWithin(query, budget)
+	if err != nil {
+		return nil, err
+	}
+	pinned := make([]*Topic, len(topics))
+	for i, topic := range topics {
+		pinned[i] = snapshots.pinned(topic)
+	}
+	return pinned, nil
+}
+
+func (f *Forum) searchWithin(query string, budget *upstreamBudget) ([]*Topic, error) {
+	query = normalizeQuery(query)
+	if query == "" {
+		return nil, nil
+	}
+	if *localSearchFlag && searchIndex.size() > 0 {
+		return searchIndex.Search(query), nil
+	}
+
+	cache := &f.searches
+	cache.mu.Lock()
+	entry := cache.entries[query]
+	cache.mu.Unlock()
+
+	now := time.Now()
+	if entry != nil && entry.time.Add(searchCacheTimeout).After(now) {
+		return entry.topics, nil
+	}
+	if entry != nil && entry.time.Add(searchCacheFallback).Before(now) {
+		entry = nil
+	}
+	if !budget.spend() {
+		if entry != nil {
+			return entry.topics, nil
+		}
+		return nil, errUpstreamBudget
+	}
+
+	type outcome struct {
+		topics []*Topic
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		topics, err := f.fetchSearch(query)
+		if err == nil {
+			cache.add(query, topics)
+		}
+		done <- outcome{topics, err}
+	}()
+
+	if entry == nil {
+		r := <-done
+		return r.topics, r.err
+	}
+	select {
+	case r := <-done:
+		if r.err != nil {
+			log.Printf("Cannot refresh search results, using cached copy: %v", r.err)
+			return entry.topics, nil
+		}
+		return r.topics, nil
+	case <-time.After(searchSlowTimeout):
+		log.Printf("Search for %q is slow, using cached copy", query)
+		return entry.topics, nil
+	}
+}
+
+func (c *searchCache) add(query string, topics []*Topic) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*searchCacheEntry)
+	}
+	if len(c.entries) >= searchCacheMaxEntries {
+		var oldest string
+		for q, e := range c.entries {
+			if e.time.Add(searchCacheFallback).Before(now) {
+				delete(c.entries, q)
+			} else if oldest == "" || e.time.Before(c.entries[oldest].time) {
+				oldest = q
+			}
+		}
+		if len(c.entries) >= searchCacheMaxEntries {
+			delete(c.entries, oldest)
+		}
+	}
+	c.entries[query] = &searchCacheEntry{time: now, topics: topics}
+}
+
+// topicSection is a part of a topic's content under one heading. The
+// first section holds the content before any heading, and has no anchor.
+type topicSection struct {
+	Title  string
+	Anchor string
+	Text   string
+}
+
+// topicSections splits content into sections at its headings.
+func topicSections(content string) []*topicSection {
+	section := &topicSection{}
+	sections := []*topicSection{section}
+	last := 0
+	for _, h := range docSearchHeading.FindAllStringSubmatchIndex(content, -1) {
+		section.Text = strings.ToLower(plainText(content[last:h[0]]))
+		section = &topicSection{Title: plainText(content[h[4]:h[5]])}
+		if a := headingAnchor.FindStringSubmatch(content[h[4]:h[5]]); a != nil {
+			section.Anchor = a[1]
+		}
+		sections = append(sections, section)
+		last = h[1]
+	}
+	section.Text = strings.ToLower(plainText(content[last:]))
+	return sections
+}
+
+// searchHit is a search result pointing at the section of the topic that
+// best matches the query, if any.
+type searchHit struct {
+	*Topic
+	Section string
+	Anchor  string
+
+	thumbnail string
+}
+
+// Thumbnail returns the thumbnail of the hit's page, which search
+// results only know of when the full page is cached.
+func (h *searchHit) Thumbnail() string {
+	return h.thumbnail
+}
+
+// Link returns the path of the hit, with the section anchor.
+func (h *searchHit) Link() string {
+	if h.Anchor == "" {
+		return h.Topic.String()
+	}
+	return h.Topic.String() + "#" + h.Anchor
+}
+
+// searchHits finds the best matching section for each of the topics
+// found for query. Only content at hand is considered, so topics not
+// fully cached point to their top.
+func searchHits(topics []*Topic, query string) []*searchHit {
+	terms := strings.Fields(normalizeQuery(query))
+	hits := make([]*searchHit, 0, len(topics))
+	for _, topic := range topics {
+		hit := &searchHit{Topic: topic}
+		hits = append(hits, hit)
+
+		full := topic
+		if cached := forum.Cached(topic.ID); cached != nil && cached.Post != nil && cached.Post.Version > 0 {
+			full = cached
+		}
+		hit.thumbnail = full.Thumbnail()
+		if full.Post == nil || full.Post.Version == 0 || len(terms) == 0 {
+			continue
+		}
+		best := 0
+		for _, section := range topicSections(full.Content()) {
+			if section.Anchor == "" {
+				continue
+			}
+			title := strings.ToLower(section.Title)
+			score := 0
+			for _, term := range terms {
+				score += 3*strings.Count(title, term) + strings.Count(section.Text, term)
+			}
+			if score > best {
+				best = score
+				hit.Section = section.Title
+				hit.Anchor = section.Anchor
+			}
+		}
+	}
+	return hits
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sectionPath returns the path of the landing page of the outline section
+// with title, listed in the index of the namespace with prefix.
+func sectionPath(prefix, title string) string {
+	return prefix + "/section/" + slugify(title)
+}
+
+// sectionOutline returns the outline listing the sections of the
+// namespace with prefix: the index of its language, if it has one, or
+// else the site indexes.
+func sectionOutline(prefix string, budget *upstreamBudget) string {
+	if l := languageRoot(prefix); l != nil && l.Index != "" {
+		idx := &Index{Path: l.Index}
+		if err := idx.init(); err == nil {
+			return idx.outline(budget)
+		}
+	}
+	return indexOutline(budget)
+}
+
+// findSection returns the outline section of the namespace with prefix
+// whose title slug is slug.
+func findSection(prefix, slug string, budget *upstreamBudget) *outlineSection {
+	for _, section := range outlineSections(sectionOutline(prefix, budget)) {
+		if section.Title != "" && slugify(section.Title) == slug {
+			return section
+		}
+	}
+	return nil
+}
+
+// listingSectionTitle returns the title of the first section listing
+// topic in the outline of its namespace, if any.
+func listingSectionTitle(topic *Topic, budget *upstreamBudget) string {
+	for _, section := range outlineSections(sectionOutline(namespacePrefix(topic), budget)) {
+		for _, id := range section.TopicIDs {
+			if id == topic.ID {
+				return section.Title
+			}
+		}
+	}
+	return ""
+}
+
+var outlineHeading = regexp.MustCompile(`(?s)(<h[1-6][^>]*>)(.*?)(</h[1-6]>)`)
+
+// linkOutlineHeadings turns the section headings of an outline into links
+// to the section landing pages.
+func linkOutlineHeadings(outline string) string {
+	return outlineHeading.ReplaceAllStringFunc(outline, func(heading string) string {
+		m := outlineHeading.FindStringSubmatch(heading)
+		title := plainText(m[2])
+		if title == "" {
+			return heading
+		}
+		return m[1] + `<a href="` + sectionPath("", title) + `">` + html.EscapeString(title) + "</a>" + m[3]
+	})
+}
+
+// Breadcrumb is a link to the parent of a page, below the root of its
+// documentation set.
+type Breadcrumb struct {
+	Root  string
+	Title string
+	Path  string
+}
+
+// Breadcrumb returns the outline section the page belongs to, if any.
+func (d *pageData) Breadcrumb() *Breadcrumb {
+	if d.Topic == nil || isIndex(d.Topic) {
+		return nil
+	}
+	title := listingSectionTitle(d.Topic, d.budget)
+	if title == "" {
+		return nil
+	}
+	prefix := namespacePrefix(d.Topic)
+	root := "/"
+	if languageRoot(prefix) != nil {
+		root = prefix + "/"
+	}
+	return &Breadcrumb{Root: root, Title: title, Path: sectionPath(prefix, title)}
+}
+
+type sectionPage struct {
+	Topic       *Topic
+	Description string
+	Updated     time.Time
+}
+
+// serveSection lists the pages of an outline section of the namespace
+// with prefix, with their descriptions and last updates.
+func serveSection(resp http.ResponseWriter, req *http.Request, prefix string) {
+	budget := requestBudget(req)
+	section := findSection(prefix, strings.TrimPrefix(req.URL.Path, "/section/"), budget)
+	if section == nil {
+		sendNotFound(resp, "Section not found: %s", req.URL.Path)
+		return
+	}
+	topics, err := forum.TopicsWithin(budget)
+	if err != nil {
+		log.Printf("Cannot send %s to %s: %v", req.URL, req.RemoteAddr, err)
+		if sendForumError(resp, err) {
+			return
+		}
+		resp.Header().Set("Location", "/")
+		resp.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+	byID := make(map[int]*Topic, len(topics))
+	for _, topic := range topics {
+		byID[topic.ID] = topic
+	}
+
+	var pages []*sectionPage
+	listed := make(map[int]bool)
+	for _, id := range section.TopicIDs {
+		topic, ok := byID[id]
+		if !ok || listed[id] {
+			continue
+		}
+		listed[id] = true
+		page := &sectionPage{Topic: topic, Updated: topic.BumpedAt}
+		if cached := forum.Cached(id); cached != nil {
+			page.Description = cached.Description()
+		}
+		pages = append(pages, page)
+	}
+
+	var buf bytes.Buffer
+	err = sectionTemplate.Execute(&buf, pages)
+	if err != nil {
+		log.Printf("Cannot execute section template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: section.Title, Content: buf.String()})
+}
+
+var sectionTemplate = template.Must(template.New("section").Funcs(pageFuncs).Parse(`
+<ul class="section-pages">
+{{range .}}<li>
+	<a href="{{.Topic}}">{{.Topic.Title}}</a> <span class="text-muted">updated {{relTime .Updated}}</span>
+	{{with .Description}}<p>{{.}}</p>{{end}}
+</li>
+{{else}}
+<li>This section has no pages yet.</li>
+{{end}}
+</ul>
+`))
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// selector is a parsed CSS selector list. It supports type, universal,
+// ID, class, and attribute selectors, combined by descendant and child
+// combinators.
+type selector [][]*compoundSelector
+
+type compoundSelector struct {
+	child bool // Whether the previous compound must be the parent.
+	tag   string
+	id    string
+	class []string
+	attrs []attrSelector
+}
+
+type attrSelector struct {
+	name  string
+	op    string // Empty, "=", "~=", "^=", "$=", or "*=".
+	value string
+}
+
+var (
+	selectorToken = regexp.MustCompile(`^(?:\*|[a-zA-Z][a-zA-Z0-9-]*)?(?:[#.][a-zA-Z0-9_-]+|\[[a-zA-Z_-]+(?:[~^$*]?=(?:"[^"]*"|'[^']*'|[^\]]*))?\])*`)
+	selectorPart  = regexp.MustCompile(`[#.][a-zA-Z0-9_-]+|\[([a-zA-Z_-]+)(?:([~^$*]?=)("[^"]*"|'[^']*'|[^\]]*))?\]`)
+)
+
+func parseSelector(s string) (selector, error) {
+	var sel selector
+	for _, group := range strings.Split(s, ",") {
+		var compounds []*compoundSelector
+		child := false
+		for _, field := range strings.Fields(strings.Replace(group, ">", " > ", -1)) {
+			if field == ">" {
+				if child || len(compounds) == 0 {
+					return nil, fmt.Errorf("invalid selector %q", s)
+				}
+				child = true
+				continue
+			}
+			if selectorToken.FindString(field) != field {
+				return nil, fmt.Errorf("invalid selector %q", s)
+			}
+			c := &compoundSelector{child: child}
+			rest := field
+			if i := strings.IndexAny(field, "#.["); i >= 0 {
+				c.tag, rest = field[:i], field[i:]
+			} else {
+				c.tag, rest = field, ""
+			}
+			if c.tag == "*" {
+				c.tag = ""
+			}
+			c.tag = strings.ToLower(c.tag)
+			for _, m := range selectorPart.FindAllStringSubmatch(rest, -1) {
+				switch m[0][0] {
+				case '#':
+					c.id = m[0][1:]
+				case '.':
+					c.class = append(c.class, m[0][1:])
+				default:
+					c.attrs = append(c.attrs, attrSelector{strings.ToLower(m[1]), m[2], strings.Trim(m[3], `"'`)})
+				}
+			}
+			compounds = append(compounds, c)
+			child = false
+		}
+		if len(compounds) == 0 || child {
+			return nil, fmt.Errorf("invalid selector %q", s)
+		}
+		sel = append(sel, compounds)
+	}
+	return sel, nil
+}
+
+// match reports whether the element n matches the selector.
+func (sel selector) match(n *html.Node) bool {
+	for _, compounds := range sel {
+		if matchCompounds(compounds, n) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchCompounds(compounds []*compoundSelector, n *html.Node) bool {
+	last := compounds[len(compounds)-1]
+	if !last.match(n) {
+		return false
+	}
+	if len(compounds) == 1 {
+		return true
+	}
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if matchCompounds(compounds[:len(compounds)-1], p) {
+			return true
+		}
+		if last.child {
+			break
+		}
+	}
+	return false
+}
+
+func (c *compoundSelector) match(n *html.Node) bool {
+	if n.Type != html.ElementNode || c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	if c.id != "" && nodeAttr(n, "id") != c.id {
+		return false
+	}
+	classes := strings.Fields(nodeAttr(n, "class"))
+	for _, class := range c.class {
+		if !containsString(classes, class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := nodeAttrOK(n, a.name)
+		if !ok {
+			return false
+		}
+		switch a.op {
+		case "=":
+			ok = value == a.value
+		case "~=":
+			ok = containsString(strings.Fields(value), a.value)
+		case "^=":
+			ok = strings.HasPrefix(value, a.value)
+		case "$=":
+			ok = strings.HasSuffix(value, a.value)
+		case "*=":
+			ok = strings.Contains(value, a.value)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func nodeAttr(n *html.Node, name string) string {
+	value, _ := nodeAttrOK(n, name)
+	return value
+}
+
+func nodeAttrOK(n *html.Node, name string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeText returns the text within n.
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var buf strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		buf.WriteString(nodeText(c))
+	}
+	return buf.String()
+}
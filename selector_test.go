@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const selectorTestContent = `<div id="main" class="cooked post"><p class="note">a <a href="https://example.com/x" data-kind="ext link">b</a></p><aside><p>c</p></aside></div><p lang="fr-CA">d</p>`
+
+var selectorTests = []struct {
+	selector string
+	matches  string // Text of the matching elements, in document order, separated by "|".
+}{
+	{"p", "a b|c|d"},
+	{"*", "a bc|a b|b|c|c|d"},
+	{"P", "a b|c|d"},
+	{"#main", "a bc"},
+	{".note", "a b"},
+	{"div.cooked.post", "a bc"},
+	{"div.cooked.missing", ""},
+	{"div p", "a b|c"},
+	{"div > p", "a b"},
+	{"div>p", "a b"},
+	{"aside p, p.note", "a b|c"},
+	{"[lang]", "d"},
+	{`a[href="https://example.com/x"]`, "b"},
+	{`a[href^=https]`, "b"},
+	{`a[href$='/x']`, "b"},
+	{`a[href*=example]`, "b"},
+	{`a[data-kind~=link]`, "b"},
+	{`a[data-kind~=lin]`, ""},
+}
+
+func TestParseSelector(t *testing.T) {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(selectorTestContent), context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range selectorTests {
+		sel, err := parseSelector(test.selector)
+		if err != nil {
+			t.Errorf("parseSelector(%q) failed: %v", test.selector, err)
+			continue
+		}
+		var matches []string
+		for _, n := range nodes {
+			walkElements(n, func(n *html.Node) bool {
+				if sel.match(n) {
+					matches = append(matches, nodeText(n))
+				}
+				return true
+			})
+		}
+		if result := strings.Join(matches, "|"); result != test.matches {
+			t.Errorf("selector %q matches %q, want %q", test.selector, result, test.matches)
+		}
+	}
+}
+
+var invalidSelectors = []string{
+	"",
+	",",
+	"p,",
+	"> p",
+	"p >",
+	"p > > a",
+	"p:first-child",
+	"p[",
+	"p + a",
+}
+
+func TestParseSelectorInvalid(t *testing.T) {
+	for _, s := range invalidSelectors {
+		if _, err := parseSelector(s); err == nil {
+			t.Errorf("parseSelector(%q) succeeded", s)
+		}
+	}
+}
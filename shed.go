@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// RouteLimit bounds the requests served concurrently for a class of
+// routes, as named by routeClass. Requests beyond the limit wait in a
+// bounded queue, and are refused with 503 when it's full or they waited
+// too long, so overload degrades predictably instead of piling up.
+type RouteLimit struct {
+	// Concurrency is how many requests are served at once.
+	Concurrency int `json:"concurrency"`
+
+	// Queue is how many further requests may wait for a turn.
+	Queue int `json:"queue"`
+
+	// Wait is how long queued requests wait, such as "5s" (the default).
+	Wait string `json:"wait"`
+
+	// RetryAfter is the number of seconds refused clients are told to
+	// wait before trying again. It defaults to 10.
+	RetryAfter int `json:"retry-after"`
+
+	slots  chan struct{}
+	queued int32
+	wait   time.Duration
+}
+
+var routeClasses = []string{"topic", "search", "admin", "api", "static", "other"}
+
+func (l *RouteLimit) init(class string) error {
+	known := false
+	for _, c := range routeClasses {
+		known = known || c == class
+	}
+	if !known {
+		return fmt.Errorf("invalid route class for load shedding: %q", class)
+	}
+	if l.Concurrency < 1 {
+		return fmt.Errorf("load shedding for %s routes needs a positive concurrency", class)
+	}
+	if l.Queue < 0 {
+		return fmt.Errorf("load shedding for %s routes has a negative queue", class)
+	}
+	l.wait = 5 * time.Second
+	if l.Wait != "" {
+		var err error
+		l.wait, err = time.ParseDuration(l.Wait)
+		if err != nil {
+			return fmt.Errorf("invalid load shedding wait for %s routes: %v", class, err)
+		}
+	}
+	if l.RetryAfter == 0 {
+		l.RetryAfter = 10
+	}
+	l.slots = make(chan struct{}, l.Concurrency)
+	return nil
+}
+
+// acquire waits for a turn to serve a request, returning false if the
+// request should be refused instead.
+func (l *RouteLimit) acquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt32(&l.queued, 1) > int32(l.Queue) {
+		atomic.AddInt32(&l.queued, -1)
+		return false
+	}
+	defer atomic.AddInt32(&l.queued, -1)
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (l *RouteLimit) release() {
+	<-l.slots
+}
+
+// shedHandler applies the configured route limits to h. Health checks
+// and the long-lived /events streams are never limited.
+func shedHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		class := routeClass(req.URL.Path)
+		limit := config.LoadShedding[class]
+		if limit == nil || req.URL.Path == "/health-check" || req.URL.Path == "/events" {
+			h(resp, req)
+			return
+		}
+		if !limit.acquire() {
+			log.Printf("Shedding request for %s from %s: too many %s requests", req.URL, req.RemoteAddr, class)
+			resp.Header().Set("Retry-After", strconv.Itoa(limit.RetryAfter))
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			resp.Write([]byte("The server is busy, please try again shortly."))
+			return
+		}
+		defer limit.release()
+		h(resp, req)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// pageSnapshot is a version of a topic as it was cached.
+type pageSnapshot struct {
+	Version int
+	Updated time.Time
+	Saved   time.Time
+	Title   string
+	Content string
+	Raw     string `json:",omitempty"`
+}
+
+// snapshotStore keeps the last versions of each topic on disk, and
+// which topics are pinned to one of them instead of the live version.
+type snapshotStore struct {
+	mu    sync.Mutex
+	pins  map[int]int // Pinned version by topic ID.
+	views map[int]*pinnedView
+}
+
+// pinnedView is a pinned snapshot as served in place of a live topic.
+type pinnedView struct {
+	live    *Topic
+	version int
+	topic   *Topic
+}
+
+var snapshots snapshotStore
+
+func snapshotDir(id int) string {
+	return filepath.Join(*snapshotsFlag, strconv.Itoa(id))
+}
+
+func snapshotPath(id, version int) string {
+	return filepath.Join(snapshotDir(id), strconv.Itoa(version)+".json")
+}
+
+// save stores the topic as a snapshot, unless its version is already
+// stored, and removes the oldest snapshots beyond -snapshot-count. The
+// pinned version is always kept.
+func (s *snapshotStore) save(topic *Topic) {
+	if topic.Post == nil || topic.Post.Version == 0 {
+		return
+	}
+	path := snapshotPath(topic.ID, topic.Post.Version)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	snapshot := &pageSnapshot{
+		Version: topic.Post.Version,
+		Updated: topic.LastUpdate(),
+		Saved:   time.Now(),
+		Title:   topic.Title,
+		Content: topic.Content(),
+		Raw:     topic.Markdown(),
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("Cannot marshal snapshot of %s: %v", topic, err)
+		return
+	}
+	err = os.MkdirAll(snapshotDir(topic.ID), 0755)
+	if err == nil {
+		err = writeCacheFile(path, &cacheEntry{Data: data})
+	}
+	if err != nil {
+		log.Printf("Cannot save snapshot of %s: %v", topic, err)
+		return
+	}
+
+	versions := s.versions(topic.ID)
+	s.mu.Lock()
+	pinned := s.pins[topic.ID]
+	s.mu.Unlock()
+	for len(versions) > *snapshotCountFlag {
+		if versions[0] != pinned {
+			if err := os.Remove(snapshotPath(topic.ID, versions[0])); err != nil {
+				log.Printf("Cannot remove old snapshot of %s: %v", topic, err)
+			}
+		}
+		versions = versions[1:]
+	}
+}
+
+// versions returns the versions of the topic with id that have
+// snapshots, oldest first.
+func (s *snapshotStore) versions(id int) []int {
+	names, _ := filepath.Glob(filepath.Join(snapshotDir(id), "*.json"))
+	var versions []int
+	for _, name := range names {
+		if v, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), ".json")); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// before returns the snapshot of the version of the topic with id that
+// was current at t, or nil if it's not kept.
+func (s *snapshotStore) before(id int, t time.Time) *pageSnapshot {
+	if *snapshotsFlag == "" {
+		return nil
+	}
+	versions := s.versions(id)
+	for i := len(versions) - 1; i >= 0; i-- {
+		snapshot, err := s.load(id, versions[i])
+		if err != nil {
+			log.Printf("Cannot load snapshot %d of topic %d: %v", versions[i], id, err)
+			continue
+		}
+		if !snapshot.Updated.After(t) {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+func (s *snapshotStore) load(id, version int) (*pageSnapshot, error) {
+	data, err := readStateFile(snapshotPath(id, version))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read snapshot: %v", err)
+	}
+	var snapshot pageSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal snapshot: %v", err)
+	}
+	return &snapshot, nil
+}
+
+func (s *snapshotStore) loadPins() error {
+	data, err := readStateFile(filepath.Join(*snapshotsFlag, "pins.json"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read pinned snapshots: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.Unmarshal(data, &s.pins); err != nil {
+		return fmt.Errorf("cannot unmarshal pinned snapshots: %v", err)
+	}
+	return nil
+}
+
+// setPin pins the topic with id to version, or unpins it if version is
+// zero, and persists the pins.
+func (s *snapshotStore) setPin(id, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pins == nil {
+		s.pins = make(map[int]int)
+	}
+	delete(s.views, id)
+	if version == 0 {
+		delete(s.pins, id)
+	} else {
+		s.pins[id] = version
+	}
+	data, err := json.Marshal(s.pins)
+	if err != nil {
+		return fmt.Errorf("cannot marshal pinned snapshots: %v", err)
+	}
+	err = writeCacheFile(filepath.Join(*snapshotsFlag, "pins.json"), &cacheEntry{Data: data})
+	if err != nil {
+		return fmt.Errorf("cannot write pinned snapshots: %v", err)
+	}
+	return nil
+}
+
+func (s *snapshotStore) pinnedVersion(id int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pins[id]
+}
+
+// pinned returns the snapshot the topic is pinned to, as a topic, or
+// the topic itself if it's not pinned. Snapshots are loaded once per
+// version of the live topic.
+func (s *snapshotStore) pinned(topic *Topic) *Topic {
+	if *snapshotsFlag == "" || topic.snapshot {
+		return topic
+	}
+	version := s.pinnedVersion(topic.ID)
+	if version == 0 || topic.Post == nil || version == topic.Post.Version {
+		return topic
+	}
+	s.mu.Lock()
+	view := s.views[topic.ID]
+	s.mu.Unlock()
+	if view != nil && view.live == topic && view.version == version {
+		return view.topic
+	}
+	snapshot, err := s.load(topic.ID, version)
+	if err != nil {
+		log.Printf("Cannot serve pinned version %d of %s: %v", version, topic, err)
+		return topic
+	}
+	view = &pinnedView{live: topic, version: version, topic: snapshot.topic(topic)}
+	s.mu.Lock()
+	if s.views == nil {
+		s.views = make(map[int]*pinnedView)
+	}
+	s.views[topic.ID] = view
+	s.mu.Unlock()
+	return view.topic
+}
+
+// topic returns a copy of live with the content of the snapshot.
+func (snapshot *pageSnapshot) topic(live *Topic) *Topic {
+	t := *live
+	post := *live.Post
+	post.Version = snapshot.Version
+	post.UpdatedAt = snapshot.Updated
+	t.Post = &post
+	t.Title = snapshot.Title
+	t.Meta = nil
+	t.raw = nil
+	if snapshot.Raw != "" {
+		t.Meta = parsePageMeta(snapshot.Raw)
+		t.raw = snappy.Encode(nil, []byte(snapshot.Raw))
+	}
+	t.content = snappy.Encode(nil, []byte(snapshot.Content))
+	t.description = contentDescription(snapshot.Content)
+	t.image = firstImage(snapshot.Content)
+	t.snapshot = true
+	return &t
+}
+
+// pinnedNotice tells readers that the page shows an earlier version.
+func pinnedNotice(topic *Topic) string {
+	return fmt.Sprintf("This page is shown as it was on %s while recent changes to it are reviewed.", topic.LastUpdate().Format("January 2, 2006"))
+}
+
+var adminSnapshotsPattern = regexp.MustCompile("^/admin/snapshots/([0-9]+)$")
+
+type snapshotsData struct {
+	Topic     *Topic
+	Pinned    int
+	Snapshots []*snapshotRow
+}
+
+type snapshotRow struct {
+	*pageSnapshot
+	Added   int
+	Removed int
+}
+
+// serveSnapshots lists the snapshots of a topic, and pins or unpins it
+// when posted to.
+func serveSnapshots(resp http.ResponseWriter, req *http.Request) {
+	if *snapshotsFlag == "" {
+		sendNotFound(resp, "Snapshots are disabled.")
+		return
+	}
+	if req.URL.Path == "/admin/snapshots" {
+		if id, err := topicPathID("/" + req.Form.Get("page")); err == nil {
+			resp.Header().Set("Location", "/admin/snapshots/"+strconv.Itoa(id))
+			resp.WriteHeader(http.StatusSeeOther)
+			return
+		}
+		serveSnapshotPins(resp, req)
+		return
+	}
+	m := adminSnapshotsPattern.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		sendNotFound(resp, "Invalid snapshots path: %s", req.URL.Path)
+		return
+	}
+	id, _ := strconv.Atoi(m[1])
+
+	if req.Method == "POST" {
+		version, _ := strconv.Atoi(req.Form.Get("version"))
+		if version != 0 {
+			if _, err := snapshots.load(id, version); err != nil {
+				sendNotFound(resp, "No version %d of page %d.", version, id)
+				return
+			}
+			audit.Record(req, "pin-snapshot", fmt.Sprintf("/%d@%d", id, version))
+		} else {
+			audit.Record(req, "unpin-snapshot", fmt.Sprintf("/%d", id))
+		}
+		if err := snapshots.setPin(id, version); err != nil {
+			log.Printf("%v", err)
+			resp.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp.Header().Set("Location", req.URL.Path)
+		resp.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	topic, err := forum.liveTopicWithin("/"+m[1], nil)
+	if err != nil {
+		sendNotFound(resp, "Cannot obtain page %d: %v", id, err)
+		return
+	}
+	data := &snapshotsData{Topic: topic, Pinned: snapshots.pinnedVersion(id)}
+	versions := snapshots.versions(id)
+	for i := len(versions) - 1; i >= 0; i-- {
+		snapshot, err := snapshots.load(id, versions[i])
+		if err != nil {
+			log.Printf("Cannot list version %d of %s: %v", versions[i], topic, err)
+			continue
+		}
+		row := &snapshotRow{pageSnapshot: snapshot}
+		for _, line := range diffLines(strings.Split(snapshot.Content, "\n"), strings.Split(topic.Content(), "\n")) {
+			switch line.Op {
+			case "+":
+				row.Added++
+			case "-":
+				row.Removed++
+			}
+		}
+		data.Snapshots = append(data.Snapshots, row)
+	}
+
+	var buf strings.Builder
+	err = snapshotsTemplate.Execute(&buf, data)
+	if err != nil {
+		log.Printf("Cannot execute snapshots template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Versions of " + topic.Title, Content: buf.String()})
+}
+
+var snapshotsTemplate = template.Must(template.New("snapshots").Funcs(pageFuncs).Parse(`
+<p>{{if .Pinned}}<a href="{{.Topic}}">The page</a> is pinned to version {{.Pinned}} instead of the live version {{.Topic.Post.Version}}.
+<form method="post"><input type="hidden" name="version" value="0"><button type="submit">Unpin and serve the live version</button></form>
+{{else}}<a href="{{.Topic}}">The page</a> serves the live version {{.Topic.Post.Version}}. Pin it to an earlier version while a bad change is fixed on the forum.{{end}}</p>
+{{if .Snapshots}}
+<table class="traffic">
+<thead><tr><th>Version</th><th>Updated</th><th>Changes to live version</th><th></th></tr></thead>
+<tbody>
+{{range .Snapshots}}<tr><td>{{.Version}}</td><td>{{formatTime .Updated}}</td><td>{{if or .Added .Removed}}{{.Added}} lines added, {{.Removed}} removed{{else}}none{{end}}</td>
+<td>{{if eq .Version $.Pinned}}pinned{{else if ne .Version $.Topic.Post.Version}}<form method="post"><input type="hidden" name="version" value="{{.Version}}"><button type="submit">Pin</button></form>{{end}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>No versions of this page were kept yet.</p>
+{{end}}
+`))
+
+// serveSnapshotPins lists the pages pinned to earlier versions.
+func serveSnapshotPins(resp http.ResponseWriter, req *http.Request) {
+	snapshots.mu.Lock()
+	ids := make([]int, 0, len(snapshots.pins))
+	for id := range snapshots.pins {
+		ids = append(ids, id)
+	}
+	snapshots.mu.Unlock()
+	sort.Ints(ids)
+
+	var buf strings.Builder
+	buf.WriteString("<p>Pages pinned to an earlier version:</p>\n<ul>\n")
+	for _, id := range ids {
+		title := strconv.Itoa(id)
+		if topic := forum.Cached(id); topic != nil {
+			title = topic.Title
+		}
+		fmt.Fprintf(&buf, "<li><a href=\"/admin/snapshots/%d\">%s</a></li>\n", id, template.HTMLEscapeString(title))
+	}
+	if len(ids) == 0 {
+		buf.WriteString("<li>None.</li>\n")
+	}
+	buf.WriteString("</ul>\n<form><label>Versions of page <input name=\"page\" placeholder=\"ID\"></label> <button type=\"submit\">Show</button></form>\n")
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Pinned pages", Content: buf.String()})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// spellingIssue is a likely misspelling found in a documentation page.
+type spellingIssue struct {
+	Path  string `json:"path"`
+	Title string `json:"title"`
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+var (
+	spellSkipBlock = regexp.MustCompile(`(?is)<(pre|code|kbd|samp|script|style)\b[^>]*>.*?</(?:pre|code|kbd|samp|script|style)>`)
+	spellURL       = regexp.MustCompile(`\b(?:https?://|www\.)\S+`)
+	spellWord      = regexp.MustCompile(`\pL+(?:['’]\pL+)*`)
+)
+
+// spellDictionary holds the known words, in lowercase.
+type spellDictionary map[string]bool
+
+// readWords adds the words listed one per line in the file at path, or
+// separated by spaces, to the dictionary. Lines starting with # are
+// comments.
+func (d spellDictionary) readWords(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, word := range strings.Fields(line) {
+			d[strings.ToLower(word)] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// known reports whether word is spelled correctly, or is not worth
+// checking, such as acronyms and identifiers in mixed case.
+func (d spellDictionary) known(word string) bool {
+	word = strings.Replace(word, "’", "'", -1)
+	lower := strings.ToLower(word)
+	if d[lower] || len([]rune(word)) < 3 {
+		return true
+	}
+	for i, r := range word {
+		if i > 0 && unicode.IsUpper(r) {
+			return true
+		}
+	}
+	if strings.HasSuffix(lower, "'s") && d[strings.TrimSuffix(lower, "'s")] {
+		return true
+	}
+	return false
+}
+
+// misspellings returns the words of the content of a page missing from
+// the dictionary, leaving out code and URLs, with how often they appear.
+func misspellings(content string, dict spellDictionary) map[string]int {
+	// Tags become spaces, so words in adjacent elements stay apart.
+	text := html.UnescapeString(htmlTag.ReplaceAllString(spellSkipBlock.ReplaceAllString(content, " "), " "))
+	text = spellURL.ReplaceAllString(text, " ")
+	words := make(map[string]int)
+	for _, word := range spellWord.FindAllString(text, -1) {
+		if !dict.known(word) {
+			words[strings.ToLower(word)]++
+		}
+	}
+	return words
+}
+
+func spellcheckCommand(args []string) error {
+	flags := flag.NewFlagSet("spellcheck", flag.ExitOnError)
+	dictionary := flags.String("dictionary", "/usr/share/dict/words", "File with the dictionary words, one per line")
+	projectWords := flags.String("words", "", "File with further project words, such as product names, one per line")
+	format := flags.String("format", "text", "Output format: text, or json for one issue object per line")
+	flags.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unsupported spellcheck format: %s", *format)
+	}
+	dict := make(spellDictionary)
+	if err := dict.readWords(*dictionary); err != nil {
+		return fmt.Errorf("cannot read dictionary: %v", err)
+	}
+	if *projectWords != "" {
+		if err := dict.readWords(*projectWords); err != nil {
+			return fmt.Errorf("cannot read project words: %v", err)
+		}
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		topics, err := forum.Topics()
+		if err != nil {
+			return err
+		}
+		for _, topic := range topics {
+			if !isIndex(topic) {
+				paths = append(paths, topic.String())
+			}
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	issues, failed := 0, 0
+	for _, path := range paths {
+		topic, err := forum.Topic(path)
+		if err != nil {
+			log.Printf("Cannot spellcheck %s: %v", path, err)
+			failed++
+			continue
+		}
+		counts := misspellings(topic.Content(), dict)
+		words := make([]string, 0, len(counts))
+		for word := range counts {
+			words = append(words, word)
+		}
+		sort.Strings(words)
+		issues += len(words)
+		if *format == "json" {
+			for _, word := range words {
+				encoder.Encode(&spellingIssue{Path: topic.String(), Title: topic.Title, Word: word, Count: counts[word]})
+			}
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		fmt.Printf("%s (%s):\n", topic, topic.Title)
+		for _, word := range words {
+			fmt.Printf("\t%s", word)
+			if counts[word] > 1 {
+				fmt.Printf(" (%d)", counts[word])
+			}
+			fmt.Println()
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("cannot spellcheck %d of %d pages", failed, len(paths))
+	}
+	if issues > 0 {
+		return fmt.Errorf("found %d likely misspellings in %d pages", issues, len(paths))
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type staticFile struct {
+	key         string
+	contentType string
+	data        []byte
+}
+
+// staticExport renders the index and every documentation page into a
+// fully static copy of the site, with pages at KEY/index.html so that
+// directory index documents serve them at their usual URLs. The pages
+// are also exported at mirror/ID.json, for the export to act as a mirror.
+func staticExport() ([]*staticFile, error) {
+	topics, err := forum.Topics()
+	if err != nil {
+		return nil, err
+	}
+
+	render := func(path string, topic *Topic) []byte {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Form = url.Values{}
+		var buf bytes.Buffer
+		renderPage(&buf, req, &pageData{Topic: topic})
+		return buf.Bytes()
+	}
+
+	index, err := forum.Topic(indexPagePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot export index: %v", err)
+	}
+	files := []*staticFile{
+		{"index.html", "text/html; charset=utf-8", render("/", index)},
+		{"icon32.png", "image/png", iconBytes},
+		{"favicon.ico", "image/x-icon", faviconBytes},
+		{"static/keys.js", "application/javascript", []byte(keyboardScript)},
+		{"static/reference.js", "application/javascript", []byte(referenceScript)},
+		{"static/progress.js", "application/javascript", []byte(progressScript)},
+	}
+	if data, err := mirrorData(index); err == nil {
+		files = append(files, &staticFile{fmt.Sprintf("mirror/%d.json", index.ID), "application/json", data})
+	}
+	if *privacyStrictFlag {
+		css, err := bootstrapStylesheet()
+		if err != nil {
+			return nil, fmt.Errorf("cannot export stylesheet: %v", err)
+		}
+		files = append(files, &staticFile{"static/bootstrap.min.css", "text/css", css})
+	}
+	for _, listed := range topics {
+		if listed.ID == indexPageID {
+			continue
+		}
+		topic, err := forum.Topic(listed.String())
+		if err != nil {
+			log.Printf("Cannot export %s: %v", listed, err)
+			continue
+		}
+		key := strings.TrimPrefix(topic.String(), "/") + "/index.html"
+		files = append(files, &staticFile{key, "text/html; charset=utf-8", render(topic.String(), topic)})
+		if data, err := mirrorData(topic); err == nil {
+			files = append(files, &staticFile{fmt.Sprintf("mirror/%d.json", topic.ID), "application/json", data})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].key < files[j].key })
+	return files, nil
+}
+
+func exportStaticCommand(args []string) error {
+	flags := flag.NewFlagSet("export-static", flag.ExitOnError)
+	output := flags.String("o", "", "Write the static site into the given directory")
+	push := flags.String("push", "", "Upload the static site to the given s3://bucket/prefix")
+	every := flags.Duration("every", 0, "Keep running and export again at the given interval")
+	endpoint := flags.String("endpoint", "s3.amazonaws.com", "S3-compatible storage endpoint (e.g. storage.googleapis.com)")
+	region := flags.String("region", "us-east-1", "Region of the storage bucket")
+	flags.Parse(args)
+
+	if *output == "" && *push == "" {
+		return fmt.Errorf("export-static requires -o and/or -push")
+	}
+	var bucket, prefix string
+	if *push != "" {
+		u, err := url.Parse(*push)
+		if err != nil || u.Scheme != "s3" || u.Host == "" {
+			return fmt.Errorf("invalid -push location, expected s3://bucket/prefix: %s", *push)
+		}
+		bucket, prefix = u.Host, strings.Trim(u.Path, "/")
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if *push != "" && (accessKey == "" || secretKey == "") {
+		return fmt.Errorf("-push requires $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	}
+	store := &s3Store{endpoint: *endpoint, region: *region, bucket: bucket, accessKey: accessKey, secretKey: secretKey}
+
+	for {
+		files, err := staticExport()
+		if err != nil && *every == 0 {
+			return err
+		}
+		if err != nil {
+			log.Printf("Cannot export static site: %v", err)
+		}
+
+		if *output != "" {
+			for _, file := range files {
+				path := filepath.Join(*output, filepath.FromSlash(file.key))
+				err := os.MkdirAll(filepath.Dir(path), 0755)
+				if err == nil {
+					err = ioutil.WriteFile(path, file.data, 0644)
+				}
+				if err != nil {
+					return fmt.Errorf("cannot write static site: %v", err)
+				}
+			}
+		}
+
+		if *push != "" && len(files) > 0 {
+			failed := 0
+			for _, file := range files {
+				key := file.key
+				if prefix != "" {
+					key = prefix + "/" + key
+				}
+				if err := store.put(key, file.contentType, file.data); err != nil {
+					log.Printf("Cannot upload %s: %v", key, err)
+					failed++
+				}
+			}
+			if failed > 0 && *every == 0 {
+				return fmt.Errorf("cannot upload %d of %d files of the static site", failed, len(files))
+			}
+			log.Printf("Uploaded %d files of the static site to %s.", len(files)-failed, *push)
+		}
+
+		if *every == 0 {
+			return nil
+		}
+		time.Sleep(*every)
+	}
+}
+
+// s3Store uploads objects to a bucket in S3 or in a storage service with
+// an S3-compatible API, such as Google Cloud Storage in interoperability
+// mode.
+type s3Store struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+}
+
+// put uploads data to key.
+func (s *s3Store) put(key, contentType string, data []byte) error {
+	resp, err := s.do("PUT", key, map[string]string{
+		"Cache-Control": "max-age=300",
+		"Content-Type":  contentType,
+	}, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a request for key with the given headers and body, signed with
+// AWS Signature V4, and returns the response if it has a 2xx status.
+func (s *s3Store) do(method, key string, header map[string]string, data []byte) (*http.Response, error) {
+	var segments []string
+	for _, segment := range strings.Split(s.bucket+"/"+key, "/") {
+		segments = append(segments, strings.Replace(url.PathEscape(segment), "+", "%2B", -1))
+	}
+	path := "/" + strings.Join(segments, "/")
+
+	req, err := http.NewRequest(method, "https://"+s.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(data)
+
+	signed := map[string]string{
+		"host":                 s.endpoint,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	for name, value := range header {
+		signed[strings.ToLower(name)] = value
+	}
+	var names []string
+	for name, value := range signed {
+		names = append(names, name)
+		if name != "host" {
+			req.Header.Set(name, value)
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, fmt.Errorf("got %v status: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const statsFlushInterval = 5 * time.Minute
+
+var stats viewStats
+
+type topicStats struct {
+	ID    int    `json:"id"`
+	Path  string `json:"path"`
+	Title string `json:"title"`
+	Views int64  `json:"views"`
+}
+
+type viewStats struct {
+	mu     sync.RWMutex
+	since  time.Time
+	topics map[int]*topicStats
+	dirty  int32
+}
+
+type statsDump struct {
+	Since  time.Time     `json:"since"`
+	Topics []*topicStats `json:"topics"`
+}
+
+// View records a view of topic.
+func (s *viewStats) View(topic *Topic) {
+	s.mu.RLock()
+	ts, ok := s.topics[topic.ID]
+	s.mu.RUnlock()
+	if !ok {
+		s.mu.Lock()
+		if s.topics == nil {
+			s.topics = make(map[int]*topicStats)
+			s.since = time.Now()
+		}
+		ts, ok = s.topics[topic.ID]
+		if !ok {
+			ts = &topicStats{ID: topic.ID}
+			s.topics[topic.ID] = ts
+		}
+		ts.Path = topic.String()
+		ts.Title = topic.Title
+		s.mu.Unlock()
+	}
+	atomic.AddInt64(&ts.Views, 1)
+	atomic.StoreInt32(&s.dirty, 1)
+}
+
+// Dump returns a snapshot of the statistics, most viewed topics first.
+func (s *viewStats) Dump() *statsDump {
+	s.mu.RLock()
+	dump := &statsDump{
+		Since:  s.since,
+		Topics: make([]*topicStats, 0, len(s.topics)),
+	}
+	for _, ts := range s.topics {
+		dump.Topics = append(dump.Topics, &topicStats{
+			ID:    ts.ID,
+			Path:  ts.Path,
+			Title: ts.Title,
+			Views: atomic.LoadInt64(&ts.Views),
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(dump.Topics, func(i, j int) bool {
+		if dump.Topics[i].Views == dump.Topics[j].Views {
+			return dump.Topics[i].ID < dump.Topics[j].ID
+		}
+		return dump.Topics[i].Views > dump.Topics[j].Views
+	})
+	return dump
+}
+
+// Popular returns the n most viewed topics.
+func (s *viewStats) Popular(n int) []*topicStats {
+	topics := s.Dump().Topics
+	if len(topics) > n {
+		topics = topics[:n]
+	}
+	return topics
+}
+
+// Load replaces the statistics with the ones previously saved at path.
+// A missing file is not an error.
+func (s *viewStats) Load(path string) error {
+	data, err := readStateFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read statistics: %v", err)
+	}
+	var dump statsDump
+	err = json.Unmarshal(data, &dump)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal statistics from %s: %v", path, err)
+	}
+	s.mu.Lock()
+	s.since = dump.Since
+	s.topics = make(map[int]*topicStats, len(dump.Topics))
+	for _, ts := range dump.Topics {
+		s.topics[ts.ID] = ts
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Save writes the statistics to path if they changed since last saved.
+func (s *viewStats) Save(path string) error {
+	if !atomic.CompareAndSwapInt32(&s.dirty, 1, 0) {
+		return nil
+	}
+	data, err := json.Marshal(s.Dump())
+	if err != nil {
+		return fmt.Errorf("cannot marshal statistics: %v", err)
+	}
+	err = writeCacheFile(path, &cacheEntry{Data: data})
+	if err != nil {
+		atomic.StoreInt32(&s.dirty, 1)
+		return fmt.Errorf("cannot write statistics: %v", err)
+	}
+	return nil
+}
+
+func (s *viewStats) flushLoop(path string) {
+	for range time.Tick(statsFlushInterval) {
+		if err := s.Save(path); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+}
+
+func serveStats(resp http.ResponseWriter, req *http.Request) {
+	data, err := json.Marshal(stats.Dump())
+	if err != nil {
+		log.Printf("Cannot marshal statistics: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
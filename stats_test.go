@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestViewStatsSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	var saved viewStats
+	saved.View(testTopic(100, "snap-format", "The snap format", ""))
+	saved.View(testTopic(100, "snap-format", "The snap format", ""))
+	saved.View(testTopic(101, "snap-confinement", "Snap confinement", ""))
+	if err := saved.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(path); len(data) == 0 || data[0] == '{' {
+		t.Fatalf("statistics not saved as a cache file: %q", data)
+	}
+
+	var loaded viewStats
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	popular := loaded.Popular(1)
+	if len(popular) != 1 || popular[0].ID != 100 || popular[0].Views != 2 || popular[0].Title != "The snap format" {
+		t.Fatalf("loaded statistics have %+v as most popular", popular)
+	}
+}
+
+func TestViewStatsLoadLegacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	if err := ioutil.WriteFile(path, []byte(`{"since":"2020-01-01T00:00:00Z","topics":[{"id":7,"views":3}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var loaded viewStats
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if topics := loaded.Dump().Topics; len(topics) != 1 || topics[0].ID != 7 || topics[0].Views != 3 {
+		t.Fatalf("loaded legacy statistics: %+v", topics)
+	}
+}
+
+func TestViewStatsLoadMissing(t *testing.T) {
+	var loaded viewStats
+	if err := loaded.Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("loading missing statistics failed: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	probeURL       = "https://forum.snapcraft.io/srv/status"
+	probeHistory   = 24 * time.Hour
+	probeSlow      = 3 * time.Second
+	probeIncidents = 20
+)
+
+// probeResult is the outcome of checking the forum once.
+type probeResult struct {
+	Time    time.Time
+	Latency time.Duration
+	Err     string
+}
+
+// incident is a window during which the forum failed its health checks.
+// End is zero while the incident is ongoing.
+type incident struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Error string    `json:"error"`
+}
+
+// Duration returns how long the incident lasted, or has lasted so far.
+func (i *incident) Duration() time.Duration {
+	end := i.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(i.Start).Round(time.Second)
+}
+
+// upstreamHealth keeps the recent health checks of the forum.
+type upstreamHealth struct {
+	mu        sync.Mutex
+	probes    []*probeResult
+	incidents []*incident
+}
+
+var health upstreamHealth
+
+func (h *upstreamHealth) probeLoop(interval time.Duration) {
+	for {
+		h.add(probeForum())
+		time.Sleep(interval)
+	}
+}
+
+// probeForum checks whether the forum is answering.
+func probeForum() *probeResult {
+	start := time.Now()
+	result := &probeResult{Time: start.UTC()}
+	resp, err := httpClient.Get(probeURL)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("got %v status", resp.StatusCode)
+		}
+	}
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Err = err.Error()
+	}
+	return result
+}
+
+// add records a health check, opening or closing incidents as the forum
+// starts or stops failing them.
+func (h *upstreamHealth) add(r *probeResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.probes = append(h.probes, r)
+	for len(h.probes) > 0 && r.Time.Sub(h.probes[0].Time) > probeHistory {
+		h.probes = h.probes[1:]
+	}
+
+	var last *incident
+	if len(h.incidents) > 0 {
+		last = h.incidents[len(h.incidents)-1]
+	}
+	ongoing := last != nil && last.End.IsZero()
+	switch {
+	case r.Err != "" && !ongoing:
+		log.Printf("Forum health check failed: %s", r.Err)
+		h.incidents = append(h.incidents, &incident{Start: r.Time, Error: r.Err})
+		if len(h.incidents) > probeIncidents {
+			h.incidents = h.incidents[1:]
+		}
+	case r.Err == "" && ongoing:
+		log.Printf("Forum health check passed again after %v.", r.Time.Sub(last.Start).Round(time.Second))
+		last.End = r.Time
+	}
+}
+
+// cacheFreshness tells how up to date the cached copies of pages are.
+type cacheFreshness struct {
+	Pages     int       `json:"pages"`
+	Stale     int       `json:"stale"` // Pages past their cache timeout.
+	Oldest    time.Time `json:"oldest"`
+	TopicList time.Time `json:"topic-list"`
+}
+
+// freshness reports on the cached copies of pages without waiting on
+// fetches, so the status is served while the forum hangs.
+func (f *Forum) freshness() *cacheFreshness {
+	f.mu.Lock()
+	caches := make([]*topicCache, 0, len(f.cache))
+	for _, cache := range f.cache {
+		caches = append(caches, cache)
+	}
+	f.mu.Unlock()
+
+	c := &cacheFreshness{}
+	now := time.Now()
+	for _, cache := range caches {
+		topic, t := cache.peek()
+		if topic != nil && !t.IsZero() {
+			c.Pages++
+			if t.Add(topicCacheTimeout).Before(now) {
+				c.Stale++
+			}
+			if c.Oldest.IsZero() || t.Before(c.Oldest) {
+				c.Oldest = t.UTC()
+			}
+		}
+	}
+	// The topic list is locked while it's fetched, and then left out.
+	if f.category.mu.TryLock() {
+		if !f.category.time.IsZero() {
+			c.TopicList = f.category.time.UTC()
+		}
+		f.category.mu.Unlock()
+	}
+	return c
+}
+
+type statusReport struct {
+	Status    string          `json:"status"` // up, degraded, down, or unknown.
+	Checked   time.Time       `json:"checked"`
+	LatencyMS int64           `json:"latency-ms"`
+	Error     string          `json:"error,omitempty"`
+	Uptime    float64         `json:"uptime"` // Percentage of checks passed in the last day.
+	Cache     *cacheFreshness `json:"cache"`
+	Incidents []*incident     `json:"incidents"`
+}
+
+// report summarizes the health of the forum and of the cache. The forum
+// is degraded if it answers slowly or failed any of the last few checks.
+func (h *upstreamHealth) report() *statusReport {
+	r := &statusReport{Status: "unknown", Cache: forum.freshness(), Incidents: []*incident{}}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.incidents) - 1; i >= 0; i-- {
+		inc := *h.incidents[i]
+		r.Incidents = append(r.Incidents, &inc)
+	}
+	if len(h.probes) == 0 {
+		return r
+	}
+	last := h.probes[len(h.probes)-1]
+	r.Checked = last.Time
+	r.LatencyMS = last.Latency.Milliseconds()
+	r.Error = last.Err
+	passed, recentFailures := 0, 0
+	for i, p := range h.probes {
+		if p.Err == "" {
+			passed++
+		} else if i >= len(h.probes)-3 {
+			recentFailures++
+		}
+	}
+	r.Uptime = float64(passed*1000/len(h.probes)) / 10
+	switch {
+	case last.Err != "":
+		r.Status = "down"
+	case last.Latency > probeSlow || recentFailures > 0:
+		r.Status = "degraded"
+	default:
+		r.Status = "up"
+	}
+	return r
+}
+
+func serveStatusAPI(resp http.ResponseWriter, req *http.Request) {
+	data, err := json.Marshal(health.report())
+	if err != nil {
+		log.Printf("Cannot marshal status: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Write(data)
+}
+
+func serveStatus(resp http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	err := statusTemplate.Execute(&buf, health.report())
+	if err != nil {
+		log.Printf("Cannot execute status template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	resp.Header().Set("Cache-Control", "no-cache")
+	renderPage(resp, req, &pageData{Title: "Status", Content: buf.String()})
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(pageFuncs).Parse(`
+{{if eq .Status "up"}}<div class="alert alert-success" role="status">The forum hosting the documentation is answering normally.</div>
+{{else if eq .Status "degraded"}}<div class="alert alert-warning" role="status">The forum hosting the documentation is slow or failing intermittently. Pages may be out of date.</div>
+{{else if eq .Status "down"}}<div class="alert alert-danger" role="status">The forum hosting the documentation is not answering. Pages are served from the cache and may be out of date.</div>
+{{else}}<div class="alert alert-info" role="status">The health of the forum is not being checked.</div>
+{{end}}
+{{if not .Checked.IsZero}}<p>Last checked on {{formatTime .Checked}}, answering in {{.LatencyMS}}ms{{with .Error}} with an error: {{.}}{{end}}.
+The forum passed {{.Uptime}}% of the checks in the last day.</p>{{end}}
+<h3>Cache</h3>
+<p>{{.Cache.Pages}} pages are cached{{if .Cache.Stale}}, {{.Cache.Stale}} of them due to be refreshed{{end}}.
+{{if not .Cache.Oldest.IsZero}}The oldest copy was fetched on {{formatTime .Cache.Oldest}}.{{end}}
+{{if not .Cache.TopicList.IsZero}}The list of pages was fetched on {{formatTime .Cache.TopicList}}.{{end}}</p>
+<h3>Recent incidents</h3>
+{{with .Incidents}}<ul>
+{{range .}}<li>{{formatTime .Start}}{{if .End.IsZero}}, ongoing for {{.Duration}}{{else}}, for {{.Duration}}{{end}}: {{.Error}}</li>
+{{end}}</ul>
+{{else}}<p>There were no incidents recently.</p>{{end}}
+<p>This status is also available <a href="/api/v1/status">as JSON</a>.</p>
+`))
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"golang.org/x/net/html"
+)
+
+// StripRule removes boilerplate from topic content, such as the "For
+// questions and comments" paragraphs pasted at the end of wiki posts.
+type StripRule struct {
+	// Pattern is a regular expression whose matches in the content HTML
+	// are removed.
+	Pattern string `json:"pattern"`
+
+	// Selector is a CSS selector whose matching elements are removed.
+	Selector string `json:"selector"`
+
+	// Contains limits a selector rule to elements whose text matches
+	// this regular expression.
+	Contains string `json:"contains"`
+
+	// Following also removes everything after a removed element within
+	// its parent.
+	Following bool `json:"following"`
+
+	pattern  *regexp.Regexp
+	selector selector
+	contains *regexp.Regexp
+}
+
+func (r *StripRule) compile() error {
+	var err error
+	if (r.Pattern == "") == (r.Selector == "") {
+		return fmt.Errorf("strip rule must have either a pattern or a selector")
+	}
+	if r.Pattern != "" {
+		if r.Contains != "" || r.Following {
+			return fmt.Errorf("strip rule with pattern %q cannot use contains or following", r.Pattern)
+		}
+		r.pattern, err = regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid strip pattern %q: %v", r.Pattern, err)
+		}
+		return nil
+	}
+	r.selector, err = parseSelector(r.Selector)
+	if err != nil {
+		return err
+	}
+	if r.Contains != "" {
+		r.contains, err = regexp.Compile(r.Contains)
+		if err != nil {
+			return fmt.Errorf("invalid strip contains pattern %q: %v", r.Contains, err)
+		}
+	}
+	return nil
+}
+
+// stripContent removes the matches of the configured strip patterns
+// from content. Selector rules are applied as content passes.
+func stripContent(content string) string {
+	for _, rule := range config.Strip {
+		if rule.pattern != nil {
+			content = rule.pattern.ReplaceAllString(content, "")
+		}
+	}
+	return content
+}
+
+func (r *StripRule) pass(root *html.Node) {
+	for _, n := range selectElements(root, r.selector, r.contains) {
+		if n.Parent == nil {
+			continue // Removed with an earlier match.
+		}
+		for r.Following && n.NextSibling != nil {
+			n.Parent.RemoveChild(n.NextSibling)
+		}
+		n.Parent.RemoveChild(n)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// svgElements are the elements kept in sanitized SVGs, by lowercase name.
+// Other elements are removed with all their content.
+var svgElements = stringSet(
+	"svg", "g", "defs", "symbol", "use", "title", "desc", "switch", "view", "style",
+	"a", "image", "path", "rect", "circle", "ellipse", "line", "polyline", "polygon",
+	"text", "tspan", "textpath",
+	"lineargradient", "radialgradient", "stop", "pattern", "clippath", "mask", "marker",
+	"filter", "feblend", "fecolormatrix", "fecomponenttransfer", "fecomposite",
+	"feconvolvematrix", "fediffuselighting", "fedisplacementmap", "fedistantlight",
+	"fedropshadow", "feflood", "fefunca", "fefuncb", "fefuncg", "fefuncr",
+	"fegaussianblur", "feimage", "femerge", "femergenode", "femorphology", "feoffset",
+	"fepointlight", "fespecularlighting", "fespotlight", "fetile", "feturbulence",
+	"animate", "animatemotion", "animatetransform", "set", "mpath",
+)
+
+// svgAnimations are the elements that change the attributes of others.
+var svgAnimations = stringSet("animate", "animatemotion", "animatetransform", "set")
+
+// svgAttrs are the attributes without a namespace kept in sanitized SVGs,
+// by lowercase name.
+var svgAttrs = stringSet(
+	"id", "class", "style", "lang", "version", "role", "href",
+	"aria-label", "aria-labelledby", "aria-describedby", "aria-hidden",
+	"x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "fx", "fy", "fr",
+	"width", "height", "d", "points", "pathlength", "viewbox", "preserveaspectratio", "transform",
+	"fill", "fill-opacity", "fill-rule", "stroke", "stroke-width", "stroke-linecap",
+	"stroke-linejoin", "stroke-miterlimit", "stroke-dasharray", "stroke-dashoffset",
+	"stroke-opacity", "opacity", "color", "display", "visibility", "overflow",
+	"clip-path", "clip-rule", "mask", "filter", "marker-start", "marker-mid", "marker-end",
+	"paint-order", "vector-effect", "shape-rendering", "text-rendering", "image-rendering",
+	"color-interpolation", "color-interpolation-filters", "mix-blend-mode", "isolation",
+	"font-family", "font-size", "font-weight", "font-style", "font-variant", "font-stretch",
+	"text-anchor", "dominant-baseline", "alignment-baseline", "baseline-shift",
+	"letter-spacing", "word-spacing", "text-decoration", "writing-mode", "direction",
+	"dx", "dy", "rotate", "textlength", "lengthadjust", "startoffset", "method", "spacing", "side",
+	"offset", "stop-color", "stop-opacity", "gradientunits", "gradienttransform", "spreadmethod",
+	"patternunits", "patterncontentunits", "patterntransform", "clippathunits",
+	"maskunits", "maskcontentunits", "markerunits", "markerwidth", "markerheight",
+	"refx", "refy", "orient", "filterunits", "primitiveunits",
+	"in", "in2", "result", "stddeviation", "mode", "operator", "k1", "k2", "k3", "k4",
+	"values", "type", "tablevalues", "slope", "intercept", "amplitude", "exponent",
+	"flood-color", "flood-opacity", "lighting-color", "surfacescale", "diffuseconstant",
+	"specularconstant", "specularexponent", "kernelmatrix", "kernelunitlength", "order",
+	"divisor", "bias", "targetx", "targety", "edgemode", "preservealpha",
+	"scale", "xchannelselector", "ychannelselector", "basefrequency", "numoctaves", "seed",
+	"stitchtiles", "azimuth", "elevation", "pointsatx", "pointsaty", "pointsatz",
+	"limitingconeangle", "radius",
+	"attributename", "attributetype", "from", "to", "by", "begin", "dur", "end",
+	"min", "max", "restart", "repeatcount", "repeatdur", "calcmode", "keytimes",
+	"keysplines", "keypoints", "additive", "accumulate", "path",
+)
+
+// svgNamespaces are the namespace declarations kept in sanitized SVGs.
+var svgNamespaces = map[string]string{
+	"xmlns":       "http://www.w3.org/2000/svg",
+	"xmlns:xlink": "http://www.w3.org/1999/xlink",
+}
+
+var svgUnsafeStyle = regexp.MustCompile(`(?i)@import|javascript:|expression\s*\(|url\(\s*['"]?\s*(?:https?:|//|javascript:)`)
+
+func stringSet(items ...string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// sanitizeSVG returns a copy of the SVG document in data keeping only
+// known drawing elements and attributes, without links to scripts or to
+// other sites, animations of links, comments, and document type
+// declarations. Invalid documents are rejected.
+func sanitizeSVG(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var open []xml.Name
+	drop := 0
+	root := false
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SVG: %v", err)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			open = append(open, token.Name)
+			if drop > 0 || !safeSVGElement(token) {
+				drop++
+				continue
+			}
+			if !root {
+				if token.Name.Local != "svg" {
+					return nil, fmt.Errorf("invalid SVG: root element is %s", token.Name.Local)
+				}
+				root = true
+			}
+			buf.WriteString("<" + xmlName(token.Name))
+			for _, attr := range token.Attr {
+				if !safeSVGAttr(attr) {
+					continue
+				}
+				buf.WriteString(" " + xmlName(attr.Name) + `="`)
+				xml.EscapeText(&buf, []byte(attr.Value))
+				buf.WriteString(`"`)
+			}
+			buf.WriteString(">")
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != token.Name {
+				return nil, fmt.Errorf("invalid SVG: unexpected end of %s", xmlName(token.Name))
+			}
+			open = open[:len(open)-1]
+			if drop > 0 {
+				drop--
+				continue
+			}
+			buf.WriteString("</" + xmlName(token.Name) + ">")
+		case xml.CharData:
+			if drop == 0 && root {
+				if svgUnsafeStyle.MatchString(svgNormalize(string(token))) {
+					continue
+				}
+				xml.EscapeText(&buf, token)
+			}
+		case xml.ProcInst:
+			if token.Target == "xml" && !root {
+				buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+			}
+		}
+		// Comments and directives, including DOCTYPE with its entities, are dropped.
+	}
+	if len(open) > 0 {
+		return nil, fmt.Errorf("invalid SVG: unclosed %s", xmlName(open[len(open)-1]))
+	}
+	if !root {
+		return nil, fmt.Errorf("invalid SVG: no svg element")
+	}
+	return buf.Bytes(), nil
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// svgNormalize returns value in lower case without whitespace and control
+// characters, which browsers ignore within URLs.
+func svgNormalize(value string) string {
+	return strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value))
+}
+
+func safeSVGElement(element xml.StartElement) bool {
+	local := strings.ToLower(element.Name.Local)
+	if element.Name.Space != "" || !svgElements[local] {
+		return false
+	}
+	if svgAnimations[local] {
+		// Animations could turn links into scripts after sanitizing.
+		for _, attr := range element.Attr {
+			if strings.ToLower(attr.Name.Local) != "attributename" {
+				continue
+			}
+			target := svgNormalize(attr.Value)
+			if target == "href" || strings.HasSuffix(target, ":href") || strings.HasPrefix(target, "on") || target == "style" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func safeSVGAttr(attr xml.Attr) bool {
+	local := strings.ToLower(attr.Name.Local)
+	name := local
+	if attr.Name.Space != "" {
+		name = strings.ToLower(attr.Name.Space) + ":" + local
+	}
+	if ns, ok := svgNamespaces[name]; ok {
+		return attr.Value == ns
+	}
+	switch name {
+	case "xlink:href", "xlink:title", "xml:space", "xml:lang":
+	default:
+		if attr.Name.Space != "" || !svgAttrs[name] {
+			return false
+		}
+	}
+	value := svgNormalize(attr.Value)
+	if local == "href" {
+		// Only references within the document and embedded images.
+		return strings.HasPrefix(value, "#") || strings.HasPrefix(value, "data:image/") && !strings.HasPrefix(value, "data:image/svg")
+	}
+	return !svgUnsafeStyle.MatchString(value)
+}
+
+var inlineSVG = regexp.MustCompile(`(?s)<svg\b.*?</svg>`)
+
+// sanitizeInlineSVGs sanitizes the SVG documents inlined in content,
+// dropping the ones that cannot be sanitized.
+func sanitizeInlineSVGs(content string) string {
+	return inlineSVG.ReplaceAllStringFunc(content, func(svg string) string {
+		data, err := sanitizeSVG([]byte(svg))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	})
+}
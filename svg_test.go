@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+)
+
+var sanitizeSVGTests = []struct {
+	summary string
+	svg     string
+	result  string // Empty when the document is rejected.
+}{{
+	summary: "Drawing",
+	svg:     `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><g fill="url(#a)"><rect x="1" y="1" width="8" height="8"/></g></svg>`,
+	result:  `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"><g fill="url(#a)"><rect x="1" y="1" width="8" height="8"></rect></g></svg>`,
+}, {
+	summary: "XML declaration",
+	svg:     `<?xml version="1.0" encoding="utf-8" standalone="no"?><svg><title>a &amp; b</title></svg>`,
+	result:  `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<svg><title>a &amp; b</title></svg>`,
+}, {
+	summary: "Script",
+	svg:     `<svg><script>alert(1)</script><SCRIPT>alert(2)</SCRIPT><circle r="1"/></svg>`,
+	result:  `<svg><circle r="1"></circle></svg>`,
+}, {
+	summary: "Foreign object",
+	svg:     `<svg><foreignObject><body xmlns="http://www.w3.org/1999/xhtml"><iframe src="x"/></body></foreignObject></svg>`,
+	result:  `<svg></svg>`,
+}, {
+	summary: "Unknown and namespaced elements",
+	svg:     `<svg><blink>a</blink><html:script xmlns:html="http://www.w3.org/1999/xhtml">b</html:script></svg>`,
+	result:  `<svg></svg>`,
+}, {
+	summary: "Event handlers",
+	svg:     `<svg onload="alert(1)"><rect ONCLICK="alert(2)" onmouseover="alert(3)" width="1"/></svg>`,
+	result:  `<svg><rect width="1"></rect></svg>`,
+}, {
+	summary: "Unknown and namespaced attributes",
+	svg:     `<svg xmlns:ev="http://www.w3.org/2001/xml-events" xmlns:xlink="http://www.w3.org/1999/xlink"><rect ev:event="click" formaction="x" cursor="url(https://example.com/c)" xml:space="preserve"/></svg>`,
+	result:  `<svg xmlns:xlink="http://www.w3.org/1999/xlink"><rect xml:space="preserve"></rect></svg>`,
+}, {
+	summary: "Other default namespace",
+	svg:     `<svg xmlns="http://www.w3.org/1999/xhtml"></svg>`,
+	result:  `<svg></svg>`,
+}, {
+	summary: "Links",
+	svg:     `<svg><a href="#x"><use xlink:href="#y"/></a><a href="https://example.com/"/><use href="other.svg#z"/></svg>`,
+	result:  `<svg><a href="#x"><use xlink:href="#y"></use></a><a></a><use></use></svg>`,
+}, {
+	summary: "Embedded images",
+	svg:     `<svg><image href="data:image/png;base64,AAAA"/><image href="data:image/svg+xml;base64,AAAA"/><image href="data:text/html,x"/></svg>`,
+	result:  `<svg><image href="data:image/png;base64,AAAA"></image><image></image><image></image></svg>`,
+}, {
+	summary: "Javascript link",
+	svg:     `<svg><a href="javascript:alert(1)">x</a><a xlink:href="JavaScript:alert(2)">y</a></svg>`,
+	result:  `<svg><a>x</a><a>y</a></svg>`,
+}, {
+	summary: "Entity-obfuscated javascript link",
+	svg:     `<svg><a href="&#106;avascript&#58;alert(1)">x</a><a href="java&#x09;script:alert(2)">y</a></svg>`,
+	result:  `<svg><a>x</a><a>y</a></svg>`,
+}, {
+	summary: "Whitespace-obfuscated javascript link",
+	svg:     "<svg><a href=\" java\tscript:alert(1)\">x</a><a href=\"java\nscript:alert(2)\">y</a></svg>",
+	result:  `<svg><a>x</a><a>y</a></svg>`,
+}, {
+	summary: "Control characters",
+	svg:     "<svg><a href=\"\x01javascript:alert(1)\">x</a></svg>",
+	result:  "",
+}, {
+	summary: "Animated link",
+	svg:     `<svg><a href="#x"><set attributeName="href" to="java&#9;script:alert(1)"/><animate attributeName="xlink:href" values="javascript:alert(2)"/>z</a></svg>`,
+	result:  `<svg><a href="#x">z</a></svg>`,
+}, {
+	summary: "Animated link without javascript",
+	svg:     `<svg><a href="#x"><set attributeName=" HREF " to="https://example.com/"/>z</a></svg>`,
+	result:  `<svg><a href="#x">z</a></svg>`,
+}, {
+	summary: "Animated handler",
+	svg:     `<svg><rect><set attributeName="onclick" to="alert(1)"/><animate attributeName="style" values="x"/></rect></svg>`,
+	result:  `<svg><rect></rect></svg>`,
+}, {
+	summary: "Animated drawing",
+	svg:     `<svg><rect><animate attributeName="width" from="1" to="2" dur="1s"/></rect></svg>`,
+	result:  `<svg><rect><animate attributeName="width" from="1" to="2" dur="1s"></animate></rect></svg>`,
+}, {
+	summary: "Styles",
+	svg:     `<svg><style>@import url(https://example.com/x.css);</style><style>rect { fill: red }</style><rect style="fill: url( https://example.com/p )"/></svg>`,
+	result:  `<svg><style></style><style>rect { fill: red }</style><rect></rect></svg>`,
+}, {
+	summary: "Comments",
+	svg:     `<svg><!-- <script>alert(1)</script> --></svg>`,
+	result:  `<svg></svg>`,
+}, {
+	summary: "DOCTYPE entities",
+	svg:     `<!DOCTYPE svg [<!ENTITY js "javascript:alert(1)">]><svg><a href="&js;">x</a></svg>`,
+	result:  "",
+}, {
+	summary: "DOCTYPE without entities",
+	svg:     `<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd"><svg></svg>`,
+	result:  `<svg></svg>`,
+}, {
+	summary: "Other root element",
+	svg:     `<html><svg></svg></html>`,
+	result:  "",
+}, {
+	summary: "Mismatched end",
+	svg:     `<svg><rect></svg>`,
+	result:  "",
+}, {
+	summary: "Mismatched end in dropped element",
+	svg:     `<svg><script></rect>alert(1)</script></svg>`,
+	result:  "",
+}, {
+	summary: "Unclosed element",
+	svg:     `<svg><g>`,
+	result:  "",
+}}
+
+func TestSanitizeSVG(t *testing.T) {
+	for _, test := range sanitizeSVGTests {
+		result, err := sanitizeSVG([]byte(test.svg))
+		if test.result == "" {
+			if err == nil {
+				t.Errorf("%s: sanitizeSVG accepted %s as %s", test.summary, test.svg, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: sanitizeSVG failed: %v", test.summary, err)
+		} else if string(result) != test.result {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.summary, result, test.result)
+		}
+	}
+}
+
+func TestSanitizeInlineSVGs(t *testing.T) {
+	content := `<p>a</p><svg><script>alert(1)</script></svg><p>b</p><svg><rect></svg>`
+	if result := sanitizeInlineSVGs(content); result != `<p>a</p><svg></svg><p>b</p>` {
+		t.Errorf("sanitizeInlineSVGs returned %q", result)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	taskItemBox = regexp.MustCompile(`<li>(\s*(?:<p>)?\s*)(<input [^>]*type="checkbox"[^>]*>|<span class="chcklst-box[^"]*"></span>)\s*`)
+	taskChecked = regexp.MustCompile(`\bchecked\b`)
+)
+
+// taskBox returns the markup of a task list checkbox.
+func taskBox(checked bool) string {
+	if checked {
+		return `<span class="task-box checked" role="img" aria-label="Done">&#x2713;</span>`
+	}
+	return `<span class="task-box" role="img" aria-label="Not done"></span>`
+}
+
+// renderTaskLists replaces the checkboxes of forum checklists, which are
+// disabled inputs or icon font placeholders, with boxes styled by the
+// page, and marks their items so they are shown without bullets.
+func renderTaskLists(content string) string {
+	if !strings.Contains(content, "chcklst-box") && !strings.Contains(content, `type="checkbox"`) {
+		return content
+	}
+	return taskItemBox.ReplaceAllStringFunc(content, func(item string) string {
+		m := taskItemBox.FindStringSubmatch(item)
+		return `<li class="task-item">` + m[1] + taskBox(taskChecked.MatchString(m[2])) + " "
+	})
+}
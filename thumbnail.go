@@ -0,0 +1,92 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const thumbnailWidth = 400
+
+var contentImage = regexp.MustCompile(`<img\b[^>]*?\ssrc="([^"]+)"[^>]*>`)
+
+// firstImage returns the URL of the first image in content that is not
+// an emoji, or an empty string if there is none.
+func firstImage(content string) string {
+	for _, m := range contentImage.FindAllStringSubmatch(content, -1) {
+		if strings.Contains(m[0], `class="emoji`) {
+			continue
+		}
+		u, err := url.Parse(html.UnescapeString(m[1]))
+		if err != nil {
+			continue
+		}
+		return forumBaseURL.ResolveReference(u).String()
+	}
+	return ""
+}
+
+var forumBaseURL = &url.URL{Scheme: "https", Host: "forum.snapcraft.io", Path: "/"}
+
+// Thumbnail returns the path of a small version of the first image in
+// the topic, or an empty string if it has no images or thumbnails are
+// disabled.
+func (t *Topic) Thumbnail() string {
+	if !*thumbnailsFlag || t.image == "" {
+		return ""
+	}
+	return "/thumbnail/" + strconv.Itoa(t.ID)
+}
+
+// ThumbnailURL returns the absolute URL of the topic thumbnail, if it
+// has one and the site's base URL is known.
+func (t *Topic) ThumbnailURL() string {
+	if *baseURLFlag == "" || t.Thumbnail() == "" {
+		return ""
+	}
+	return strings.TrimSuffix(*baseURLFlag, "/") + t.Thumbnail()
+}
+
+// thumbnailURL returns the absolute URL of the thumbnail at path, which
+// may be empty.
+func thumbnailURL(req *http.Request, path string) string {
+	if path == "" {
+		return ""
+	}
+	return siteURL(req, path)
+}
+
+var thumbnailPattern = regexp.MustCompile(`^/thumbnail/([0-9]+)$`)
+
+// serveThumbnail serves a small version of the first image of the
+// documentation page whose ID is in the path.
+func serveThumbnail(resp http.ResponseWriter, req *http.Request) {
+	m := thumbnailPattern.FindStringSubmatch(req.URL.Path)
+	if m == nil {
+		sendNotFound(resp, "Invalid thumbnail path: %s", req.URL.Path)
+		return
+	}
+	topic, err := forum.TopicWithin("/"+m[1], requestBudget(req))
+	if err != nil && sendForumError(resp, err) {
+		return
+	}
+	if err != nil || !isDocCategory(topic.Category) || topic.image == "" {
+		sendNotFound(resp, "No thumbnail for page %s.", m[1])
+		return
+	}
+	u, err := url.Parse(topic.image)
+	if err != nil || !imageProxyAllowed(u) {
+		sendNotFound(resp, "No thumbnail for page %s.", m[1])
+		return
+	}
+	resp.Header().Add("Vary", "Accept")
+	data, mediaType, err := proxyImage(u, thumbnailWidth, imageFormat(req))
+	if err != nil {
+		sendImageError(resp, u, err)
+		return
+	}
+	sendImage(resp, data, mediaType)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// titleStopWords are ignored when comparing titles.
+var titleStopWords = map[string]bool{"a": true, "an": true, "the": true, "to": true, "of": true, "and": true}
+
+// titleKey returns the form of title used to detect duplicates, in
+// lowercase, without punctuation, stop words, or plural endings.
+func titleKey(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var key []string
+	for _, word := range words {
+		if titleStopWords[word] {
+			continue
+		}
+		if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+			word = word[:len(word)-1]
+		}
+		key = append(key, word)
+	}
+	return strings.Join(key, " ")
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cur[j] = prev[j-1]
+			if ra[i-1] != rb[j-1] {
+				cur[j]++
+			}
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// similarTitles returns whether two title keys are close enough to
+// confuse readers: equal, or within a couple of typos for longer ones.
+func similarTitles(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if len(a) < 12 || len(b) < 12 {
+		return false
+	}
+	return editDistance(a, b) <= 2
+}
+
+// duplicateTitles returns groups of documentation topics with identical
+// or near-identical titles.
+func duplicateTitles() ([][]*Topic, error) {
+	topics, err := forum.Topics()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(topics))
+	for i, topic := range topics {
+		keys[i] = titleKey(topic.Title)
+	}
+	grouped := make([]bool, len(topics))
+	var groups [][]*Topic
+	for i := range topics {
+		if grouped[i] || isIndex(topics[i]) {
+			continue
+		}
+		group := []*Topic{topics[i]}
+		for j := i + 1; j < len(topics); j++ {
+			if !grouped[j] && !isIndex(topics[j]) && similarTitles(keys[i], keys[j]) {
+				grouped[j] = true
+				group = append(group, topics[j])
+			}
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i][0].Title) < strings.ToLower(groups[j][0].Title)
+	})
+	return groups, nil
+}
+
+func serveDuplicates(resp http.ResponseWriter, req *http.Request) {
+	groups, err := duplicateTitles()
+	if err != nil {
+		log.Printf("Cannot send %s to %s: %v", req.URL, req.RemoteAddr, err)
+		resp.WriteHeader(http.StatusBadGateway)
+		resp.Write([]byte("Cannot check titles: " + err.Error()))
+		return
+	}
+	var buf bytes.Buffer
+	err = duplicatesTemplate.Execute(&buf, groups)
+	if err != nil {
+		log.Printf("Cannot execute duplicates template: %v", err)
+	}
+	resp.Header().Set("Content-Type", "text/html")
+	renderPage(resp, req, &pageData{Title: "Duplicate titles", Content: buf.String()})
+}
+
+var duplicatesTemplate = template.Must(template.New("duplicates").Parse(`
+{{if .}}
+<p>These documentation pages have identical or near-identical titles, which confuse search and navigation.</p>
+<table class="duplicates">
+<thead><tr><th>Pages</th><th>On the forum</th></tr></thead>
+<tbody>
+{{range .}}<tr><td>{{range $i, $t := .}}{{if $i}}<br>{{end}}<a href="{{$t}}">{{$t.Title}}</a>{{end}}</td><td>{{range $i, $t := .}}{{if $i}}<br>{{end}}<a href="{{$t.ForumURL}}">{{$t.ForumURL}}</a>{{end}}</td></tr>
+{{end}}
+</tbody>
+</table>
+{{else}}
+<p>No documentation pages have duplicate titles.</p>
+{{end}}
+`))
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager returns the manager obtaining certificates for the -domains
+// from Let's Encrypt. Challenges are answered via TLS-ALPN-01 on the HTTPS
+// listener, and via HTTP-01 at the -acme-http address if one is set.
+func acmeManager() *autocert.Manager {
+	domains := append([]string{"localhost"}, strings.Split(*domainsFlag, ",")...)
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       acmeCache(),
+		RenewBefore: 24 * 30 * time.Hour,
+		HostPolicy:  autocert.HostWhitelist(domains...),
+		Email:       "gustavo@niemeyer.net",
+	}
+}
+
+// HostCertificate is a certificate served for a specific host name.
+type HostCertificate struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+
+	cert *tls.Certificate
+}
+
+func (c *HostCertificate) init(host string) error {
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate for %s: %v", host, err)
+	}
+	c.cert = &cert
+	return nil
+}
+
+// hostCertificate returns the configured certificate for the host name,
+// which may also match a "*.example.com" entry, or nil if there is none.
+func hostCertificate(host string) *tls.Certificate {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if c, ok := config.Certificates[host]; ok {
+		return c.cert
+	}
+	if i := strings.Index(host, "."); i >= 0 {
+		if c, ok := config.Certificates["*"+host[i:]]; ok {
+			return c.cert
+		}
+	}
+	return nil
+}
+
+// withHostCertificates returns a copy of base that serves the configured
+// host certificates, leaving other host names to base.
+func withHostCertificates(base *tls.Config) *tls.Config {
+	if len(config.Certificates) == 0 {
+		return base
+	}
+	if base == nil {
+		base = &tls.Config{}
+	}
+	c := base.Clone()
+	next := base.GetCertificate
+	c.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert := hostCertificate(hello.ServerName); cert != nil {
+			return cert, nil
+		}
+		if next != nil {
+			return next(hello)
+		}
+		// Fall back to the -cert certificate.
+		return nil, nil
+	}
+	return c
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"html"
+	"regexp"
+)
+
+// tocMinEntries is the number of headings a page needs for a table of
+// contents to be worth showing.
+const tocMinEntries = 3
+
+var tocHeading = regexp.MustCompile(`(?s)<h([23])[^>]*>(.*?)</h[23]>`)
+
+type tocEntry struct {
+	Level  int
+	Title  string
+	Anchor string
+}
+
+// TOC returns the table of contents of the article, listing its second
+// and third level headings that have anchors. Pages with the hide-toc
+// body class keep it hidden.
+func (d *pageData) TOC() []*tocEntry {
+	if d.Topic == nil || isIndex(d.Topic) {
+		return nil
+	}
+	var entries []*tocEntry
+	for _, m := range tocHeading.FindAllStringSubmatch(d.Content, -1) {
+		a := anchorPattern.FindStringSubmatch(m[2])
+		title := plainText(m[2])
+		if a == nil || title == "" {
+			continue
+		}
+		entries = append(entries, &tocEntry{Level: int(m[1][0] - '0'), Title: title, Anchor: html.UnescapeString(a[1])})
+	}
+	if len(entries) < tocMinEntries {
+		return nil
+	}
+	return entries
+}
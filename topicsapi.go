@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const topicsAPIPageSize = 100
+
+type topicsAPIResult struct {
+	Topics []*topicsAPITopic `json:"topics"`
+	Total  int               `json:"total"`
+	Page   int               `json:"page"`
+	Pages  int               `json:"pages"`
+	Next   string            `json:"next,omitempty"`
+}
+
+type topicsAPITopic struct {
+	ID       int      `json:"id"`
+	Slug     string   `json:"slug"`
+	Title    string   `json:"title"`
+	URL      string   `json:"url"`
+	ForumURL string   `json:"forum_url"`
+	Section  string   `json:"section,omitempty"`
+	Category int      `json:"category"`
+	Tags     []string `json:"tags,omitempty"`
+	Created  string   `json:"created"`
+	Updated  string   `json:"updated"`
+}
+
+// outlineSectionTitles maps the IDs of the topics in the outline to the
+// title of the first section listing them, as far as budget allows.
+func outlineSectionTitles(budget *upstreamBudget) map[int]string {
+	sections := make(map[int]string)
+	for _, section := range outlineSections(indexOutline(budget)) {
+		for _, id := range section.TopicIDs {
+			if _, ok := sections[id]; !ok {
+				sections[id] = section.Title
+			}
+		}
+	}
+	return sections
+}
+
+// parseAPITime parses a time given to the API as RFC 3339 or as a date.
+func parseAPITime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, must be RFC 3339 or YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+// serveTopicsAPI lists the known documentation topics as JSON, in pages,
+// optionally filtered by outline section, tag, and last update.
+func serveTopicsAPI(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Access-Control-Allow-Origin", "*")
+
+	section := req.Form.Get("section")
+	tag := req.Form.Get("tag")
+	var updatedAfter time.Time
+	if s := req.Form.Get("updated_after"); s != "" {
+		var err error
+		updatedAfter, err = parseAPITime(s)
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			resp.Write([]byte(err.Error()))
+			return
+		}
+	}
+	page := 1
+	if s := req.Form.Get("page"); s != "" {
+		var err error
+		page, err = strconv.Atoi(s)
+		if err != nil || page < 1 {
+			resp.WriteHeader(http.StatusBadRequest)
+			resp.Write([]byte("invalid page " + strconv.Quote(s)))
+			return
+		}
+	}
+
+	budget := requestBudget(req)
+	topics, err := forum.TopicsWithin(budget)
+	if err != nil {
+		log.Printf("Cannot list topics: %v", err)
+		if !sendForumError(resp, err) {
+			resp.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	sections := outlineSectionTitles(budget)
+
+	var matched []*topicsAPITopic
+	for _, topic := range topics {
+		if section != "" && !strings.EqualFold(sections[topic.ID], section) {
+			continue
+		}
+		if tag != "" && !hasTag(topic, tag) {
+			continue
+		}
+		if !updatedAfter.IsZero() && !topic.BumpedAt.After(updatedAfter) {
+			continue
+		}
+		matched = append(matched, &topicsAPITopic{
+			ID:       topic.ID,
+			Slug:     topic.Slug,
+			Title:    topic.Title,
+			URL:      siteURL(req, topic.String()),
+			ForumURL: topic.ForumURL(),
+			Section:  sections[topic.ID],
+			Category: topic.Category,
+			Tags:     topic.Tags,
+			Created:  formatTime(topic.CreatedAt),
+			Updated:  formatTime(topic.BumpedAt),
+		})
+	}
+
+	result := &topicsAPIResult{
+		Topics: []*topicsAPITopic{},
+		Total:  len(matched),
+		Page:   page,
+		Pages:  (len(matched) + topicsAPIPageSize - 1) / topicsAPIPageSize,
+	}
+	if start := (page - 1) * topicsAPIPageSize; start < len(matched) {
+		end := start + topicsAPIPageSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		result.Topics = matched[start:end]
+	}
+	if page < result.Pages {
+		query := req.URL.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		result.Next = siteURL(req, req.URL.Path+"?"+query.Encode())
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Cannot marshal topic list: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
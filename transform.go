@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// contentPass is a transformation of the parsed content of a topic. Each
+// pass works on the whole tree so it may be applied and checked alone.
+type contentPass func(root *html.Node)
+
+// contentPasses returns the passes applied to topic content: the selector
+// strip rules, the URL rewrites, and then the configured transforms.
+func contentPasses() []contentPass {
+	var passes []contentPass
+	for _, rule := range config.Strip {
+		if rule.selector != nil {
+			passes = append(passes, rule.pass)
+		}
+	}
+	passes = append(passes, rewritePass)
+	for _, rule := range config.Transforms {
+		passes = append(passes, rule.pass)
+	}
+	if *privacyStrictFlag {
+		passes = append(passes, privacyPass)
+	}
+	return passes
+}
+
+// transformContent parses the HTML fragment in content, applies passes to
+// it, and renders it back.
+func transformContent(content string, passes ...contentPass) string {
+	context := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		log.Printf("Cannot parse content to transform: %v", err)
+		return content
+	}
+	root := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	for _, pass := range passes {
+		pass(root)
+	}
+	var buf strings.Builder
+	for n := root.FirstChild; n != nil; n = n.NextSibling {
+		if err := html.Render(&buf, n); err != nil {
+			log.Printf("Cannot render transformed content: %v", err)
+			return content
+		}
+	}
+	return buf.String()
+}
+
+// selectElements returns the elements within root matching sel whose text
+// matches contains, if set, leaving out those within other matches.
+func selectElements(root *html.Node, sel selector, contains *regexp.Regexp) []*html.Node {
+	var matches []*html.Node
+	walkElements(root, func(n *html.Node) bool {
+		if n == root || !sel.match(n) {
+			return true
+		}
+		if contains != nil && !contains.MatchString(strings.TrimSpace(nodeText(n))) {
+			return true
+		}
+		matches = append(matches, n)
+		return false
+	})
+	return matches
+}
+
+// walkElements calls f for n and the elements within it, in document
+// order, skipping the children of elements for which f returns false.
+func walkElements(n *html.Node, f func(*html.Node) bool) {
+	if n.Type == html.ElementNode && !f(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		walkElements(c, f)
+		c = next
+	}
+}
+
+// TransformRule changes the elements of topic content matching a CSS
+// selector.
+type TransformRule struct {
+	Selector string `json:"selector"`
+
+	// Action is one of "remove", "unwrap", "wrap", "add-class",
+	// "set-attribute", or "remove-attribute".
+	Action string `json:"action"`
+
+	// Contains limits the rule to elements whose text matches this
+	// regular expression.
+	Contains string `json:"contains"`
+
+	// Element wraps the matching elements for the "wrap" action, as a
+	// tag name optionally followed by classes, like "div.table-wrapper".
+	Element string `json:"element"`
+
+	// Attribute and Value are the attribute set or removed, or the class
+	// added as Value.
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+
+	selector  selector
+	contains  *regexp.Regexp
+	wrapTag   string
+	wrapClass string
+}
+
+var transformElement = regexp.MustCompile(`^([a-z][a-z0-9]*)((?:\.[a-zA-Z0-9_-]+)*)$`)
+
+func (r *TransformRule) compile() error {
+	var err error
+	r.selector, err = parseSelector(r.Selector)
+	if err != nil {
+		return err
+	}
+	if r.Contains != "" {
+		r.contains, err = regexp.Compile(r.Contains)
+		if err != nil {
+			return fmt.Errorf("invalid transform contains pattern %q: %v", r.Contains, err)
+		}
+	}
+	switch r.Action {
+	case "remove", "unwrap":
+	case "wrap":
+		m := transformElement.FindStringSubmatch(r.Element)
+		if m == nil {
+			return fmt.Errorf("invalid wrapping element %q for %s", r.Element, r.Selector)
+		}
+		r.wrapTag = m[1]
+		r.wrapClass = strings.TrimSpace(strings.Replace(m[2], ".", " ", -1))
+	case "add-class":
+		if r.Value == "" {
+			return fmt.Errorf("transform of %s adds no class", r.Selector)
+		}
+	case "set-attribute", "remove-attribute":
+		if r.Attribute == "" || strings.HasPrefix(strings.ToLower(r.Attribute), "on") {
+			return fmt.Errorf("invalid attribute %q for transform of %s", r.Attribute, r.Selector)
+		}
+	default:
+		return fmt.Errorf("invalid transform action %q for %s", r.Action, r.Selector)
+	}
+	return nil
+}
+
+func (r *TransformRule) pass(root *html.Node) {
+	for _, n := range selectElements(root, r.selector, r.contains) {
+		switch r.Action {
+		case "remove":
+			n.Parent.RemoveChild(n)
+		case "unwrap":
+			for n.FirstChild != nil {
+				c := n.FirstChild
+				n.RemoveChild(c)
+				n.Parent.InsertBefore(c, n)
+			}
+			n.Parent.RemoveChild(n)
+		case "wrap":
+			wrapper := &html.Node{Type: html.ElementNode, Data: r.wrapTag, DataAtom: atom.Lookup([]byte(r.wrapTag))}
+			if r.wrapClass != "" {
+				wrapper.Attr = []html.Attribute{{Key: "class", Val: r.wrapClass}}
+			}
+			n.Parent.InsertBefore(wrapper, n)
+			n.Parent.RemoveChild(n)
+			wrapper.AppendChild(n)
+		case "add-class":
+			classes := strings.Fields(nodeAttr(n, "class"))
+			if !containsString(classes, r.Value) {
+				setNodeAttr(n, "class", strings.Join(append(classes, r.Value), " "))
+			}
+		case "set-attribute":
+			setNodeAttr(n, r.Attribute, r.Value)
+		case "remove-attribute":
+			removeNodeAttr(n, r.Attribute)
+		}
+	}
+}
+
+func setNodeAttr(n *html.Node, name, value string) {
+	for i, a := range n.Attr {
+		if a.Namespace == "" && a.Key == name {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: name, Val: value})
+}
+
+func removeNodeAttr(n *html.Node, name string) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		if a.Namespace != "" || a.Key != name {
+			attrs = append(attrs, a)
+		}
+	}
+	n.Attr = attrs
+}
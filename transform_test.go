@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var transformContentTests = []struct {
+	content string
+	result  string
+}{
+	{"", ""},
+	{"<p>text</p>", "<p>text</p>"},
+	{"plain <b>bold</b>", "plain <b>bold</b>"},
+	{"<p>unclosed", "<p>unclosed</p>"},
+	{"<table><tr><td>a</td></tr></table>", "<table><tbody><tr><td>a</td></tr></tbody></table>"},
+	{`<a href="x" title="a&amp;b">&lt;</a>`, `<a href="x" title="a&amp;b">&lt;</a>`},
+}
+
+func TestTransformContent(t *testing.T) {
+	for _, test := range transformContentTests {
+		if result := transformContent(test.content); result != test.result {
+			t.Errorf("transformContent(%q) = %q, want %q", test.content, result, test.result)
+		}
+	}
+}
+
+func TestTransformContentPasses(t *testing.T) {
+	// Passes apply in order, each seeing the result of the previous one.
+	var order []string
+	first := func(root *html.Node) {
+		order = append(order, "first")
+		root.AppendChild(&html.Node{Type: html.ElementNode, Data: "hr"})
+	}
+	second := func(root *html.Node) {
+		if root.LastChild == nil || root.LastChild.Data != "hr" {
+			t.Errorf("second pass doesn't see the first one")
+		}
+		order = append(order, "second")
+	}
+	if result := transformContent("<p>a</p>", first, second); result != "<p>a</p><hr/>" {
+		t.Errorf("transformContent returned %q", result)
+	}
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("passes applied in order %v", order)
+	}
+}
+
+var transformRuleTests = []struct {
+	rule    TransformRule
+	content string
+	result  string
+}{{
+	rule:    TransformRule{Selector: "div.note", Action: "remove"},
+	content: `<div class="note">a</div><div>b</div>`,
+	result:  `<div>b</div>`,
+}, {
+	rule:    TransformRule{Selector: "span", Action: "unwrap"},
+	content: `<p>a <span>b <em>c</em></span> d</p>`,
+	result:  `<p>a b <em>c</em> d</p>`,
+}, {
+	rule:    TransformRule{Selector: "table", Action: "wrap", Element: "div.table-wrapper.scroll"},
+	content: `<table><tbody><tr><td>a</td></tr></tbody></table>`,
+	result:  `<div class="table-wrapper scroll"><table><tbody><tr><td>a</td></tr></tbody></table></div>`,
+}, {
+	rule:    TransformRule{Selector: "p", Action: "add-class", Value: "lead"},
+	content: `<p>a</p><p class="x">b</p><p class="lead">c</p>`,
+	result:  `<p class="lead">a</p><p class="x lead">b</p><p class="lead">c</p>`,
+}, {
+	rule:    TransformRule{Selector: `a[href^="http"]`, Action: "set-attribute", Attribute: "rel", Value: "nofollow"},
+	content: `<a href="/local">a</a><a href="https://example.com" rel="x">b</a>`,
+	result:  `<a href="/local">a</a><a href="https://example.com" rel="nofollow">b</a>`,
+}, {
+	rule:    TransformRule{Selector: "img", Action: "remove-attribute", Attribute: "width"},
+	content: `<img src="a.png" width="10" height="10"/>`,
+	result:  `<img src="a.png" height="10"/>`,
+}, {
+	rule:    TransformRule{Selector: "p", Action: "remove", Contains: "^Note:"},
+	content: `<p>Note: obsolete</p><p>A Note: kept</p>`,
+	result:  `<p>A Note: kept</p>`,
+}, {
+	// Matches within matches are left to the outer one.
+	rule:    TransformRule{Selector: "div", Action: "wrap", Element: "section"},
+	content: `<div><div>a</div></div>`,
+	result:  `<section><div><div>a</div></div></section>`,
+}}
+
+func TestTransformRulePass(t *testing.T) {
+	for _, test := range transformRuleTests {
+		rule := test.rule
+		if err := rule.compile(); err != nil {
+			t.Errorf("cannot compile transform of %s: %v", rule.Selector, err)
+			continue
+		}
+		if result := transformContent(test.content, rule.pass); result != test.result {
+			t.Errorf("%s of %s in %s:\ngot  %s\nwant %s", rule.Action, rule.Selector, test.content, result, test.result)
+		}
+	}
+}
+
+var invalidTransformRules = []TransformRule{
+	{Selector: "p", Action: "explode"},
+	{Selector: "p >", Action: "remove"},
+	{Selector: "p", Action: "remove", Contains: "("},
+	{Selector: "p", Action: "wrap", Element: "div#id"},
+	{Selector: "p", Action: "add-class"},
+	{Selector: "p", Action: "set-attribute"},
+	{Selector: "p", Action: "set-attribute", Attribute: "onclick", Value: "x"},
+}
+
+func TestTransformRuleInvalid(t *testing.T) {
+	for _, rule := range invalidTransformRules {
+		if err := rule.compile(); err == nil {
+			t.Errorf("compiling %s transform of %q succeeded", rule.Action, rule.Selector)
+		}
+	}
+}
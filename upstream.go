@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errUpstreamBudget = forumErrorf(Unavailable, "cannot obtain documentation page: page budget exhausted")
+
+// upstreamBudget bounds the forum calls made while serving a page, so a
+// slow forum cannot stall it indefinitely. A nil budget is unlimited.
+type upstreamBudget struct {
+	mu       sync.Mutex
+	deadline time.Time
+	calls    int // Calls left, or -1 if unlimited.
+	crawl    bool
+	mirrored bool // Fetching for another instance, so mirrors are not asked.
+	routed   bool // Routed here by its hint, so other replicas are not asked.
+}
+
+// crawlBudget returns an unlimited budget for fetches made by crawls,
+// which are subject to the "crawl" upstream limits.
+func crawlBudget() *upstreamBudget {
+	return &upstreamBudget{calls: -1, crawl: true}
+}
+
+// mirrorBudget returns an unlimited budget for fetches made on behalf of
+// other instances, which must not be passed on to the mirrors.
+func mirrorBudget() *upstreamBudget {
+	return &upstreamBudget{calls: -1, mirrored: true}
+}
+
+// mayMirror reports whether pages may be fetched from the mirrors when
+// the forum fails.
+func (b *upstreamBudget) mayMirror() bool {
+	return b == nil || !b.mirrored
+}
+
+// cacheOnlyBudget returns a budget allowing no forum calls at all, so
+// that only cached copies are served.
+func cacheOnlyBudget() *upstreamBudget {
+	return &upstreamBudget{}
+}
+
+// requestBudget returns the budget for serving req. Bots are only served
+// cached copies, so they never wait on the forum nor make it work.
+func requestBudget(req *http.Request) *upstreamBudget {
+	if isBot(req) {
+		return cacheOnlyBudget()
+	}
+	return newUpstreamBudget()
+}
+
+type budgetKey struct{}
+
+// withBudget returns a context carrying budget, for code reached without
+// a request at hand, such as GraphQL resolvers.
+func withBudget(ctx context.Context, budget *upstreamBudget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// contextBudget returns the budget carried by ctx, or nil if none.
+func contextBudget(ctx context.Context) *upstreamBudget {
+	budget, _ := ctx.Value(budgetKey{}).(*upstreamBudget)
+	return budget
+}
+
+// newUpstreamBudget returns the budget for serving a page, or nil if
+// none is configured.
+func newUpstreamBudget() *upstreamBudget {
+	if *upstreamDeadlineFlag == 0 && *upstreamCallsFlag == 0 {
+		return nil
+	}
+	b := &upstreamBudget{calls: *upstreamCallsFlag}
+	if b.calls == 0 {
+		b.calls = -1
+	}
+	if *upstreamDeadlineFlag > 0 {
+		b.deadline = time.Now().Add(*upstreamDeadlineFlag)
+	}
+	return b
+}
+
+// spend reports whether another forum call fits in the budget, and
+// accounts for it if so.
+func (b *upstreamBudget) spend() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return false
+	}
+	if b.calls == 0 {
+		return false
+	}
+	if b.calls > 0 {
+		b.calls--
+	}
+	return true
+}
+
+// bounded reports whether callers must not wait indefinitely within the
+// budget, as it has a deadline or no calls left.
+func (b *upstreamBudget) bounded() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.deadline.IsZero() || b.calls == 0
+}
+
+// expired reports whether no more forum calls fit in the budget.
+func (b *upstreamBudget) expired() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls == 0 || !b.deadline.IsZero() && time.Now().After(b.deadline)
+}
+
+const lockPollInterval = 10 * time.Millisecond
+
+// lockWithin locks c.mu, waiting for it no longer than budget allows. It
+// reports whether the lock was taken.
+func (c *topicCache) lockWithin(budget *upstreamBudget) bool {
+	if !budget.bounded() {
+		c.mu.Lock()
+		return true
+	}
+	for !c.mu.TryLock() {
+		if budget.expired() {
+			return false
+		}
+		time.Sleep(lockPollInterval)
+	}
+	return true
+}
+
+// context returns a context ending at the budget deadline, if any.
+func (b *upstreamBudget) context() (context.Context, context.CancelFunc) {
+	if b == nil {
+		return context.WithCancel(context.Background())
+	}
+	ctx := context.Background()
+	if b.crawl {
+		ctx = withCrawl(ctx)
+	}
+	if b.deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, b.deadline)
+}
+
+// fetchTopic fetches the topic at path within the budget, failing with
+// errUpstreamBudget past its deadline.
+func (b *upstreamBudget) fetchTopic(path string) (*Topic, error) {
+	ctx, cancel := b.context()
+	defer cancel()
+	topic, err := b.fetchTopicHedged(ctx, path)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, errUpstreamBudget
+	}
+	return topic, err
+}
+
+// fetchTopicHedged fetches the topic at path. With -hedge, a second
+// request is sent if the first takes longer than 99% of recent ones, and
+// the first answer wins.
+func (b *upstreamBudget) fetchTopicHedged(ctx context.Context, path string) (*Topic, error) {
+	if !*hedgeFlag {
+		return fetchTopic(ctx, path)
+	}
+
+	type outcome struct {
+		topic *Topic
+		err   error
+	}
+	done := make(chan outcome, 2)
+	attempt := func() {
+		start := time.Now()
+		topic, err := fetchTopic(ctx, path)
+		if err == nil {
+			fetchLatency.add(time.Since(start))
+		}
+		done <- outcome{topic, err}
+	}
+
+	go attempt()
+	pending := 1
+	hedge := time.NewTimer(fetchLatency.p99())
+	defer hedge.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case r := <-done:
+			pending--
+			if r.err == nil {
+				return r.topic, nil
+			}
+			err = r.err
+		case <-hedge.C:
+			if b.spend() {
+				log.Printf("Fetching %s is slow, trying again alongside.", path)
+				go attempt()
+				pending++
+			}
+		}
+	}
+	return nil, err
+}
+
+const (
+	latencySamples    = 200
+	latencyMinSamples = 20
+	latencyDefault    = time.Second
+	latencyMin        = 100 * time.Millisecond
+)
+
+// latencyTracker keeps recent forum response times.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+var fetchLatency latencyTracker
+
+func (l *latencyTracker) add(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencySamples
+}
+
+// p99 returns the 99th percentile of recent response times, or a default
+// until there are enough of them.
+func (l *latencyTracker) p99() time.Duration {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples...)
+	l.mu.Unlock()
+	if len(sorted) < latencyMinSamples {
+		return latencyDefault
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p99 := sorted[len(sorted)*99/100]
+	if p99 < latencyMin {
+		return latencyMin
+	}
+	return p99
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteVariable is a placeholder value obtained from a URL. If Path is
+// set the URL must return JSON, and Path selects the value in it with
+// dot-separated object keys and array indexes (e.g. "channel-map.0.version").
+// Otherwise the whole response body, trimmed, is the value.
+type RemoteVariable struct {
+	URL  string `json:"url"`
+	Path string `json:"path"`
+
+	mu       sync.Mutex
+	time     time.Time // When value was fetched.
+	tried    time.Time // When fetching was last tried.
+	fetching bool
+	value    string
+}
+
+const (
+	remoteVariableTimeout = 1 * time.Hour
+	remoteVariableRetry   = 5 * time.Minute
+)
+
+var variablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// expandVariables replaces placeholders such as {{channel}} in content
+// with the escaped values configured for them. Unknown placeholders are
+// left alone.
+func expandVariables(content string) string {
+	if len(config.Variables) == 0 && len(config.RemoteVariables) == 0 {
+		return content
+	}
+	return variablePattern.ReplaceAllStringFunc(content, func(placeholder string) string {
+		name := variablePattern.FindStringSubmatch(placeholder)[1]
+		if value, ok := config.Variables[name]; ok {
+			return html.EscapeString(value)
+		}
+		if v, ok := config.RemoteVariables[name]; ok {
+			if value, ok := v.Value(); ok {
+				return html.EscapeString(value)
+			}
+		}
+		return placeholder
+	})
+}
+
+// Value returns the value of the remote variable, fetching it if the
+// previously fetched value is missing or too old. A previous value is
+// served while a new one is fetched in the background, or if fetching
+// fails, and failed fetches are only retried after remoteVariableRetry.
+func (v *RemoteVariable) Value() (value string, ok bool) {
+	now := time.Now()
+	v.mu.Lock()
+	if v.fetching || v.time.Add(remoteVariableTimeout).After(now) || v.tried.Add(remoteVariableRetry).After(now) {
+		defer v.mu.Unlock()
+		return v.value, !v.time.IsZero()
+	}
+	v.fetching = true
+	v.tried = now
+	value, ok = v.value, !v.time.IsZero()
+	v.mu.Unlock()
+
+	if ok {
+		go v.refresh()
+		return value, ok
+	}
+	v.refresh()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.value, !v.time.IsZero()
+}
+
+// refresh fetches the value of the remote variable, keeping the previous
+// one if that fails.
+func (v *RemoteVariable) refresh() {
+	value, err := v.fetch()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.fetching = false
+	if err != nil {
+		log.Printf("Cannot obtain variable value from %s: %v", v.URL, err)
+		return
+	}
+	v.value = value
+	v.time = time.Now()
+}
+
+func (v *RemoteVariable) fetch() (string, error) {
+	resp, err := httpClient.Get(v.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("got %v status", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if v.Path == "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(v.Path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("no index %q in path %q", key, v.Path)
+			}
+			value = node[i]
+		default:
+			value = nil
+		}
+		if value == nil {
+			return "", fmt.Errorf("no value at path %q", v.Path)
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case float64, bool:
+		return fmt.Sprint(value), nil
+	}
+	return "", fmt.Errorf("value at path %q is not a string, number, or boolean", v.Path)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRemoteVariableValue(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+		fmt.Fprintf(resp, `{"channel-map": [{"version": "1.%d"}]}`, n)
+	}))
+	defer server.Close()
+
+	v := &RemoteVariable{URL: server.URL, Path: "channel-map.0.version"}
+
+	// The first value is waited for, and then served until it's too old.
+	if value, ok := v.Value(); value != "1.1" || !ok {
+		t.Fatalf("first Value() = %q, %v", value, ok)
+	}
+	if value, ok := v.Value(); value != "1.1" || !ok || atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("cached Value() = %q, %v after %d fetches", value, ok, atomic.LoadInt32(&hits))
+	}
+
+	// An old value is served while a new one is fetched in the background.
+	v.mu.Lock()
+	v.time = v.time.Add(-remoteVariableTimeout)
+	v.tried = v.time
+	v.mu.Unlock()
+	if value, ok := v.Value(); value != "1.1" || !ok {
+		t.Fatalf("stale Value() = %q, %v", value, ok)
+	}
+	for i := 0; ; i++ {
+		if value, _ := v.Value(); value == "1.2" {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("value not refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRemoteVariableRetry(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		resp.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	v := &RemoteVariable{URL: server.URL}
+	for i := 0; i < 3; i++ {
+		if value, ok := v.Value(); value != "" || ok {
+			t.Fatalf("failed Value() = %q, %v", value, ok)
+		}
+	}
+	if hits := atomic.LoadInt32(&hits); hits != 1 {
+		t.Fatalf("failed fetch was tried %d times, want once until it's retried", hits)
+	}
+
+	v.mu.Lock()
+	v.tried = v.tried.Add(-remoteVariableRetry)
+	v.mu.Unlock()
+	v.Value()
+	if hits := atomic.LoadInt32(&hits); hits != 2 {
+		t.Fatalf("failed fetch was tried %d times after the retry delay, want 2", hits)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Watcher posts a message to Webhook whenever any of the given topics,
+// or any topic with one of the given tags, changes.
+type Watcher struct {
+	Topics  []int    `json:"topics"`
+	Tags    []string `json:"tags"`
+	Webhook string   `json:"webhook"`
+}
+
+func (w *Watcher) watches(topic *Topic) bool {
+	for _, id := range w.Topics {
+		if id == topic.ID {
+			return true
+		}
+	}
+	for _, tag := range w.Tags {
+		for _, t := range topic.Tags {
+			if tag == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func notifyWatchers(old, new *Topic) {
+	var text string
+	for _, w := range config.Watchers {
+		if !w.watches(new) {
+			continue
+		}
+		if text == "" {
+			text = changeMessage(old, new)
+		}
+		if err := postWebhook(w.Webhook, text); err != nil {
+			log.Printf("Cannot notify watcher of change to %s: %v", new, err)
+		}
+	}
+}
+
+// changeMessage describes the change from old to new for humans, linking
+// to the page and to the forum revision with the differences.
+func changeMessage(old, new *Topic) string {
+	var added, removed int
+	for _, line := range diffLines(strings.Split(old.Content(), "\n"), strings.Split(new.Content(), "\n")) {
+		switch line.Op {
+		case "+":
+			added++
+		case "-":
+			removed++
+		}
+	}
+	msg := fmt.Sprintf("%s was edited by %s (%d lines added, %d removed).\n%s",
+		new.Title, lastEditor(new), added, removed, new.URL())
+	if url := revisionURL(new); url != "" {
+		msg += "\nChanges: " + url
+	}
+	return msg
+}
+
+// lastEditor returns the username of whoever last edited the first post
+// of topic, falling back to the post author if that's unknown.
+func lastEditor(topic *Topic) string {
+	if topic.Post.ID == 0 {
+		return topic.Post.Username
+	}
+	resp, err := httpClient.Get(fmt.Sprintf("https://forum.snapcraft.io/posts/%d/revisions/latest.json", topic.Post.ID))
+	if err != nil {
+		log.Printf("Cannot obtain last revision of %s: %v", topic, err)
+		return topic.Post.Username
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Username string `json:"username"`
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == nil && resp.StatusCode == 200 {
+		err = json.Unmarshal(data, &result)
+	}
+	if err != nil || result.Username == "" {
+		// Posts that were never edited have no revisions.
+		return topic.Post.Username
+	}
+	return result.Username
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type searchResult struct {
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	URL        string `json:"url"`
+	Section    string `json:"section,omitempty"`
+	Blurb      string `json:"blurb"`
+	LastUpdate string `json:"last_update"`
+	Thumbnail  string `json:"thumbnail,omitempty"`
+}
+
+// serveSearchAPI serves search results as JSON to any origin, for the
+// search widget and other sites.
+func serveSearchAPI(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Access-Control-Allow-Origin", "*")
+
+	topics, err := forum.SearchWithin(req.Form.Get("q"), requestBudget(req))
+	if err != nil {
+		log.Printf("Cannot search for %q: %v", req.Form.Get("q"), err)
+		if !sendForumError(resp, err) {
+			resp.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+	results := make([]*searchResult, 0, len(topics))
+	for _, hit := range searchHits(topics, req.Form.Get("q")) {
+		topic := hit.Topic
+		results = append(results, &searchResult{
+			ID:         topic.ID,
+			Title:      topic.Title,
+			URL:        siteURL(req, hit.Link()),
+			Section:    hit.Section,
+			Blurb:      plainText(topic.Blurb()),
+			LastUpdate: formatTime(topic.LastUpdate()),
+			Thumbnail:  thumbnailURL(req, hit.Thumbnail()),
+		})
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("Cannot marshal search results: %v", err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write(data)
+}
+
+// siteURL returns the absolute URL for path on this site, based on the
+// configured base URL or otherwise on the request.
+func siteURL(req *http.Request, path string) string {
+	if *baseURLFlag != "" {
+		return strings.TrimSuffix(*baseURLFlag, "/") + path
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + path
+}
+
+func serveWidget(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "application/javascript")
+	resp.Header().Set("Cache-Control", "max-age=3600")
+	resp.Write([]byte(widgetScript))
+}
+
+// widgetScript turns every element with the snapdocs-search class in the
+// including page into a documentation search box:
+//
+//	<div class="snapdocs-search"></div>
+//	<script src="https://docs.example.com/widget.js" async></script>
+const widgetScript = `(function() {
+	var script = document.currentScript;
+	var origin = script ? new URL(script.src).origin : "";
+
+	function setup(box) {
+		if (box.getAttribute("data-snapdocs-ready")) {
+			return;
+		}
+		box.setAttribute("data-snapdocs-ready", "1");
+
+		var input = document.createElement("input");
+		input.type = "search";
+		input.placeholder = box.getAttribute("data-placeholder") || "Search the documentation";
+		input.style.width = "100%";
+		var list = document.createElement("ul");
+		list.style.listStyle = "none";
+		list.style.paddingLeft = "0";
+		box.appendChild(input);
+		box.appendChild(list);
+
+		var timer = null;
+		var latest = 0;
+		input.addEventListener("input", function() {
+			clearTimeout(timer);
+			timer = setTimeout(search, 300);
+		});
+
+		function search() {
+			var query = input.value.trim();
+			var current = ++latest;
+			if (!query) {
+				list.innerHTML = "";
+				return;
+			}
+			fetch(origin + "/api/v1/search?q=" + encodeURIComponent(query)).then(function(resp) {
+				return resp.json();
+			}).then(function(results) {
+				if (current !== latest) {
+					return;
+				}
+				list.innerHTML = "";
+				results.slice(0, 10).forEach(function(result) {
+					var item = document.createElement("li");
+					var link = document.createElement("a");
+					link.href = result.url;
+					link.target = "_blank";
+					link.textContent = result.title;
+					var blurb = document.createElement("div");
+					blurb.textContent = result.blurb;
+					blurb.style.fontSize = "smaller";
+					item.appendChild(link);
+					item.appendChild(blurb);
+					list.appendChild(item);
+				});
+				if (results.length === 0) {
+					var item = document.createElement("li");
+					item.textContent = "No matching documents.";
+					list.appendChild(item);
+				}
+			});
+		}
+	}
+
+	function setupAll() {
+		var boxes = document.querySelectorAll(".snapdocs-search");
+		for (var i = 0; i < boxes.length; i++) {
+			setup(boxes[i]);
+		}
+	}
+
+	if (document.readyState === "loading") {
+		document.addEventListener("DOMContentLoaded", setupAll);
+	} else {
+		setupAll();
+	}
+})();
+`